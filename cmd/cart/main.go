@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"TKMall/build/proto_gen/cart"
 	"TKMall/cmd/cart/model"
+	"TKMall/cmd/cart/repository"
 	"TKMall/cmd/cart/service"
 	"TKMall/common/config"
 	"TKMall/common/etcd"
+	"TKMall/common/events"
 	"TKMall/common/log"
 	commonModel "TKMall/common/model"
 	"TKMall/common/proxy"
@@ -76,20 +80,77 @@ func main() {
 
 	serviceProxy := proxy.NewGrpcProxy(serviceEndpoints, redisAddr)
 
+	// 初始化购物车存储
+	cartRepo, err := repository.New(repository.Options{
+		Storage:  viper.GetString("cart.storage"),
+		RedisTTL: viper.GetDuration("cart.redis_ttl_hours") * time.Hour,
+	}, db, rdb)
+	if err != nil {
+		log.Fatalf("初始化购物车存储失败: %v", err)
+	}
+
+	// Redis存储异步回写MySQL，关闭服务时完成最后一次回写
+	writeBackCtx, stopWriteBack := context.WithCancel(context.Background())
+	writeBackDone := make(chan struct{})
+	if redisRepo, ok := cartRepo.(*repository.RedisRepository); ok {
+		interval := viper.GetDuration("cart.write_back_interval") * time.Second
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		log.Infof("购物车使用Redis存储，回写间隔: %v", interval)
+		go func() {
+			defer close(writeBackDone)
+			redisRepo.RunWriteBack(writeBackCtx, interval)
+		}()
+	} else {
+		close(writeBackDone)
+	}
+
+	// 初始化事件总线，失败时不处理降价提醒
+	kafkaBrokers := []string{"localhost:9092"} // 默认值
+	if brokers := viper.GetStringSlice("kafka.brokers"); len(brokers) > 0 {
+		kafkaBrokers = brokers
+	}
+	if os.Getenv("KAFKA_BROKERS") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_BROKERS"), ",")
+	} else if os.Getenv("KAFKA_ADDR") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_ADDR"), ",")
+	}
+	log.Infof("使用Kafka地址: %v", kafkaBrokers)
+	var eventBus events.EventBus
+	if bus, err := events.NewKafkaEventBus(kafkaBrokers); err != nil {
+		log.Errorf("初始化事件总线失败: %v", err)
+	} else {
+		eventBus = bus
+	}
+
 	// 创建gRPC服务器
 	server := grpc.NewServer()
 
 	// 初始化购物车服务
 	cartService := &service.CartServiceServer{
-		DB:    db,
-		Redis: rdb,
-		Node:  node,
-		Proxy: serviceProxy,
+		DB:           db,
+		Redis:        rdb,
+		Node:         node,
+		Proxy:        serviceProxy,
+		Repo:         cartRepo,
+		EventBus:     eventBus,
+		GuestCartTTL: viper.GetDuration("guest_cart.ttl_hours") * time.Hour,
+		Limits: service.CartLimits{
+			MaxItemQuantity:  viper.GetInt("cart.limits.max_item_quantity"),
+			MaxOrderQuantity: viper.GetInt("cart.limits.max_order_quantity"),
+			MaxLines:         viper.GetInt("cart.limits.max_lines"),
+		},
 	}
 
 	// 注册购物车服务
 	cart.RegisterCartServiceServer(server, cartService)
 
+	// 商品降价时提醒收藏了该商品的用户
+	if eventBus != nil {
+		eventBus.Subscribe(events.ProductPriceChanged, cartService.HandlePriceChanged)
+	}
+
 	// 获取服务配置
 	port := viper.GetInt("server.port")
 	serviceName := viper.GetString("server.name")
@@ -151,5 +212,7 @@ func main() {
 
 	// 优雅地关闭服务
 	server.GracefulStop()
+	stopWriteBack()
+	<-writeBackDone
 	log.Info("服务已关闭")
 }
@@ -10,23 +10,71 @@ import (
 // Cart 购物车模型
 type Cart struct {
 	model.BaseModel
-	UserID    int64     `gorm:"index;not null"` // 用户ID
+	UserID    int64     `gorm:"uniqueIndex;not null"` // 用户ID，每个用户只有一个购物车
 	UpdatedAt time.Time // 最后更新时间
+	Version   int64     `gorm:"not null;default:0"` // Redis存储最后回写的版本号
 }
 
-// CartItem 购物车项模型
+// CartItem 购物车项模型，删除时直接物理删除，保证唯一索引不受已删除记录影响
 type CartItem struct {
 	model.BaseModel
-	CartID    uint    `gorm:"index;not null"`              // 购物车ID
-	ProductID uint    `gorm:"index;not null"`              // 商品ID
-	Quantity  int     `gorm:"not null"`                    // 商品数量
-	Price     float64 `gorm:"type:decimal(10,2);not null"` // 商品单价（加入时的价格）
+	CartID    uint    `gorm:"uniqueIndex:idx_cart_product;not null"`       // 购物车ID
+	ProductID uint    `gorm:"uniqueIndex:idx_cart_product;index;not null"` // 商品ID
+	Quantity  int     `gorm:"not null"`                                    // 商品数量
+	Price     float64 `gorm:"type:decimal(10,2);not null"`                 // 商品单价（加入时的价格）
+	Selected  bool    `gorm:"not null;default:true"`                       // 是否勾选结算
 }
 
 // 初始化数据库表
 func AutoMigrate(db *gorm.DB) error {
+	if err := dedupeCarts(db); err != nil {
+		return err
+	}
 	return db.AutoMigrate(
 		&Cart{},
 		&CartItem{},
+		&Wishlist{},
+		&WishlistItem{},
+		&WishlistNotification{},
 	)
 }
+
+// dedupeCarts 创建唯一索引前合并重复数据：每个用户保留最早的购物车，同一购物车中的重复商品数量相加
+func dedupeCarts(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Cart{}) || !migrator.HasTable(&CartItem{}) || migrator.HasIndex(&CartItem{}, "idx_cart_product") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			// 已软删除的购物车项不再保留
+			`DELETE FROM cart_items WHERE deleted_at IS NOT NULL`,
+			// 商品移到用户最早的购物车
+			`UPDATE cart_items ci
+				JOIN carts c ON ci.cart_id = c.id
+				JOIN (SELECT user_id, MIN(id) AS keep_id FROM carts GROUP BY user_id) k ON k.user_id = c.user_id
+				SET ci.cart_id = k.keep_id
+				WHERE ci.cart_id <> k.keep_id`,
+			`DELETE c FROM carts c
+				JOIN (SELECT user_id, MIN(id) AS keep_id FROM carts GROUP BY user_id) k ON k.user_id = c.user_id
+				WHERE c.id <> k.keep_id`,
+			// 重复商品的数量合并到最早的一条
+			`UPDATE cart_items ci
+				JOIN (SELECT MIN(id) AS keep_id, SUM(quantity) AS total FROM cart_items
+					GROUP BY cart_id, product_id HAVING COUNT(*) > 1) d ON ci.id = d.keep_id
+				SET ci.quantity = d.total`,
+			`DELETE ci FROM cart_items ci
+				JOIN (SELECT cart_id, product_id, MIN(id) AS keep_id FROM cart_items
+					GROUP BY cart_id, product_id HAVING COUNT(*) > 1) d
+					ON ci.cart_id = d.cart_id AND ci.product_id = d.product_id
+				WHERE ci.id <> d.keep_id`,
+		}
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"TKMall/common/model"
+)
+
+// DefaultWishlistName 默认清单的名称，每个用户一个，首次使用时创建
+const DefaultWishlistName = "稍后再买"
+
+// Wishlist 用户的收藏清单。删除时直接物理删除，删除后可以重新创建同名清单
+type Wishlist struct {
+	model.BaseModel
+	UserID    int64  `gorm:"not null;uniqueIndex:idx_user_wishlist_name,priority:1"`
+	Name      string `gorm:"type:varchar(50);not null;uniqueIndex:idx_user_wishlist_name,priority:2"`
+	IsDefault bool   `gorm:"not null;default:false"`
+}
+
+// WishlistItem 清单中的商品，删除时直接物理删除
+type WishlistItem struct {
+	model.BaseModel
+	WishlistID uint    `gorm:"not null;uniqueIndex:idx_wishlist_product,priority:1"`
+	ProductID  uint    `gorm:"not null;uniqueIndex:idx_wishlist_product,priority:2;index"`
+	UserID     int64   `gorm:"not null;index"`
+	PriceAtAdd float64 `gorm:"type:decimal(10,2);not null"` // 加入清单时的售价
+	// 最近一次降价提醒时的售价，为0表示还没有提醒过。价格降到更低时才再次提醒
+	NotifiedPrice float64 `gorm:"type:decimal(10,2);not null;default:0"`
+}
+
+// WishlistNotification 收藏商品的站内降价提醒
+type WishlistNotification struct {
+	model.BaseModel
+	UserID     int64   `gorm:"not null;index:idx_user_read,priority:1"`
+	IsRead     bool    `gorm:"not null;default:false;index:idx_user_read,priority:2"`
+	ProductID  uint    `gorm:"not null"`
+	WishlistID uint    `gorm:"not null"`
+	OldPrice   float64 `gorm:"type:decimal(10,2);not null"` // 加入清单时或上次提醒时的售价
+	NewPrice   float64 `gorm:"type:decimal(10,2);not null"`
+}
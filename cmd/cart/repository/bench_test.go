@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"TKMall/cmd/cart/model"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 并发AddItem的压测，比较两种存储：
+//
+//	CART_TEST_MYSQL_DSN=... CART_BENCH_REDIS_ADDR=localhost:6379 go test -run ^$ -bench AddItem ./cmd/cart/repository/
+//
+// 测试和压测的用户ID从testUserIDBase开始，结束时只清理这些用户的购物车。没有配置MySQL时跳过MySQL压测，
+// Redis压测使用内存持久化存储；没有配置Redis时使用miniredis。
+const (
+	benchUsers    = 1000
+	benchProducts = 50
+)
+
+// testUserIDBase 测试使用的用户ID起始值，远大于真实用户ID，避免影响测试库中的其他数据
+const testUserIDBase int64 = 900_000_000_000
+
+func benchUserIDs() []int64 {
+	ids := make([]int64, benchUsers)
+	for i := range ids {
+		ids[i] = testUserIDBase + int64(i) + 1
+	}
+	return ids
+}
+
+func BenchmarkAddItemMySQL(b *testing.B) {
+	db := openTestDB(b, benchUserIDs()...)
+	benchmarkAddItem(b, NewMySQLRepository(db))
+}
+
+func BenchmarkAddItemRedis(b *testing.B) {
+	var store DurableStore = newMemoryStore()
+	if os.Getenv("CART_TEST_MYSQL_DSN") != "" {
+		store = NewMySQLRepository(openTestDB(b, benchUserIDs()...))
+	}
+
+	var rdb *redis.Client
+	if addr := os.Getenv("CART_BENCH_REDIS_ADDR"); addr != "" {
+		rdb = redis.NewClient(&redis.Options{Addr: addr, PoolSize: 64})
+		b.Cleanup(func() {
+			ctx := context.Background()
+			for _, userID := range benchUserIDs() {
+				rdb.Del(ctx, fmt.Sprintf(userCartKey, userID))
+				rdb.SRem(ctx, dirtyCartsKey, userID)
+			}
+			rdb.Close()
+		})
+	} else {
+		mr, _, _ := newTestRedisRepository(b)
+		rdb = redis.NewClient(&redis.Options{Addr: mr.Addr(), PoolSize: 64})
+		b.Cleanup(func() { rdb.Close() })
+	}
+
+	repo := NewRedisRepository(rdb, store, 0)
+	benchmarkAddItem(b, repo)
+
+	// 回写时间不计入AddItem耗时，单独报告
+	b.StopTimer()
+	if err := repo.Flush(context.Background()); err != nil {
+		b.Fatalf("回写失败: %v", err)
+	}
+}
+
+func benchmarkAddItem(b *testing.B, repo CartRepository) {
+	ctx := context.Background()
+	var seed int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewSource(atomic.AddInt64(&seed, 1)))
+		for pb.Next() {
+			userID := testUserIDBase + int64(rng.Intn(benchUsers)+1)
+			productID := uint32(rng.Intn(benchProducts) + 1)
+			if err := repo.AddItem(ctx, userID, productID, 1, 9.9, false, math.MaxInt32); err != nil {
+				b.Errorf("AddItem失败: %v", err)
+				return
+			}
+		}
+	})
+}
+
+// openTestDB 连接CART_TEST_MYSQL_DSN指定的测试库，未设置时跳过。
+// 开始前和结束后删除userIDs的购物车，不影响库中的其他数据
+func openTestDB(tb testing.TB, userIDs ...int64) *gorm.DB {
+	dsn := os.Getenv("CART_TEST_MYSQL_DSN")
+	if dsn == "" {
+		tb.Skip("未设置CART_TEST_MYSQL_DSN")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatalf("连接MySQL失败: %v", err)
+	}
+	if err := model.AutoMigrate(db); err != nil {
+		tb.Fatalf("迁移失败: %v", err)
+	}
+	cleanup := func() {
+		carts := db.Model(&model.Cart{}).Select("id").Where("user_id IN ?", userIDs)
+		db.Unscoped().Where("cart_id IN (?)", carts).Delete(&model.CartItem{})
+		db.Unscoped().Where("user_id IN ?", userIDs).Delete(&model.Cart{})
+	}
+	// 清理上次中断的测试留下的数据
+	cleanup()
+	tb.Cleanup(cleanup)
+	return db
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 并发向同一个购物车添加同一商品，最终只有一行且数量为所有请求之和
+const (
+	concurrentWorkers = 50
+	addsPerWorker     = 20
+
+	concurrentUserID      = testUserIDBase + 42
+	concurrentLimitUserID = testUserIDBase + 43
+)
+
+func TestMySQLRepositoryConcurrentAddItem(t *testing.T) {
+	repo := NewMySQLRepository(openTestDB(t, concurrentUserID))
+	testConcurrentAddItem(t, repo)
+}
+
+func TestRedisRepositoryConcurrentAddItem(t *testing.T) {
+	_, repo, _ := newTestRedisRepository(t)
+	testConcurrentAddItem(t, repo)
+}
+
+func TestMySQLRepositoryConcurrentAddItemLimit(t *testing.T) {
+	repo := NewMySQLRepository(openTestDB(t, concurrentLimitUserID))
+	testConcurrentAddItemLimit(t, repo)
+}
+
+func TestRedisRepositoryConcurrentAddItemLimit(t *testing.T) {
+	_, repo, _ := newTestRedisRepository(t)
+	testConcurrentAddItemLimit(t, repo)
+}
+
+// 并发累加同一商品时数量不超过上限，超过上限的请求返回ErrQuantityLimit
+func testConcurrentAddItemLimit(t *testing.T, repo CartRepository) {
+	ctx := context.Background()
+	const userID, productID, limit = concurrentLimitUserID, uint32(7), 10
+
+	var wg sync.WaitGroup
+	var rejected int64
+	errs := make(chan error, concurrentWorkers)
+	for i := 0; i < concurrentWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.AddItem(ctx, userID, productID, 1, 9.9, false, limit)
+			switch {
+			case errors.Is(err, ErrQuantityLimit):
+				atomic.AddInt64(&rejected, 1)
+			case err != nil:
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("AddItem失败: %v", err)
+	}
+
+	items, err := repo.Items(ctx, userID)
+	if err != nil {
+		t.Fatalf("Items失败: %v", err)
+	}
+	if len(items) != 1 || items[0].Quantity != limit {
+		t.Fatalf("数量应为上限%d，实际%v", limit, items)
+	}
+	if want := int64(concurrentWorkers - limit); rejected != want {
+		t.Errorf("应拒绝%d次，实际%d次", want, rejected)
+	}
+}
+
+func testConcurrentAddItem(t *testing.T, repo CartRepository) {
+	ctx := context.Background()
+	const userID, productID = concurrentUserID, uint32(7)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentWorkers*addsPerWorker)
+	for i := 0; i < concurrentWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < addsPerWorker; j++ {
+				if err := repo.AddItem(ctx, userID, productID, 1, 9.9, false, concurrentWorkers*addsPerWorker); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("AddItem失败: %v", err)
+	}
+
+	items, err := repo.Items(ctx, userID)
+	if err != nil {
+		t.Fatalf("Items失败: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("购物车应只有1个商品，实际%d个", len(items))
+	}
+	if want := concurrentWorkers * addsPerWorker; items[0].Quantity != want {
+		t.Errorf("数量应为%d，实际%d", want, items[0].Quantity)
+	}
+}
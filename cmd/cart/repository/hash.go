@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"TKMall/cmd/cart/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// HashCart 存放在Redis哈希中的购物车，游客购物车和Redis存储的用户购物车共用这种结构。
+// 每个商品占三个字段：q:{商品ID} 数量、p:{商品ID} 价格快照、s:{商品ID} 是否勾选。
+type HashCart struct {
+	rdb *redis.Client
+	key string
+	ttl time.Duration
+	// 每次写入时在同一个事务中追加的命令，返回的命令结果为0时写入已撤销，write返回errCartExpired
+	onWrite func(ctx context.Context, pipe redis.Pipeliner) *redis.Cmd
+}
+
+// errCartExpired 写入时购物车已经过期，写入已撤销，需要重新加载后重试
+var errCartExpired = errors.New("购物车已过期")
+
+func NewHashCart(rdb *redis.Client, key string, ttl time.Duration) *HashCart {
+	return &HashCart{rdb: rdb, key: key, ttl: ttl}
+}
+
+// Items 读取购物车中的商品，按商品ID排序
+func (h *HashCart) Items(ctx context.Context) ([]model.CartItem, error) {
+	fields, err := h.rdb.HGetAll(ctx, h.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	return ParseHashItems(fields), nil
+}
+
+// AddItem 加入商品，加入后的数量超过maxQuantity时不修改购物车并返回ErrQuantityLimit
+func (h *HashCart) AddItem(ctx context.Context, productID uint32, quantity int, price float64, setQuantity bool, maxQuantity int) error {
+	mode := "incr"
+	if setQuantity {
+		mode = "set"
+	}
+	var result *redis.Cmd
+	err := h.write(ctx, func(pipe redis.Pipeliner) {
+		result = pipe.Eval(ctx, addItemScript, []string{h.key},
+			HashField("q", productID), HashField("p", productID), HashField("s", productID),
+			quantity, strconv.FormatFloat(price, 'f', 2, 64), mode, maxQuantity)
+	})
+	if err != nil {
+		return err
+	}
+	if added, _ := result.Int(); added == 0 {
+		return ErrQuantityLimit
+	}
+	return nil
+}
+
+func (h *HashCart) UpdateQuantity(ctx context.Context, productID uint32, quantity int) error {
+	var result *redis.Cmd
+	err := h.write(ctx, func(pipe redis.Pipeliner) {
+		// 只修改购物车中已有的商品
+		result = pipe.Eval(ctx, setIfExistsScript, []string{h.key}, HashField("q", productID), HashField("q", productID), quantity)
+	})
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.Int(); updated == 0 {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+func (h *HashCart) RemoveItems(ctx context.Context, productIDs []uint32) error {
+	fields := make([]string, 0, len(productIDs)*3)
+	for _, id := range productIDs {
+		fields = append(fields, HashField("q", id), HashField("p", id), HashField("s", id))
+	}
+	return h.write(ctx, func(pipe redis.Pipeliner) {
+		pipe.HDel(ctx, h.key, fields...)
+	})
+}
+
+// SelectItems 修改勾选状态，productIDs为空时作用于所有商品
+func (h *HashCart) SelectItems(ctx context.Context, productIDs []uint32, selected bool) error {
+	if len(productIDs) == 0 {
+		items, err := h.Items(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			productIDs = append(productIDs, uint32(item.ProductID))
+		}
+	}
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	value := "0"
+	if selected {
+		value = "1"
+	}
+	return h.write(ctx, func(pipe redis.Pipeliner) {
+		for _, id := range productIDs {
+			pipe.Eval(ctx, setIfExistsScript, []string{h.key}, HashField("q", id), HashField("s", id), value)
+		}
+	})
+}
+
+func (h *HashCart) Empty(ctx context.Context) error {
+	return h.write(ctx, func(pipe redis.Pipeliner) {
+		pipe.Del(ctx, h.key)
+	})
+}
+
+// SaveItems 按商品ID写入数量、价格快照和勾选状态
+func (h *HashCart) SaveItems(ctx context.Context, items []model.CartItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	values := make([]interface{}, 0, len(items)*6)
+	for _, item := range items {
+		selected := "0"
+		if item.Selected {
+			selected = "1"
+		}
+		id := uint32(item.ProductID)
+		values = append(values,
+			HashField("q", id), item.Quantity,
+			HashField("p", id), strconv.FormatFloat(item.Price, 'f', 2, 64),
+			HashField("s", id), selected,
+		)
+	}
+	return h.write(ctx, func(pipe redis.Pipeliner) {
+		pipe.HSet(ctx, h.key, values...)
+	})
+}
+
+// write 在事务中执行写命令并刷新过期时间
+func (h *HashCart) write(ctx context.Context, fn func(pipe redis.Pipeliner)) error {
+	var check *redis.Cmd
+	_, err := h.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		fn(pipe)
+		if h.onWrite != nil {
+			check = h.onWrite(ctx, pipe)
+		}
+		if h.ttl > 0 {
+			pipe.Expire(ctx, h.key, h.ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		if ok, _ := check.Int(); ok == 0 {
+			return errCartExpired
+		}
+	}
+	return nil
+}
+
+// ARGV[1..3]为数量、价格、勾选字段，ARGV[4]为数量，ARGV[5]为价格，ARGV[6]为set或incr，ARGV[7]为数量上限。
+// 加入后的数量超过上限时不修改并返回0
+const addItemScript = `
+local quantity = tonumber(ARGV[4])
+if ARGV[6] == "incr" then
+	quantity = quantity + tonumber(redis.call("HGET", KEYS[1], ARGV[1]) or "0")
+end
+if quantity > tonumber(ARGV[7]) then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], quantity, ARGV[2], ARGV[5], ARGV[3], "1")
+return 1
+`
+
+// ARGV[1]存在时将ARGV[2]设置为ARGV[3]，返回是否修改
+const setIfExistsScript = `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	redis.call("HSET", KEYS[1], ARGV[2], ARGV[3])
+	return 1
+end
+return 0
+`
+
+func HashField(kind string, productID uint32) string {
+	return kind + ":" + strconv.FormatUint(uint64(productID), 10)
+}
+
+// ParseHashItems 解析购物车哈希，忽略无法识别的字段
+func ParseHashItems(fields map[string]string) []model.CartItem {
+	items := make(map[uint]*model.CartItem)
+	for field, value := range fields {
+		kind, idStr, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			continue
+		}
+		item, ok := items[uint(id)]
+		if !ok {
+			item = &model.CartItem{ProductID: uint(id), Selected: true}
+			items[uint(id)] = item
+		}
+		switch kind {
+		case "q":
+			item.Quantity, _ = strconv.Atoi(value)
+		case "p":
+			item.Price, _ = strconv.ParseFloat(value, 64)
+		case "s":
+			item.Selected = value == "1"
+		}
+	}
+
+	result := make([]model.CartItem, 0, len(items))
+	for _, item := range items {
+		// 没有数量字段说明商品已被删除，只残留了其他字段
+		if item.Quantity > 0 {
+			result = append(result, *item)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ProductID < result[j].ProductID })
+	return result
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试购物车哈希字段的解析
+func TestParseHashItems(t *testing.T) {
+	items := ParseHashItems(map[string]string{
+		"q:102": "1", "p:102": "299.99", "s:102": "0",
+		"q:101": "2", "p:101": "199.99", "s:101": "1",
+		"p:103":     "9.9", // 已删除商品的残留字段
+		loadedField: "1",
+		"bad:x":     "x",
+	})
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, uint(101), items[0].ProductID)
+		assert.Equal(t, 2, items[0].Quantity)
+		assert.InDelta(t, 199.99, items[0].Price, 0.001)
+		assert.True(t, items[0].Selected)
+		assert.Equal(t, uint(102), items[1].ProductID)
+		assert.False(t, items[1].Selected)
+	}
+}
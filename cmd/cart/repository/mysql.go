@@ -0,0 +1,304 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"TKMall/cmd/cart/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MySQLRepository 购物车直接读写MySQL
+type MySQLRepository struct {
+	db *gorm.DB
+}
+
+func NewMySQLRepository(db *gorm.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+func (r *MySQLRepository) Items(ctx context.Context, userID int64) ([]model.CartItem, error) {
+	items, _, err := r.Load(ctx, userID)
+	return items, err
+}
+
+// Load 读取购物车商品和Redis存储最后回写的版本号
+func (r *MySQLRepository) Load(ctx context.Context, userID int64) ([]model.CartItem, int64, error) {
+	userCart, err := r.findCart(ctx, userID)
+	if err != nil || userCart == nil {
+		return nil, 0, err
+	}
+
+	var cartItems []model.CartItem
+	if err := r.db.WithContext(ctx).Where("cart_id = ?", userCart.ID).Order("id ASC").Find(&cartItems).Error; err != nil {
+		return nil, 0, fmt.Errorf("获取购物车商品失败: %w", err)
+	}
+	return cartItems, userCart.Version, nil
+}
+
+// AddItem 累加时用带上限条件的UPDATE完成，商品不在购物车中时再插入，并发添加同一商品时依靠唯一索引保证只有一行
+func (r *MySQLRepository) AddItem(ctx context.Context, userID int64, productID uint32, quantity int, price float64, setQuantity bool, maxQuantity int) error {
+	if quantity > maxQuantity {
+		return ErrQuantityLimit
+	}
+	// 查询或创建用户的购物车
+	userCart, err := r.findOrCreateCart(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	item := model.CartItem{
+		ProductID: uint(productID),
+		Quantity:  quantity,
+		Price:     price,
+		Selected:  true,
+	}
+	db := r.db.WithContext(ctx)
+	if setQuantity {
+		if err := upsertItem(db, userCart.ID, item, false); err != nil {
+			return fmt.Errorf("添加购物车项失败: %w", err)
+		}
+		return nil
+	}
+
+	// 商品已存在时累加数量，价格快照更新为用户本次看到的价格。
+	// 插入时与并发的插入冲突说明商品已经存在，重新尝试累加
+	for attempt := 0; attempt < 2; attempt++ {
+		updated := db.Model(&model.CartItem{}).
+			Where("cart_id = ? AND product_id = ? AND quantity + ? <= ?", userCart.ID, productID, quantity, maxQuantity).
+			Updates(map[string]interface{}{
+				"quantity": gorm.Expr("quantity + ?", quantity),
+				"price":    price,
+				"selected": true,
+			})
+		if updated.Error != nil {
+			return fmt.Errorf("添加购物车项失败: %w", updated.Error)
+		}
+		if updated.RowsAffected > 0 {
+			return nil
+		}
+
+		created, err := insertItem(db, userCart.ID, item)
+		if err != nil {
+			return fmt.Errorf("添加购物车项失败: %w", err)
+		}
+		if created {
+			return nil
+		}
+	}
+	// 商品已在购物车中，累加后超过上限
+	return ErrQuantityLimit
+}
+
+func (r *MySQLRepository) UpdateQuantity(ctx context.Context, userID int64, productID uint32, quantity int) error {
+	userCart, err := r.findCart(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if userCart == nil {
+		return ErrItemNotFound
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.CartItem{}).
+			Where("cart_id = ? AND product_id = ?", userCart.ID, productID).
+			Update("quantity", quantity)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrItemNotFound
+		}
+		return touchCart(tx, userCart)
+	})
+}
+
+func (r *MySQLRepository) RemoveItems(ctx context.Context, userID int64, productIDs []uint32) error {
+	userCart, err := r.findCart(ctx, userID)
+	if err != nil || userCart == nil {
+		return err
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("cart_id = ? AND product_id IN ?", userCart.ID, productIDs).
+			Delete(&model.CartItem{}).Error; err != nil {
+			return err
+		}
+		return touchCart(tx, userCart)
+	})
+}
+
+func (r *MySQLRepository) SelectItems(ctx context.Context, userID int64, productIDs []uint32, selected bool) error {
+	userCart, err := r.findCart(ctx, userID)
+	if err != nil || userCart == nil {
+		return err
+	}
+
+	query := r.db.WithContext(ctx).Model(&model.CartItem{}).Where("cart_id = ?", userCart.ID)
+	if len(productIDs) > 0 {
+		query = query.Where("product_id IN ?", productIDs)
+	}
+	return query.Update("selected", selected).Error
+}
+
+func (r *MySQLRepository) Empty(ctx context.Context, userID int64) error {
+	userCart, err := r.findCart(ctx, userID)
+	if err != nil || userCart == nil {
+		return err
+	}
+
+	// 开启事务，删除所有购物车项
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("cart_id = ?", userCart.ID).Delete(&model.CartItem{}).Error; err != nil {
+			return err
+		}
+		return touchCart(tx, userCart)
+	})
+}
+
+func (r *MySQLRepository) SaveItems(ctx context.Context, userID int64, items []model.CartItem) error {
+	userCart, err := r.findOrCreateCart(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := saveItems(tx, userCart.ID, items); err != nil {
+			return err
+		}
+		return touchCart(tx, userCart)
+	})
+}
+
+// ReplaceItems 用items整体替换用户的购物车，供Redis存储回写使用。
+// 按版本号条件更新购物车并锁定到事务结束，已保存相同或更新的版本时不修改
+func (r *MySQLRepository) ReplaceItems(ctx context.Context, userID int64, items []model.CartItem, version int64) error {
+	userCart, err := r.findOrCreateCart(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Cart{}).Where("id = ? AND version < ?", userCart.ID, version).
+			Updates(map[string]interface{}{"version": version, "updated_at": gorm.Expr("NOW()")})
+		if result.Error != nil {
+			return fmt.Errorf("更新购物车版本失败: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		productIDs := make([]uint, 0, len(items))
+		for _, item := range items {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		query := tx.Unscoped().Where("cart_id = ?", userCart.ID)
+		if len(productIDs) > 0 {
+			query = query.Where("product_id NOT IN ?", productIDs)
+		}
+		if err := query.Delete(&model.CartItem{}).Error; err != nil {
+			return err
+		}
+
+		return saveItems(tx, userCart.ID, items)
+	})
+}
+
+// saveItems 逐个写入购物车项，已存在的覆盖数量、价格快照和勾选状态
+func saveItems(tx *gorm.DB, cartID uint, items []model.CartItem) error {
+	for _, item := range items {
+		if err := upsertItem(tx, cartID, item, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertItem 插入购物车项，(cart_id, product_id)已存在时更新。increment为true时累加数量，否则覆盖。
+// 使用map插入，selected为false时也会写入，不会被数据库默认值覆盖。
+func upsertItem(tx *gorm.DB, cartID uint, item model.CartItem, increment bool) error {
+	quantity := gorm.Expr("VALUES(quantity)")
+	if increment {
+		quantity = gorm.Expr("quantity + VALUES(quantity)")
+	}
+	now := time.Now()
+	return tx.Model(&model.CartItem{}).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "cart_id"}, {Name: "product_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   quantity,
+			"price":      gorm.Expr("VALUES(price)"),
+			"selected":   gorm.Expr("VALUES(selected)"),
+			"updated_at": gorm.Expr("VALUES(updated_at)"),
+		}),
+	}).Create(map[string]interface{}{
+		"cart_id":    cartID,
+		"product_id": item.ProductID,
+		"quantity":   item.Quantity,
+		"price":      item.Price,
+		"selected":   item.Selected,
+		"created_at": now,
+		"updated_at": now,
+	}).Error
+}
+
+// insertItem 插入购物车项，(cart_id, product_id)已存在时不修改并返回false
+func insertItem(tx *gorm.DB, cartID uint, item model.CartItem) (bool, error) {
+	now := time.Now()
+	result := tx.Model(&model.CartItem{}).Clauses(clause.OnConflict{DoNothing: true}).Create(map[string]interface{}{
+		"cart_id":    cartID,
+		"product_id": item.ProductID,
+		"quantity":   item.Quantity,
+		"price":      item.Price,
+		"selected":   item.Selected,
+		"created_at": now,
+		"updated_at": now,
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// findCart 查询用户的购物车，用户还没有购物车时返回nil
+func (r *MySQLRepository) findCart(ctx context.Context, userID int64) (*model.Cart, error) {
+	var userCart model.Cart
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&userCart).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询购物车失败: %w", err)
+	}
+	return &userCart, nil
+}
+
+// findOrCreateCart 获取用户的购物车，不存在时创建并更新最后修改时间。
+// user_id有唯一索引，并发创建时只会插入一行，其余请求变为更新。
+func (r *MySQLRepository) findOrCreateCart(ctx context.Context, userID int64) (*model.Cart, error) {
+	now := time.Now()
+	db := r.db.WithContext(ctx)
+	if err := db.Model(&model.Cart{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"updated_at": now}),
+	}).Create(map[string]interface{}{
+		"user_id":    userID,
+		"created_at": now,
+		"updated_at": now,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("获取购物车失败: %w", err)
+	}
+
+	var userCart model.Cart
+	if err := db.Where("user_id = ?", userID).First(&userCart).Error; err != nil {
+		return nil, fmt.Errorf("获取购物车失败: %w", err)
+	}
+	return &userCart, nil
+}
+
+// touchCart 更新购物车最后修改时间
+func touchCart(tx *gorm.DB, userCart *model.Cart) error {
+	if err := tx.Model(userCart).Update("updated_at", gorm.Expr("NOW()")).Error; err != nil {
+		return fmt.Errorf("更新购物车时间失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"TKMall/cmd/cart/model"
+	"TKMall/common/log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	userCartKey = "cart:user:%d"
+	// 有未回写修改的用户ID集合
+	dirtyCartsKey = "cart:dirty"
+	// 购物车已从MySQL加载的标记字段，用于区分空购物车和未加载
+	loadedField = "_loaded"
+	// 购物车的版本号字段，每次写入加一，回写时跳过比已保存的版本旧的快照
+	versionField = "_version"
+
+	DefaultRedisCartTTL = 30 * 24 * time.Hour
+
+	// 每轮回写从脏集合中取出的用户数量
+	writeBackBatchSize = 100
+	// 写入时购物车过期后重新加载的次数
+	maxCartLoadAttempts = 3
+)
+
+// DurableStore Redis购物车的持久化存储
+type DurableStore interface {
+	// Load 读取用户的购物车和最后回写的版本号
+	Load(ctx context.Context, userID int64) ([]model.CartItem, int64, error)
+	// ReplaceItems 用items整体替换用户的购物车。version不大于已保存的版本时不修改，
+	// 多个实例回写同一个购物车时较早的快照不会覆盖较新的回写
+	ReplaceItems(ctx context.Context, userID int64, items []model.CartItem, version int64) error
+}
+
+// RedisRepository 购物车读写Redis哈希，修改过的购物车记入脏集合，由RunWriteBack异步回写MySQL。
+// 缓存过期或首次访问时从MySQL加载。
+type RedisRepository struct {
+	rdb   *redis.Client
+	store DurableStore
+	ttl   time.Duration
+}
+
+func NewRedisRepository(rdb *redis.Client, store DurableStore, ttl time.Duration) *RedisRepository {
+	if ttl <= 0 {
+		ttl = DefaultRedisCartTTL
+	}
+	return &RedisRepository{rdb: rdb, store: store, ttl: ttl}
+}
+
+func (r *RedisRepository) Items(ctx context.Context, userID int64) ([]model.CartItem, error) {
+	h, err := r.cart(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return h.Items(ctx)
+}
+
+func (r *RedisRepository) AddItem(ctx context.Context, userID int64, productID uint32, quantity int, price float64, setQuantity bool, maxQuantity int) error {
+	return r.update(ctx, userID, func(h *HashCart) error {
+		return h.AddItem(ctx, productID, quantity, price, setQuantity, maxQuantity)
+	})
+}
+
+func (r *RedisRepository) UpdateQuantity(ctx context.Context, userID int64, productID uint32, quantity int) error {
+	return r.update(ctx, userID, func(h *HashCart) error {
+		return h.UpdateQuantity(ctx, productID, quantity)
+	})
+}
+
+func (r *RedisRepository) RemoveItems(ctx context.Context, userID int64, productIDs []uint32) error {
+	return r.update(ctx, userID, func(h *HashCart) error {
+		return h.RemoveItems(ctx, productIDs)
+	})
+}
+
+func (r *RedisRepository) SelectItems(ctx context.Context, userID int64, productIDs []uint32, selected bool) error {
+	return r.update(ctx, userID, func(h *HashCart) error {
+		return h.SelectItems(ctx, productIDs, selected)
+	})
+}
+
+func (r *RedisRepository) Empty(ctx context.Context, userID int64) error {
+	// 清空后保留加载标记和版本号，避免下次访问时从MySQL加载回旧数据，回写时也不会被当作旧快照跳过
+	return r.update(ctx, userID, func(h *HashCart) error {
+		return h.write(ctx, func(pipe redis.Pipeliner) {
+			pipe.Eval(ctx, clearItemsScript, []string{h.key}, loadedField, versionField)
+		})
+	})
+}
+
+func (r *RedisRepository) SaveItems(ctx context.Context, userID int64, items []model.CartItem) error {
+	return r.update(ctx, userID, func(h *HashCart) error {
+		return h.SaveItems(ctx, items)
+	})
+}
+
+// RunWriteBack 定期将修改过的购物车回写MySQL，ctx取消时执行最后一次回写后返回
+func (r *RedisRepository) RunWriteBack(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Flush(context.Background()); err != nil {
+				log.Errorf("购物车回写失败: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Errorf("购物车回写失败: %v", err)
+			}
+		}
+	}
+}
+
+// Flush 回写所有修改过的购物车。先从脏集合中移除再读取购物车，期间发生的修改会重新记入脏集合，下一轮回写。
+func (r *RedisRepository) Flush(ctx context.Context) error {
+	for {
+		members, err := r.rdb.SPopN(ctx, dirtyCartsKey, writeBackBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return nil
+		}
+
+		for _, member := range members {
+			userID, err := strconv.ParseInt(member, 10, 64)
+			if err != nil {
+				continue
+			}
+			if err := r.writeBack(ctx, userID); err != nil {
+				// 放回脏集合等待下次回写
+				r.rdb.SAdd(ctx, dirtyCartsKey, member)
+				return fmt.Errorf("回写用户%d的购物车失败: %w", userID, err)
+			}
+		}
+	}
+}
+
+func (r *RedisRepository) writeBack(ctx context.Context, userID int64) error {
+	key := fmt.Sprintf(userCartKey, userID)
+	fields, err := r.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	// 购物车已过期，过期前的修改已经回写过
+	if _, ok := fields[loadedField]; !ok {
+		return nil
+	}
+	version, _ := strconv.ParseInt(fields[versionField], 10, 64)
+	return r.store.ReplaceItems(ctx, userID, ParseHashItems(fields), version)
+}
+
+// update 在用户的购物车上执行写操作。检查购物车存在和写入之间购物车过期时写入会被撤销，
+// 此时重新从MySQL加载后重试，避免只含本次修改的残缺购物车回写覆盖MySQL
+func (r *RedisRepository) update(ctx context.Context, userID int64, fn func(h *HashCart) error) error {
+	for attempt := 1; ; attempt++ {
+		h, err := r.cart(ctx, userID)
+		if err != nil {
+			return err
+		}
+		err = fn(h)
+		if !errors.Is(err, errCartExpired) || attempt == maxCartLoadAttempts {
+			return err
+		}
+	}
+}
+
+// cart 返回用户的购物车，Redis中没有时从MySQL加载
+func (r *RedisRepository) cart(ctx context.Context, userID int64) (*HashCart, error) {
+	h := r.hashCart(userID)
+	exists, err := r.rdb.Exists(ctx, h.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 1 {
+		return h, nil
+	}
+
+	items, version, err := r.store.Load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// 版本号从最后回写的版本继续增加
+	args := []interface{}{loadedField, "1", versionField, version}
+	for _, item := range items {
+		selected := "0"
+		if item.Selected {
+			selected = "1"
+		}
+		id := uint32(item.ProductID)
+		args = append(args,
+			HashField("q", id), item.Quantity,
+			HashField("p", id), strconv.FormatFloat(item.Price, 'f', 2, 64),
+			HashField("s", id), selected,
+		)
+	}
+	// 并发加载时只有第一个写入生效，避免覆盖其他请求的修改
+	if err := r.rdb.Eval(ctx, loadCartScript, []string{h.key}, append([]interface{}{int64(r.ttl.Seconds())}, args...)...).Err(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (r *RedisRepository) hashCart(userID int64) *HashCart {
+	h := NewHashCart(r.rdb, fmt.Sprintf(userCartKey, userID), r.ttl)
+	h.onWrite = func(ctx context.Context, pipe redis.Pipeliner) *redis.Cmd {
+		return pipe.Eval(ctx, markDirtyScript, []string{h.key, dirtyCartsKey}, loadedField, userID, versionField)
+	}
+	return h
+}
+
+// ARGV[1]为过期秒数，其余参数为字段和值
+const loadCartScript = `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], unpack(ARGV, 2))
+redis.call("EXPIRE", KEYS[1], ARGV[1])
+return 1
+`
+
+// 在写命令之后执行：购物车有加载标记时增加版本号并记入脏集合；没有时说明写入前购物车已过期，
+// 删除写入产生的残缺数据。KEYS[1]为购物车，KEYS[2]为脏集合，ARGV[1]为加载标记字段，ARGV[2]为用户ID，
+// ARGV[3]为版本号字段
+const markDirtyScript = `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	redis.call("DEL", KEYS[1])
+	return 0
+end
+redis.call("HINCRBY", KEYS[1], ARGV[3], 1)
+redis.call("SADD", KEYS[2], ARGV[2])
+return 1
+`
+
+// 删除购物车中的商品，保留ARGV中的字段
+const clearItemsScript = `
+local keep = {}
+for _, field in ipairs(ARGV) do
+	keep[field] = true
+end
+for _, field in ipairs(redis.call("HKEYS", KEYS[1])) do
+	if not keep[field] then
+		redis.call("HDEL", KEYS[1], field)
+	end
+end
+return 1
+`
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"TKMall/cmd/cart/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore 测试用的持久化存储
+type memoryStore struct {
+	mu       sync.Mutex
+	carts    map[int64][]model.CartItem
+	versions map[int64]int64
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{carts: make(map[int64][]model.CartItem), versions: make(map[int64]int64)}
+}
+
+func (m *memoryStore) Load(ctx context.Context, userID int64) ([]model.CartItem, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]model.CartItem(nil), m.carts[userID]...), m.versions[userID], nil
+}
+
+func (m *memoryStore) ReplaceItems(ctx context.Context, userID int64, items []model.CartItem, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if version <= m.versions[userID] {
+		return nil
+	}
+	m.carts[userID] = append([]model.CartItem(nil), items...)
+	m.versions[userID] = version
+	return nil
+}
+
+func newTestRedisRepository(t testing.TB) (*miniredis.Miniredis, *RedisRepository, *memoryStore) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	store := newMemoryStore()
+	return mr, NewRedisRepository(rdb, store, 0), store
+}
+
+// 测试Redis购物车的读写和回写
+func TestRedisRepositoryWriteBack(t *testing.T) {
+	ctx := context.Background()
+	mr, repo, store := newTestRedisRepository(t)
+
+	// 首次访问从持久化存储加载
+	store.carts[1] = []model.CartItem{{ProductID: 7, Quantity: 1, Price: 10, Selected: true}}
+	require.NoError(t, repo.AddItem(ctx, 1, 7, 2, 12.5, false, 99))
+	require.NoError(t, repo.AddItem(ctx, 1, 8, 1, 3, false, 99))
+	require.NoError(t, repo.SelectItems(ctx, 1, []uint32{8}, false))
+
+	items, err := repo.Items(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, 3, items[0].Quantity, "在加载的数量上累加")
+	assert.InDelta(t, 12.5, items[0].Price, 0.001)
+	assert.False(t, items[1].Selected)
+
+	assert.ErrorIs(t, repo.UpdateQuantity(ctx, 1, 9, 1), ErrItemNotFound)
+
+	// 回写前持久化存储保持不变
+	assert.Len(t, store.carts[1], 1)
+	require.NoError(t, repo.Flush(ctx))
+	assert.Equal(t, items, store.carts[1])
+	assert.False(t, mr.Exists(dirtyCartsKey), "回写后脏集合为空")
+
+	// 清空后不会从持久化存储加载回旧数据
+	require.NoError(t, repo.Empty(ctx, 1))
+	items, err = repo.Items(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+	require.NoError(t, repo.Flush(ctx))
+	assert.Empty(t, store.carts[1])
+}
+
+// 测试检查购物车存在之后购物车过期时，写入被撤销并重新加载，回写不会用残缺的购物车覆盖持久化存储
+func TestRedisRepositoryExpiredBeforeWrite(t *testing.T) {
+	ctx := context.Background()
+	mr, repo, store := newTestRedisRepository(t)
+	store.carts[1] = []model.CartItem{{ProductID: 7, Quantity: 1, Price: 10, Selected: true}}
+
+	// 模拟加载后、写入前购物车过期
+	err := repo.hashCart(1).AddItem(ctx, 8, 1, 3, false, 99)
+	assert.ErrorIs(t, err, errCartExpired)
+	assert.False(t, mr.Exists("cart:user:1"), "撤销写入产生的残缺数据")
+	assert.False(t, mr.Exists(dirtyCartsKey))
+
+	require.NoError(t, repo.AddItem(ctx, 1, 8, 1, 3, false, 99))
+	require.NoError(t, repo.Flush(ctx))
+	require.Len(t, store.carts[1], 2, "重新加载后写入")
+	assert.Equal(t, uint(7), store.carts[1][0].ProductID)
+	assert.Equal(t, uint(8), store.carts[1][1].ProductID)
+}
+
+// 测试回写按版本号进行：较早读取的快照不会覆盖较新的回写，购物车过期重新加载后版本号继续增加
+func TestRedisRepositoryStaleWriteBack(t *testing.T) {
+	ctx := context.Background()
+	mr, repo, store := newTestRedisRepository(t)
+
+	require.NoError(t, repo.AddItem(ctx, 1, 7, 1, 10, false, 99))
+	// 模拟另一个实例在此时读取了快照，回写前暂停
+	stale, err := repo.Items(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "1", mr.HGet("cart:user:1", versionField))
+
+	require.NoError(t, repo.AddItem(ctx, 1, 8, 1, 3, false, 99))
+	require.NoError(t, repo.Flush(ctx))
+	require.Len(t, store.carts[1], 2)
+	assert.Equal(t, int64(2), store.versions[1])
+
+	require.NoError(t, store.ReplaceItems(ctx, 1, stale, 1))
+	assert.Len(t, store.carts[1], 2, "较早的快照不覆盖较新的回写")
+
+	// 过期后从持久化存储加载版本号，清空也会增加版本号
+	mr.Del("cart:user:1")
+	require.NoError(t, repo.Empty(ctx, 1))
+	assert.Equal(t, "3", mr.HGet("cart:user:1", versionField))
+	require.NoError(t, repo.Flush(ctx))
+	assert.Empty(t, store.carts[1])
+	assert.Equal(t, int64(3), store.versions[1])
+}
+
+// 测试MySQL回写跳过不大于已保存版本的快照
+func TestMySQLRepositoryReplaceItemsVersion(t *testing.T) {
+	const userID = testUserIDBase + 44
+	ctx := context.Background()
+	repo := NewMySQLRepository(openTestDB(t, userID))
+
+	newer := []model.CartItem{{ProductID: 7, Quantity: 2, Price: 10, Selected: true}, {ProductID: 8, Quantity: 1, Price: 3, Selected: true}}
+	require.NoError(t, repo.ReplaceItems(ctx, userID, newer, 2))
+	require.NoError(t, repo.ReplaceItems(ctx, userID, newer[:1], 1))
+	require.NoError(t, repo.ReplaceItems(ctx, userID, nil, 2))
+
+	items, version, err := repo.Load(ctx, userID)
+	require.NoError(t, err)
+	assert.Len(t, items, 2, "较早或相同版本的快照不覆盖")
+	assert.Equal(t, int64(2), version)
+}
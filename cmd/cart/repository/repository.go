@@ -0,0 +1,59 @@
+// Package repository 登录用户购物车的存储，支持MySQL和Redis两种实现，通过配置cart.storage选择。
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"TKMall/cmd/cart/model"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// ErrItemNotFound 购物车中没有该商品
+var ErrItemNotFound = errors.New("购物车中没有该商品")
+
+// ErrQuantityLimit 加入后商品数量超过上限，购物车没有修改
+var ErrQuantityLimit = errors.New("商品数量超过上限")
+
+const (
+	StorageMySQL = "mysql"
+	StorageRedis = "redis"
+)
+
+// CartRepository 按用户ID读写购物车，返回的商品按加入顺序或商品ID排序
+type CartRepository interface {
+	Items(ctx context.Context, userID int64) ([]model.CartItem, error)
+	// AddItem setQuantity为false时在已有数量上累加，同时更新价格快照并勾选该商品。
+	// 加入后的数量超过maxQuantity时不修改购物车，返回ErrQuantityLimit，并发加购时也不会超过上限
+	AddItem(ctx context.Context, userID int64, productID uint32, quantity int, price float64, setQuantity bool, maxQuantity int) error
+	UpdateQuantity(ctx context.Context, userID int64, productID uint32, quantity int) error
+	RemoveItems(ctx context.Context, userID int64, productIDs []uint32) error
+	// SelectItems productIDs为空时作用于所有商品
+	SelectItems(ctx context.Context, userID int64, productIDs []uint32, selected bool) error
+	Empty(ctx context.Context, userID int64) error
+	// SaveItems 按商品ID写入数量、价格快照和勾选状态，不在items中的商品保持不变
+	SaveItems(ctx context.Context, userID int64, items []model.CartItem) error
+}
+
+// Options 创建购物车存储的参数
+type Options struct {
+	Storage string
+	// Redis存储中购物车的过期时间，过期后从MySQL重新加载
+	RedisTTL time.Duration
+}
+
+// New 根据配置创建购物车存储，Redis存储以MySQL作为持久化存储
+func New(opts Options, db *gorm.DB, rdb *redis.Client) (CartRepository, error) {
+	mysqlRepo := NewMySQLRepository(db)
+	switch opts.Storage {
+	case "", StorageMySQL:
+		return mysqlRepo, nil
+	case StorageRedis:
+		return NewRedisRepository(rdb, mysqlRepo, opts.RedisTTL), nil
+	}
+	return nil, fmt.Errorf("不支持的购物车存储: %s", opts.Storage)
+}
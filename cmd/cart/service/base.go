@@ -1,7 +1,10 @@
 package service
 
 import (
+	"time"
+
 	"TKMall/build/proto_gen/cart"
+	"TKMall/cmd/cart/repository"
 	"TKMall/common/events"
 	"TKMall/common/proxy"
 
@@ -18,4 +21,9 @@ type CartServiceServer struct {
 	Node     *snowflake.Node
 	Proxy    proxy.ServiceProxy
 	EventBus events.EventBus
+	Repo     repository.CartRepository // 登录用户购物车的存储
+
+	// 游客购物车过期时间，为0时使用DefaultGuestCartTTL
+	GuestCartTTL time.Duration
+	Limits       CartLimits
 }
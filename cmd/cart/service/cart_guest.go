@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"TKMall/cmd/cart/model"
+	"TKMall/cmd/cart/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 游客购物车存放在Redis哈希中，结构见repository.HashCart
+const (
+	guestCartKey        = "cart:guest:%s"
+	guestCartMergingKey = "cart:guest:merging:%s"
+
+	DefaultGuestCartTTL = 7 * 24 * time.Hour
+
+	cartTokenMinLength = 16
+	cartTokenMaxLength = 64
+)
+
+// checkCartOwner 校验购物车归属：登录用户使用user_id，游客使用网关签发的cart_token
+func checkCartOwner(userID int64, cartToken string) error {
+	if userID != 0 {
+		return nil
+	}
+	if cartToken == "" {
+		return status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if !validCartToken(cartToken) {
+		return status.Error(codes.InvalidArgument, "无效的购物车令牌")
+	}
+	return nil
+}
+
+func validCartToken(token string) bool {
+	if len(token) < cartTokenMinLength || len(token) > cartTokenMaxLength {
+		return false
+	}
+	for _, c := range token {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *CartServiceServer) guestCartTTL() time.Duration {
+	if s.GuestCartTTL > 0 {
+		return s.GuestCartTTL
+	}
+	return DefaultGuestCartTTL
+}
+
+// claimGuestCart 将游客购物车改名后读取，保证同一个购物车只会被合并一次。
+// 返回的key在合并成功后删除，失败时通过releaseGuestCart恢复。
+func (s *CartServiceServer) claimGuestCart(ctx context.Context, token string) ([]model.CartItem, string, error) {
+	key := fmt.Sprintf(guestCartKey, token)
+	mergingKey := fmt.Sprintf(guestCartMergingKey, token)
+	if err := s.Redis.Rename(ctx, key, mergingKey).Err(); err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return nil, "", nil
+		}
+		return nil, "", status.Errorf(codes.Internal, "获取游客购物车失败: %v", err)
+	}
+
+	items, err := repository.NewHashCart(s.Redis, mergingKey, 0).Items(ctx)
+	if err != nil {
+		s.releaseGuestCart(ctx, token)
+		return nil, "", status.Errorf(codes.Internal, "获取游客购物车失败: %v", err)
+	}
+	return items, mergingKey, nil
+}
+
+// releaseGuestCart 合并失败时恢复游客购物车，期间游客又添加了商品时以新购物车为准
+func (s *CartServiceServer) releaseGuestCart(ctx context.Context, token string) {
+	s.Redis.RenameNX(ctx, fmt.Sprintf(guestCartMergingKey, token), fmt.Sprintf(guestCartKey, token))
+}
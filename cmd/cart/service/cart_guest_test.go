@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试游客购物车合并时的数量规则
+func TestMergeQuantity(t *testing.T) {
+	tests := []struct {
+		name                   string
+		existing, guest, stock int
+		want                   int
+	}{
+		{"库存充足时相加", 2, 3, 10, 5},
+		{"超过库存取库存", 2, 3, 4, 4},
+		{"新商品超过库存", 0, 5, 3, 3},
+		{"已售罄不加入", 0, 2, 0, 0},
+		{"库存低于原有数量时保持不变", 5, 1, 3, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mergeQuantity(tt.existing, tt.guest, tt.stock))
+		})
+	}
+}
+
+// 测试游客购物车令牌格式
+func TestValidCartToken(t *testing.T) {
+	assert.True(t, validCartToken("0123456789abcdef0123456789abcdef"))
+	assert.False(t, validCartToken("short"))
+	assert.False(t, validCartToken("0123456789abcdef:cart"))
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/cmd/cart/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UpdateItemQuantity 修改购物车中商品的数量
+func (s *CartServiceServer) UpdateItemQuantity(ctx context.Context, req *cart.UpdateItemQuantityReq) (*cart.UpdateItemQuantityResp, error) {
+	// 参数校验
+	if err := checkCartOwner(req.UserId, req.CartToken); err != nil {
+		return nil, err
+	}
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+	if req.Quantity <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品数量必须大于0，删除商品请使用RemoveItem")
+	}
+
+	products, err := s.fetchProducts(ctx, []uint32{req.ProductId})
+	if err != nil {
+		return nil, err
+	}
+	if v := checkItem(req.ProductId, int(req.Quantity), products[req.ProductId], s.limits()); v != nil {
+		return nil, violationError(v)
+	}
+
+	err = s.cartStore(req.UserId, req.CartToken).UpdateQuantity(ctx, req.ProductId, int(req.Quantity))
+	if err != nil {
+		if errors.Is(err, repository.ErrItemNotFound) {
+			return nil, status.Error(codes.NotFound, "购物车中没有该商品")
+		}
+		return nil, status.Errorf(codes.Internal, "修改商品数量失败: %v", err)
+	}
+
+	return &cart.UpdateItemQuantityResp{}, nil
+}
+
+// RemoveItem 从购物车中删除指定商品，商品不在购物车中时忽略
+func (s *CartServiceServer) RemoveItem(ctx context.Context, req *cart.RemoveItemReq) (*cart.RemoveItemResp, error) {
+	// 参数校验
+	if err := checkCartOwner(req.UserId, req.CartToken); err != nil {
+		return nil, err
+	}
+	if len(req.ProductIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	if err := s.cartStore(req.UserId, req.CartToken).RemoveItems(ctx, req.ProductIds); err != nil {
+		return nil, status.Errorf(codes.Internal, "删除购物车商品失败: %v", err)
+	}
+
+	return &cart.RemoveItemResp{}, nil
+}
+
+// SelectItems 勾选或取消勾选购物车商品，结算时只购买勾选的商品
+func (s *CartServiceServer) SelectItems(ctx context.Context, req *cart.SelectItemsReq) (*cart.SelectItemsResp, error) {
+	// 参数校验
+	if err := checkCartOwner(req.UserId, req.CartToken); err != nil {
+		return nil, err
+	}
+
+	if err := s.cartStore(req.UserId, req.CartToken).SelectItems(ctx, req.ProductIds, req.Selected); err != nil {
+		return nil, status.Errorf(codes.Internal, "更新勾选状态失败: %v", err)
+	}
+
+	return &cart.SelectItemsResp{}, nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/cart/model"
+	"TKMall/common/proxy"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testCartToken = "0123456789abcdef0123456789abcdef"
+
+// productProxy 模拟商品服务的批量查询，只返回请求中存在的商品
+type productProxy struct {
+	products map[uint32]*product.Product
+}
+
+func (p *productProxy) Call(ctx context.Context, service, method string, req interface{}) (interface{}, error) {
+	resp := &product.BatchGetProductsResp{}
+	for _, id := range req.(*product.BatchGetProductsReq).Ids {
+		if prod, ok := p.products[id]; ok {
+			resp.Products = append(resp.Products, prod)
+		}
+	}
+	return resp, nil
+}
+
+func (p *productProxy) AsyncCall(ctx context.Context, service, method string, req interface{}) (<-chan proxy.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+// newItemTestServer 使用游客购物车测试，商品1、2、3库存均为10
+func newItemTestServer(t *testing.T) *CartServiceServer {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	products := make(map[uint32]*product.Product)
+	for _, id := range []uint32{1, 2, 3} {
+		products[id] = &product.Product{Id: id, Name: "商品", Price: 10, Stock: 10, Available: true}
+	}
+	return &CartServiceServer{Redis: client, Proxy: &productProxy{products: products}}
+}
+
+func addTestItem(t *testing.T, s *CartServiceServer, productID uint32, quantity int32, setQuantity bool) {
+	_, err := s.AddItem(context.Background(), &cart.AddItemReq{
+		CartToken:   testCartToken,
+		Item:        &cart.CartItem{ProductId: productID, Quantity: quantity},
+		SetQuantity: setQuantity,
+	})
+	require.NoError(t, err)
+}
+
+func testCartItems(t *testing.T, s *CartServiceServer) []model.CartItem {
+	items, err := s.cartStore(0, testCartToken).Items(context.Background())
+	require.NoError(t, err)
+	return items
+}
+
+// 测试加入购物车默认累加数量，SetQuantity时直接设置为指定数量
+func TestAddItemSetQuantity(t *testing.T) {
+	s := newItemTestServer(t)
+
+	addTestItem(t, s, 1, 2, false)
+	addTestItem(t, s, 1, 3, false)
+	assert.Equal(t, 5, testCartItems(t, s)[0].Quantity)
+
+	addTestItem(t, s, 1, 4, true)
+	assert.Equal(t, 4, testCartItems(t, s)[0].Quantity)
+
+	// 设置的数量按库存校验，不与原有数量相加
+	addTestItem(t, s, 1, 10, true)
+	assert.Equal(t, 10, testCartItems(t, s)[0].Quantity)
+
+	_, err := s.AddItem(context.Background(), &cart.AddItemReq{
+		CartToken: testCartToken,
+		Item:      &cart.CartItem{ProductId: 1, Quantity: 1},
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "累加后超过库存")
+}
+
+// 测试修改数量只作用于购物车中已有的商品
+func TestUpdateItemQuantity(t *testing.T) {
+	s := newItemTestServer(t)
+	ctx := context.Background()
+	addTestItem(t, s, 1, 2, false)
+
+	_, err := s.UpdateItemQuantity(ctx, &cart.UpdateItemQuantityReq{CartToken: testCartToken, ProductId: 1, Quantity: 6})
+	require.NoError(t, err)
+	assert.Equal(t, 6, testCartItems(t, s)[0].Quantity)
+
+	_, err = s.UpdateItemQuantity(ctx, &cart.UpdateItemQuantityReq{CartToken: testCartToken, ProductId: 2, Quantity: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Len(t, testCartItems(t, s), 1, "不在购物车中的商品不会被加入")
+
+	_, err = s.UpdateItemQuantity(ctx, &cart.UpdateItemQuantityReq{CartToken: testCartToken, ProductId: 1, Quantity: 0})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.UpdateItemQuantity(ctx, &cart.UpdateItemQuantityReq{CartToken: testCartToken, ProductId: 1, Quantity: 11})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "超过库存")
+	assert.Equal(t, 6, testCartItems(t, s)[0].Quantity)
+}
+
+// 测试删除商品，不在购物车中的商品忽略
+func TestRemoveItem(t *testing.T) {
+	s := newItemTestServer(t)
+	ctx := context.Background()
+	addTestItem(t, s, 1, 1, false)
+	addTestItem(t, s, 2, 1, false)
+
+	_, err := s.RemoveItem(ctx, &cart.RemoveItemReq{CartToken: testCartToken, ProductIds: []uint32{1, 3}})
+	require.NoError(t, err)
+	items := testCartItems(t, s)
+	require.Len(t, items, 1)
+	assert.Equal(t, uint(2), items[0].ProductID)
+
+	_, err = s.RemoveItem(ctx, &cart.RemoveItemReq{CartToken: testCartToken, ProductIds: []uint32{3}})
+	assert.NoError(t, err)
+
+	_, err = s.RemoveItem(ctx, &cart.RemoveItemReq{CartToken: testCartToken})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// 测试勾选指定商品和不指定商品时全部勾选
+func TestSelectItems(t *testing.T) {
+	s := newItemTestServer(t)
+	ctx := context.Background()
+	for _, id := range []uint32{1, 2, 3} {
+		addTestItem(t, s, id, 1, false)
+	}
+	selected := func() []bool {
+		var result []bool
+		for _, item := range testCartItems(t, s) {
+			result = append(result, item.Selected)
+		}
+		return result
+	}
+
+	_, err := s.SelectItems(ctx, &cart.SelectItemsReq{CartToken: testCartToken, ProductIds: []uint32{1, 3}, Selected: false})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, false}, selected())
+
+	// 不在购物车中的商品不会被加入
+	_, err = s.SelectItems(ctx, &cart.SelectItemsReq{CartToken: testCartToken, ProductIds: []uint32{4}, Selected: true})
+	require.NoError(t, err)
+	assert.Len(t, testCartItems(t, s), 3)
+
+	_, err = s.SelectItems(ctx, &cart.SelectItemsReq{CartToken: testCartToken, Selected: false})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false, false}, selected())
+
+	_, err = s.SelectItems(ctx, &cart.SelectItemsReq{CartToken: testCartToken, Selected: true})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true}, selected())
+}
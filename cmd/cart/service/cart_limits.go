@@ -0,0 +1,174 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/cart/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultMaxItemQuantity  = 99
+	DefaultMaxOrderQuantity = 200
+	DefaultMaxCartLines     = 100
+)
+
+// CartLimits 购物车数量限制，为0的字段使用默认值
+type CartLimits struct {
+	MaxItemQuantity  int // 单件商品的数量上限，商品自身的限购数量另外校验
+	MaxOrderQuantity int // 一次结算的商品总数上限
+	MaxLines         int // 购物车中的商品种类上限
+}
+
+func (l CartLimits) withDefaults() CartLimits {
+	if l.MaxItemQuantity <= 0 {
+		l.MaxItemQuantity = DefaultMaxItemQuantity
+	}
+	if l.MaxOrderQuantity <= 0 {
+		l.MaxOrderQuantity = DefaultMaxOrderQuantity
+	}
+	if l.MaxLines <= 0 {
+		l.MaxLines = DefaultMaxCartLines
+	}
+	return l
+}
+
+// maxQuantity 商品当前最多能购买的数量，取库存、商品限购和单件上限中的最小值
+func maxQuantity(p *product.Product, limits CartLimits) int {
+	if p == nil || !p.Available || p.Stock <= 0 {
+		return 0
+	}
+	max := int(p.Stock)
+	if p.PurchaseLimit > 0 && int(p.PurchaseLimit) < max {
+		max = int(p.PurchaseLimit)
+	}
+	if limits.MaxItemQuantity < max {
+		max = limits.MaxItemQuantity
+	}
+	return max
+}
+
+// checkItem 校验单个商品能否按quantity购买，通过时返回nil。商品不存在时p为nil。
+func checkItem(productID uint32, quantity int, p *product.Product, limits CartLimits) *cart.CartViolation {
+	violation := func(reason cart.CartViolationReason, limit int, format string, args ...interface{}) *cart.CartViolation {
+		return &cart.CartViolation{
+			ProductId: productID,
+			Reason:    reason,
+			Message:   fmt.Sprintf(format, args...),
+			Limit:     int32(limit),
+		}
+	}
+
+	switch {
+	case p == nil:
+		return violation(cart.CartViolationReason_PRODUCT_NOT_FOUND, 0, "商品%d不存在", productID)
+	case !p.Available:
+		return violation(cart.CartViolationReason_PRODUCT_UNAVAILABLE, 0, "%s已下架", productName(p))
+	case p.Stock <= 0:
+		return violation(cart.CartViolationReason_OUT_OF_STOCK, 0, "%s已售罄", productName(p))
+	case quantity > int(p.Stock):
+		return violation(cart.CartViolationReason_INSUFFICIENT_STOCK, int(p.Stock), "%s库存不足，最多可购买%d件", productName(p), p.Stock)
+	case p.PurchaseLimit > 0 && quantity > int(p.PurchaseLimit):
+		return violation(cart.CartViolationReason_EXCEEDS_PURCHASE_LIMIT, int(p.PurchaseLimit), "%s每单限购%d件", productName(p), p.PurchaseLimit)
+	case quantity > limits.MaxItemQuantity:
+		return violation(cart.CartViolationReason_EXCEEDS_ITEM_QUANTITY, limits.MaxItemQuantity, "%s单次最多购买%d件", productName(p), limits.MaxItemQuantity)
+	}
+	return nil
+}
+
+// quantityLimitViolation 写入时加入后的数量超过maxQuantity，返回起限制作用的原因
+func quantityLimitViolation(productID uint32, p *product.Product, limits CartLimits) *cart.CartViolation {
+	return checkItem(productID, maxQuantity(p, limits)+1, p, limits)
+}
+
+// checkAdd 校验商品加入购物车后是否超出限制，cartItems为购物车中现有的商品。
+// setQuantity为false时在已有数量上累加quantity。
+func checkAdd(cartItems []model.CartItem, productID uint32, quantity int, setQuantity bool, p *product.Product, limits CartLimits) *cart.CartViolation {
+	inCart := false
+	for _, item := range cartItems {
+		if uint32(item.ProductID) == productID {
+			inCart = true
+			if !setQuantity {
+				quantity += item.Quantity
+			}
+			break
+		}
+	}
+	if v := checkItem(productID, quantity, p, limits); v != nil {
+		return v
+	}
+	if !inCart && len(cartItems) >= limits.MaxLines {
+		return tooManyLines(limits)
+	}
+	return nil
+}
+
+// checkCart 校验购物车能否结算：商品种类不超过上限，已勾选的商品逐个校验，总数量不超过单笔订单上限
+func checkCart(items []model.CartItem, products map[uint32]*product.Product, limits CartLimits) []*cart.CartViolation {
+	var violations []*cart.CartViolation
+	if len(items) > limits.MaxLines {
+		violations = append(violations, tooManyLines(limits))
+	}
+
+	total := 0
+	for _, item := range items {
+		if !item.Selected {
+			continue
+		}
+		productID := uint32(item.ProductID)
+		if v := checkItem(productID, item.Quantity, products[productID], limits); v != nil {
+			violations = append(violations, v)
+		}
+		total += item.Quantity
+	}
+	if total > limits.MaxOrderQuantity {
+		violations = append(violations, &cart.CartViolation{
+			Reason:  cart.CartViolationReason_EXCEEDS_ORDER_QUANTITY,
+			Message: fmt.Sprintf("单笔订单最多购买%d件商品", limits.MaxOrderQuantity),
+			Limit:   int32(limits.MaxOrderQuantity),
+		})
+	}
+	return violations
+}
+
+// violationError 将校验失败的原因作为错误详情返回，商品不存在时为NotFound，其余为FailedPrecondition
+func violationError(violations ...*cart.CartViolation) error {
+	code := codes.FailedPrecondition
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		if v.Reason == cart.CartViolationReason_PRODUCT_NOT_FOUND && len(violations) == 1 {
+			code = codes.NotFound
+		}
+		messages = append(messages, v.Message)
+	}
+
+	st := status.New(code, strings.Join(messages, "；"))
+	if detailed, err := st.WithDetails(&cart.CartViolations{Violations: violations}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func tooManyLines(limits CartLimits) *cart.CartViolation {
+	return &cart.CartViolation{
+		Reason:  cart.CartViolationReason_TOO_MANY_ITEMS,
+		Message: fmt.Sprintf("购物车最多存放%d种商品", limits.MaxLines),
+		Limit:   int32(limits.MaxLines),
+	}
+}
+
+func productName(p *product.Product) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("商品%d", p.Id)
+}
+
+func (s *CartServiceServer) limits() CartLimits {
+	return s.Limits.withDefaults()
+}
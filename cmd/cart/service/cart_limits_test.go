@@ -0,0 +1,118 @@
+package service
+
+import (
+	"testing"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/cart/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 测试单个商品的数量校验
+func TestCheckItem(t *testing.T) {
+	limits := CartLimits{MaxItemQuantity: 10}.withDefaults()
+	onSale := &product.Product{Id: 1, Name: "耳机", Stock: 20, Available: true}
+	limited := &product.Product{Id: 2, Name: "显卡", Stock: 20, Available: true, PurchaseLimit: 2}
+
+	tests := []struct {
+		name     string
+		p        *product.Product
+		quantity int
+		reason   cart.CartViolationReason
+		limit    int32
+	}{
+		{"正常购买", onSale, 5, cart.CartViolationReason_CART_VIOLATION_UNSPECIFIED, 0},
+		{"商品不存在", nil, 1, cart.CartViolationReason_PRODUCT_NOT_FOUND, 0},
+		{"已下架", &product.Product{Id: 1, Stock: 5}, 1, cart.CartViolationReason_PRODUCT_UNAVAILABLE, 0},
+		{"已售罄", &product.Product{Id: 1, Available: true}, 1, cart.CartViolationReason_OUT_OF_STOCK, 0},
+		{"库存不足", &product.Product{Id: 1, Stock: 3, Available: true}, 4, cart.CartViolationReason_INSUFFICIENT_STOCK, 3},
+		{"超过商品限购", limited, 3, cart.CartViolationReason_EXCEEDS_PURCHASE_LIMIT, 2},
+		{"超过单件上限", onSale, 11, cart.CartViolationReason_EXCEEDS_ITEM_QUANTITY, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := checkItem(1, tt.quantity, tt.p, limits)
+			if tt.reason == cart.CartViolationReason_CART_VIOLATION_UNSPECIFIED {
+				assert.Nil(t, v)
+				return
+			}
+			require.NotNil(t, v)
+			assert.Equal(t, tt.reason, v.Reason)
+			assert.Equal(t, tt.limit, v.Limit)
+			assert.NotEmpty(t, v.Message)
+		})
+	}
+}
+
+// 测试可购买数量取各项限制的最小值
+func TestMaxQuantity(t *testing.T) {
+	limits := CartLimits{MaxItemQuantity: 10}.withDefaults()
+	assert.Equal(t, 3, maxQuantity(&product.Product{Stock: 3, Available: true}, limits))
+	assert.Equal(t, 2, maxQuantity(&product.Product{Stock: 30, Available: true, PurchaseLimit: 2}, limits))
+	assert.Equal(t, 10, maxQuantity(&product.Product{Stock: 30, Available: true}, limits))
+	assert.Equal(t, 0, maxQuantity(&product.Product{Stock: 30}, limits))
+	assert.Equal(t, 0, maxQuantity(nil, limits))
+
+	// 写入时超过上限，返回起限制作用的原因
+	v := quantityLimitViolation(2, &product.Product{Id: 2, Stock: 30, Available: true, PurchaseLimit: 2}, limits)
+	require.NotNil(t, v)
+	assert.Equal(t, cart.CartViolationReason_EXCEEDS_PURCHASE_LIMIT, v.Reason)
+	v = quantityLimitViolation(1, &product.Product{Id: 1, Stock: 3, Available: true}, limits)
+	require.NotNil(t, v)
+	assert.Equal(t, cart.CartViolationReason_INSUFFICIENT_STOCK, v.Reason)
+}
+
+// 测试结算前的整体校验只检查已勾选的商品
+func TestCheckCart(t *testing.T) {
+	limits := CartLimits{MaxOrderQuantity: 5, MaxLines: 2}.withDefaults()
+	products := map[uint32]*product.Product{
+		1: {Id: 1, Stock: 10, Available: true},
+		2: {Id: 2, Stock: 10, Available: true},
+	}
+
+	t.Run("通过", func(t *testing.T) {
+		items := []model.CartItem{{ProductID: 1, Quantity: 2, Selected: true}, {ProductID: 2, Quantity: 3, Selected: true}}
+		assert.Empty(t, checkCart(items, products, limits))
+	})
+
+	t.Run("未勾选的商品不校验", func(t *testing.T) {
+		items := []model.CartItem{{ProductID: 1, Quantity: 2, Selected: true}, {ProductID: 9, Quantity: 9}}
+		assert.Empty(t, checkCart(items, products, limits))
+	})
+
+	t.Run("多项原因同时返回", func(t *testing.T) {
+		items := []model.CartItem{
+			{ProductID: 1, Quantity: 4, Selected: true},
+			{ProductID: 2, Quantity: 3, Selected: true},
+			{ProductID: 9, Quantity: 1, Selected: true},
+		}
+		var reasons []cart.CartViolationReason
+		for _, v := range checkCart(items, products, limits) {
+			reasons = append(reasons, v.Reason)
+		}
+		assert.Equal(t, []cart.CartViolationReason{
+			cart.CartViolationReason_TOO_MANY_ITEMS,
+			cart.CartViolationReason_PRODUCT_NOT_FOUND,
+			cart.CartViolationReason_EXCEEDS_ORDER_QUANTITY,
+		}, reasons)
+	})
+}
+
+// 测试校验失败的原因作为错误详情返回
+func TestViolationError(t *testing.T) {
+	v := checkItem(1, 1, nil, CartLimits{}.withDefaults())
+	st := status.Convert(violationError(v))
+	assert.Equal(t, codes.NotFound, st.Code())
+	require.Len(t, st.Details(), 1)
+	details, ok := st.Details()[0].(*cart.CartViolations)
+	require.True(t, ok)
+	assert.Equal(t, cart.CartViolationReason_PRODUCT_NOT_FOUND, details.Violations[0].Reason)
+
+	stock := checkItem(2, 5, &product.Product{Id: 2, Stock: 1, Available: true}, CartLimits{}.withDefaults())
+	assert.Equal(t, codes.FailedPrecondition, status.Code(violationError(v, stock)))
+}
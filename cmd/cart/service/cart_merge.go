@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/cmd/cart/model"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MergeGuestCart 登录后将游客购物车合并到用户购物车。同一商品数量相加，不超过当前可购买数量；
+// 已下架或不存在的商品以及超出商品种类上限的商品丢弃。合并成功后删除游客购物车。
+func (s *CartServiceServer) MergeGuestCart(ctx context.Context, req *cart.MergeGuestCartReq) (*cart.MergeGuestCartResp, error) {
+	// 参数校验
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if !validCartToken(req.CartToken) {
+		return nil, status.Error(codes.InvalidArgument, "无效的购物车令牌")
+	}
+
+	guestItems, mergingKey, err := s.claimGuestCart(ctx, req.CartToken)
+	if err != nil {
+		return nil, err
+	}
+	if mergingKey == "" {
+		return &cart.MergeGuestCartResp{}, nil
+	}
+
+	merged, err := s.mergeIntoUserCart(ctx, req.UserId, guestItems)
+	if err != nil {
+		s.releaseGuestCart(ctx, req.CartToken)
+		return nil, err
+	}
+
+	if err := s.Redis.Del(ctx, mergingKey).Err(); err != nil {
+		log.Errorf("删除已合并的游客购物车失败: %v", err)
+	}
+	return &cart.MergeGuestCartResp{MergedItems: int32(merged)}, nil
+}
+
+func (s *CartServiceServer) mergeIntoUserCart(ctx context.Context, userID int64, guestItems []model.CartItem) (int, error) {
+	if len(guestItems) == 0 {
+		return 0, nil
+	}
+
+	productIDs := make([]uint32, 0, len(guestItems))
+	for _, item := range guestItems {
+		productIDs = append(productIDs, uint32(item.ProductID))
+	}
+	products, err := s.fetchProducts(ctx, productIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	existingItems, err := s.Repo.Items(ctx, userID)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "获取购物车失败: %v", err)
+	}
+	existing := make(map[uint]*model.CartItem, len(existingItems))
+	for i := range existingItems {
+		existing[existingItems[i].ProductID] = &existingItems[i]
+	}
+
+	limits := s.limits()
+	lines := len(existingItems)
+	merged := make([]model.CartItem, 0, len(guestItems))
+	for _, guestItem := range guestItems {
+		p, ok := products[uint32(guestItem.ProductID)]
+		if !ok || !p.Available {
+			continue
+		}
+
+		// 已有商品保留用户购物车中的价格快照
+		item := guestItem
+		existingQuantity := 0
+		current, inCart := existing[guestItem.ProductID]
+		if inCart {
+			existingQuantity = current.Quantity
+			item.Price = current.Price
+		} else if lines >= limits.MaxLines {
+			continue
+		}
+		item.Quantity = mergeQuantity(existingQuantity, guestItem.Quantity, maxQuantity(p, limits))
+		if item.Quantity == 0 {
+			continue
+		}
+		if !inCart {
+			lines++
+		}
+		item.Selected = true
+		merged = append(merged, item)
+	}
+
+	if err := s.Repo.SaveItems(ctx, userID, merged); err != nil {
+		return 0, status.Errorf(codes.Internal, "合并购物车失败: %v", err)
+	}
+	return len(merged), nil
+}
+
+// mergeQuantity 计算合并后的数量：两边相加，超过可购买数量时取上限，但不会减少用户购物车中原有的数量
+func mergeQuantity(existing, guest, max int) int {
+	sum := existing + guest
+	if sum <= max {
+		return sum
+	}
+	if max > existing {
+		return max
+	}
+	return existing
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"math"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/cart/model"
+	"TKMall/common/proxy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fetchProducts 通过商品服务查询实时售价和库存，返回商品ID到商品的映射，不存在的商品不在结果中
+func (s *CartServiceServer) fetchProducts(ctx context.Context, productIDs []uint32) (map[uint32]*product.Product, error) {
+	products := make(map[uint32]*product.Product, len(productIDs))
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+
+	// 价格和库存需要实时数据，不使用代理层的响应缓存
+	respInterface, err := s.Proxy.Call(proxy.WithoutCache(ctx), "product", "BatchGetProducts", &product.BatchGetProductsReq{
+		Ids: productIDs,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "查询商品信息失败: %v", err)
+	}
+	resp, ok := respInterface.(*product.BatchGetProductsResp)
+	if !ok {
+		return nil, status.Error(codes.Internal, "响应类型转换失败")
+	}
+
+	for _, p := range resp.Products {
+		products[p.Id] = p
+	}
+	return products, nil
+}
+
+// currentPrice 商品当前的实际售价，老版本商品服务未返回实际售价时使用基础价格
+func currentPrice(p *product.Product) float64 {
+	if p.EffectivePrice > 0 {
+		return roundPrice(float64(p.EffectivePrice))
+	}
+	return roundPrice(float64(p.Price))
+}
+
+// buildItemDetail 根据购物车项和商品当前信息生成展示数据，商品已不存在时p为nil
+func buildItemDetail(item *model.CartItem, p *product.Product) *cart.CartItemDetail {
+	detail := &cart.CartItemDetail{
+		ProductId:     uint32(item.ProductID),
+		Quantity:      int32(item.Quantity),
+		SnapshotPrice: float32(item.Price),
+		Selected:      item.Selected,
+	}
+	if p == nil {
+		return detail
+	}
+
+	price := currentPrice(p)
+	detail.Name = p.Name
+	detail.Picture = p.Picture
+	detail.UnitPrice = float32(price)
+	detail.PriceChanged = !samePrice(price, item.Price)
+	detail.InStock = p.Available && int(p.Stock) >= item.Quantity
+	detail.LineTotal = float32(roundPrice(price * float64(item.Quantity)))
+	return detail
+}
+
+func roundPrice(price float64) float64 {
+	return math.Round(price*100) / 100
+}
+
+func samePrice(a, b float64) bool {
+	return math.Round(a*100) == math.Round(b*100)
+}
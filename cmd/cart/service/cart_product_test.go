@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/cart/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试购物车项的价格变化和库存标记
+func TestBuildItemDetail(t *testing.T) {
+	item := &model.CartItem{ProductID: 101, Quantity: 2, Price: 199.99}
+
+	t.Run("价格未变且有货", func(t *testing.T) {
+		d := buildItemDetail(item, &product.Product{Id: 101, Name: "耳机", Price: 199.99, EffectivePrice: 199.99, Stock: 5, Available: true})
+		assert.Equal(t, "耳机", d.Name)
+		assert.False(t, d.PriceChanged)
+		assert.True(t, d.InStock)
+		assert.InDelta(t, 399.98, d.LineTotal, 0.001)
+	})
+
+	t.Run("促销降价", func(t *testing.T) {
+		d := buildItemDetail(item, &product.Product{Id: 101, Price: 199.99, EffectivePrice: 149, Stock: 5, Available: true})
+		assert.True(t, d.PriceChanged)
+		assert.InDelta(t, 149, d.UnitPrice, 0.001)
+		assert.InDelta(t, 199.99, d.SnapshotPrice, 0.001)
+	})
+
+	t.Run("库存不足", func(t *testing.T) {
+		d := buildItemDetail(item, &product.Product{Id: 101, Price: 199.99, Stock: 1, Available: true})
+		assert.False(t, d.InStock)
+		assert.False(t, d.PriceChanged, "没有实际售价时使用基础价格")
+	})
+
+	t.Run("已下架", func(t *testing.T) {
+		d := buildItemDetail(item, &product.Product{Id: 101, Price: 199.99, Stock: 5})
+		assert.False(t, d.InStock)
+	})
+
+	t.Run("商品已删除", func(t *testing.T) {
+		d := buildItemDetail(item, nil)
+		assert.False(t, d.InStock)
+		assert.Zero(t, d.LineTotal)
+		assert.InDelta(t, 199.99, d.SnapshotPrice, 0.001)
+	})
+}
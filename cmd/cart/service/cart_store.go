@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"TKMall/cmd/cart/model"
+	"TKMall/cmd/cart/repository"
+)
+
+// cartStore 单个购物车的读写，登录用户和游客购物车使用相同的操作
+type cartStore interface {
+	Items(ctx context.Context) ([]model.CartItem, error)
+	AddItem(ctx context.Context, productID uint32, quantity int, price float64, setQuantity bool, maxQuantity int) error
+	UpdateQuantity(ctx context.Context, productID uint32, quantity int) error
+	RemoveItems(ctx context.Context, productIDs []uint32) error
+	SelectItems(ctx context.Context, productIDs []uint32, selected bool) error
+	Empty(ctx context.Context) error
+}
+
+// cartStore 返回请求对应的购物车，userID为0时为游客购物车，调用前需要先通过checkCartOwner校验
+func (s *CartServiceServer) cartStore(userID int64, cartToken string) cartStore {
+	if userID == 0 {
+		return repository.NewHashCart(s.Redis, fmt.Sprintf(guestCartKey, cartToken), s.guestCartTTL())
+	}
+	return &userCartStore{repo: s.Repo, userID: userID}
+}
+
+type userCartStore struct {
+	repo   repository.CartRepository
+	userID int64
+}
+
+func (u *userCartStore) Items(ctx context.Context) ([]model.CartItem, error) {
+	return u.repo.Items(ctx, u.userID)
+}
+
+func (u *userCartStore) AddItem(ctx context.Context, productID uint32, quantity int, price float64, setQuantity bool, maxQuantity int) error {
+	return u.repo.AddItem(ctx, u.userID, productID, quantity, price, setQuantity, maxQuantity)
+}
+
+func (u *userCartStore) UpdateQuantity(ctx context.Context, productID uint32, quantity int) error {
+	return u.repo.UpdateQuantity(ctx, u.userID, productID, quantity)
+}
+
+func (u *userCartStore) RemoveItems(ctx context.Context, productIDs []uint32) error {
+	return u.repo.RemoveItems(ctx, u.userID, productIDs)
+}
+
+func (u *userCartStore) SelectItems(ctx context.Context, productIDs []uint32, selected bool) error {
+	return u.repo.SelectItems(ctx, u.userID, productIDs, selected)
+}
+
+func (u *userCartStore) Empty(ctx context.Context) error {
+	return u.repo.Empty(ctx, u.userID)
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/cart/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxWishlistsPerUser   = 20
+	maxWishlistItems      = 200
+	wishlistNameMaxLength = 50
+)
+
+// CreateWishlist 创建收藏清单
+func (s *CartServiceServer) CreateWishlist(ctx context.Context, req *cart.CreateWishlistReq) (*cart.CreateWishlistResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	name, err := normalizeWishlistName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.DB.WithContext(ctx).Model(&model.Wishlist{}).Where("user_id = ?", req.UserId).Count(&count).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询收藏清单失败: %v", err)
+	}
+	if count >= maxWishlistsPerUser {
+		return nil, status.Errorf(codes.FailedPrecondition, "最多创建%d个收藏清单", maxWishlistsPerUser)
+	}
+
+	list := model.Wishlist{UserID: req.UserId, Name: name}
+	result := s.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&list)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "创建收藏清单失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.AlreadyExists, "已有同名的收藏清单")
+	}
+
+	return &cart.CreateWishlistResp{Wishlist: convertToProtoWishlist(&list, 0)}, nil
+}
+
+// RenameWishlist 修改收藏清单名称，默认清单不能改名
+func (s *CartServiceServer) RenameWishlist(ctx context.Context, req *cart.RenameWishlistReq) (*cart.RenameWishlistResp, error) {
+	if req.UserId == 0 || req.WishlistId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID和清单ID不能为空")
+	}
+	name, err := normalizeWishlistName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := s.findWishlist(ctx, req.UserId, req.WishlistId)
+	if err != nil {
+		return nil, err
+	}
+	if list.IsDefault {
+		return nil, status.Error(codes.FailedPrecondition, "默认清单不能改名")
+	}
+
+	var count int64
+	if err := s.DB.WithContext(ctx).Model(&model.Wishlist{}).
+		Where("user_id = ? AND name = ? AND id <> ?", req.UserId, name, list.ID).
+		Count(&count).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询收藏清单失败: %v", err)
+	}
+	if count > 0 {
+		return nil, status.Error(codes.AlreadyExists, "已有同名的收藏清单")
+	}
+	if err := s.DB.WithContext(ctx).Model(list).Update("name", name).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "修改收藏清单失败: %v", err)
+	}
+
+	itemCount, err := s.countWishlistItems(ctx, list.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &cart.RenameWishlistResp{Wishlist: convertToProtoWishlist(list, itemCount)}, nil
+}
+
+// DeleteWishlist 删除收藏清单及其中的商品，默认清单不能删除
+func (s *CartServiceServer) DeleteWishlist(ctx context.Context, req *cart.DeleteWishlistReq) (*cart.DeleteWishlistResp, error) {
+	if req.UserId == 0 || req.WishlistId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID和清单ID不能为空")
+	}
+
+	list, err := s.findWishlist(ctx, req.UserId, req.WishlistId)
+	if err != nil {
+		return nil, err
+	}
+	if list.IsDefault {
+		return nil, status.Error(codes.FailedPrecondition, "默认清单不能删除")
+	}
+
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("wishlist_id = ?", list.ID).Delete(&model.WishlistItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(list).Error
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "删除收藏清单失败: %v", err)
+	}
+
+	return &cart.DeleteWishlistResp{}, nil
+}
+
+// ListWishlists 查询用户的所有收藏清单，默认清单排在最前面
+func (s *CartServiceServer) ListWishlists(ctx context.Context, req *cart.ListWishlistsReq) (*cart.ListWishlistsResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if _, err := s.findWishlist(ctx, req.UserId, 0); err != nil {
+		return nil, err
+	}
+
+	var lists []model.Wishlist
+	if err := s.DB.WithContext(ctx).Where("user_id = ?", req.UserId).
+		Order("is_default DESC, id ASC").Find(&lists).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询收藏清单失败: %v", err)
+	}
+
+	var counts []struct {
+		WishlistID uint
+		Count      int
+	}
+	if err := s.DB.WithContext(ctx).Model(&model.WishlistItem{}).
+		Select("wishlist_id, COUNT(*) AS count").
+		Where("user_id = ?", req.UserId).
+		Group("wishlist_id").Scan(&counts).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "统计收藏商品失败: %v", err)
+	}
+	countByList := make(map[uint]int, len(counts))
+	for _, c := range counts {
+		countByList[c.WishlistID] = c.Count
+	}
+
+	protoLists := make([]*cart.Wishlist, 0, len(lists))
+	for i := range lists {
+		protoLists = append(protoLists, convertToProtoWishlist(&lists[i], countByList[lists[i].ID]))
+	}
+	return &cart.ListWishlistsResp{Wishlists: protoLists}, nil
+}
+
+// GetWishlist 查询清单中的商品及当前售价，最近加入的排在前面
+func (s *CartServiceServer) GetWishlist(ctx context.Context, req *cart.GetWishlistReq) (*cart.GetWishlistResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+
+	list, err := s.findWishlist(ctx, req.UserId, req.WishlistId)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []model.WishlistItem
+	if err := s.DB.WithContext(ctx).Where("wishlist_id = ?", list.ID).
+		Order("created_at DESC, id DESC").Find(&items).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询收藏商品失败: %v", err)
+	}
+
+	productIDs := make([]uint32, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, uint32(item.ProductID))
+	}
+	products, err := s.fetchProducts(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	protoItems := make([]*cart.WishlistItem, 0, len(items))
+	for i := range items {
+		protoItems = append(protoItems, buildWishlistItem(&items[i], products[uint32(items[i].ProductID)]))
+	}
+	return &cart.GetWishlistResp{
+		Wishlist: convertToProtoWishlist(list, len(items)),
+		Items:    protoItems,
+	}, nil
+}
+
+// AddToWishlist 收藏商品，记录当前售价用于降价提醒。商品已在清单中时忽略
+func (s *CartServiceServer) AddToWishlist(ctx context.Context, req *cart.AddToWishlistReq) (*cart.AddToWishlistResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	list, err := s.findWishlist(ctx, req.UserId, req.WishlistId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 已售罄或下架的商品也可以收藏，但必须存在
+	products, err := s.fetchProducts(ctx, []uint32{req.ProductId})
+	if err != nil {
+		return nil, err
+	}
+	p, ok := products[req.ProductId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "商品不存在")
+	}
+
+	item := model.WishlistItem{ProductID: uint(req.ProductId), PriceAtAdd: currentPrice(p)}
+	if err := addWishlistItems(s.DB.WithContext(ctx), list, []model.WishlistItem{item}); err != nil {
+		return nil, err
+	}
+	return &cart.AddToWishlistResp{}, nil
+}
+
+// RemoveFromWishlist 从清单中删除商品，不在清单中的商品忽略
+func (s *CartServiceServer) RemoveFromWishlist(ctx context.Context, req *cart.RemoveFromWishlistReq) (*cart.RemoveFromWishlistResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if len(req.ProductIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	list, err := s.findWishlist(ctx, req.UserId, req.WishlistId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.WithContext(ctx).Unscoped().
+		Where("wishlist_id = ? AND product_id IN ?", list.ID, req.ProductIds).
+		Delete(&model.WishlistItem{}).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "删除收藏商品失败: %v", err)
+	}
+	return &cart.RemoveFromWishlistResp{}, nil
+}
+
+// findWishlist 查询用户的清单，wishlistID为0时返回默认清单，不存在时创建
+func (s *CartServiceServer) findWishlist(ctx context.Context, userID int64, wishlistID uint32) (*model.Wishlist, error) {
+	db := s.DB.WithContext(ctx)
+	var list model.Wishlist
+	if wishlistID != 0 {
+		if err := db.Where("id = ? AND user_id = ?", wishlistID, userID).First(&list).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, status.Error(codes.NotFound, "收藏清单不存在")
+			}
+			return nil, status.Errorf(codes.Internal, "查询收藏清单失败: %v", err)
+		}
+		return &list, nil
+	}
+
+	// (user_id, name)有唯一索引，并发创建默认清单时只会插入一行
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.Wishlist{
+		UserID:    userID,
+		Name:      model.DefaultWishlistName,
+		IsDefault: true,
+	}).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "创建默认清单失败: %v", err)
+	}
+	if err := db.Where("user_id = ? AND is_default = ?", userID, true).First(&list).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询收藏清单失败: %v", err)
+	}
+	return &list, nil
+}
+
+// addWishlistItems 将商品加入清单，已在清单中的商品保留原来的记录。超出清单容量时整体失败
+func addWishlistItems(tx *gorm.DB, list *model.Wishlist, items []model.WishlistItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&model.WishlistItem{}).Where("wishlist_id = ?", list.ID).Count(&count).Error; err != nil {
+		return status.Errorf(codes.Internal, "查询收藏商品失败: %v", err)
+	}
+	if int(count)+len(items) > maxWishlistItems {
+		return status.Errorf(codes.FailedPrecondition, "每个清单最多收藏%d件商品", maxWishlistItems)
+	}
+
+	for i := range items {
+		items[i].ID = 0
+		items[i].WishlistID = list.ID
+		items[i].UserID = list.UserID
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&items).Error; err != nil {
+		return status.Errorf(codes.Internal, "收藏商品失败: %v", err)
+	}
+	return nil
+}
+
+func (s *CartServiceServer) countWishlistItems(ctx context.Context, wishlistID uint) (int, error) {
+	var count int64
+	if err := s.DB.WithContext(ctx).Model(&model.WishlistItem{}).Where("wishlist_id = ?", wishlistID).Count(&count).Error; err != nil {
+		return 0, status.Errorf(codes.Internal, "统计收藏商品失败: %v", err)
+	}
+	return int(count), nil
+}
+
+// normalizeWishlistName 去掉首尾空白并校验清单名称
+func normalizeWishlistName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", status.Error(codes.InvalidArgument, "清单名称不能为空")
+	}
+	if utf8.RuneCountInString(name) > wishlistNameMaxLength {
+		return "", status.Errorf(codes.InvalidArgument, "清单名称不能超过%d个字符", wishlistNameMaxLength)
+	}
+	if name == model.DefaultWishlistName {
+		return "", status.Error(codes.InvalidArgument, "不能使用默认清单的名称")
+	}
+	return name, nil
+}
+
+// buildWishlistItem 根据收藏记录和商品当前信息生成展示数据，商品已不存在时p为nil
+func buildWishlistItem(item *model.WishlistItem, p *product.Product) *cart.WishlistItem {
+	protoItem := &cart.WishlistItem{
+		ProductId:  uint32(item.ProductID),
+		PriceAtAdd: float32(item.PriceAtAdd),
+		AddedAt:    item.CreatedAt.Unix(),
+	}
+	if p == nil {
+		return protoItem
+	}
+
+	price := currentPrice(p)
+	protoItem.Name = p.Name
+	protoItem.Picture = p.Picture
+	protoItem.CurrentPrice = float32(price)
+	protoItem.PriceDropped = price < item.PriceAtAdd && !samePrice(price, item.PriceAtAdd)
+	protoItem.Available = p.Available && p.Stock > 0
+	return protoItem
+}
+
+func convertToProtoWishlist(list *model.Wishlist, itemCount int) *cart.Wishlist {
+	return &cart.Wishlist{
+		Id:        uint32(list.ID),
+		Name:      list.Name,
+		IsDefault: list.IsDefault,
+		ItemCount: int32(itemCount),
+		CreatedAt: list.CreatedAt.Unix(),
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/cmd/cart/model"
+	"TKMall/cmd/cart/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// MoveToCart 将清单中的商品加入购物车，每个商品加1件。不满足购物车限制的商品留在清单中并返回原因
+func (s *CartServiceServer) MoveToCart(ctx context.Context, req *cart.MoveToCartReq) (*cart.MoveToCartResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if len(req.ProductIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	list, err := s.findWishlist(ctx, req.UserId, req.WishlistId)
+	if err != nil {
+		return nil, err
+	}
+	var items []model.WishlistItem
+	if err := s.DB.WithContext(ctx).Where("wishlist_id = ? AND product_id IN ?", list.ID, req.ProductIds).
+		Order("id ASC").Find(&items).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询收藏商品失败: %v", err)
+	}
+	if len(items) == 0 {
+		return nil, status.Error(codes.NotFound, "清单中没有这些商品")
+	}
+
+	productIDs := make([]uint32, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, uint32(item.ProductID))
+	}
+	products, err := s.fetchProducts(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	store := s.cartStore(req.UserId, "")
+	cartItems, err := store.Items(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "获取购物车失败: %v", err)
+	}
+
+	limits := s.limits()
+	resp := &cart.MoveToCartResp{}
+	for _, productID := range productIDs {
+		p := products[productID]
+		if v := checkAdd(cartItems, productID, 1, false, p, limits); v != nil {
+			resp.Violations = append(resp.Violations, v)
+			continue
+		}
+		err := store.AddItem(ctx, productID, 1, currentPrice(p), false, maxQuantity(p, limits))
+		if errors.Is(err, repository.ErrQuantityLimit) {
+			resp.Violations = append(resp.Violations, quantityLimitViolation(productID, p, limits))
+			continue
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "操作购物车失败: %v", err)
+		}
+		cartItems = addedToCart(cartItems, productID)
+		resp.MovedProductIds = append(resp.MovedProductIds, productID)
+	}
+
+	if len(resp.MovedProductIds) > 0 {
+		if err := s.DB.WithContext(ctx).Unscoped().
+			Where("wishlist_id = ? AND product_id IN ?", list.ID, resp.MovedProductIds).
+			Delete(&model.WishlistItem{}).Error; err != nil {
+			return nil, status.Errorf(codes.Internal, "删除收藏商品失败: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// MoveToWishlist 将购物车中的商品移入清单，或在两个清单之间移动商品
+func (s *CartServiceServer) MoveToWishlist(ctx context.Context, req *cart.MoveToWishlistReq) (*cart.MoveToWishlistResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if len(req.ProductIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	target, err := s.findWishlist(ctx, req.UserId, req.WishlistId)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.FromWishlistId == 0 {
+		if err := s.moveFromCart(ctx, req.UserId, target, req.ProductIds); err != nil {
+			return nil, err
+		}
+		return &cart.MoveToWishlistResp{}, nil
+	}
+
+	source, err := s.findWishlist(ctx, req.UserId, req.FromWishlistId)
+	if err != nil {
+		return nil, err
+	}
+	if source.ID == target.ID {
+		return nil, status.Error(codes.InvalidArgument, "源清单和目标清单相同")
+	}
+	if err := s.moveBetweenWishlists(ctx, source, target, req.ProductIds); err != nil {
+		return nil, err
+	}
+	return &cart.MoveToWishlistResp{}, nil
+}
+
+// moveFromCart 购物车中的商品按加入购物车时的价格收藏，收藏成功后从购物车删除
+func (s *CartServiceServer) moveFromCart(ctx context.Context, userID int64, target *model.Wishlist, productIDs []uint32) error {
+	store := s.cartStore(userID, "")
+	cartItems, err := store.Items(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "获取购物车失败: %v", err)
+	}
+
+	wanted := make(map[uint32]bool, len(productIDs))
+	for _, id := range productIDs {
+		wanted[id] = true
+	}
+	var items []model.WishlistItem
+	var moved []uint32
+	for _, cartItem := range cartItems {
+		if !wanted[uint32(cartItem.ProductID)] {
+			continue
+		}
+		items = append(items, model.WishlistItem{ProductID: cartItem.ProductID, PriceAtAdd: cartItem.Price})
+		moved = append(moved, uint32(cartItem.ProductID))
+	}
+	if len(items) == 0 {
+		return status.Error(codes.NotFound, "购物车中没有这些商品")
+	}
+
+	if err := addWishlistItems(s.DB.WithContext(ctx), target, items); err != nil {
+		return err
+	}
+	if err := store.RemoveItems(ctx, moved); err != nil {
+		return status.Errorf(codes.Internal, "删除购物车商品失败: %v", err)
+	}
+	return nil
+}
+
+// moveBetweenWishlists 在一个事务中将商品从source移到target，保留加入时的价格和降价提醒记录
+func (s *CartServiceServer) moveBetweenWishlists(ctx context.Context, source, target *model.Wishlist, productIDs []uint32) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var items []model.WishlistItem
+		if err := tx.Where("wishlist_id = ? AND product_id IN ?", source.ID, productIDs).Find(&items).Error; err != nil {
+			return status.Errorf(codes.Internal, "查询收藏商品失败: %v", err)
+		}
+		if len(items) == 0 {
+			return status.Error(codes.NotFound, "清单中没有这些商品")
+		}
+
+		if err := addWishlistItems(tx, target, items); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("wishlist_id = ? AND product_id IN ?", source.ID, productIDs).
+			Delete(&model.WishlistItem{}).Error; err != nil {
+			return status.Errorf(codes.Internal, "删除收藏商品失败: %v", err)
+		}
+		return nil
+	})
+}
+
+// addedToCart 返回加入1件商品后的购物车内容，用于同一请求中后续商品的校验
+func addedToCart(cartItems []model.CartItem, productID uint32) []model.CartItem {
+	for i := range cartItems {
+		if uint32(cartItems[i].ProductID) == productID {
+			cartItems[i].Quantity++
+			return cartItems
+		}
+	}
+	return append(cartItems, model.CartItem{ProductID: uint(productID), Quantity: 1, Selected: true})
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"TKMall/build/proto_gen/cart"
+	"TKMall/cmd/cart/model"
+	"TKMall/common/events"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// HandlePriceChanged 处理商品价格变更事件，降价后低于用户收藏时（或上次提醒时）的价格则生成降价提醒。
+// 同一用户在多个清单中收藏同一商品时只提醒一次；事件重复投递时不会重复提醒。
+func (s *CartServiceServer) HandlePriceChanged(ctx context.Context, event events.Event) error {
+	var payload events.ProductPriceChangedPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	if payload.ProductID == 0 || payload.NewPrice >= payload.OldPrice {
+		return nil
+	}
+	newPrice := roundPrice(payload.NewPrice)
+
+	var items []model.WishlistItem
+	if err := s.DB.WithContext(ctx).
+		Where("product_id = ? AND price_at_add > ? AND (notified_price = 0 OR notified_price > ?)", payload.ProductID, newPrice, newPrice).
+		Order("id ASC").Find(&items).Error; err != nil {
+		return err
+	}
+
+	notified := make(map[int64]bool)
+	for i := range items {
+		item := &items[i]
+		if notified[item.UserID] || !shouldNotifyPriceDrop(item, newPrice) {
+			continue
+		}
+		notified[item.UserID] = true
+		if err := s.notifyPriceDrop(ctx, item, newPrice, payload.ChangedAt); err != nil {
+			log.Errorf("生成降价提醒失败: user=%d product=%d: %v", item.UserID, item.ProductID, err)
+		}
+	}
+	return nil
+}
+
+// notifyPriceDrop 更新用户所有清单中该商品的提醒价格并生成提醒。
+// 提醒价格的条件更新保证并发或重复处理同一降价时只生成一条提醒。
+func (s *CartServiceServer) notifyPriceDrop(ctx context.Context, item *model.WishlistItem, newPrice float64, droppedAt time.Time) error {
+	notification := model.WishlistNotification{
+		UserID:     item.UserID,
+		ProductID:  item.ProductID,
+		WishlistID: item.WishlistID,
+		OldPrice:   priceDropReference(item),
+		NewPrice:   newPrice,
+	}
+
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.WishlistItem{}).
+			Where("user_id = ? AND product_id = ? AND price_at_add > ? AND (notified_price = 0 OR notified_price > ?)",
+				item.UserID, item.ProductID, newPrice, newPrice).
+			Update("notified_price", newPrice)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return tx.Create(&notification).Error
+	})
+	if err != nil || notification.ID == 0 {
+		return err
+	}
+
+	s.publishPriceDropped(&notification, droppedAt)
+	return nil
+}
+
+// publishPriceDropped 发布降价提醒事件，由邮件、推送等渠道发送通知
+func (s *CartServiceServer) publishPriceDropped(notification *model.WishlistNotification, droppedAt time.Time) {
+	if s.EventBus == nil {
+		return
+	}
+	if droppedAt.IsZero() {
+		droppedAt = time.Now()
+	}
+	event := events.Event{
+		Type: events.WishlistPriceDropped,
+		Payload: events.WishlistPriceDroppedPayload{
+			NotificationID: uint32(notification.ID),
+			UserID:         notification.UserID,
+			ProductID:      uint32(notification.ProductID),
+			WishlistID:     uint32(notification.WishlistID),
+			OldPrice:       notification.OldPrice,
+			NewPrice:       notification.NewPrice,
+			DroppedAt:      droppedAt,
+		},
+		Timestamp: time.Now(),
+	}
+	go func() {
+		if err := s.EventBus.Publish(context.Background(), event); err != nil {
+			log.Errorf("发布降价提醒事件失败: %v", err)
+		}
+	}()
+}
+
+// ListWishlistNotifications 分页查询降价提醒，最新的排在前面
+func (s *CartServiceServer) ListWishlistNotifications(ctx context.Context, req *cart.ListWishlistNotificationsReq) (*cart.ListWishlistNotificationsResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	page, pageSize := req.Page, req.PageSize
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	db := s.DB.WithContext(ctx)
+	var unread int64
+	if err := db.Model(&model.WishlistNotification{}).
+		Where("user_id = ? AND is_read = ?", req.UserId, false).
+		Count(&unread).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "获取未读数量失败: %v", err)
+	}
+
+	query := db.Model(&model.WishlistNotification{}).Where("user_id = ?", req.UserId)
+	if req.UnreadOnly {
+		query = query.Where("is_read = ?", false)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "获取总数失败: %v", err)
+	}
+
+	var notifications []model.WishlistNotification
+	if err := query.Order("id DESC").
+		Offset(int((page - 1) * pageSize)).Limit(int(pageSize)).
+		Find(&notifications).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询降价提醒失败: %v", err)
+	}
+
+	protoNotifications := make([]*cart.WishlistNotification, 0, len(notifications))
+	for i := range notifications {
+		n := &notifications[i]
+		protoNotifications = append(protoNotifications, &cart.WishlistNotification{
+			Id:         uint32(n.ID),
+			ProductId:  uint32(n.ProductID),
+			WishlistId: uint32(n.WishlistID),
+			OldPrice:   float32(n.OldPrice),
+			NewPrice:   float32(n.NewPrice),
+			Read:       n.IsRead,
+			CreatedAt:  n.CreatedAt.Unix(),
+		})
+	}
+	return &cart.ListWishlistNotificationsResp{
+		Notifications: protoNotifications,
+		Total:         total,
+		Unread:        unread,
+	}, nil
+}
+
+// MarkWishlistNotificationsRead 将降价提醒标记为已读
+func (s *CartServiceServer) MarkWishlistNotificationsRead(ctx context.Context, req *cart.MarkWishlistNotificationsReadReq) (*cart.MarkWishlistNotificationsReadResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+
+	query := s.DB.WithContext(ctx).Model(&model.WishlistNotification{}).
+		Where("user_id = ? AND is_read = ?", req.UserId, false)
+	if len(req.Ids) > 0 {
+		query = query.Where("id IN ?", req.Ids)
+	}
+	if err := query.Update("is_read", true).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "更新降价提醒失败: %v", err)
+	}
+	return &cart.MarkWishlistNotificationsReadResp{}, nil
+}
+
+// priceDropReference 判断是否降价的参考价格：提醒过则为上次提醒时的价格，否则为收藏时的价格
+func priceDropReference(item *model.WishlistItem) float64 {
+	if item.NotifiedPrice > 0 {
+		return item.NotifiedPrice
+	}
+	return item.PriceAtAdd
+}
+
+// shouldNotifyPriceDrop 新价格低于参考价格时提醒，按分比较避免浮点误差
+func shouldNotifyPriceDrop(item *model.WishlistItem, newPrice float64) bool {
+	reference := priceDropReference(item)
+	return newPrice < reference && !samePrice(newPrice, reference)
+}
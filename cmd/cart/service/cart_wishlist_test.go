@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/cart/model"
+	"TKMall/common/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 测试降价提醒的触发条件
+func TestShouldNotifyPriceDrop(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     model.WishlistItem
+		newPrice float64
+		want     bool
+	}{
+		{"低于收藏时价格", model.WishlistItem{PriceAtAdd: 199.99}, 149, true},
+		{"与收藏时价格相同", model.WishlistItem{PriceAtAdd: 199.99}, 199.99, false},
+		{"高于收藏时价格", model.WishlistItem{PriceAtAdd: 199.99}, 219, false},
+		{"已按更低价格提醒过", model.WishlistItem{PriceAtAdd: 199.99, NotifiedPrice: 149}, 159, false},
+		{"再次降到更低", model.WishlistItem{PriceAtAdd: 199.99, NotifiedPrice: 149}, 129, true},
+		{"浮点误差不算降价", model.WishlistItem{PriceAtAdd: 0.3}, 0.1 + 0.2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, shouldNotifyPriceDrop(&tt.item, tt.newPrice))
+		})
+	}
+}
+
+// 测试清单名称校验
+func TestNormalizeWishlistName(t *testing.T) {
+	name, err := normalizeWishlistName("  生日礼物  ")
+	require.NoError(t, err)
+	assert.Equal(t, "生日礼物", name)
+
+	for _, invalid := range []string{"", "   ", model.DefaultWishlistName, string(make([]rune, 51))} {
+		_, err := normalizeWishlistName(invalid)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "名称%q应校验失败", invalid)
+	}
+}
+
+// 测试收藏商品的展示数据
+func TestBuildWishlistItem(t *testing.T) {
+	item := &model.WishlistItem{ProductID: 101, PriceAtAdd: 199.99}
+
+	d := buildWishlistItem(item, &product.Product{Id: 101, Name: "耳机", Price: 199.99, EffectivePrice: 149, Stock: 3, Available: true})
+	assert.Equal(t, "耳机", d.Name)
+	assert.True(t, d.PriceDropped)
+	assert.True(t, d.Available)
+	assert.InDelta(t, 149, d.CurrentPrice, 0.001)
+
+	d = buildWishlistItem(item, &product.Product{Id: 101, Price: 219, Available: true})
+	assert.False(t, d.PriceDropped)
+	assert.False(t, d.Available, "售罄的商品不可购买")
+
+	d = buildWishlistItem(item, nil)
+	assert.Equal(t, uint32(101), d.ProductId)
+	assert.False(t, d.Available)
+}
+
+// 测试批量加入购物车时本地购物车内容的更新
+func TestAddedToCart(t *testing.T) {
+	items := []model.CartItem{{ProductID: 1, Quantity: 2}}
+	items = addedToCart(items, 1)
+	items = addedToCart(items, 2)
+	require.Len(t, items, 2)
+	assert.Equal(t, 3, items[0].Quantity)
+	assert.Equal(t, 1, items[1].Quantity)
+}
+
+// 测试从Kafka消费的事件可以解析出价格变更数据
+func TestDecodePriceChangedPayload(t *testing.T) {
+	changedAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	event := events.Event{Payload: map[string]interface{}{
+		"product_id": 7,
+		"old_price":  199.99,
+		"new_price":  149.0,
+		"reason":     "RULE_START",
+		"changed_at": changedAt.Format(time.RFC3339),
+	}}
+
+	var payload events.ProductPriceChangedPayload
+	require.NoError(t, event.DecodePayload(&payload))
+	assert.Equal(t, uint32(7), payload.ProductID)
+	assert.InDelta(t, 149, payload.NewPrice, 0.001)
+	assert.True(t, payload.ChangedAt.Equal(changedAt))
+}
@@ -7,6 +7,9 @@ import (
 	"TKMall/build/proto_gen/checkout"
 	"TKMall/build/proto_gen/order"
 	"TKMall/build/proto_gen/payment"
+	"TKMall/common/clientinfo"
+	"TKMall/common/idempotency"
+	"TKMall/common/proxy"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -21,12 +24,17 @@ func (s *CheckoutServiceServer) Checkout(ctx context.Context, req *checkout.Chec
 	if req.Address == nil {
 		return nil, status.Error(codes.InvalidArgument, "收货地址不能为空")
 	}
-	if req.CreditCard == nil {
+	if req.CreditCard == nil && req.PaymentMethodToken == "" && req.Wallet == nil {
 		return nil, status.Error(codes.InvalidArgument, "支付信息不能为空")
 	}
 
+	// 客户端携带幂等键重试结账时，下单和支付使用派生的幂等键，不会重复创建订单和扣款
+	idempotencyKey := idempotency.KeyFromContext(ctx, "")
+
 	// 1. 获取用户购物车
 	cartReq := &cart.GetCartReq{UserId: req.UserId}
+	// 结账流程中的调用都需要实时数据，不使用代理层的响应缓存
+	ctx = proxy.WithoutCache(ctx)
 	cartRespInterface, err := s.Proxy.Call(ctx, "cart", "GetCart", cartReq)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "获取购物车失败: %v", err)
@@ -40,21 +48,50 @@ func (s *CheckoutServiceServer) Checkout(ctx context.Context, req *checkout.Chec
 	if cartResp.Cart == nil || len(cartResp.Cart.Items) == 0 {
 		return nil, status.Error(codes.FailedPrecondition, "购物车为空，无法结账")
 	}
+	// 库存、限购等校验未通过时原样返回原因，前端逐条展示
+	if len(cartResp.Cart.Violations) > 0 {
+		st := status.New(codes.FailedPrecondition, "购物车中有商品不满足结算条件")
+		if detailed, err := st.WithDetails(&cart.CartViolations{Violations: cartResp.Cart.Violations}); err == nil {
+			st = detailed
+		}
+		return nil, st.Err()
+	}
+
+	// 新卡先存入保险库换成令牌，之后的调用只传令牌
+	paymentReq := &payment.ChargeReq{UserId: req.UserId}
+	if req.Wallet != nil {
+		paymentReq.PaymentMethod = &payment.ChargeReq_Wallet{Wallet: req.Wallet}
+	} else {
+		paymentToken, err := s.paymentToken(ctx, req, idempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		paymentReq.PaymentMethod = &payment.ChargeReq_PaymentMethodToken{PaymentMethodToken: paymentToken}
+	}
+	// 风控的账单国家取收货地址的国家
+	paymentReq.RiskContext = &payment.RiskContext{BillingCountry: req.Address.Country}
 
-	// 2. 创建订单项
+	// 2. 创建订单项，只购买勾选的商品，其余商品留在购物车中
 	var orderItems []*order.OrderItem
-	for _, item := range cartResp.Cart.Items {
-		// 实际应用中，应该从商品服务获取商品价格
-		itemCost := float32(item.Quantity) * 100.0 // 假设每个商品100元
+	for _, detail := range cartResp.Cart.Details {
+		if !detail.Selected {
+			continue
+		}
+		if !detail.InStock {
+			return nil, status.Errorf(codes.FailedPrecondition, "商品%d已下架或库存不足", detail.ProductId)
+		}
 		orderItem := &order.OrderItem{
 			Item: &cart.CartItem{
-				ProductId: item.ProductId,
-				Quantity:  item.Quantity,
+				ProductId: detail.ProductId,
+				Quantity:  detail.Quantity,
 			},
-			Cost: itemCost,
+			Cost: detail.LineTotal,
 		}
 		orderItems = append(orderItems, orderItem)
 	}
+	if len(orderItems) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "没有勾选要结算的商品")
+	}
 
 	// 3. 创建订单
 	orderReq := &order.PlaceOrderReq{
@@ -64,6 +101,9 @@ func (s *CheckoutServiceServer) Checkout(ctx context.Context, req *checkout.Chec
 		Email:        req.Email,
 		OrderItems:   orderItems,
 	}
+	if idempotencyKey != "" {
+		orderReq.IdempotencyKey = "checkout:order:" + idempotencyKey
+	}
 
 	orderRespInterface, err := s.Proxy.Call(ctx, "order", "PlaceOrder", orderReq)
 	if err != nil {
@@ -79,26 +119,26 @@ func (s *CheckoutServiceServer) Checkout(ctx context.Context, req *checkout.Chec
 		return nil, status.Error(codes.Internal, "创建订单失败：无效的订单ID")
 	}
 
-	// 4. 处理支付
+	// 4. 卡支付预授权，发货时扣款，订单取消时撤销；钱包支付不支持预授权，用户扫码付款时直接扣款
 	// 计算订单总金额
 	totalAmount := float32(0)
 	for _, item := range orderItems {
 		totalAmount += item.Cost
 	}
 
-	paymentReq := &payment.ChargeReq{
-		Amount: totalAmount,
-		CreditCard: &payment.CreditCardInfo{
-			CreditCardNumber:          req.CreditCard.CreditCardNumber,
-			CreditCardCvv:             req.CreditCard.CreditCardCvv,
-			CreditCardExpirationYear:  req.CreditCard.CreditCardExpirationYear,
-			CreditCardExpirationMonth: req.CreditCard.CreditCardExpirationMonth,
-		},
-		OrderId: orderResp.Order.OrderId,
-		UserId:  req.UserId,
+	paymentReq.Amount = totalAmount
+	paymentReq.OrderId = orderResp.Order.OrderId
+	if idempotencyKey != "" {
+		paymentReq.IdempotencyKey = "checkout:payment:" + idempotencyKey
 	}
 
-	paymentRespInterface, err := s.Proxy.Call(ctx, "payment", "Charge", paymentReq)
+	method := "Authorize"
+	if req.Wallet != nil {
+		method = "Charge"
+	}
+	// 将网关写入的客户端IP和国家转发给支付服务用于风控
+	paymentCtx := clientinfo.AppendToOutgoingContext(ctx, clientinfo.FromIncomingContext(ctx))
+	paymentRespInterface, err := s.Proxy.Call(paymentCtx, "payment", method, paymentReq)
 	if err != nil {
 		// 支付失败，但订单已创建
 		return nil, status.Errorf(codes.Internal, "支付处理失败: %v", err)
@@ -113,5 +153,34 @@ func (s *CheckoutServiceServer) Checkout(ctx context.Context, req *checkout.Chec
 	return &checkout.CheckoutResp{
 		OrderId:       orderResp.Order.OrderId,
 		TransactionId: paymentResp.TransactionId,
+		PaymentStatus: paymentResp.Status,
+		NextActionUrl: paymentResp.NextActionUrl,
+		QrCode:        paymentResp.QrCode,
+		ExpiresAt:     paymentResp.ExpiresAt,
 	}, nil
 }
+
+// paymentToken 返回支付使用的令牌，传入新卡时调用支付服务加密保存
+func (s *CheckoutServiceServer) paymentToken(ctx context.Context, req *checkout.CheckoutReq, idempotencyKey string) (string, error) {
+	if req.PaymentMethodToken != "" {
+		return req.PaymentMethodToken, nil
+	}
+	tokenizeReq := &payment.TokenizeCardReq{
+		UserId:     req.UserId,
+		CreditCard: req.CreditCard,
+		Save:       req.SaveCard,
+	}
+	if idempotencyKey != "" {
+		// 重试结账时得到相同的令牌，支付请求才能命中幂等键
+		tokenizeReq.IdempotencyKey = "checkout:tokenize:" + idempotencyKey
+	}
+	respInterface, err := s.Proxy.Call(ctx, "payment", "TokenizeCard", tokenizeReq)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "保存支付信息失败: %v", err)
+	}
+	resp, ok := respInterface.(*payment.TokenizeCardResp)
+	if !ok {
+		return "", status.Error(codes.Internal, "响应类型转换失败")
+	}
+	return resp.Token, nil
+}
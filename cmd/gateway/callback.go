@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/common/log"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 支付通知请求体的大小上限
+const maxCallbackBodySize = 1 << 20
+
+// PaymentCallback 接收支付网关的异步通知，原样转发请求体和请求头给支付服务校验签名。
+// 返回非2xx时网关会重发通知
+func (w *RPCWrapper) PaymentCallback(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCallbackBodySize)
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		uploadError(c, http.StatusBadRequest, "读取通知内容失败")
+		return
+	}
+	headers := make(map[string]string, len(c.Request.Header))
+	for name, values := range c.Request.Header {
+		if len(values) > 0 {
+			headers[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	var client payment.PaymentServiceClient
+	if err := w.serviceCtx.GetClient("payment", &client); err != nil {
+		uploadError(c, http.StatusServiceUnavailable, "payment service unavailable")
+		return
+	}
+	resp, err := client.HandleWebhook(c.Request.Context(), &payment.HandleWebhookReq{
+		Provider: c.Param("provider"),
+		Payload:  payload,
+		Headers:  headers,
+	})
+	if err != nil {
+		log.Errorf("处理支付通知失败: provider=%s: %v", c.Param("provider"), err)
+		uploadError(c, callbackStatus(err), status.Convert(err).Message())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"received":  true,
+		"event_id":  resp.EventId,
+		"duplicate": resp.Duplicate,
+	})
+}
+
+// callbackStatus 签名错误、格式错误等重发也不会成功的情况返回4xx
+func callbackStatus(err error) int {
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound, codes.Unimplemented:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
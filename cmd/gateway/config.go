@@ -10,6 +10,8 @@ type Config struct {
 	Server struct {
 		Name string `mapstructure:"name"`
 		Port int    `mapstructure:"port"`
+		// 网关前的CDN、负载均衡的IP或CIDR，只信任这些代理设置的X-Forwarded-For和X-Client-Country
+		TrustedProxies []string `mapstructure:"trusted_proxies"`
 	} `mapstructure:"server"`
 
 	Etcd struct {
@@ -26,6 +28,23 @@ type Config struct {
 		CheckoutService string `mapstructure:"checkout_service"`
 		CartService string `mapstructure:"cart_service"`
 	} `mapstructure:"services"`
+
+	Media struct {
+		LocalRoot string `mapstructure:"local_root"`
+		// 单张图片上传大小上限（MB），不能超过商品服务的上限
+		MaxUploadMB int64 `mapstructure:"max_upload_mb"`
+	} `mapstructure:"media"`
+}
+
+// defaultMaxUploadMB 未配置时的图片上传大小上限（MB）
+const defaultMaxUploadMB = 10
+
+// maxUploadSize 单张图片上传大小上限（字节）
+func (c *Config) maxUploadSize() int64 {
+	if c.Media.MaxUploadMB <= 0 {
+		return defaultMaxUploadMB << 20
+	}
+	return c.Media.MaxUploadMB << 20
 }
 
 func loadConfig() (*Config, error) {
@@ -81,10 +81,10 @@ func main() {
 	middleware.InitEnforcer(e)
 
 	serviceCtx := NewServiceContext(config)
-	rpcWrapper := NewRPCWrapper(serviceCtx)
+	rpcWrapper := NewRPCWrapper(serviceCtx, config.maxUploadSize())
 
 	// 使用现有的 router 函数
-	r := router(rpcWrapper, e)
+	r := router(rpcWrapper, e, config.Media.LocalRoot, config.Server.TrustedProxies)
 
 	server01 := &http.Server{
 		Addr:         fmt.Sprintf(":%d", config.Server.Port),
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CartTokenHeader = "X-Cart-Token"
+	CartTokenCookie = "cart_token"
+
+	// CartTokenContextKey 请求上下文中游客购物车令牌的键，转发RPC时填入请求的CartToken字段
+	CartTokenContextKey = "cartToken"
+
+	cartTokenMaxAge = 7 * 24 * time.Hour
+)
+
+// CartTokenMiddleware 读取游客购物车令牌，优先使用请求头，其次是Cookie。
+// issue为true时，请求没有携带令牌会签发新令牌并写入Cookie和响应头。
+func CartTokenMiddleware(issue bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader(CartTokenHeader)
+		if token == "" {
+			token, _ = c.Cookie(CartTokenCookie)
+		}
+
+		if token == "" && issue {
+			var err error
+			if token, err = newCartToken(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "生成购物车令牌失败"})
+				c.Abort()
+				return
+			}
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(CartTokenCookie, token, int(cartTokenMaxAge.Seconds()), "/", "", c.Request.TLS != nil, true)
+			c.Header(CartTokenHeader, token)
+		}
+
+		if token != "" {
+			c.Set(CartTokenContextKey, token)
+		}
+		c.Next()
+	}
+}
+
+func newCartToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
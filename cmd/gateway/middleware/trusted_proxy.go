@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"TKMall/common/clientinfo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ParseTrustedProxies 解析可信代理的IP或CIDR，单个IP视为只包含该地址的网段
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的可信代理地址: %s", proxy)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("无效的可信代理地址: %s", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ClientCountryMiddleware 客户端IP所在国家只能由可信代理按客户端IP设置，
+// 直接连接网关或经过其他代理的请求删除X-Client-Country请求头，避免客户端伪造国家绕过风控
+func ClientCountryMiddleware(trusted []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader(clientinfo.CountryHeader) != "" && !containsIP(trusted, net.ParseIP(c.RemoteIP())) {
+			c.Request.Header.Del(clientinfo.CountryHeader)
+		}
+		c.Next()
+	}
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"TKMall/common/clientinfo"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试只有可信代理设置的国家请求头和X-Forwarded-For生效
+func TestClientCountryMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxies := []string{"10.0.0.0/8", "192.168.1.1"}
+	trusted, err := ParseTrustedProxies(proxies)
+	require.NoError(t, err)
+
+	r := gin.New()
+	require.NoError(t, r.SetTrustedProxies(proxies))
+	r.Use(ClientCountryMiddleware(trusted))
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP()+" "+c.GetHeader(clientinfo.CountryHeader))
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{"经过可信代理", "10.1.2.3:5000", "203.0.113.7 CN"},
+		{"可信代理的单个IP", "192.168.1.1:5000", "203.0.113.7 CN"},
+		{"客户端直接连接", "198.51.100.9:5000", "198.51.100.9 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			req.Header.Set(clientinfo.CountryHeader, "CN")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Body.String())
+		})
+	}
+
+	_, err = ParseTrustedProxies([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
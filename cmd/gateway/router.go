@@ -18,7 +18,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func router(rpc *RPCWrapper, enforcer *casbin.Enforcer) http.Handler {
+func router(rpc *RPCWrapper, enforcer *casbin.Enforcer, mediaRoot string, trustedProxies []string) http.Handler {
 	// 加载白名单配置
 	if err := middleware.LoadWhitelistConfig(); err != nil {
 		log.Fatalf("初始化白名单配置失败: %v", err)
@@ -29,8 +29,18 @@ func router(rpc *RPCWrapper, enforcer *casbin.Enforcer) http.Handler {
 		log.Errorf("初始化速率限制配置失败: %v，将使用默认配置", err)
 	}
 
+	// 只信任网关前的代理转发的客户端IP和国家，为空时使用连接的对端地址
+	trusted, err := middleware.ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		log.Fatalf("初始化可信代理失败: %v", err)
+	}
+
 	e := gin.New()
 	e.Use(gin.Recovery())
+	if err := e.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("初始化可信代理失败: %v", err)
+	}
+	e.Use(middleware.ClientCountryMiddleware(trusted))
 
 	// 注册限流中间件，应当在所有其他中间件之前
 	e.Use(middleware.RateLimiterMiddleware())
@@ -51,7 +61,7 @@ func router(rpc *RPCWrapper, enforcer *casbin.Enforcer) http.Handler {
 
 	e.GET("/test_auth", rpc.Call("auth", auth.AuthServiceClient.TestGateWayMsg))
 
-	e.POST("/login", rpc.Call("user", user.UserServiceClient.Login))
+	e.POST("/login", middleware.CartTokenMiddleware(false), rpc.Call("user", user.UserServiceClient.Login))
 	e.POST("/register", rpc.Call("user", user.UserServiceClient.Register))
 
 	// 添加商品服务路由
@@ -60,14 +70,66 @@ func router(rpc *RPCWrapper, enforcer *casbin.Enforcer) http.Handler {
 		productGroup.GET("", rpc.Call("product", product.ProductCatalogServiceClient.ListProducts))
 		productGroup.GET("/get", middleware.CacheMiddleware(5*time.Minute), rpc.Call("product", product.ProductCatalogServiceClient.GetProduct))
 		productGroup.POST("/search", rpc.Call("product", product.ProductCatalogServiceClient.SearchProducts))
+		productGroup.GET("/suggest", rpc.Call("product", product.ProductCatalogServiceClient.SuggestProducts))
+		productGroup.GET("/categories", rpc.Call("product", product.ProductCatalogServiceClient.ListCategories))
+		productGroup.GET("/reviews", rpc.Call("product", product.ProductCatalogServiceClient.ListReviews))
+		// 发表评价需要登录，用户ID取自登录令牌，按该用户的已送达订单校验购买资格
+		productReviewGroup := productGroup.Group("/review", middleware.AuthMiddleware())
+		productReviewGroup.POST("/create", rpc.Call("product", product.ProductCatalogServiceClient.CreateReview))
+	}
+
+	// 商品管理路由，不在白名单内，需要管理员权限
+	productAdminGroup := e.Group("/admin/product")
+	{
+		productAdminGroup.POST("/category/create", rpc.Call("product", product.ProductCatalogServiceClient.CreateCategory))
+		productAdminGroup.POST("/category/update", rpc.Call("product", product.ProductCatalogServiceClient.UpdateCategory))
+		productAdminGroup.POST("/category/delete", rpc.Call("product", product.ProductCatalogServiceClient.DeleteCategory))
+		productAdminGroup.POST("/categories/set", rpc.Call("product", product.ProductCatalogServiceClient.SetProductCategories))
+		productAdminGroup.POST("/image/upload", rpc.UploadProductImage)
+		productAdminGroup.POST("/image/delete", rpc.Call("product", product.ProductCatalogServiceClient.DeleteProductImage))
+		productAdminGroup.GET("/reviews", rpc.Call("product", product.ProductCatalogServiceClient.ListReviewsByStatus))
+		productAdminGroup.POST("/review/moderate", rpc.Call("product", product.ProductCatalogServiceClient.ModerateReview))
+		productAdminGroup.POST("/price/update", rpc.Call("product", product.ProductCatalogServiceClient.UpdateProductPrice))
+		productAdminGroup.GET("/price/history", rpc.Call("product", product.ProductCatalogServiceClient.ListPriceHistory))
+		productAdminGroup.GET("/price_rules", rpc.Call("product", product.ProductCatalogServiceClient.ListPriceRules))
+		productAdminGroup.POST("/price_rule/create", rpc.Call("product", product.ProductCatalogServiceClient.CreatePriceRule))
+		productAdminGroup.POST("/price_rule/delete", rpc.Call("product", product.ProductCatalogServiceClient.DeletePriceRule))
+	}
+
+	// 本地存储的商品图片，对象地址按内容寻址，可长期缓存
+	if mediaRoot != "" {
+		mediaGroup := e.Group("/media", func(c *gin.Context) {
+			c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		})
+		mediaGroup.Static("/", mediaRoot)
 	}
 
 	// 添加购物车服务路由
-	cartGroup := e.Group("/cart")
+	// 未登录用户使用网关签发的令牌访问游客购物车
+	cartGroup := e.Group("/cart", middleware.CartTokenMiddleware(true))
 	{
 		cartGroup.POST("/add", rpc.Call("cart", cart.CartServiceClient.AddItem))
 		cartGroup.GET("/get", rpc.Call("cart", cart.CartServiceClient.GetCart))
 		cartGroup.DELETE("/empty", rpc.Call("cart", cart.CartServiceClient.EmptyCart))
+		cartGroup.POST("/update", rpc.Call("cart", cart.CartServiceClient.UpdateItemQuantity))
+		cartGroup.POST("/remove", rpc.Call("cart", cart.CartServiceClient.RemoveItem))
+		cartGroup.POST("/select", rpc.Call("cart", cart.CartServiceClient.SelectItems))
+	}
+
+	// 添加收藏清单路由，wishlist_id为0时表示默认的“稍后再买”清单
+	wishlistGroup := e.Group("/wishlist")
+	{
+		wishlistGroup.GET("/list", rpc.Call("cart", cart.CartServiceClient.ListWishlists))
+		wishlistGroup.GET("/get", rpc.Call("cart", cart.CartServiceClient.GetWishlist))
+		wishlistGroup.POST("/create", rpc.Call("cart", cart.CartServiceClient.CreateWishlist))
+		wishlistGroup.POST("/rename", rpc.Call("cart", cart.CartServiceClient.RenameWishlist))
+		wishlistGroup.POST("/delete", rpc.Call("cart", cart.CartServiceClient.DeleteWishlist))
+		wishlistGroup.POST("/add", rpc.Call("cart", cart.CartServiceClient.AddToWishlist))
+		wishlistGroup.POST("/remove", rpc.Call("cart", cart.CartServiceClient.RemoveFromWishlist))
+		wishlistGroup.POST("/move_to_cart", rpc.Call("cart", cart.CartServiceClient.MoveToCart))
+		wishlistGroup.POST("/move", rpc.Call("cart", cart.CartServiceClient.MoveToWishlist))
+		wishlistGroup.GET("/notifications", rpc.Call("cart", cart.CartServiceClient.ListWishlistNotifications))
+		wishlistGroup.POST("/notifications/read", rpc.Call("cart", cart.CartServiceClient.MarkWishlistNotificationsRead))
 	}
 
 	// 添加订单服务路由
@@ -76,12 +138,40 @@ func router(rpc *RPCWrapper, enforcer *casbin.Enforcer) http.Handler {
 		orderGroup.POST("/place", rpc.Call("order", order.OrderServiceClient.PlaceOrder))
 		orderGroup.GET("/list", rpc.Call("order", order.OrderServiceClient.ListOrder))
 		orderGroup.POST("/mark_paid", rpc.Call("order", order.OrderServiceClient.MarkOrderPaid))
+		orderGroup.POST("/cancel", rpc.Call("order", order.OrderServiceClient.CancelOrder))
+	}
+
+	// 订单管理路由，需要管理员权限
+	orderAdminGroup := e.Group("/admin/order")
+	{
+		orderAdminGroup.POST("/ship", rpc.Call("order", order.OrderServiceClient.ShipOrder))
+		orderAdminGroup.POST("/deliver", rpc.Call("order", order.OrderServiceClient.DeliverOrder))
 	}
 
 	// 添加支付服务路由
 	paymentGroup := e.Group("/payment")
 	{
 		paymentGroup.POST("/charge", rpc.Call("payment", payment.PaymentServiceClient.Charge))
+		// 钱包扫码支付时前端轮询支付结果
+		paymentGroup.GET("/status", rpc.Call("payment", payment.PaymentServiceClient.GetPayment))
+		// 卡号只在换取令牌时出现，结账和支付只传令牌。保存的卡需要登录，用户ID取自登录令牌
+		paymentMethodGroup := paymentGroup.Group("", middleware.AuthMiddleware())
+		paymentMethodGroup.POST("/tokenize", rpc.Call("payment", payment.PaymentServiceClient.TokenizeCard))
+		paymentMethodGroup.GET("/methods", rpc.Call("payment", payment.PaymentServiceClient.ListPaymentMethods))
+		paymentMethodGroup.POST("/methods/delete", rpc.Call("payment", payment.PaymentServiceClient.DeletePaymentMethod))
+		// 支付网关的异步通知，通过签名校验来源，不需要登录
+		paymentGroup.POST("/callback/:provider", rpc.PaymentCallback)
+	}
+
+	// 支付管理路由，需要管理员权限
+	paymentAdminGroup := e.Group("/admin/payment")
+	{
+		paymentAdminGroup.POST("/refund", rpc.Call("payment", payment.PaymentServiceClient.Refund))
+		paymentAdminGroup.POST("/capture", rpc.Call("payment", payment.PaymentServiceClient.Capture))
+		paymentAdminGroup.POST("/void", rpc.Call("payment", payment.PaymentServiceClient.Void))
+		// 风控审核：待审核的交易审核通过前不能扣款
+		paymentAdminGroup.GET("/risk/reviews", rpc.Call("payment", payment.PaymentServiceClient.ListRiskReviews))
+		paymentAdminGroup.POST("/risk/review", rpc.Call("payment", payment.PaymentServiceClient.ReviewRisk))
 	}
 
 	// 添加结账服务路由
@@ -1,26 +1,40 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"runtime"
 	"strings"
 
+	"TKMall/cmd/gateway/middleware"
+	"TKMall/common/clientinfo"
+	"TKMall/common/idempotency"
 	"TKMall/common/log"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // 注册BadRequest等标准错误详情，errorDetails才能解析
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-func NewRPCWrapper(serviceCtx *ServiceContext) *RPCWrapper {
+func NewRPCWrapper(serviceCtx *ServiceContext, maxUploadSize int64) *RPCWrapper {
 	return &RPCWrapper{
-		serviceCtx: serviceCtx,
+		serviceCtx:    serviceCtx,
+		maxUploadSize: maxUploadSize,
 	}
 }
 
 type RPCWrapper struct {
-	serviceCtx *ServiceContext
+	serviceCtx    *ServiceContext
+	maxUploadSize int64 // 单张图片上传大小上限（字节）
 }
 
 func (w *RPCWrapper) Call(serviceName string, fn interface{}) gin.HandlerFunc {
@@ -71,14 +85,15 @@ func (w *RPCWrapper) Call(serviceName string, fn interface{}) gin.HandlerFunc {
 		var bindErr error
 		if c.Request.Method == "POST" && c.ContentType() == "application/json" {
 			// 使用ShouldBindBodyWith处理JSON请求
-			bindErr = c.ShouldBindBodyWith(req, binding.JSON)
+			bindErr = c.ShouldBindBodyWith(req, protoJSON)
 			if bindErr == nil {
-				log.Infof("JSON绑定成功: %v", req)
+				log.Infof("JSON绑定成功: %v", redact(req))
 			} else {
 				log.Errorf("JSON绑定失败: %v", bindErr)
 			}
 		} else {
 			// 处理URL查询参数
+			aliasQueryParams(c.Request, reqType.Elem())
 			bindErr = c.ShouldBindQuery(req)
 			if bindErr != nil {
 				log.Errorf("查询参数绑定失败: %v", bindErr)
@@ -94,25 +109,196 @@ func (w *RPCWrapper) Call(serviceName string, fn interface{}) gin.HandlerFunc {
 			return
 		}
 
+		injectCartToken(c, req)
+		injectUserID(c, req)
+		clearRiskContext(req)
+
 		// 打印解析后的请求参数
-		log.Infof("Parsed request: %+v", req)
+		log.Infof("Parsed request: %+v", redact(req))
 
 		// 调用方法
 		results := method.Call([]reflect.Value{
-			reflect.ValueOf(c.Request.Context()),
+			reflect.ValueOf(outgoingContext(c)),
 			reflect.ValueOf(req),
 		})
 
 		// 处理结果
 		if !results[1].IsNil() {
 			err := results[1].Interface().(error)
-			c.JSON(http.StatusInternalServerError, gin.H{
+			body := gin.H{
 				"code":  http.StatusInternalServerError,
 				"error": err.Error(),
-			})
+			}
+			if details := errorDetails(err); len(details) > 0 {
+				body["details"] = details
+			}
+			c.JSON(http.StatusInternalServerError, body)
 			return
 		}
 
 		c.JSON(http.StatusOK, results[0].Interface())
 	}
 }
+
+// protoJSON 按proto的JSON格式绑定请求体，支持oneof和枚举名称，忽略未知字段
+var protoJSON binding.BindingBody = protoJSONBinding{}
+
+type protoJSONBinding struct{}
+
+func (protoJSONBinding) Name() string {
+	return "protojson"
+}
+
+func (b protoJSONBinding) Bind(req *http.Request, obj interface{}) error {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return b.BindBody(body, obj)
+}
+
+func (protoJSONBinding) BindBody(body []byte, obj interface{}) error {
+	msg, ok := obj.(proto.Message)
+	if !ok {
+		return binding.JSON.BindBody(body, obj)
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, msg)
+}
+
+// errorDetails 取出gRPC错误携带的详情（如购物车校验失败的原因），按proto字段名转成JSON
+func errorDetails(err error) []json.RawMessage {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	var details []json.RawMessage
+	for _, detail := range st.Details() {
+		msg, ok := detail.(proto.Message)
+		if !ok {
+			continue
+		}
+		data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		details = append(details, data)
+	}
+	return details
+}
+
+// 日志中需要脱敏的字段
+var sensitiveFields = map[protoreflect.Name]bool{
+	"credit_card_number": true,
+	"credit_card_cvv":    true,
+}
+
+// redact 返回隐藏了卡号、CVV的请求副本，用于打印日志
+func redact(req interface{}) interface{} {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return req
+	}
+	msg = proto.Clone(msg)
+	redactMessage(msg.ProtoReflect())
+	return msg
+}
+
+func redactMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case sensitiveFields[fd.Name()]:
+			if fd.Kind() == protoreflect.StringKind {
+				m.Set(fd, protoreflect.ValueOfString(maskCardNumber(v.String())))
+			} else {
+				m.Clear(fd)
+			}
+		case fd.Kind() == protoreflect.MessageKind && fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				redactMessage(list.Get(i).Message())
+			}
+		case fd.Kind() == protoreflect.MessageKind && !fd.IsMap():
+			redactMessage(v.Message())
+		}
+		return true
+	})
+}
+
+// maskCardNumber 只保留卡号后四位
+func maskCardNumber(number string) string {
+	if len(number) <= 4 {
+		return "****"
+	}
+	return "****" + number[len(number)-4:]
+}
+
+// IdempotencyKeyHeader 客户端重试时携带相同的值，下游服务返回第一次的结果
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// outgoingContext 将幂等键请求头和客户端信息转发到gRPC metadata，客户端信息用于支付风控
+func outgoingContext(c *gin.Context) context.Context {
+	ctx := c.Request.Context()
+	if key := c.GetHeader(IdempotencyKeyHeader); key != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, idempotency.MetadataKey, key)
+	}
+	return clientinfo.AppendToOutgoingContext(ctx, clientinfo.Info{
+		IP:      c.ClientIP(),
+		Country: c.GetHeader(clientinfo.CountryHeader),
+	})
+}
+
+// clearRiskContext 清除客户端传入的风控信息，风控使用的账单国家只能由结账服务按收货地址设置
+func clearRiskContext(req interface{}) {
+	field := reflect.ValueOf(req).Elem().FieldByName("RiskContext")
+	if field.IsValid() && field.Kind() == reflect.Ptr && field.CanSet() {
+		field.Set(reflect.Zero(field.Type()))
+	}
+}
+
+// injectUserID 经过登录认证的请求使用令牌中的用户ID，覆盖请求体中的user_id
+func injectUserID(c *gin.Context, req interface{}) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		return
+	}
+	id, ok := userID.(int64)
+	if !ok {
+		return
+	}
+	field := reflect.ValueOf(req).Elem().FieldByName("UserId")
+	if field.IsValid() && field.Kind() == reflect.Int64 && field.CanSet() {
+		field.SetInt(id)
+	}
+}
+
+// injectCartToken 请求体中没有指定购物车令牌时，使用中间件从请求头或Cookie中读取的令牌
+func injectCartToken(c *gin.Context, req interface{}) {
+	token := c.GetString(middleware.CartTokenContextKey)
+	if token == "" {
+		return
+	}
+	field := reflect.ValueOf(req).Elem().FieldByName("CartToken")
+	if field.IsValid() && field.Kind() == reflect.String && field.String() == "" {
+		field.SetString(token)
+	}
+}
+
+// 查询参数默认按Go字段名绑定（如 ?Id=1），这里补充按json字段名传参（如 ?q=手机）
+func aliasQueryParams(r *http.Request, reqType reflect.Type) {
+	query := r.URL.Query()
+	changed := false
+	for i := 0; i < reqType.NumField(); i++ {
+		field := reqType.Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if jsonName == "" || jsonName == "-" || jsonName == field.Name {
+			continue
+		}
+		if values, ok := query[jsonName]; ok && !query.Has(field.Name) {
+			query[field.Name] = values
+			changed = true
+		}
+	}
+	if changed {
+		r.URL.RawQuery = query.Encode()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"TKMall/build/proto_gen/checkout"
+	"TKMall/build/proto_gen/payment"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 测试日志中不出现卡号和CVV，原请求不受影响
+func TestRedact(t *testing.T) {
+	req := &checkout.CheckoutReq{
+		UserId: 1001,
+		CreditCard: &payment.CreditCardInfo{
+			CreditCardNumber:          "4242424242424242",
+			CreditCardCvv:             123,
+			CreditCardExpirationMonth: 12,
+		},
+	}
+	logged := fmt.Sprintf("%+v", redact(req))
+	assert.NotContains(t, logged, "4242424242424242")
+	assert.NotContains(t, logged, "123")
+	assert.Contains(t, logged, "****4242")
+
+	assert.Equal(t, "4242424242424242", req.CreditCard.CreditCardNumber)
+	assert.Equal(t, int32(123), req.CreditCard.CreditCardCvv)
+}
+
+// 测试请求体按proto的JSON格式绑定，oneof和枚举名称可以正确解析
+func TestProtoJSONBinding(t *testing.T) {
+	var req payment.ChargeReq
+	err := protoJSON.BindBody([]byte(`{"order_id":"ORD-1","user_id":"1001","amount":9.9,"wallet":{"wallet":"ALIPAY"},"unknown":1}`), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ORD-1", req.OrderId)
+	assert.Equal(t, int64(1001), req.UserId)
+	assert.Equal(t, payment.Wallet_ALIPAY, req.GetWallet().GetWallet())
+
+	req.Reset()
+	err = protoJSON.BindBody([]byte(`{"user_id":1001,"credit_card":{"credit_card_number":"4242424242424242"}}`), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, "4242424242424242", req.GetCreditCard().GetCreditCardNumber())
+}
+
+// 测试错误详情按proto字段名输出，卡号校验失败时列出不合法的字段
+func TestErrorDetails(t *testing.T) {
+	st, err := status.New(codes.InvalidArgument, "卡号校验位错误").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "credit_card.credit_card_number", Description: "卡号校验位错误，请检查卡号"},
+		},
+	})
+	assert.NoError(t, err)
+
+	details := errorDetails(st.Err())
+	if assert.Len(t, details, 1) {
+		assert.Contains(t, string(details[0]), `"field_violations"`)
+		assert.Contains(t, string(details[0]), "credit_card.credit_card_number")
+	}
+}
+
+// 测试客户端传入的风控信息被清除，不影响其他字段
+func TestClearRiskContext(t *testing.T) {
+	req := &payment.ChargeReq{OrderId: "ORD-1", RiskContext: &payment.RiskContext{BillingCountry: "CN"}}
+	clearRiskContext(req)
+	assert.Nil(t, req.RiskContext)
+	assert.Equal(t, "ORD-1", req.OrderId)
+
+	clearRiskContext(&checkout.CheckoutReq{UserId: 1001})
+}
+
+func TestInjectUserID(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	req := &payment.ListPaymentMethodsReq{UserId: 2002}
+	injectUserID(c, req)
+	assert.Equal(t, int64(2002), req.UserId, "未登录时不修改")
+
+	c.Set("userID", int64(1001))
+	injectUserID(c, req)
+	assert.Equal(t, int64(1001), req.UserId, "使用登录令牌中的用户ID")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	product "TKMall/build/proto_gen/product"
+	"TKMall/common/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadProductImage 接收multipart/form-data上传的商品图片并转发给商品服务。
+// 表单字段：product_id、sort_order（可选）、file。
+func (w *RPCWrapper) UploadProductImage(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, w.maxUploadSize+1<<20)
+
+	productID, err := strconv.ParseUint(c.PostForm("product_id"), 10, 32)
+	if err != nil || productID == 0 {
+		uploadError(c, http.StatusBadRequest, "无效的商品ID")
+		return
+	}
+	sortOrder, _ := strconv.Atoi(c.PostForm("sort_order"))
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		uploadError(c, http.StatusBadRequest, fmt.Sprintf("读取上传文件失败: %v", err))
+		return
+	}
+	if fileHeader.Size > w.maxUploadSize {
+		uploadError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("图片大小不能超过%dMB", w.maxUploadSize>>20))
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		uploadError(c, http.StatusBadRequest, fmt.Sprintf("读取上传文件失败: %v", err))
+		return
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		uploadError(c, http.StatusBadRequest, fmt.Sprintf("读取上传文件失败: %v", err))
+		return
+	}
+
+	var client product.ProductCatalogServiceClient
+	if err := w.serviceCtx.GetClient("product", &client); err != nil {
+		uploadError(c, http.StatusInternalServerError, "product service unavailable")
+		return
+	}
+
+	resp, err := client.UploadProductImage(c.Request.Context(), &product.UploadProductImageReq{
+		ProductId: uint32(productID),
+		Filename:  fileHeader.Filename,
+		Content:   content,
+		SortOrder: int32(sortOrder),
+	})
+	if err != nil {
+		log.Errorf("上传商品图片失败: %v", err)
+		uploadError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func uploadError(c *gin.Context, code int, msg string) {
+	c.JSON(code, gin.H{
+		"code":  code,
+		"error": msg,
+	})
+}
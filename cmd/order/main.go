@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,6 +14,8 @@ import (
 	"TKMall/cmd/order/service"
 	"TKMall/common/config"
 	"TKMall/common/etcd"
+	"TKMall/common/events"
+	"TKMall/common/idempotency"
 	"TKMall/common/log"
 	commonModel "TKMall/common/model"
 	"TKMall/common/proxy"
@@ -38,6 +41,9 @@ func main() {
 	if err := model.AutoMigrate(db); err != nil {
 		log.Fatalf("数据库迁移失败: %v", err)
 	}
+	if err := idempotency.AutoMigrate(db); err != nil {
+		log.Fatalf("数据库迁移失败: %v", err)
+	}
 
 	// 连接Redis
 	rdb := redis.NewClient(&redis.Options{
@@ -62,8 +68,18 @@ func main() {
 		log.Infof("使用配置文件中的cart服务地址: %s", cartServiceAddr)
 	}
 
+	// 发货扣款、取消订单撤销授权时调用payment服务
+	paymentServiceAddr := viper.GetString("payment_service.address")
+	if addr := os.Getenv("PAYMENT_SERVICE_ADDR"); addr != "" {
+		log.Infof("使用环境变量地址 PAYMENT_SERVICE_ADDR: %s", addr)
+		paymentServiceAddr = addr
+	} else {
+		log.Infof("使用配置文件中的payment服务地址: %s", paymentServiceAddr)
+	}
+
 	serviceEndpoints := map[string]string{
-		"cart": cartServiceAddr,
+		"cart":    cartServiceAddr,
+		"payment": paymentServiceAddr,
 	}
 
 	// 获取Redis地址，优先使用环境变量
@@ -75,15 +91,43 @@ func main() {
 
 	serviceProxy := proxy.NewGrpcProxy(serviceEndpoints, redisAddr)
 
+	// 初始化事件总线，失败时不处理退款事件
+	kafkaBrokers := []string{"localhost:9092"} // 默认值
+	if brokers := viper.GetStringSlice("kafka.brokers"); len(brokers) > 0 {
+		kafkaBrokers = brokers
+	}
+	if os.Getenv("KAFKA_BROKERS") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_BROKERS"), ",")
+	} else if os.Getenv("KAFKA_ADDR") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_ADDR"), ",")
+	}
+	log.Infof("使用Kafka地址: %v", kafkaBrokers)
+	var eventBus events.EventBus
+	if bus, err := events.NewKafkaEventBus(kafkaBrokers); err != nil {
+		log.Errorf("初始化事件总线失败: %v", err)
+	} else {
+		eventBus = bus
+	}
+
 	// 创建gRPC服务器
 	server := grpc.NewServer()
 
 	// 初始化订单服务
 	orderService := &service.OrderServiceServer{
-		DB:    db,
-		Redis: rdb,
-		Node:  node,
-		Proxy: serviceProxy,
+		DB:       db,
+		Redis:    rdb,
+		Node:     node,
+		Proxy:    serviceProxy,
+		EventBus: eventBus,
+
+		Idempotency: idempotency.NewStore(db, viper.GetDuration("idempotency.ttl_hours")*time.Hour),
+	}
+
+	// 支付服务退款、预授权过期或风控审核拒绝后更新订单状态
+	if eventBus != nil {
+		eventBus.Subscribe(events.PaymentRefunded, orderService.HandlePaymentRefunded)
+		eventBus.Subscribe(events.PaymentAuthorizationExpired, orderService.HandleAuthorizationExpired)
+		eventBus.Subscribe(events.PaymentRiskRejected, orderService.HandleRiskRejected)
 	}
 
 	// 注册订单服务
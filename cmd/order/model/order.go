@@ -53,24 +53,27 @@ const (
 	OrderStatusDelivered OrderStatus = "DELIVERED" // 已送达
 	OrderStatusCancelled OrderStatus = "CANCELLED" // 已取消
 	OrderStatusReturned  OrderStatus = "RETURNED"  // 已退货
+	OrderStatusRefunded  OrderStatus = "REFUNDED"  // 已全额退款
 )
 
 // 订单
 type Order struct {
 	model.BaseModel
-	OrderID       string      `gorm:"type:varchar(50);uniqueIndex;not null"`       // 订单号
-	UserID        int64       `gorm:"index;not null"`                              // 用户ID
-	Status        OrderStatus `gorm:"type:varchar(20);not null;default:'CREATED'"` // 订单状态
-	TotalAmount   float64     `gorm:"type:decimal(10,2);not null"`                 // 订单总金额
-	Address       Address     `gorm:"type:json"`                                   // 收货地址
-	Email         string      `gorm:"type:varchar(100)"`                           // 用户邮箱
-	UserCurrency  string      `gorm:"type:varchar(10);default:'CNY'"`              // 用户货币类型
-	PaymentID     string      `gorm:"type:varchar(50);index"`                      // 支付ID
-	TransactionID string      `gorm:"type:varchar(100)"`                           // 交易ID
-	PaidAt        *time.Time  `gorm:"index"`                                       // 支付时间
-	ShippedAt     *time.Time  // 发货时间
-	DeliveredAt   *time.Time  // 送达时间
-	CancelledAt   *time.Time  // 取消时间
+	OrderID        string      `gorm:"type:varchar(50);uniqueIndex;not null"`       // 订单号
+	UserID         int64       `gorm:"index;not null"`                              // 用户ID
+	Status         OrderStatus `gorm:"type:varchar(20);not null;default:'CREATED'"` // 订单状态
+	TotalAmount    float64     `gorm:"type:decimal(10,2);not null"`                 // 订单总金额
+	Address        Address     `gorm:"type:json"`                                   // 收货地址
+	Email          string      `gorm:"type:varchar(100)"`                           // 用户邮箱
+	UserCurrency   string      `gorm:"type:varchar(10);default:'CNY'"`              // 用户货币类型
+	PaymentID      string      `gorm:"type:varchar(50);index"`                      // 支付ID
+	TransactionID  string      `gorm:"type:varchar(100)"`                           // 交易ID
+	PaidAt         *time.Time  `gorm:"index"`                                       // 支付时间
+	ShippedAt      *time.Time  // 发货时间
+	DeliveredAt    *time.Time  // 送达时间
+	CancelledAt    *time.Time  // 取消时间
+	RefundedAmount float64     `gorm:"type:decimal(10,2);not null;default:0"` // 累计退款金额
+	RefundedAt     *time.Time  // 全额退款时间
 }
 
 // 初始化数据库表
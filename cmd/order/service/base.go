@@ -3,6 +3,7 @@ package service
 import (
 	"TKMall/build/proto_gen/order"
 	"TKMall/common/events"
+	"TKMall/common/idempotency"
 	"TKMall/common/proxy"
 
 	"github.com/bwmarrin/snowflake"
@@ -18,6 +19,9 @@ type OrderServiceServer struct {
 	Node     *snowflake.Node
 	Proxy    proxy.ServiceProxy
 	EventBus events.EventBus
+
+	// 幂等键存储，为nil时不做幂等处理
+	Idempotency *idempotency.Store
 }
 
 // Address结构体
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"TKMall/build/proto_gen/order"
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/order/model"
+	"TKMall/common/events"
+	"TKMall/common/log"
+	"TKMall/common/proxy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// CancelOrder 取消未发货的订单。已支付的订单先撤销预授权或退款，失败时不取消
+func (s *OrderServiceServer) CancelOrder(ctx context.Context, req *order.CancelOrderReq) (*order.CancelOrderResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if req.OrderId == "" {
+		return nil, status.Error(codes.InvalidArgument, "订单ID不能为空")
+	}
+
+	var orderInfo model.Order
+	if err := s.DB.WithContext(ctx).Where("order_id = ? AND user_id = ?", req.OrderId, req.UserId).
+		First(&orderInfo).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "订单不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询订单失败: %v", err)
+	}
+
+	switch orderInfo.Status {
+	case model.OrderStatusCancelled:
+		return &order.CancelOrderResp{}, nil
+	case model.OrderStatusCreated:
+	case model.OrderStatusPaid:
+		if orderInfo.TransactionID != "" {
+			if err := s.releasePayment(ctx, &orderInfo, req.Reason); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "订单已发货，无法取消，当前状态: %s", orderInfo.Status)
+	}
+
+	result := s.DB.WithContext(ctx).Model(&model.Order{}).
+		Where("id = ? AND status = ?", orderInfo.ID, orderInfo.Status).
+		Updates(map[string]interface{}{
+			"status":       model.OrderStatusCancelled,
+			"cancelled_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "更新订单状态失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.Aborted, "订单状态已变化，请刷新后重试")
+	}
+	return &order.CancelOrderResp{}, nil
+}
+
+// releasePayment 按交易状态撤销未扣款的预授权，已扣款的交易退还剩余金额
+func (s *OrderServiceServer) releasePayment(ctx context.Context, orderInfo *model.Order, reason string) error {
+	respInterface, err := s.Proxy.Call(proxy.WithoutCache(ctx), "payment", "GetPayment", &payment.GetPaymentReq{
+		TransactionId: orderInfo.TransactionID,
+	})
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "查询支付状态失败，无法取消订单: %v", err)
+	}
+	paymentInfo, ok := respInterface.(*payment.GetPaymentResp)
+	if !ok {
+		return status.Error(codes.Internal, "响应类型转换失败")
+	}
+
+	switch paymentInfo.Status {
+	case "VOIDED", "REFUNDED":
+		// 上次取消时已撤销或已退款，只是订单状态没有保存成功
+		return nil
+	case "AUTHORIZED", "REQUIRES_ACTION":
+		_, err := s.Proxy.Call(proxy.WithoutCache(ctx), "payment", "Void", &payment.VoidReq{
+			TransactionId: orderInfo.TransactionID,
+			Reason:        reason,
+		})
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "撤销支付授权失败，无法取消订单: %v", err)
+		}
+	case "COMPLETED", "CAPTURED", "PARTIALLY_REFUNDED":
+		amount := math.Round((orderInfo.TotalAmount-orderInfo.RefundedAmount)*100) / 100
+		if amount <= 0 {
+			return nil
+		}
+		_, err := s.Proxy.Call(proxy.WithoutCache(ctx), "payment", "Refund", &payment.RefundReq{
+			TransactionId: orderInfo.TransactionID,
+			Amount:        float32(amount),
+			Reason:        reason,
+			// 重试取消时不会重复退款
+			IdempotencyKey: "cancel:" + orderInfo.OrderID,
+		})
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "退款失败，无法取消订单: %v", err)
+		}
+	default:
+		return status.Errorf(codes.FailedPrecondition, "支付状态不允许取消订单，当前状态: %s", paymentInfo.Status)
+	}
+	return nil
+}
+
+// HandleAuthorizationExpired 处理预授权过期事件，授权已被支付服务撤销，取消仍未发货的订单
+func (s *OrderServiceServer) HandleAuthorizationExpired(ctx context.Context, event events.Event) error {
+	var payload events.PaymentAuthorizationExpiredPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	if payload.OrderID == "" {
+		return nil
+	}
+
+	cancelled, err := s.cancelPaidOrder(ctx, payload.OrderID, payload.UserID, payload.TransactionID, payload.ExpiredAt)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		log.Infof("预授权过期，订单已取消: order=%s transaction=%s", payload.OrderID, payload.TransactionID)
+	}
+	return nil
+}
+
+// HandleRiskRejected 处理风控审核拒绝事件，授权已被支付服务撤销，取消仍未发货的订单
+func (s *OrderServiceServer) HandleRiskRejected(ctx context.Context, event events.Event) error {
+	var payload events.PaymentRiskRejectedPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	if payload.OrderID == "" {
+		return nil
+	}
+
+	cancelled, err := s.cancelPaidOrder(ctx, payload.OrderID, payload.UserID, payload.TransactionID, payload.RejectedAt)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		log.Infof("风控审核拒绝，订单已取消: order=%s transaction=%s", payload.OrderID, payload.TransactionID)
+	}
+	return nil
+}
+
+// cancelPaidOrder 取消使用该交易支付且未发货的订单，返回是否取消
+func (s *OrderServiceServer) cancelPaidOrder(ctx context.Context, orderID string, userID int64, transactionID string, cancelledAt time.Time) (bool, error) {
+	result := s.DB.WithContext(ctx).Model(&model.Order{}).
+		Where("order_id = ? AND user_id = ? AND status = ? AND transaction_id = ?",
+			orderID, userID, model.OrderStatusPaid, transactionID).
+		Updates(map[string]interface{}{
+			"status":       model.OrderStatusCancelled,
+			"cancelled_at": cancelledAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"TKMall/build/proto_gen/order"
+	"TKMall/build/proto_gen/payment"
+	"TKMall/common/proxy"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// paymentProxy 模拟支付服务，返回指定的交易状态并记录调用的方法
+type paymentProxy struct {
+	status  string
+	calls   []string
+	refunds []*payment.RefundReq
+}
+
+func (p *paymentProxy) Call(ctx context.Context, service, method string, req interface{}) (interface{}, error) {
+	p.calls = append(p.calls, method)
+	switch method {
+	case "GetPayment":
+		return &payment.GetPaymentResp{Status: p.status}, nil
+	case "Void":
+		if p.status != "AUTHORIZED" {
+			return nil, status.Error(codes.FailedPrecondition, "交易已扣款，请使用退款")
+		}
+		return &payment.VoidResp{}, nil
+	case "Refund":
+		p.refunds = append(p.refunds, req.(*payment.RefundReq))
+		return &payment.RefundResp{}, nil
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (p *paymentProxy) AsyncCall(ctx context.Context, service, method string, req interface{}) (<-chan proxy.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+func newCancelTestServer(t *testing.T, payments *paymentProxy) (*OrderServiceServer, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	return &OrderServiceServer{DB: db, Proxy: payments}, mock
+}
+
+func expectPaidOrder(mock sqlmock.Sqlmock, refunded float64) {
+	mock.ExpectQuery("SELECT \\* FROM `orders`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "user_id", "status", "total_amount", "transaction_id", "refunded_amount"}).
+			AddRow(1, "ORD-1", 1001, "PAID", 99.9, "TXN-1", refunded))
+}
+
+func expectOrderCancelled(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("UPDATE `orders` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// 测试取消已支付的订单时按交易状态撤销预授权或退款
+func TestCancelPaidOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		refunded float64
+		calls    []string
+		refund   float32
+	}{
+		{"预授权撤销", "AUTHORIZED", 0, []string{"GetPayment", "Void"}, 0},
+		{"已扣款退款", "COMPLETED", 0, []string{"GetPayment", "Refund"}, 99.9},
+		{"预授权后已扣款退款", "CAPTURED", 0, []string{"GetPayment", "Refund"}, 99.9},
+		{"部分退款后退还剩余金额", "PARTIALLY_REFUNDED", 40, []string{"GetPayment", "Refund"}, 59.9},
+		{"上次已退款", "REFUNDED", 0, []string{"GetPayment"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payments := &paymentProxy{status: tt.status}
+			s, mock := newCancelTestServer(t, payments)
+			expectPaidOrder(mock, tt.refunded)
+			expectOrderCancelled(mock)
+
+			_, err := s.CancelOrder(context.Background(), &order.CancelOrderReq{UserId: 1001, OrderId: "ORD-1", Reason: "不想要了"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.calls, payments.calls)
+			if tt.refund > 0 && assert.Len(t, payments.refunds, 1) {
+				assert.Equal(t, "TXN-1", payments.refunds[0].TransactionId)
+				assert.Equal(t, tt.refund, payments.refunds[0].Amount)
+				assert.Equal(t, "cancel:ORD-1", payments.refunds[0].IdempotencyKey)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// 测试支付结果未确认时不取消订单
+func TestCancelOrderPendingPayment(t *testing.T) {
+	payments := &paymentProxy{status: "PENDING"}
+	s, mock := newCancelTestServer(t, payments)
+	expectPaidOrder(mock, 0)
+
+	_, err := s.CancelOrder(context.Background(), &order.CancelOrderReq{UserId: 1001, OrderId: "ORD-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, []string{"GetPayment"}, payments.calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
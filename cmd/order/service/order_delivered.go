@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"TKMall/build/proto_gen/order"
+	"TKMall/cmd/order/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// HasDeliveredOrder 查询用户是否购买过指定商品且订单已送达
+func (s *OrderServiceServer) HasDeliveredOrder(ctx context.Context, req *order.HasDeliveredOrderReq) (*order.HasDeliveredOrderResp, error) {
+	// 参数校验
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	var orderInfo model.Order
+	err := s.DB.WithContext(ctx).
+		Joins("JOIN order_items ON order_items.order_id = orders.order_id AND order_items.deleted_at IS NULL").
+		Where("orders.user_id = ? AND orders.status = ? AND order_items.product_id = ?",
+			req.UserId, model.OrderStatusDelivered, req.ProductId).
+		Order("orders.delivered_at DESC").
+		First(&orderInfo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &order.HasDeliveredOrderResp{Delivered: false}, nil
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "查询订单失败: %v", err)
+	}
+
+	return &order.HasDeliveredOrderResp{
+		Delivered: true,
+		OrderId:   orderInfo.OrderID,
+	}, nil
+}
+
+// DeliverOrder 确认已发货的订单送达，重复确认直接返回
+func (s *OrderServiceServer) DeliverOrder(ctx context.Context, req *order.DeliverOrderReq) (*order.DeliverOrderResp, error) {
+	if req.OrderId == "" {
+		return nil, status.Error(codes.InvalidArgument, "订单ID不能为空")
+	}
+
+	var orderInfo model.Order
+	if err := s.DB.WithContext(ctx).Where("order_id = ?", req.OrderId).First(&orderInfo).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "订单不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询订单失败: %v", err)
+	}
+	switch orderInfo.Status {
+	case model.OrderStatusShipped:
+	case model.OrderStatusDelivered:
+		return &order.DeliverOrderResp{}, nil
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "订单状态不正确，当前状态: %s", orderInfo.Status)
+	}
+
+	result := s.DB.WithContext(ctx).Model(&model.Order{}).
+		Where("id = ? AND status = ?", orderInfo.ID, model.OrderStatusShipped).
+		Updates(map[string]interface{}{
+			"status":       model.OrderStatusDelivered,
+			"delivered_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "更新订单状态失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.Aborted, "订单状态已变化，请刷新后重试")
+	}
+	return &order.DeliverOrderResp{}, nil
+}
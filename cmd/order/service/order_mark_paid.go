@@ -27,6 +27,11 @@ func (s *OrderServiceServer) MarkOrderPaid(ctx context.Context, req *order.MarkO
 		return nil, status.Errorf(codes.NotFound, "订单不存在: %v", err)
 	}
 
+	// 支付服务通知失败后会重试，同一交易的重复通知直接返回
+	if req.TransactionId != "" && orderInfo.TransactionID == req.TransactionId {
+		return &order.MarkOrderPaidResp{}, nil
+	}
+
 	// 检查订单状态
 	if orderInfo.Status != model.OrderStatusCreated {
 		return nil, status.Errorf(codes.FailedPrecondition, "订单状态不正确，当前状态: %s", orderInfo.Status)
@@ -40,6 +45,10 @@ func (s *OrderServiceServer) MarkOrderPaid(ctx context.Context, req *order.MarkO
 		"status":  model.OrderStatusPaid,
 		"paid_at": now,
 	}
+	// 记录交易ID，发货时扣款、取消时撤销授权
+	if req.TransactionId != "" {
+		updates["transaction_id"] = req.TransactionId
+	}
 
 	if err := s.DB.Model(&orderInfo).Updates(updates).Error; err != nil {
 		return nil, status.Errorf(codes.Internal, "更新订单状态失败: %v", err)
@@ -7,14 +7,23 @@ import (
 	"TKMall/build/proto_gen/cart"
 	"TKMall/build/proto_gen/order"
 	"TKMall/cmd/order/model"
+	"TKMall/common/idempotency"
+	"TKMall/common/proxy"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
-// PlaceOrder 创建订单
+// PlaceOrder 创建订单，携带幂等键的重复请求返回第一次创建的订单
 func (s *OrderServiceServer) PlaceOrder(ctx context.Context, req *order.PlaceOrderReq) (*order.PlaceOrderResp, error) {
+	key := idempotency.KeyFromContext(ctx, req.IdempotencyKey)
+	return idempotency.Do(ctx, s.Idempotency, "order.PlaceOrder", req.UserId, key, req, func() (*order.PlaceOrderResp, error) {
+		return s.placeOrder(ctx, req)
+	})
+}
+
+func (s *OrderServiceServer) placeOrder(ctx context.Context, req *order.PlaceOrderReq) (*order.PlaceOrderResp, error) {
 	// 参数校验
 	if req.UserId == 0 {
 		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
@@ -74,15 +83,22 @@ func (s *OrderServiceServer) PlaceOrder(ctx context.Context, req *order.PlaceOrd
 			return fmt.Errorf("创建订单项失败: %w", err)
 		}
 
-		// 清空购物车（可选，取决于业务需求）
-		emptyCartReq := &cart.EmptyCartReq{
-			UserId: req.UserId,
+		// 从购物车移除已下单的商品，未勾选结算的商品保留在购物车中
+		productIDs := make([]uint32, 0, len(orderItems))
+		for _, item := range orderItems {
+			productIDs = append(productIDs, uint32(item.ProductID))
+		}
+		if len(productIDs) == 0 {
+			return nil
+		}
+		removeReq := &cart.RemoveItemReq{
+			UserId:     req.UserId,
+			ProductIds: productIDs,
 		}
 
-		_, err := s.Proxy.Call(ctx, "cart", "EmptyCart", emptyCartReq)
+		_, err := s.Proxy.Call(proxy.WithoutCache(ctx), "cart", "RemoveItem", removeReq)
 		if err != nil {
-			// 仅记录日志，不影响订单创建
-			return fmt.Errorf("清空购物车失败: %w", err)
+			return fmt.Errorf("移除购物车商品失败: %w", err)
 		}
 
 		return nil
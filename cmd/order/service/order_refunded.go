@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"TKMall/cmd/order/model"
+	"TKMall/common/events"
+	"TKMall/common/log"
+)
+
+// HandlePaymentRefunded 处理支付服务的退款事件，更新订单的累计退款金额，全额退款时订单变为已退款。
+// 事件中的累计金额只增不减，按累计金额条件更新，重复或乱序投递的事件不会覆盖更新的结果
+func (s *OrderServiceServer) HandlePaymentRefunded(ctx context.Context, event events.Event) error {
+	var payload events.PaymentRefundedPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	if payload.OrderID == "" {
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"refunded_amount": payload.RefundedAmount,
+	}
+	if payload.FullyRefunded {
+		refundedAt := payload.RefundedAt
+		if refundedAt.IsZero() {
+			refundedAt = time.Now()
+		}
+		updates["status"] = model.OrderStatusRefunded
+		updates["refunded_at"] = refundedAt
+	}
+
+	result := s.DB.WithContext(ctx).Model(&model.Order{}).
+		Where("order_id = ? AND user_id = ? AND refunded_amount < ?", payload.OrderID, payload.UserID, payload.RefundedAmount).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Infof("订单退款: order=%s refund=%s amount=%.2f total=%.2f", payload.OrderID, payload.RefundID, payload.Amount, payload.RefundedAmount)
+	}
+	return nil
+}
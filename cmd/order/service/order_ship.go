@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"TKMall/build/proto_gen/order"
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/order/model"
+	"TKMall/common/proxy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// ShipOrder 发货。预授权的订单先扣款，扣款失败时不发货
+func (s *OrderServiceServer) ShipOrder(ctx context.Context, req *order.ShipOrderReq) (*order.ShipOrderResp, error) {
+	if req.OrderId == "" {
+		return nil, status.Error(codes.InvalidArgument, "订单ID不能为空")
+	}
+
+	var orderInfo model.Order
+	if err := s.DB.WithContext(ctx).Where("order_id = ?", req.OrderId).First(&orderInfo).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "订单不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询订单失败: %v", err)
+	}
+	if orderInfo.Status != model.OrderStatusPaid {
+		return nil, status.Errorf(codes.FailedPrecondition, "订单状态不正确，当前状态: %s", orderInfo.Status)
+	}
+
+	// 已扣款的交易重复扣款会直接返回成功，发货失败后可以重试
+	if orderInfo.TransactionID != "" {
+		_, err := s.Proxy.Call(proxy.WithoutCache(ctx), "payment", "Capture", &payment.CaptureReq{
+			TransactionId: orderInfo.TransactionID,
+		})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "扣款失败，无法发货: %v", err)
+		}
+	}
+
+	result := s.DB.WithContext(ctx).Model(&model.Order{}).
+		Where("id = ? AND status = ?", orderInfo.ID, model.OrderStatusPaid).
+		Updates(map[string]interface{}{
+			"status":     model.OrderStatusShipped,
+			"shipped_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "更新订单状态失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.Aborted, "订单状态已变化，请刷新后重试")
+	}
+	return &order.ShipOrderResp{}, nil
+}
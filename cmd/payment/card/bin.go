@@ -0,0 +1,194 @@
+// Package card 银行卡校验：Luhn校验位、有效期、按卡组织的卡号长度和CVV位数。
+// 卡组织按BIN（卡号前缀）识别，BIN表从数据文件加载，内置的表见 bins.csv。
+package card
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Network 卡组织
+type Network string
+
+const (
+	Visa       Network = "VISA"
+	MasterCard Network = "MASTERCARD"
+	Amex       Network = "AMEX"
+	Discover   Network = "DISCOVER"
+	UnionPay   Network = "UNIONPAY"
+	JCB        Network = "JCB"
+	DinersClub Network = "DINERS"
+	Unknown    Network = "UNKNOWN"
+)
+
+// BINRange 一段BIN范围及该范围内卡号的规则
+type BINRange struct {
+	From      string // 起始前缀，与To位数相同
+	To        string
+	Network   Network
+	Lengths   []int // 允许的卡号长度
+	CVVLength int
+}
+
+// 未收录的BIN按通用规则校验
+var unknownRange = BINRange{
+	Network:   Unknown,
+	Lengths:   []int{13, 14, 15, 16, 17, 18, 19},
+	CVVLength: 4,
+}
+
+// BINTable 按卡号前缀查找卡组织
+type BINTable struct {
+	ranges []BINRange // 按前缀位数降序，位数多的范围优先匹配
+}
+
+//go:embed bins.csv
+var defaultBINData string
+
+var (
+	defaultTable     *BINTable
+	defaultTableOnce sync.Once
+)
+
+// DefaultBINTable 内置的BIN表
+func DefaultBINTable() *BINTable {
+	defaultTableOnce.Do(func() {
+		table, err := ParseBINTable(strings.NewReader(defaultBINData))
+		if err != nil {
+			panic(fmt.Sprintf("内置BIN表格式错误: %v", err))
+		}
+		defaultTable = table
+	})
+	return defaultTable
+}
+
+// LoadBINTable 从文件加载BIN表
+func LoadBINTable(path string) (*BINTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseBINTable(f)
+}
+
+// ParseBINTable 解析BIN表，格式见 bins.csv：每行为 起始前缀,结束前缀,卡组织,卡号长度,CVV位数，# 开头的行为注释
+func ParseBINTable(r io.Reader) (*BINTable, error) {
+	table := &BINTable{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		binRange, err := parseBINRange(text)
+		if err != nil {
+			return nil, fmt.Errorf("第%d行: %w", line, err)
+		}
+		table.ranges = append(table.ranges, binRange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(table.ranges) == 0 {
+		return nil, fmt.Errorf("BIN表为空")
+	}
+	sort.SliceStable(table.ranges, func(i, j int) bool {
+		return len(table.ranges[i].From) > len(table.ranges[j].From)
+	})
+	return table, nil
+}
+
+func parseBINRange(text string) (BINRange, error) {
+	fields := strings.Split(text, ",")
+	if len(fields) != 5 {
+		return BINRange{}, fmt.Errorf("应有5列，实际%d列", len(fields))
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	from, to := fields[0], fields[1]
+	if from == "" || len(from) != len(to) || !isDigits(from) || !isDigits(to) || from > to {
+		return BINRange{}, fmt.Errorf("无效的前缀范围: %s-%s", from, to)
+	}
+	if fields[2] == "" {
+		return BINRange{}, fmt.Errorf("卡组织不能为空")
+	}
+	lengths, err := parseLengths(fields[3])
+	if err != nil {
+		return BINRange{}, err
+	}
+	cvvLength, err := strconv.Atoi(fields[4])
+	if err != nil || cvvLength < 3 || cvvLength > 4 {
+		return BINRange{}, fmt.Errorf("无效的CVV位数: %s", fields[4])
+	}
+	return BINRange{
+		From:      from,
+		To:        to,
+		Network:   Network(strings.ToUpper(fields[2])),
+		Lengths:   lengths,
+		CVVLength: cvvLength,
+	}, nil
+}
+
+// parseLengths 解析卡号长度，如 13|16|19、16-19
+func parseLengths(text string) ([]int, error) {
+	var lengths []int
+	for _, part := range strings.Split(text, "|") {
+		low, high, isRange := strings.Cut(part, "-")
+		min, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("无效的卡号长度: %s", text)
+		}
+		max := min
+		if isRange {
+			if max, err = strconv.Atoi(high); err != nil || max < min {
+				return nil, fmt.Errorf("无效的卡号长度: %s", text)
+			}
+		}
+		if min < 12 || max > 19 {
+			return nil, fmt.Errorf("卡号长度超出范围: %s", text)
+		}
+		for n := min; n <= max; n++ {
+			lengths = append(lengths, n)
+		}
+	}
+	return lengths, nil
+}
+
+// Lookup 查找卡号所属的BIN范围，未收录时返回卡组织为UNKNOWN的通用规则
+func (t *BINTable) Lookup(number string) BINRange {
+	for _, binRange := range t.ranges {
+		if len(number) < len(binRange.From) {
+			continue
+		}
+		prefix := number[:len(binRange.From)]
+		if prefix >= binRange.From && prefix <= binRange.To {
+			return binRange
+		}
+	}
+	return unknownRange
+}
+
+// Detect 识别卡组织
+func (t *BINTable) Detect(number string) Network {
+	return t.Lookup(number).Network
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
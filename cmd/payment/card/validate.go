@@ -0,0 +1,156 @@
+package card
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 字段名与 payment.CreditCardInfo 一致
+const (
+	FieldNumber          = "credit_card_number"
+	FieldCVV             = "credit_card_cvv"
+	FieldExpirationYear  = "credit_card_expiration_year"
+	FieldExpirationMonth = "credit_card_expiration_month"
+)
+
+// 有效期最多允许的年数，超过时视为输入错误
+const maxValidYears = 20
+
+// Info 待校验的卡信息
+type Info struct {
+	Number   string
+	CVV      int32
+	ExpMonth int32
+	ExpYear  int32 // 四位年份，两位时按20xx处理
+}
+
+// Violation 某个字段不合法的原因
+type Violation struct {
+	Field       string
+	Description string
+}
+
+// Error 卡信息校验失败，列出所有不合法的字段
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	descriptions := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		descriptions[i] = v.Description
+	}
+	return strings.Join(descriptions, "；")
+}
+
+// Validate 校验卡号、有效期和CVV，返回卡号所属的BIN范围。校验失败时返回*Error
+func (t *BINTable) Validate(info Info, now time.Time) (BINRange, error) {
+	binRange := t.Lookup(info.Number)
+	var violations []Violation
+	add := func(field, format string, args ...interface{}) {
+		violations = append(violations, Violation{Field: field, Description: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case info.Number == "":
+		add(FieldNumber, "卡号不能为空")
+	case !isDigits(info.Number):
+		add(FieldNumber, "卡号只能包含数字")
+	case !validLength(binRange, len(info.Number)):
+		add(FieldNumber, "%s卡号长度应为%s位", networkName(binRange.Network), formatLengths(binRange.Lengths))
+	case !Luhn(info.Number):
+		add(FieldNumber, "卡号校验位错误，请检查卡号")
+	}
+
+	year := normalizeYear(info.ExpYear)
+	switch {
+	case info.ExpMonth < 1 || info.ExpMonth > 12:
+		add(FieldExpirationMonth, "有效期月份应在1到12之间")
+	case year <= 0:
+		add(FieldExpirationYear, "有效期年份不能为空")
+	case Expired(info.ExpMonth, year, now):
+		add(FieldExpirationYear, "卡片已过期")
+	case int(year) > now.Year()+maxValidYears:
+		add(FieldExpirationYear, "有效期年份无效")
+	}
+
+	// CVV为整数，前导零会丢失，只能校验不超过该卡组织的位数
+	maxCVV := int32(1)
+	for i := 0; i < binRange.CVVLength; i++ {
+		maxCVV *= 10
+	}
+	switch {
+	case info.CVV <= 0:
+		add(FieldCVV, "CVV不能为空")
+	case info.CVV >= maxCVV:
+		add(FieldCVV, "%sCVV应为%d位", networkName(binRange.Network), binRange.CVVLength)
+	}
+
+	if len(violations) > 0 {
+		return binRange, &Error{Violations: violations}
+	}
+	return binRange, nil
+}
+
+// Luhn 校验卡号的Luhn校验位，number只能包含数字
+func Luhn(number string) bool {
+	if number == "" {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Expired 卡片在有效期月份的最后一天之后过期
+func Expired(month, year int32, now time.Time) bool {
+	expiresAt := time.Date(int(normalizeYear(year)), time.Month(month)+1, 1, 0, 0, 0, 0, now.Location())
+	return !now.Before(expiresAt)
+}
+
+func normalizeYear(year int32) int32 {
+	if year > 0 && year < 100 {
+		return 2000 + year
+	}
+	return year
+}
+
+func validLength(binRange BINRange, length int) bool {
+	for _, n := range binRange.Lengths {
+		if n == length {
+			return true
+		}
+	}
+	return false
+}
+
+// formatLengths 将卡号长度格式化为 16、13/16/19、16-19
+func formatLengths(lengths []int) string {
+	if len(lengths) > 2 && lengths[len(lengths)-1]-lengths[0] == len(lengths)-1 {
+		return fmt.Sprintf("%d-%d", lengths[0], lengths[len(lengths)-1])
+	}
+	parts := make([]string, len(lengths))
+	for i, n := range lengths {
+		parts[i] = fmt.Sprint(n)
+	}
+	return strings.Join(parts, "/")
+}
+
+func networkName(network Network) string {
+	if network == Unknown {
+		return ""
+	}
+	return string(network)
+}
@@ -0,0 +1,127 @@
+package card
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLuhn(t *testing.T) {
+	tests := []struct {
+		number string
+		valid  bool
+	}{
+		{"4242424242424242", true},
+		{"4242424242424241", false},
+		{"371449635398431", true},
+		{"6011111111111117", true},
+		{"6200000000000005", true},
+		{"0", true},
+		{"1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.valid, Luhn(tt.number), tt.number)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		number  string
+		network Network
+	}{
+		{"4111111111111111", Visa},
+		{"5555555555554444", MasterCard},
+		{"2223003122003222", MasterCard},
+		{"2721000000000000", Unknown},
+		{"371449635398431", Amex},
+		{"340000000000009", Amex},
+		{"6011111111111117", Discover},
+		{"6445644564456445", Discover},
+		{"6200000000000005", UnionPay},
+		{"6221260000000000", UnionPay},
+		{"3566002020360505", JCB},
+		{"30569309025904", DinersClub},
+		{"36227206271667", DinersClub},
+		{"9999999999999995", Unknown},
+		{"", Unknown},
+	}
+	table := DefaultBINTable()
+	for _, tt := range tests {
+		assert.Equal(t, tt.network, table.Detect(tt.number), tt.number)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+	valid := Info{Number: "4242424242424242", CVV: 123, ExpMonth: 12, ExpYear: 2030}
+	tests := []struct {
+		name   string
+		modify func(*Info)
+		fields []string // 不合法的字段，为空时校验通过
+	}{
+		{"有效的卡", func(*Info) {}, nil},
+		{"两位年份", func(i *Info) { i.ExpYear = 30 }, nil},
+		{"当月到期仍然有效", func(i *Info) { i.ExpMonth, i.ExpYear = 6, 2026 }, nil},
+		{"AMEX四位CVV", func(i *Info) { i.Number, i.CVV = "371449635398431", 1234 }, nil},
+		{"未收录的BIN", func(i *Info) { i.Number = "9999999999999995" }, nil},
+		{"卡号为空", func(i *Info) { i.Number = "" }, []string{FieldNumber}},
+		{"卡号含空格", func(i *Info) { i.Number = "4242 4242 4242 4242" }, []string{FieldNumber}},
+		{"校验位错误", func(i *Info) { i.Number = "4242424242424241" }, []string{FieldNumber}},
+		{"VISA长度错误", func(i *Info) { i.Number = "42424242424242428" }, []string{FieldNumber}},
+		{"AMEX长度错误", func(i *Info) { i.Number, i.CVV = "3714496353984318", 1234 }, []string{FieldNumber}},
+		{"上月已过期", func(i *Info) { i.ExpMonth, i.ExpYear = 5, 2026 }, []string{FieldExpirationYear}},
+		{"月份无效", func(i *Info) { i.ExpMonth = 13 }, []string{FieldExpirationMonth}},
+		{"年份为空", func(i *Info) { i.ExpYear = 0 }, []string{FieldExpirationYear}},
+		{"年份过远", func(i *Info) { i.ExpYear = 2099 }, []string{FieldExpirationYear}},
+		{"VISA四位CVV", func(i *Info) { i.CVV = 1234 }, []string{FieldCVV}},
+		{"CVV为空", func(i *Info) { i.CVV = 0 }, []string{FieldCVV}},
+		{"多个字段", func(i *Info) { i.Number, i.ExpMonth, i.CVV = "4242424242424241", 0, 0 },
+			[]string{FieldNumber, FieldExpirationMonth, FieldCVV}},
+	}
+	table := DefaultBINTable()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := valid
+			tt.modify(&info)
+			_, err := table.Validate(info, now)
+			if len(tt.fields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var cardErr *Error
+			require.ErrorAs(t, err, &cardErr)
+			var fields []string
+			for _, v := range cardErr.Violations {
+				fields = append(fields, v.Field)
+				assert.NotEmpty(t, v.Description)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestParseBINTable(t *testing.T) {
+	table, err := ParseBINTable(strings.NewReader("# 注释\n4,4,visa,16,3\n\n4000,4000,TEST,16,4\n"))
+	require.NoError(t, err)
+	// 前缀位数多的范围优先
+	assert.Equal(t, Network("TEST"), table.Detect("4000000000000002"))
+	assert.Equal(t, Visa, table.Detect("4242424242424242"))
+
+	for _, data := range []string{
+		"",
+		"4,4,VISA,16",
+		"4,45,VISA,16,3",
+		"5,4,VISA,16,3",
+		"4,4,VISA,20,3",
+		"4,4,VISA,16-x,3",
+		"4,4,VISA,16,5",
+		"4,4,,16,3",
+	} {
+		_, err := ParseBINTable(strings.NewReader(data))
+		assert.Error(t, err, data)
+	}
+}
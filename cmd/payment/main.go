@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/card"
 	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/cmd/payment/risk"
 	"TKMall/cmd/payment/service"
+	"TKMall/cmd/payment/vault"
 	"TKMall/common/config"
 	"TKMall/common/etcd"
+	"TKMall/common/events"
+	"TKMall/common/idempotency"
 	"TKMall/common/log"
 	commonModel "TKMall/common/model"
 	"TKMall/common/proxy"
@@ -38,6 +46,9 @@ func main() {
 	if err := model.AutoMigrate(db); err != nil {
 		log.Fatalf("数据库迁移失败: %v", err)
 	}
+	if err := idempotency.AutoMigrate(db); err != nil {
+		log.Fatalf("数据库迁移失败: %v", err)
+	}
 
 	// 连接Redis
 	rdb := redis.NewClient(&redis.Options{
@@ -75,17 +86,83 @@ func main() {
 
 	serviceProxy := proxy.NewGrpcProxy(serviceEndpoints, redisAddr)
 
+	// 初始化支付网关
+	paymentProvider, err := provider.NewFromConfig()
+	if err != nil {
+		log.Fatalf("初始化支付网关失败: %v", err)
+	}
+	log.Infof("使用支付网关: %s", paymentProvider.Name())
+
+	// 初始化卡号保险库
+	keyManager, err := vault.NewKeyManagerFromConfig()
+	if err != nil {
+		log.Fatalf("初始化卡号保险库失败: %v", err)
+	}
+	cardVault := vault.New(db, keyManager, viper.GetDuration("vault.token_ttl_minutes")*time.Minute)
+
+	// 未配置BIN表文件时使用内置的BIN表
+	var bins *card.BINTable
+	if path := viper.GetString("payment.bin_table_file"); path != "" {
+		if bins, err = card.LoadBINTable(path); err != nil {
+			log.Fatalf("加载BIN表失败: %v", err)
+		}
+	}
+
+	// 初始化风控，未启用时不评分
+	riskEngine, err := risk.NewEngineFromConfig(rdb)
+	if err != nil {
+		log.Fatalf("初始化风控失败: %v", err)
+	}
+	if riskEngine == nil {
+		log.Infof("风控未启用")
+	}
+
+	// 初始化事件总线，失败时不发布退款事件
+	kafkaBrokers := []string{"localhost:9092"} // 默认值
+	if brokers := viper.GetStringSlice("kafka.brokers"); len(brokers) > 0 {
+		kafkaBrokers = brokers
+	}
+	if os.Getenv("KAFKA_BROKERS") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_BROKERS"), ",")
+	} else if os.Getenv("KAFKA_ADDR") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_ADDR"), ",")
+	}
+	log.Infof("使用Kafka地址: %v", kafkaBrokers)
+	var eventBus events.EventBus
+	if bus, err := events.NewKafkaEventBus(kafkaBrokers); err != nil {
+		log.Errorf("初始化事件总线失败: %v", err)
+	} else {
+		eventBus = bus
+	}
+
 	// 创建gRPC服务器
 	server := grpc.NewServer()
 
 	// 初始化支付服务
 	paymentService := &service.PaymentServiceServer{
-		DB:    db,
-		Redis: rdb,
-		Node:  node,
-		Proxy: serviceProxy,
+		DB:       db,
+		Redis:    rdb,
+		Node:     node,
+		Proxy:    serviceProxy,
+		EventBus: eventBus,
+		Provider: paymentProvider,
+
+		GatewayTimeout: viper.GetDuration("payment.gateway_timeout_seconds") * time.Second,
+		Idempotency:    idempotency.NewStore(db, viper.GetDuration("idempotency.ttl_hours")*time.Hour),
+
+		AuthorizationTTL: viper.GetDuration("payment.authorization_ttl_hours") * time.Hour,
+		Vault:            cardVault,
+		BINs:             bins,
+		Risk:             riskEngine,
 	}
 
+	// 定时向支付网关确认结果未知的交易
+	syncCtx, cancelSync := context.WithCancel(context.Background())
+	defer cancelSync()
+	go paymentService.RunPendingSync(syncCtx, viper.GetDuration("payment.pending_sync_interval_seconds")*time.Second)
+	// 定时撤销过期的预授权
+	go paymentService.RunAuthorizationSweeper(syncCtx, viper.GetDuration("payment.authorization_sweep_interval_seconds")*time.Second)
+
 	// 注册支付服务
 	payment.RegisterPaymentServiceServer(server, paymentService)
 
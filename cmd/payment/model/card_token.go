@@ -0,0 +1,20 @@
+package model
+
+import (
+	"TKMall/common/model"
+	"time"
+)
+
+// 支付令牌，卡号和CVV加密存储，其他服务只传令牌
+type CardToken struct {
+	model.BaseModel
+	Token        string     `gorm:"type:varchar(64);uniqueIndex;not null"`          // 支付令牌
+	UserID       int64      `gorm:"index:idx_card_token_fingerprint;not null"`      // 用户ID，令牌只能由该用户使用
+	Fingerprint  string     `gorm:"type:char(64);index:idx_card_token_fingerprint"` // 卡号指纹，识别重复保存的卡
+	KeyID        string     `gorm:"type:varchar(50);not null"`                      // 加密使用的密钥ID，密钥轮换后用于解密
+	EncryptedPAN []byte     `gorm:"type:varbinary(255);not null"`                   // 加密的卡号
+	EncryptedCVV []byte     `gorm:"type:varbinary(255)"`                            // 加密的CVV，第一次支付后清除
+	Reusable     bool       `gorm:"not null;default:false"`                         // 用户保存的卡可以重复使用
+	ExpiresAt    *time.Time `gorm:"index"`                                          // 一次性令牌的过期时间
+	UsedAt       *time.Time // 第一次支付的时间，一次性令牌使用后失效
+}
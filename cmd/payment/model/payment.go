@@ -11,11 +11,16 @@ import (
 type PaymentStatus string
 
 const (
-	PaymentStatusPending   PaymentStatus = "PENDING"   // 等待支付
-	PaymentStatusCompleted PaymentStatus = "COMPLETED" // 支付完成
-	PaymentStatusFailed    PaymentStatus = "FAILED"    // 支付失败
-	PaymentStatusRefunded  PaymentStatus = "REFUNDED"  // 已退款
-	PaymentStatusCancelled PaymentStatus = "CANCELLED" // 已取消
+	PaymentStatusPending        PaymentStatus = "PENDING"            // 等待支付，或网关异步处理中
+	PaymentStatusRequiresAction PaymentStatus = "REQUIRES_ACTION"    // 等待用户完成3DS验证
+	PaymentStatusCompleted      PaymentStatus = "COMPLETED"          // 支付完成（授权并立即扣款）
+	PaymentStatusAuthorized     PaymentStatus = "AUTHORIZED"         // 预授权成功，等待扣款
+	PaymentStatusCaptured       PaymentStatus = "CAPTURED"           // 预授权后已扣款
+	PaymentStatusVoided         PaymentStatus = "VOIDED"             // 预授权已撤销或过期
+	PaymentStatusFailed         PaymentStatus = "FAILED"             // 支付失败
+	PaymentStatusRefunded       PaymentStatus = "REFUNDED"           // 已全额退款
+	PaymentStatusPartRefunded   PaymentStatus = "PARTIALLY_REFUNDED" // 已部分退款
+	PaymentStatusCancelled      PaymentStatus = "CANCELLED"          // 已取消
 )
 
 // 信用卡信息（脱敏存储）
@@ -27,24 +32,34 @@ type CreditCard struct {
 	ExpirationYear     int    `gorm:"not null"`                  // 过期年份
 	CardType           string `gorm:"type:varchar(20);not null"` // 卡类型（Visa, Mastercard等）
 	BillingAddressHash string `gorm:"type:varchar(64);not null"` // 账单地址哈希（用于验证）
+	Token              string `gorm:"type:varchar(64);index"`    // 保险库中的支付令牌
+	Saved              bool   `gorm:"not null;default:false"`    // 用户保存的卡，下次支付可以直接使用
 }
 
 // 交易记录
 type Transaction struct {
 	model.BaseModel
-	TransactionID      string        `gorm:"type:varchar(100);uniqueIndex;not null"` // 交易ID
-	OrderID            string        `gorm:"type:varchar(50);index;not null"`        // 关联的订单ID
-	UserID             int64         `gorm:"index;not null"`                         // 用户ID
-	Amount             float64       `gorm:"type:decimal(10,2);not null"`            // 交易金额
-	Currency           string        `gorm:"type:varchar(10);default:'CNY'"`         // 货币类型
-	Status             PaymentStatus `gorm:"type:varchar(20);index;not null"`        // 交易状态
-	PaymentMethod      string        `gorm:"type:varchar(20);not null"`              // 支付方式
-	CreditCardID       uint          `gorm:"index"`                                  // 信用卡ID
-	TransactionTime    *time.Time    `gorm:"index"`                                  // 交易时间
-	LastFourDigits     string        `gorm:"type:varchar(4)"`                        // 卡号后四位（冗余存储）
-	GatewayResponseRaw string        `gorm:"type:text"`                              // 支付网关原始响应
-	ErrorCode          string        `gorm:"type:varchar(50)"`                       // 错误代码
-	ErrorMessage       string        `gorm:"type:varchar(255)"`                      // 错误信息
+	TransactionID      string         `gorm:"type:varchar(100);uniqueIndex;not null"` // 交易ID
+	OrderID            string         `gorm:"type:varchar(50);index;not null"`        // 关联的订单ID
+	UserID             int64          `gorm:"index;not null"`                         // 用户ID
+	Amount             float64        `gorm:"type:decimal(10,2);not null"`            // 交易金额
+	Currency           string         `gorm:"type:varchar(10);default:'CNY'"`         // 货币类型
+	Status             PaymentStatus  `gorm:"type:varchar(20);index;not null"`        // 交易状态
+	PaymentMethod      string         `gorm:"type:varchar(20);not null"`              // 支付方式
+	CreditCardID       uint           `gorm:"index"`                                  // 信用卡ID
+	TransactionTime    *time.Time     `gorm:"index"`                                  // 交易时间
+	LastFourDigits     string         `gorm:"type:varchar(4)"`                        // 卡号后四位（冗余存储）
+	RefundedAmount     float64        `gorm:"type:decimal(10,2);not null;default:0"`  // 已退款金额，包含处理中的退款
+	CapturedAmount     float64        `gorm:"type:decimal(10,2);not null;default:0"`  // 已扣款金额，预授权可以部分扣款
+	Provider           string         `gorm:"type:varchar(20)"`                       // 支付网关名称
+	ProviderRef        string         `gorm:"type:varchar(100);index"`                // 支付网关交易号
+	GatewayResponseRaw string         `gorm:"type:text"`                              // 支付网关原始响应
+	ErrorCode          string         `gorm:"type:varchar(50)"`                       // 错误代码
+	ErrorMessage       string         `gorm:"type:varchar(255)"`                      // 错误信息
+	AuthExpiresAt      *time.Time     `gorm:"index"`                                  // 预授权的过期时间，过期后自动撤销
+	CapturedAt         *time.Time     // 扣款时间
+	OrderNotifyPending bool           `gorm:"index;not null;default:false"`  // 支付成功但还没有通知订单服务，和交易状态一起保存，由定时任务重试
+	Risk               RiskAssessment `gorm:"embedded;embeddedPrefix:risk_"` // 风控评分和审核结果
 }
 
 // 初始化数据库表
@@ -52,5 +67,8 @@ func AutoMigrate(db *gorm.DB) error {
 	return db.AutoMigrate(
 		&CreditCard{},
 		&Transaction{},
+		&Refund{},
+		&CardToken{},
+		&WebhookEvent{},
 	)
 }
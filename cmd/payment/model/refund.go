@@ -0,0 +1,33 @@
+package model
+
+import (
+	"TKMall/common/model"
+	"time"
+)
+
+// 退款状态
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "PENDING"   // 已提交网关，结果未知
+	RefundStatusSucceeded RefundStatus = "SUCCEEDED" // 退款成功
+	RefundStatusFailed    RefundStatus = "FAILED"    // 退款失败，金额已释放
+)
+
+// 退款记录，一笔交易可以多次部分退款
+type Refund struct {
+	model.BaseModel
+	RefundID       string       `gorm:"type:varchar(100);uniqueIndex;not null"` // 退款ID
+	TransactionID  string       `gorm:"type:varchar(100);index;not null"`       // 关联的交易ID
+	OrderID        string       `gorm:"type:varchar(50);index;not null"`        // 关联的订单ID
+	UserID         int64        `gorm:"index;not null"`                         // 用户ID
+	Amount         float64      `gorm:"type:decimal(10,2);not null"`            // 退款金额
+	Reason         string       `gorm:"type:varchar(255)"`                      // 退款原因
+	Status         RefundStatus `gorm:"type:varchar(20);index;not null"`        // 退款状态
+	ProviderRef    string       `gorm:"type:varchar(100)"`                      // 支付网关交易号
+	ErrorCode      string       `gorm:"type:varchar(50)"`                       // 错误代码
+	ErrorMessage   string       `gorm:"type:varchar(255)"`                      // 错误信息
+	RefundedAt     *time.Time   // 退款成功时间
+	EventPending   bool         `gorm:"index;not null;default:false"` // 退款事件待发布，与退款结果一起保存，发布失败时由定时任务重试
+	IdempotencyKey string       `gorm:"type:varchar(255);index"`      // 幂等键，同一交易上相同的键只创建一笔退款
+}
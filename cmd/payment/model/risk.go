@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// 风控审核状态
+type RiskReviewStatus string
+
+const (
+	RiskReviewPending  RiskReviewStatus = "PENDING"  // 待审核，审核通过前不能扣款
+	RiskReviewApproved RiskReviewStatus = "APPROVED" // 审核通过
+	RiskReviewRejected RiskReviewStatus = "REJECTED" // 审核拒绝，授权已撤销或已退款
+)
+
+// 授权前的风控评分，嵌入在交易记录中，列名以risk_开头
+type RiskAssessment struct {
+	Score        int              `gorm:"not null;default:0"`     // 风控总分
+	Decision     string           `gorm:"type:varchar(20)"`       // APPROVE、REVIEW、REJECT，未做风控时为空
+	Signals      string           `gorm:"type:text"`              // 命中的规则，JSON
+	ReviewStatus RiskReviewStatus `gorm:"type:varchar(20);index"` // 审核状态，不需要审核时为空
+	Reviewer     string           `gorm:"type:varchar(64)"`       // 审核人
+	ReviewNote   string           `gorm:"type:varchar(255)"`      // 审核备注
+	ReviewedAt   *time.Time       // 审核时间
+}
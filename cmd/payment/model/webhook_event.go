@@ -0,0 +1,26 @@
+package model
+
+import (
+	"TKMall/common/model"
+	"time"
+)
+
+// 异步通知的处理状态
+type WebhookStatus string
+
+const (
+	WebhookStatusReceived  WebhookStatus = "RECEIVED"  // 已收到，处理失败时网关重发会再次处理
+	WebhookStatusProcessed WebhookStatus = "PROCESSED" // 已处理，重复的通知直接忽略
+)
+
+// 支付网关的异步通知，按网关和事件ID去重
+type WebhookEvent struct {
+	model.BaseModel
+	Provider      string        `gorm:"type:varchar(20);not null;uniqueIndex:idx_webhook_event"`  // 支付网关名称
+	EventID       string        `gorm:"type:varchar(100);not null;uniqueIndex:idx_webhook_event"` // 网关的事件ID
+	EventType     string        `gorm:"type:varchar(50)"`                                         // 事件类型
+	TransactionID string        `gorm:"type:varchar(100);index"`                                  // 关联的交易ID
+	Status        WebhookStatus `gorm:"type:varchar(20);not null"`                                // 处理状态
+	Payload       string        `gorm:"type:text"`                                                // 原始通知内容
+	ProcessedAt   *time.Time    // 处理完成时间
+}
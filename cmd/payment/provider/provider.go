@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Status 支付网关返回的交易状态
+type Status string
+
+const (
+	StatusAuthorized     Status = "AUTHORIZED"         // 已授权，等待扣款
+	StatusCaptured       Status = "CAPTURED"           // 已扣款
+	StatusVoided         Status = "VOIDED"             // 授权已撤销
+	StatusRefunded       Status = "REFUNDED"           // 已全额退款
+	StatusPartRefunded   Status = "PARTIALLY_REFUNDED" // 已部分退款
+	StatusDeclined       Status = "DECLINED"           // 发卡行拒绝
+	StatusPending        Status = "PENDING"            // 网关异步处理中，需要通过QueryStatus查询最终结果
+	StatusRequiresAction Status = "REQUIRES_ACTION"    // 需要用户完成3DS验证
+	StatusFailed         Status = "FAILED"             // 网关处理失败
+)
+
+// ErrTimeout 网关请求超时，交易结果未知，需要稍后通过QueryStatus确认
+var ErrTimeout = errors.New("支付网关请求超时")
+
+// ErrNotFound 网关中没有该交易
+var ErrNotFound = errors.New("支付网关中不存在该交易")
+
+// ErrInvalidState 交易当前状态不允许该操作，如对未授权的交易扣款
+var ErrInvalidState = errors.New("交易状态不允许该操作")
+
+// Card 卡片信息，只在请求网关时使用，不落库
+type Card struct {
+	Number   string
+	CVV      int32
+	ExpMonth int32
+	ExpYear  int32
+}
+
+// 钱包类型，与交易记录的支付方式一致
+const (
+	WalletAlipay    = "ALIPAY"
+	WalletWeChatPay = "WECHAT_PAY"
+)
+
+// AuthorizeRequest 授权请求，Capture为true时授权成功后立即扣款。
+// Wallet不为空时为钱包支付，网关返回REQUIRES_ACTION和二维码，用户扫码付款后通过通知或QueryStatus得到结果
+type AuthorizeRequest struct {
+	TransactionID string // 本系统的交易ID，网关用于幂等
+	OrderID       string
+	UserID        int64
+	Amount        float64
+	Currency      string
+	Card          Card
+	Capture       bool
+	Wallet        string
+	ReturnURL     string // 钱包跳转支付完成后返回的页面
+}
+
+// Result 网关的处理结果。拒绝、3DS等业务结果通过Status返回，error只表示请求本身失败
+type Result struct {
+	ProviderRef string // 网关交易号，后续Capture、Void、Refund、QueryStatus使用
+	Status      Status
+	Amount      float64 // 本次操作涉及的金额
+	DeclineCode string  // 拒绝或失败原因代码，如 insufficient_funds
+	Message     string
+	ActionURL   string    // Status为REQUIRES_ACTION时用户完成验证或跳转钱包付款的地址
+	QRCode      string    // 钱包支付的二维码内容
+	ExpiresAt   time.Time // 二维码和跳转地址的过期时间
+	Raw         string    // 网关原始响应，用于排查问题
+}
+
+// PaymentProvider 支付网关
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error)
+	// Capture 对已授权的交易扣款，amount不能超过授权金额
+	Capture(ctx context.Context, providerRef string, amount float64) (*Result, error)
+	// Void 撤销未扣款的授权
+	Void(ctx context.Context, providerRef string) (*Result, error)
+	// Refund 退款，amount不能超过已扣款且未退款的金额。refundID用于幂等，
+	// 超时后用相同的refundID重新提交时返回第一次的结果，不会重复退款
+	Refund(ctx context.Context, providerRef, refundID string, amount float64) (*Result, error)
+	// QueryStatus 查询交易的当前状态，用于异步结果和超时后的确认。
+	// 授权超时时没有网关交易号，可以传入授权请求的TransactionID查询
+	QueryStatus(ctx context.Context, providerRef string) (*Result, error)
+}
+
+// NewFromConfig 根据配置创建支付网关
+func NewFromConfig() (PaymentProvider, error) {
+	switch name := viper.GetString("payment.provider"); name {
+	case "", "simulator":
+		return NewSimulator(SimulatorConfig{
+			Latency:      viper.GetDuration("payment.simulator.latency_ms") * time.Millisecond,
+			AsyncDelay:   viper.GetDuration("payment.simulator.async_delay_seconds") * time.Second,
+			MagicAmounts: viper.GetBool("payment.simulator.magic_amounts"),
+			Cards:        viper.GetStringMapString("payment.simulator.cards"),
+
+			WalletPayDelay: viper.GetDuration("payment.simulator.wallet_pay_delay_seconds") * time.Second,
+			WalletExpiry:   viper.GetDuration("payment.simulator.wallet_expiry_minutes") * time.Minute,
+			NotifyURL:      viper.GetString("payment.simulator.notify_url"),
+
+			WebhookSecret:    viper.GetString("payment.simulator.webhook_secret"),
+			WebhookTolerance: viper.GetDuration("payment.webhook_tolerance_seconds") * time.Second,
+		}), nil
+	default:
+		return nil, fmt.Errorf("不支持的支付网关: %s", name)
+	}
+}
@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SettlementEntry 网关结算文件中的一笔交易
+type SettlementEntry struct {
+	TransactionID  string
+	ProviderRef    string
+	Status         Status
+	Amount         float64 // 授权金额
+	CapturedAmount float64
+	RefundedAmount float64
+	Currency       string
+	CreatedAt      time.Time
+}
+
+// SettlementProvider 可以导出结算记录的网关实现该接口，真实网关一般通过下载结算文件获取
+type SettlementProvider interface {
+	// Settlement 返回[from, to)内创建的交易的最新状态
+	Settlement(ctx context.Context, from, to time.Time) ([]SettlementEntry, error)
+}
+
+// Settlement 按创建时间导出模拟网关内存中的交易，服务重启后之前的交易不再出现
+func (s *Simulator) Settlement(ctx context.Context, from, to time.Time) ([]SettlementEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]SettlementEntry, 0, len(s.payments))
+	for ref, p := range s.payments {
+		if p.createdAt.Before(from) || !p.createdAt.Before(to) {
+			continue
+		}
+		entries = append(entries, SettlementEntry{
+			TransactionID:  strings.TrimPrefix(ref, "sim_"),
+			ProviderRef:    ref,
+			Status:         p.status,
+			Amount:         p.authorized,
+			CapturedAmount: p.captured,
+			RefundedAmount: p.refunded,
+			Currency:       "CNY",
+			CreatedAt:      p.createdAt,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
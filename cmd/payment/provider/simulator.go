@@ -0,0 +1,357 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Outcome 模拟器对授权请求的处理结果
+type Outcome string
+
+const (
+	OutcomeApprove           Outcome = "approve"
+	OutcomeDecline           Outcome = "decline"
+	OutcomeInsufficientFunds Outcome = "insufficient_funds"
+	OutcomeExpiredCard       Outcome = "expired_card"
+	OutcomeError             Outcome = "error"         // 网关处理失败
+	OutcomeTimeout           Outcome = "timeout"       // 网关已处理但响应超时
+	OutcomeRequires3DS       Outcome = "3ds"           // 需要3DS验证
+	OutcomeAsyncSuccess      Outcome = "async_success" // 先返回PENDING，稍后成功
+	OutcomeAsyncFailure      Outcome = "async_failure" // 先返回PENDING，稍后失败
+)
+
+// 默认的测试卡号，与常见支付网关的测试卡号保持一致，其余卡号一律授权成功
+var defaultSimulatorCards = map[string]Outcome{
+	"4000000000000002": OutcomeDecline,
+	"4000000000009995": OutcomeInsufficientFunds,
+	"4000000000000069": OutcomeExpiredCard,
+	"4000000000000119": OutcomeError,
+	"4000000000000408": OutcomeTimeout,
+	"4000000000003220": OutcomeRequires3DS,
+	"4000000000000077": OutcomeAsyncSuccess,
+	"4000000000000259": OutcomeAsyncFailure,
+}
+
+// 开启MagicAmounts时按金额的分决定结果，如 100.02 被拒绝
+var simulatorMagicCents = map[int64]Outcome{
+	2:  OutcomeDecline,
+	51: OutcomeInsufficientFunds,
+	19: OutcomeError,
+	8:  OutcomeTimeout,
+	30: OutcomeRequires3DS,
+	77: OutcomeAsyncSuccess,
+	59: OutcomeAsyncFailure,
+}
+
+// SimulatorConfig 本地模拟网关的配置
+type SimulatorConfig struct {
+	Latency      time.Duration     // 每次请求的模拟延迟
+	AsyncDelay   time.Duration     // 异步交易从PENDING到最终结果的时间
+	Timeout      time.Duration     // 超时场景下等待的时间，请求的ctx先结束时以ctx为准，默认5秒
+	MagicAmounts bool              // 是否按金额触发特殊结果
+	Cards        map[string]string // 额外的卡号到结果的映射，覆盖默认测试卡号
+
+	WebhookSecret    string        // 异步通知的签名密钥
+	WebhookTolerance time.Duration // 通知签名允许的时间偏差，默认5分钟
+
+	WalletPayDelay time.Duration // 钱包支付下单后模拟用户扫码付款的时间，为0时用户不会付款，二维码到期后交易失败
+	WalletExpiry   time.Duration // 钱包支付二维码的有效期，默认15分钟
+	NotifyURL      string        // 模拟用户付款后发送签名通知的地址，为空时只能通过QueryStatus查询结果
+}
+
+// Simulator 本地模拟的支付网关，交易保存在内存中，用于开发和测试
+type Simulator struct {
+	cfg      SimulatorConfig
+	cards    map[string]Outcome
+	mu       sync.Mutex
+	payments map[string]*simPayment
+	refunds  map[string]*Result // 按退款ID保存的退款结果
+}
+
+type simPayment struct {
+	status      Status
+	authorized  float64
+	captured    float64
+	refunded    float64
+	capture     bool
+	outcome     Outcome
+	declineCode string
+	createdAt   time.Time
+	wallet      string
+	expiresAt   time.Time // 钱包支付二维码的过期时间
+}
+
+func NewSimulator(cfg SimulatorConfig) *Simulator {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.WalletExpiry <= 0 {
+		cfg.WalletExpiry = defaultWalletExpiry
+	}
+	cards := make(map[string]Outcome, len(defaultSimulatorCards)+len(cfg.Cards))
+	for number, outcome := range defaultSimulatorCards {
+		cards[number] = outcome
+	}
+	for number, outcome := range cfg.Cards {
+		cards[number] = Outcome(outcome)
+	}
+	return &Simulator{cfg: cfg, cards: cards, payments: make(map[string]*simPayment), refunds: make(map[string]*Result)}
+}
+
+func (s *Simulator) Name() string {
+	return "simulator"
+}
+
+// Authorize 按卡号或金额决定结果。同一个交易ID重复请求时返回第一次的结果
+func (s *Simulator) Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error) {
+	if err := s.wait(ctx, s.cfg.Latency); err != nil {
+		return nil, err
+	}
+	ref := "sim_" + req.TransactionID
+	outcome := s.outcome(req)
+	if req.Wallet != "" {
+		return s.authorizeWallet(ref, req, outcome)
+	}
+
+	s.mu.Lock()
+	p, exists := s.payments[ref]
+	if !exists {
+		p = &simPayment{authorized: req.Amount, capture: req.Capture, outcome: outcome, createdAt: time.Now()}
+		switch outcome {
+		case OutcomeDecline:
+			p.status, p.declineCode = StatusDeclined, "card_declined"
+		case OutcomeInsufficientFunds:
+			p.status, p.declineCode = StatusDeclined, "insufficient_funds"
+		case OutcomeExpiredCard:
+			p.status, p.declineCode = StatusDeclined, "expired_card"
+		case OutcomeError:
+			p.status, p.declineCode = StatusFailed, "processing_error"
+		case OutcomeRequires3DS:
+			p.status = StatusRequiresAction
+		case OutcomeAsyncSuccess, OutcomeAsyncFailure:
+			p.status = StatusPending
+		default:
+			// 超时场景下网关实际已经处理成功，只是响应丢失
+			p.approve()
+		}
+		s.payments[ref] = p
+	}
+	result := s.result(ref, p, p.authorized)
+	s.mu.Unlock()
+
+	if !exists && outcome == OutcomeTimeout {
+		if err := s.wait(ctx, s.cfg.Timeout); err != nil {
+			return nil, err
+		}
+		return nil, ErrTimeout
+	}
+	if p.status == StatusRequiresAction {
+		result.ActionURL = fmt.Sprintf("https://simulator.local/3ds/%s", ref)
+	}
+	return result, nil
+}
+
+func (s *Simulator) Capture(ctx context.Context, providerRef string, amount float64) (*Result, error) {
+	if err := s.wait(ctx, s.cfg.Latency); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.find(providerRef)
+	if err != nil {
+		return nil, err
+	}
+	if p.status != StatusAuthorized {
+		return nil, fmt.Errorf("%w: 当前状态%s", ErrInvalidState, p.status)
+	}
+	if amount <= 0 {
+		amount = p.authorized
+	}
+	if amount > p.authorized+0.005 {
+		return nil, fmt.Errorf("%w: 扣款金额%.2f超过授权金额%.2f", ErrInvalidState, amount, p.authorized)
+	}
+	p.status = StatusCaptured
+	p.captured = amount
+	return s.result(providerRef, p, amount), nil
+}
+
+func (s *Simulator) Void(ctx context.Context, providerRef string) (*Result, error) {
+	if err := s.wait(ctx, s.cfg.Latency); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.find(providerRef)
+	if err != nil {
+		return nil, err
+	}
+	if p.status != StatusAuthorized && p.status != StatusRequiresAction {
+		return nil, fmt.Errorf("%w: 当前状态%s", ErrInvalidState, p.status)
+	}
+	p.status = StatusVoided
+	return s.result(providerRef, p, p.authorized), nil
+}
+
+// Refund 退款，相同的refundID只退款一次
+func (s *Simulator) Refund(ctx context.Context, providerRef, refundID string, amount float64) (*Result, error) {
+	if err := s.wait(ctx, s.cfg.Latency); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if result, ok := s.refunds[refundID]; ok {
+		return result, nil
+	}
+	p, err := s.find(providerRef)
+	if err != nil {
+		return nil, err
+	}
+	if p.status != StatusCaptured && p.status != StatusPartRefunded {
+		return nil, fmt.Errorf("%w: 当前状态%s", ErrInvalidState, p.status)
+	}
+	remaining := p.captured - p.refunded
+	if amount <= 0 || amount > remaining+0.005 {
+		return nil, fmt.Errorf("%w: 退款金额%.2f超过可退金额%.2f", ErrInvalidState, amount, remaining)
+	}
+	p.refunded += amount
+	if p.captured-p.refunded < 0.005 {
+		p.status = StatusRefunded
+	} else {
+		p.status = StatusPartRefunded
+	}
+	result := s.result(providerRef, p, amount)
+	s.refunds[refundID] = result
+	return result, nil
+}
+
+// QueryStatus 查询交易状态，异步交易在AsyncDelay之后得到最终结果
+func (s *Simulator) QueryStatus(ctx context.Context, providerRef string) (*Result, error) {
+	if err := s.wait(ctx, s.cfg.Latency); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.find(providerRef)
+	if err != nil {
+		return nil, err
+	}
+	s.advanceWallet(p, time.Now())
+	if p.status == StatusPending && time.Since(p.createdAt) >= s.cfg.AsyncDelay {
+		if p.outcome == OutcomeAsyncFailure {
+			p.status, p.declineCode = StatusFailed, "async_payment_failed"
+		} else {
+			p.approve()
+		}
+	}
+	return s.result(providerRef, p, p.authorized), nil
+}
+
+// CompleteAction 模拟用户完成（或放弃）3DS验证或钱包付款
+func (s *Simulator) CompleteAction(providerRef string, success bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.find(providerRef)
+	if err != nil {
+		return err
+	}
+	if p.status != StatusRequiresAction {
+		return fmt.Errorf("%w: 当前状态%s", ErrInvalidState, p.status)
+	}
+	switch {
+	case success:
+		p.approve()
+	case p.wallet != "":
+		p.status, p.declineCode = StatusDeclined, "user_cancelled"
+	default:
+		p.status, p.declineCode = StatusDeclined, "authentication_failed"
+	}
+	return nil
+}
+
+func (s *Simulator) outcome(req AuthorizeRequest) Outcome {
+	if outcome, ok := s.cards[req.Card.Number]; ok {
+		return outcome
+	}
+	if s.cfg.MagicAmounts {
+		cents := int64(math.Round(req.Amount*100)) % 100
+		if outcome, ok := simulatorMagicCents[cents]; ok {
+			return outcome
+		}
+	}
+	return OutcomeApprove
+}
+
+func (s *Simulator) find(providerRef string) (*simPayment, error) {
+	if p, ok := s.payments[providerRef]; ok {
+		return p, nil
+	}
+	if p, ok := s.payments["sim_"+providerRef]; ok {
+		return p, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Simulator) result(ref string, p *simPayment, amount float64) *Result {
+	result := &Result{
+		ProviderRef: ref,
+		Status:      p.status,
+		Amount:      amount,
+		DeclineCode: p.declineCode,
+		Message:     simulatorMessages[p.declineCode],
+	}
+	raw, _ := json.Marshal(map[string]interface{}{
+		"provider":     s.Name(),
+		"id":           ref,
+		"status":       p.status,
+		"amount":       amount,
+		"decline_code": p.declineCode,
+		"wallet":       p.wallet,
+		"time":         time.Now().Format(time.RFC3339),
+	})
+	result.Raw = string(raw)
+	return result
+}
+
+// wait 模拟网络延迟，ctx先结束时返回超时
+func (s *Simulator) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrTimeout
+	}
+}
+
+func (p *simPayment) approve() {
+	if p.capture {
+		p.status = StatusCaptured
+		p.captured = p.authorized
+	} else {
+		p.status = StatusAuthorized
+	}
+}
+
+var simulatorMessages = map[string]string{
+	"card_declined":         "发卡行拒绝交易",
+	"insufficient_funds":    "余额不足",
+	"expired_card":          "卡片已过期",
+	"processing_error":      "网关处理失败",
+	"async_payment_failed":  "支付处理失败",
+	"authentication_failed": "3DS验证失败",
+	"user_cancelled":        "用户取消支付",
+	"payment_expired":       "二维码已过期，支付超时",
+}
@@ -0,0 +1,219 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func authorizeReq(txn, card string, amount float64, capture bool) AuthorizeRequest {
+	return AuthorizeRequest{
+		TransactionID: txn,
+		Amount:        amount,
+		Currency:      "CNY",
+		Card:          Card{Number: card, CVV: 123, ExpMonth: 12, ExpYear: 2030},
+		Capture:       capture,
+	}
+}
+
+// 测试按测试卡号返回的结果
+func TestSimulatorCards(t *testing.T) {
+	tests := []struct {
+		name        string
+		card        string
+		status      Status
+		declineCode string
+	}{
+		{"成功", "4242424242424242", StatusCaptured, ""},
+		{"拒绝", "4000000000000002", StatusDeclined, "card_declined"},
+		{"余额不足", "4000000000009995", StatusDeclined, "insufficient_funds"},
+		{"卡片过期", "4000000000000069", StatusDeclined, "expired_card"},
+		{"网关处理失败", "4000000000000119", StatusFailed, "processing_error"},
+		{"需要3DS", "4000000000003220", StatusRequiresAction, ""},
+		{"异步处理", "4000000000000077", StatusPending, ""},
+	}
+	sim := NewSimulator(SimulatorConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := sim.Authorize(context.Background(), authorizeReq(tt.name, tt.card, 100, true))
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.declineCode, result.DeclineCode)
+			assert.NotEmpty(t, result.ProviderRef)
+			assert.NotEmpty(t, result.Raw)
+			if tt.declineCode != "" {
+				assert.NotEmpty(t, result.Message)
+			}
+			if tt.status == StatusRequiresAction {
+				assert.NotEmpty(t, result.ActionURL)
+			}
+		})
+	}
+}
+
+// 测试按金额触发的结果只在开启MagicAmounts时生效
+func TestSimulatorMagicAmounts(t *testing.T) {
+	off := NewSimulator(SimulatorConfig{})
+	result, err := off.Authorize(context.Background(), authorizeReq("t1", "4242424242424242", 100.02, true))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, result.Status)
+
+	on := NewSimulator(SimulatorConfig{MagicAmounts: true})
+	result, err = on.Authorize(context.Background(), authorizeReq("t1", "4242424242424242", 100.02, true))
+	require.NoError(t, err)
+	assert.Equal(t, StatusDeclined, result.Status)
+
+	result, err = on.Authorize(context.Background(), authorizeReq("t2", "4242424242424242", 100.30, true))
+	require.NoError(t, err)
+	assert.Equal(t, StatusRequiresAction, result.Status)
+}
+
+// 测试配置的卡号覆盖默认测试卡号
+func TestSimulatorConfiguredCards(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{Cards: map[string]string{
+		"4000000000000002": string(OutcomeApprove),
+		"5555555555554444": string(OutcomeDecline),
+	}})
+	result, err := sim.Authorize(context.Background(), authorizeReq("t1", "4000000000000002", 10, true))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, result.Status)
+
+	result, err = sim.Authorize(context.Background(), authorizeReq("t2", "5555555555554444", 10, true))
+	require.NoError(t, err)
+	assert.Equal(t, StatusDeclined, result.Status)
+}
+
+// 测试超时：请求返回ErrTimeout，但网关已扣款，之后可以按交易ID查询到结果
+func TestSimulatorTimeout(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{Timeout: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := sim.Authorize(ctx, authorizeReq("TXN-1", "4000000000000408", 50, true))
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	result, err := sim.QueryStatus(context.Background(), "TXN-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, result.Status)
+
+	// 重试授权返回第一次的结果，不会重复扣款
+	result, err = sim.Authorize(context.Background(), authorizeReq("TXN-1", "4000000000000408", 50, true))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, result.Status)
+}
+
+// 测试异步交易在AsyncDelay之后得到最终结果
+func TestSimulatorAsync(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{AsyncDelay: 20 * time.Millisecond})
+	ok, err := sim.Authorize(context.Background(), authorizeReq("t1", "4000000000000077", 10, true))
+	require.NoError(t, err)
+	fail, err := sim.Authorize(context.Background(), authorizeReq("t2", "4000000000000259", 10, true))
+	require.NoError(t, err)
+
+	result, err := sim.QueryStatus(context.Background(), ok.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, result.Status)
+
+	time.Sleep(30 * time.Millisecond)
+	result, err = sim.QueryStatus(context.Background(), ok.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, result.Status)
+
+	result, err = sim.QueryStatus(context.Background(), fail.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Equal(t, "async_payment_failed", result.DeclineCode)
+
+	_, err = sim.QueryStatus(context.Background(), "sim_unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// 测试3DS验证完成或失败
+func TestSimulatorCompleteAction(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{})
+	passed, err := sim.Authorize(context.Background(), authorizeReq("t1", "4000000000003220", 10, true))
+	require.NoError(t, err)
+	failed, err := sim.Authorize(context.Background(), authorizeReq("t2", "4000000000003220", 10, true))
+	require.NoError(t, err)
+
+	require.NoError(t, sim.CompleteAction(passed.ProviderRef, true))
+	require.NoError(t, sim.CompleteAction(failed.ProviderRef, false))
+	assert.ErrorIs(t, sim.CompleteAction(passed.ProviderRef, true), ErrInvalidState)
+
+	result, err := sim.QueryStatus(context.Background(), passed.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, result.Status)
+
+	result, err = sim.QueryStatus(context.Background(), failed.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDeclined, result.Status)
+}
+
+// 测试授权、扣款、撤销、退款的状态流转
+func TestSimulatorLifecycle(t *testing.T) {
+	ctx := context.Background()
+	sim := NewSimulator(SimulatorConfig{})
+
+	auth, err := sim.Authorize(ctx, authorizeReq("t1", "4242424242424242", 100, false))
+	require.NoError(t, err)
+	assert.Equal(t, StatusAuthorized, auth.Status)
+
+	_, err = sim.Refund(ctx, auth.ProviderRef, "r1", 10)
+	assert.ErrorIs(t, err, ErrInvalidState, "未扣款不能退款")
+	_, err = sim.Capture(ctx, auth.ProviderRef, 120)
+	assert.ErrorIs(t, err, ErrInvalidState, "扣款金额不能超过授权金额")
+
+	captured, err := sim.Capture(ctx, auth.ProviderRef, 80)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, captured.Status)
+	_, err = sim.Void(ctx, auth.ProviderRef)
+	assert.ErrorIs(t, err, ErrInvalidState, "已扣款不能撤销")
+
+	refunded, err := sim.Refund(ctx, auth.ProviderRef, "r2", 30)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPartRefunded, refunded.Status)
+	retried, err := sim.Refund(ctx, auth.ProviderRef, "r2", 30)
+	require.NoError(t, err)
+	assert.Equal(t, refunded, retried, "相同的退款ID不重复退款")
+	_, err = sim.Refund(ctx, auth.ProviderRef, "r3", 60)
+	assert.ErrorIs(t, err, ErrInvalidState, "退款金额不能超过可退金额")
+	refunded, err = sim.Refund(ctx, auth.ProviderRef, "r4", 50)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRefunded, refunded.Status)
+
+	other, err := sim.Authorize(ctx, authorizeReq("t2", "4242424242424242", 100, false))
+	require.NoError(t, err)
+	voided, err := sim.Void(ctx, other.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, StatusVoided, voided.Status)
+	_, err = sim.Capture(ctx, other.ProviderRef, 0)
+	assert.ErrorIs(t, err, ErrInvalidState, "已撤销不能扣款")
+}
+
+// 测试结算记录按创建时间导出交易的最新状态
+func TestSimulatorSettlement(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{})
+	ctx := context.Background()
+	_, err := sim.Authorize(ctx, authorizeReq("TXN-1", "4242424242424242", 99.9, true))
+	require.NoError(t, err)
+	_, err = sim.Authorize(ctx, authorizeReq("TXN-2", "4242424242424242", 50, false))
+	require.NoError(t, err)
+
+	now := time.Now()
+	entries, err := sim.Settlement(ctx, now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "TXN-1", entries[0].TransactionID)
+	assert.Equal(t, "sim_TXN-1", entries[0].ProviderRef)
+	assert.Equal(t, StatusCaptured, entries[0].Status)
+	assert.Equal(t, 99.9, entries[0].CapturedAmount)
+	assert.Equal(t, StatusAuthorized, entries[1].Status)
+	assert.Zero(t, entries[1].CapturedAmount)
+
+	entries, err = sim.Settlement(ctx, now.Add(time.Minute), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultWalletExpiry = 15 * time.Minute
+
+// authorizeWallet 创建钱包支付，返回二维码和跳转地址。金额的分按MagicAmounts决定用户付款时的结果
+func (s *Simulator) authorizeWallet(ref string, req AuthorizeRequest, outcome Outcome) (*Result, error) {
+	if req.Wallet != WalletAlipay && req.Wallet != WalletWeChatPay {
+		return nil, fmt.Errorf("模拟网关不支持的钱包: %s", req.Wallet)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, exists := s.payments[ref]
+	if !exists {
+		now := time.Now()
+		// 钱包支付在用户付款时直接扣款
+		p = &simPayment{
+			status:     StatusRequiresAction,
+			authorized: req.Amount,
+			capture:    true,
+			outcome:    outcome,
+			createdAt:  now,
+			wallet:     req.Wallet,
+			expiresAt:  now.Add(s.cfg.WalletExpiry),
+		}
+		s.payments[ref] = p
+		if s.cfg.NotifyURL != "" && s.cfg.WalletPayDelay > 0 && s.cfg.WalletPayDelay < s.cfg.WalletExpiry {
+			time.AfterFunc(s.cfg.WalletPayDelay, func() { s.notifyWallet(ref) })
+		}
+	}
+	s.advanceWallet(p, time.Now())
+
+	result := s.result(ref, p, p.authorized)
+	if p.status == StatusRequiresAction {
+		result.QRCode = fmt.Sprintf("https://simulator.local/%s/qr/%s", strings.ToLower(p.wallet), ref)
+		result.ActionURL = fmt.Sprintf("https://simulator.local/%s/pay/%s", strings.ToLower(p.wallet), ref)
+		if req.ReturnURL != "" {
+			result.ActionURL += "?return_url=" + url.QueryEscape(req.ReturnURL)
+		}
+		result.ExpiresAt = p.expiresAt
+	}
+	return result, nil
+}
+
+// advanceWallet 按时间推进钱包支付：到WalletPayDelay时模拟用户付款，二维码过期仍未付款时交易失败
+func (s *Simulator) advanceWallet(p *simPayment, now time.Time) {
+	if p.wallet == "" || p.status != StatusRequiresAction {
+		return
+	}
+	paidAt := p.createdAt.Add(s.cfg.WalletPayDelay)
+	switch {
+	case s.cfg.WalletPayDelay > 0 && paidAt.Before(p.expiresAt) && !now.Before(paidAt):
+		switch p.outcome {
+		case OutcomeDecline:
+			p.status, p.declineCode = StatusDeclined, "user_cancelled"
+		case OutcomeInsufficientFunds:
+			p.status, p.declineCode = StatusDeclined, "insufficient_funds"
+		case OutcomeError, OutcomeAsyncFailure:
+			p.status, p.declineCode = StatusFailed, "processing_error"
+		default:
+			p.approve()
+		}
+	case !now.Before(p.expiresAt):
+		p.status, p.declineCode = StatusFailed, "payment_expired"
+	}
+}
+
+// notifyWallet 模拟用户付款后向NotifyURL发送签名的通知。只发送一次，
+// 发送失败时由支付服务的定时任务通过QueryStatus确认结果
+func (s *Simulator) notifyWallet(ref string) {
+	s.mu.Lock()
+	p, ok := s.payments[ref]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	s.advanceWallet(p, time.Now())
+	webhook := SimulatorWebhook{
+		ID:   fmt.Sprintf("evt_%s_%s", ref, strings.ToLower(string(p.status))),
+		Type: "payment.updated",
+		Data: SimulatorWebhookData{
+			ID:            ref,
+			TransactionID: strings.TrimPrefix(ref, "sim_"),
+			Status:        p.status,
+			Amount:        p.authorized,
+			DeclineCode:   p.declineCode,
+		},
+	}
+	s.mu.Unlock()
+
+	payload, signature, err := SignSimulatorWebhook([]byte(s.cfg.WebhookSecret), webhook, time.Now())
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.NotifyURL, bytes.NewReader(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SimulatorSignatureHeader, signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
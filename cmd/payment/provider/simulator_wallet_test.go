@@ -0,0 +1,91 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func walletReq(txn string, amount float64) AuthorizeRequest {
+	return AuthorizeRequest{TransactionID: txn, Amount: amount, Currency: "CNY", Wallet: WalletAlipay, Capture: true}
+}
+
+// 测试钱包支付：先返回二维码，模拟用户付款后查询到扣款成功，金额的分决定付款结果
+func TestSimulatorWallet(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{WalletPayDelay: 20 * time.Millisecond, MagicAmounts: true})
+	ctx := context.Background()
+
+	result, err := sim.Authorize(ctx, walletReq("TXN-1", 99.9))
+	require.NoError(t, err)
+	assert.Equal(t, StatusRequiresAction, result.Status)
+	assert.Contains(t, result.QRCode, "sim_TXN-1")
+	assert.NotEmpty(t, result.ActionURL)
+	assert.WithinDuration(t, time.Now().Add(defaultWalletExpiry), result.ExpiresAt, time.Second)
+
+	// 重复请求返回相同的二维码
+	again, err := sim.Authorize(ctx, walletReq("TXN-1", 99.9))
+	require.NoError(t, err)
+	assert.Equal(t, result.QRCode, again.QRCode)
+
+	_, err = sim.Authorize(ctx, walletReq("TXN-2", 100.02))
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	result, err = sim.QueryStatus(ctx, "sim_TXN-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCaptured, result.Status)
+	result, err = sim.QueryStatus(ctx, "sim_TXN-2")
+	require.NoError(t, err)
+	assert.Equal(t, StatusDeclined, result.Status)
+	assert.Equal(t, "user_cancelled", result.DeclineCode)
+
+	_, err = sim.Authorize(ctx, AuthorizeRequest{TransactionID: "TXN-3", Amount: 1, Wallet: "PAYPAL"})
+	assert.Error(t, err)
+}
+
+// 测试用户不付款时二维码过期，交易失败
+func TestSimulatorWalletExpired(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{WalletExpiry: 10 * time.Millisecond})
+	ctx := context.Background()
+
+	_, err := sim.Authorize(ctx, walletReq("TXN-1", 10))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	result, err := sim.QueryStatus(ctx, "sim_TXN-1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Equal(t, "payment_expired", result.DeclineCode)
+}
+
+// 测试用户付款后向NotifyURL发送可以验证签名的通知
+func TestSimulatorWalletNotify(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	sim := NewSimulator(SimulatorConfig{WalletPayDelay: 10 * time.Millisecond, NotifyURL: server.URL, WebhookSecret: "whsec_test"})
+	_, err := sim.Authorize(context.Background(), walletReq("TXN-1", 10))
+	require.NoError(t, err)
+
+	var r *http.Request
+	select {
+	case r = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("没有收到支付通知")
+	}
+	event, err := sim.ParseWebhook(<-bodies, map[string]string{"simulator-signature": r.Header.Get(SimulatorSignatureHeader)}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "TXN-1", event.TransactionID)
+	assert.Equal(t, StatusCaptured, event.Result.Status)
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SimulatorSignatureHeader 模拟网关通知的签名请求头
+const SimulatorSignatureHeader = "Simulator-Signature"
+
+// SimulatorWebhook 模拟网关通知的内容
+type SimulatorWebhook struct {
+	ID      string               `json:"id"`
+	Type    string               `json:"type"` // payment.updated
+	Created int64                `json:"created"`
+	Data    SimulatorWebhookData `json:"data"`
+}
+
+type SimulatorWebhookData struct {
+	ID            string  `json:"id"` // 网关交易号
+	TransactionID string  `json:"transaction_id"`
+	Status        Status  `json:"status"`
+	Amount        float64 `json:"amount"`
+	DeclineCode   string  `json:"decline_code,omitempty"`
+}
+
+// SignSimulatorWebhook 生成签名的模拟网关通知，返回请求体和签名请求头的值，用于本地测试
+func SignSimulatorWebhook(secret []byte, webhook SimulatorWebhook, now time.Time) ([]byte, string, error) {
+	if webhook.Created == 0 {
+		webhook.Created = now.Unix()
+	}
+	payload, err := json.Marshal(webhook)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, SignPayload(secret, payload, now), nil
+}
+
+// ParseWebhook 校验模拟网关的通知签名
+func (s *Simulator) ParseWebhook(payload []byte, headers map[string]string, now time.Time) (*WebhookEvent, error) {
+	if err := VerifySignature([]byte(s.cfg.WebhookSecret), payload, headers[strings.ToLower(SimulatorSignatureHeader)], now, s.cfg.WebhookTolerance); err != nil {
+		return nil, err
+	}
+	var webhook SimulatorWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	if webhook.ID == "" || (webhook.Data.ID == "" && webhook.Data.TransactionID == "") {
+		return nil, fmt.Errorf("%w: 缺少事件ID或交易号", ErrInvalidWebhook)
+	}
+	ref := webhook.Data.ID
+	if ref == "" {
+		ref = "sim_" + webhook.Data.TransactionID
+	}
+	return &WebhookEvent{
+		ID:            webhook.ID,
+		Type:          webhook.Type,
+		TransactionID: webhook.Data.TransactionID,
+		Result: Result{
+			ProviderRef: ref,
+			Status:      webhook.Data.Status,
+			Amount:      webhook.Data.Amount,
+			DeclineCode: webhook.Data.DeclineCode,
+			Message:     simulatorMessages[webhook.Data.DeclineCode],
+			Raw:         string(payload),
+		},
+		CreatedAt: time.Unix(webhook.Created, 0),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature 异步通知的签名不正确或已过期
+var ErrInvalidSignature = errors.New("通知签名校验失败")
+
+// ErrInvalidWebhook 异步通知的内容无法解析
+var ErrInvalidWebhook = errors.New("通知内容格式不正确")
+
+// DefaultWebhookTolerance 通知签名中的时间与当前时间允许的最大偏差，超过时视为重放
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// WebhookEvent 网关的异步通知，Result为交易的最新状态
+type WebhookEvent struct {
+	ID            string // 网关的事件ID，网关重发时不变，用于去重
+	Type          string
+	TransactionID string // 授权请求中本系统的交易ID
+	Result        Result
+	CreatedAt     time.Time
+}
+
+// WebhookProvider 支持异步通知的网关实现该接口
+type WebhookProvider interface {
+	// ParseWebhook 校验签名并解析通知，headers的键为小写的请求头名称
+	ParseWebhook(payload []byte, headers map[string]string, now time.Time) (*WebhookEvent, error)
+}
+
+// SignPayload 计算通知签名，格式为 t=时间戳,v1=HMAC-SHA256(时间戳.内容)
+func SignPayload(secret, payload []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, computeSignature(secret, ts, payload))
+}
+
+// VerifySignature 校验SignPayload生成的签名，签名时间超出tolerance时拒绝，防止重放
+func VerifySignature(secret, payload []byte, header string, now time.Time, tolerance time.Duration) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("%w: 未配置签名密钥", ErrInvalidSignature)
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: 签名格式不正确", ErrInvalidSignature)
+	}
+	if diff := now.Sub(time.Unix(unix, 0)); diff > tolerance || diff < -tolerance {
+		return fmt.Errorf("%w: 签名已过期", ErrInvalidSignature)
+	}
+
+	expected := computeSignature(secret, ts, payload)
+	// 轮换密钥期间网关可能同时携带多个签名
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func computeSignature(secret []byte, ts string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
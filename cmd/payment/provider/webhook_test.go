@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试签名校验：内容被篡改、密钥错误、签名过期都要拒绝
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("whsec_test")
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	header := SignPayload(secret, payload, now)
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		payload []byte
+		header  string
+		now     time.Time
+		ok      bool
+	}{
+		{"签名正确", secret, payload, header, now, true},
+		{"允许时间偏差", secret, payload, header, now.Add(4 * time.Minute), true},
+		{"多个签名之一正确", secret, payload, "t=1700000000,v1=deadbeef," + header[len("t=1700000000,"):], now, true},
+		{"内容被篡改", secret, []byte(`{"id":"evt_2"}`), header, now, false},
+		{"密钥错误", []byte("other"), payload, header, now, false},
+		{"签名过期", secret, payload, header, now.Add(6 * time.Minute), false},
+		{"格式错误", secret, payload, "v1=abc", now, false},
+		{"未配置密钥", nil, payload, header, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.secret, tt.payload, tt.header, tt.now, 0)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+// 测试模拟网关通知的签名和解析
+func TestSimulatorParseWebhook(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{WebhookSecret: "whsec_test"})
+	now := time.Now()
+	payload, signature, err := SignSimulatorWebhook([]byte("whsec_test"), SimulatorWebhook{
+		ID:   "evt_1",
+		Type: "payment.updated",
+		Data: SimulatorWebhookData{TransactionID: "TXN-1", Status: StatusDeclined, DeclineCode: "insufficient_funds"},
+	}, now)
+	require.NoError(t, err)
+
+	event, err := sim.ParseWebhook(payload, map[string]string{"simulator-signature": signature}, now)
+	require.NoError(t, err)
+	assert.Equal(t, "evt_1", event.ID)
+	assert.Equal(t, "TXN-1", event.TransactionID)
+	assert.Equal(t, "sim_TXN-1", event.Result.ProviderRef)
+	assert.Equal(t, StatusDeclined, event.Result.Status)
+	assert.Equal(t, "余额不足", event.Result.Message)
+
+	_, err = sim.ParseWebhook(payload, map[string]string{}, now)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
@@ -0,0 +1,87 @@
+package risk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+// Config 风控配置，对应配置文件中的risk
+type Config struct {
+	Enabled              bool              `mapstructure:"enabled"`
+	ReviewScore          int               `mapstructure:"review_score"`
+	RejectScore          int               `mapstructure:"reject_score"`
+	Velocity             []VelocityConfig  `mapstructure:"velocity"`
+	AmountThresholds     []AmountThreshold `mapstructure:"amount_thresholds"`
+	CountryMismatchScore int               `mapstructure:"country_mismatch_score"`
+	BlacklistedBINs      []string          `mapstructure:"blacklisted_bins"`
+	BlacklistedBINScore  int               `mapstructure:"blacklisted_bin_score"`
+}
+
+// VelocityConfig 频率规则的配置
+type VelocityConfig struct {
+	Dimension     string `mapstructure:"dimension"`
+	WindowSeconds int    `mapstructure:"window_seconds"`
+	Limit         int64  `mapstructure:"limit"`
+	Score         int    `mapstructure:"score"`
+}
+
+// NewEngine 按配置创建规则，分数为0的规则不启用
+func NewEngine(cfg Config, counter Counter) (*Engine, error) {
+	engine := &Engine{ReviewScore: cfg.ReviewScore, RejectScore: cfg.RejectScore}
+	if engine.RejectScore > 0 && engine.ReviewScore >= engine.RejectScore {
+		return nil, fmt.Errorf("review_score必须小于reject_score")
+	}
+
+	for _, v := range cfg.Velocity {
+		if _, ok := dimensionNames[v.Dimension]; !ok {
+			return nil, fmt.Errorf("不支持的统计维度: %s", v.Dimension)
+		}
+		if v.WindowSeconds <= 0 || v.Limit <= 0 {
+			return nil, fmt.Errorf("频率规则%s的window_seconds和limit必须大于0", v.Dimension)
+		}
+		if v.Score == 0 {
+			continue
+		}
+		engine.Rules = append(engine.Rules, &VelocityRule{
+			Dimension: v.Dimension,
+			Window:    time.Duration(v.WindowSeconds) * time.Second,
+			Limit:     v.Limit,
+			Score:     v.Score,
+			Counter:   counter,
+		})
+	}
+	if len(cfg.AmountThresholds) > 0 {
+		engine.Rules = append(engine.Rules, &AmountRule{Thresholds: cfg.AmountThresholds})
+	}
+	if cfg.CountryMismatchScore != 0 {
+		engine.Rules = append(engine.Rules, &CountryMismatchRule{Score: cfg.CountryMismatchScore})
+	}
+	if len(cfg.BlacklistedBINs) > 0 {
+		for _, prefix := range cfg.BlacklistedBINs {
+			if prefix == "" || len(prefix) > 8 {
+				return nil, fmt.Errorf("BIN黑名单的前缀必须为1到8位: %q", prefix)
+			}
+		}
+		score := cfg.BlacklistedBINScore
+		if score == 0 {
+			score = cfg.RejectScore
+		}
+		engine.Rules = append(engine.Rules, &BINBlacklistRule{Prefixes: cfg.BlacklistedBINs, Score: score})
+	}
+	return engine, nil
+}
+
+// NewEngineFromConfig 读取配置文件中的risk创建风控引擎，未启用时返回nil
+func NewEngineFromConfig(rdb *redis.Client) (*Engine, error) {
+	var cfg Config
+	if err := viper.UnmarshalKey("risk", &cfg); err != nil {
+		return nil, fmt.Errorf("解析风控配置失败: %w", err)
+	}
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	return NewEngine(cfg, &RedisCounter{Client: rdb})
+}
@@ -0,0 +1,81 @@
+// Package risk 支付授权前的风控评分。每条规则命中时给出分数和原因，
+// 总分达到复核分数时交易需要人工审核，达到拒绝分数时拒绝支付。
+package risk
+
+import (
+	"context"
+	"fmt"
+)
+
+// Decision 风控结论
+type Decision string
+
+const (
+	DecisionApprove Decision = "APPROVE" // 放行
+	DecisionReview  Decision = "REVIEW"  // 放行授权，人工审核通过前不能扣款
+	DecisionReject  Decision = "REJECT"  // 拒绝支付，不请求网关
+)
+
+// Input 评分使用的支付信息，不包含完整卡号
+type Input struct {
+	UserID          int64
+	Amount          float64
+	Currency        string
+	PaymentMethod   string
+	CardFingerprint string // 卡号指纹，同一张卡相同，没有卡号保险库时为空
+	BIN             string // 卡号前8位
+	IP              string // 客户端IP
+	IPCountry       string // 客户端IP所在国家，ISO 3166-1 alpha-2
+	BillingCountry  string // 账单地址国家
+}
+
+// Signal 命中的规则
+type Signal struct {
+	Rule   string `json:"rule"`
+	Score  int    `json:"score"`
+	Reason string `json:"reason"`
+}
+
+// Assessment 评分结果
+type Assessment struct {
+	Score    int
+	Decision Decision
+	Signals  []Signal
+}
+
+// Rule 风控规则，未命中时返回nil
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, in *Input) (*Signal, error)
+}
+
+// Engine 依次执行规则并累加分数
+type Engine struct {
+	Rules       []Rule
+	ReviewScore int // 总分达到时需要人工审核，为0时不审核
+	RejectScore int // 总分达到时拒绝支付，为0时不拒绝
+}
+
+// Assess 计算风控评分。规则执行失败时不影响支付，记录一条0分的信号便于排查
+func (e *Engine) Assess(ctx context.Context, in *Input) *Assessment {
+	assessment := &Assessment{Decision: DecisionApprove}
+	for _, rule := range e.Rules {
+		signal, err := rule.Evaluate(ctx, in)
+		if err != nil {
+			signal = &Signal{Rule: rule.Name(), Reason: fmt.Sprintf("规则执行失败: %v", err)}
+		}
+		if signal == nil {
+			continue
+		}
+		assessment.Score += signal.Score
+		assessment.Signals = append(assessment.Signals, *signal)
+	}
+
+	switch {
+	case e.RejectScore > 0 && assessment.Score >= e.RejectScore:
+		assessment.Decision = DecisionReject
+	case e.ReviewScore > 0 && assessment.Score >= e.ReviewScore:
+		assessment.Decision = DecisionReview
+	}
+	return assessment
+}
@@ -0,0 +1,142 @@
+package risk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCounter(t *testing.T) (*miniredis.Miniredis, *RedisCounter) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, &RedisCounter{Client: client}
+}
+
+// 测试同一张卡在时间窗口内超过次数后命中，换卡或窗口过后重新计数
+func TestVelocityRule(t *testing.T) {
+	mr, counter := newTestCounter(t)
+	rule := &VelocityRule{Dimension: DimensionCard, Window: time.Minute, Limit: 2, Score: 60, Counter: counter}
+	ctx := context.Background()
+	in := &Input{UserID: 1, CardFingerprint: "fp1"}
+
+	for i := 0; i < 2; i++ {
+		signal, err := rule.Evaluate(ctx, in)
+		require.NoError(t, err)
+		assert.Nil(t, signal)
+	}
+	signal, err := rule.Evaluate(ctx, in)
+	require.NoError(t, err)
+	require.NotNil(t, signal)
+	assert.Equal(t, "velocity_card_60s", signal.Rule)
+	assert.Equal(t, 60, signal.Score)
+
+	signal, err = rule.Evaluate(ctx, &Input{UserID: 1, CardFingerprint: "fp2"})
+	require.NoError(t, err)
+	assert.Nil(t, signal, "不同的卡分别计数")
+
+	signal, err = rule.Evaluate(ctx, &Input{UserID: 1})
+	require.NoError(t, err)
+	assert.Nil(t, signal, "没有卡号指纹时不统计")
+
+	mr.FastForward(2 * time.Minute)
+	assert.Empty(t, mr.Keys(), "计数在窗口结束后过期")
+}
+
+func TestRules(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		rule  Rule
+		in    Input
+		score int // 0表示不命中
+	}{
+		{"金额未超过阈值", &AmountRule{Thresholds: []AmountThreshold{{5000, 20}, {20000, 50}}}, Input{Amount: 5000}, 0},
+		{"金额取最高一档", &AmountRule{Thresholds: []AmountThreshold{{5000, 20}, {20000, 50}}}, Input{Amount: 30000}, 50},
+		{"国家一致", &CountryMismatchRule{Score: 30}, Input{IPCountry: "cn", BillingCountry: "CN"}, 0},
+		{"国家未知", &CountryMismatchRule{Score: 30}, Input{BillingCountry: "CN"}, 0},
+		{"国家不一致", &CountryMismatchRule{Score: 30}, Input{IPCountry: "US", BillingCountry: "CN"}, 30},
+		{"BIN在黑名单", &BINBlacklistRule{Prefixes: []string{"411111"}, Score: 100}, Input{BIN: "41111111"}, 100},
+		{"BIN不在黑名单", &BINBlacklistRule{Prefixes: []string{"411111"}, Score: 100}, Input{BIN: "42424242"}, 0},
+		{"钱包支付没有BIN", &BINBlacklistRule{Prefixes: []string{"4"}, Score: 100}, Input{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal, err := tt.rule.Evaluate(ctx, &tt.in)
+			require.NoError(t, err)
+			if tt.score == 0 {
+				assert.Nil(t, signal)
+				return
+			}
+			require.NotNil(t, signal)
+			assert.Equal(t, tt.score, signal.Score)
+			assert.Equal(t, tt.rule.Name(), signal.Rule)
+		})
+	}
+}
+
+type failingRule struct{}
+
+func (failingRule) Name() string { return "failing" }
+func (failingRule) Evaluate(context.Context, *Input) (*Signal, error) {
+	return nil, errors.New("redis unavailable")
+}
+
+// 测试分数累加和结论，规则执行失败时不影响结论
+func TestEngineAssess(t *testing.T) {
+	engine := &Engine{
+		Rules: []Rule{
+			failingRule{},
+			&AmountRule{Thresholds: []AmountThreshold{{1000, 30}}},
+			&CountryMismatchRule{Score: 30},
+		},
+		ReviewScore: 50,
+		RejectScore: 80,
+	}
+	ctx := context.Background()
+
+	assessment := engine.Assess(ctx, &Input{Amount: 100})
+	assert.Equal(t, DecisionApprove, assessment.Decision)
+	assert.Equal(t, 0, assessment.Score)
+	require.Len(t, assessment.Signals, 1)
+	assert.Equal(t, "failing", assessment.Signals[0].Rule)
+
+	assessment = engine.Assess(ctx, &Input{Amount: 2000, IPCountry: "US", BillingCountry: "CN"})
+	assert.Equal(t, DecisionReview, assessment.Decision)
+	assert.Equal(t, 60, assessment.Score)
+
+	engine.RejectScore = 60
+	engine.ReviewScore = 30
+	assessment = engine.Assess(ctx, &Input{Amount: 2000, IPCountry: "US", BillingCountry: "CN"})
+	assert.Equal(t, DecisionReject, assessment.Decision)
+}
+
+func TestNewEngine(t *testing.T) {
+	_, counter := newTestCounter(t)
+	engine, err := NewEngine(Config{
+		ReviewScore: 50,
+		RejectScore: 80,
+		Velocity: []VelocityConfig{
+			{Dimension: DimensionUser, WindowSeconds: 60, Limit: 5, Score: 40},
+			{Dimension: DimensionIP, WindowSeconds: 60, Limit: 5, Score: 0},
+		},
+		CountryMismatchScore: 30,
+		BlacklistedBINs:      []string{"411111"},
+	}, counter)
+	require.NoError(t, err)
+	require.Len(t, engine.Rules, 3, "分数为0的规则不启用")
+	assert.Equal(t, 80, engine.Rules[2].(*BINBlacklistRule).Score, "BIN黑名单默认直接拒绝")
+
+	_, err = NewEngine(Config{Velocity: []VelocityConfig{{Dimension: "device", WindowSeconds: 60, Limit: 1, Score: 10}}}, counter)
+	assert.Error(t, err)
+	_, err = NewEngine(Config{ReviewScore: 80, RejectScore: 50}, counter)
+	assert.Error(t, err)
+	_, err = NewEngine(Config{BlacklistedBINs: []string{"4111111111"}}, counter)
+	assert.Error(t, err)
+}
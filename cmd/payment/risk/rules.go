@@ -0,0 +1,166 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 频率规则统计的维度
+const (
+	DimensionUser = "user"
+	DimensionCard = "card"
+	DimensionIP   = "ip"
+)
+
+var dimensionNames = map[string]string{
+	DimensionUser: "用户",
+	DimensionCard: "卡号",
+	DimensionIP:   "IP",
+}
+
+// Counter 按时间窗口计数
+type Counter interface {
+	// Incr 计数加1，返回当前窗口内的次数
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+// RedisCounter 使用Redis的固定时间窗口计数，多个支付服务实例共享计数
+type RedisCounter struct {
+	Client *redis.Client
+}
+
+func (c *RedisCounter) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	bucket := time.Now().UnixNano() / int64(window)
+	key = fmt.Sprintf("%s:%d", key, bucket)
+
+	pipe := c.Client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// VelocityRule 同一用户、卡或IP在时间窗口内的支付次数超过Limit时命中。
+// 被拒绝的支付同样计数，用于发现盗刷时短时间内的大量尝试
+type VelocityRule struct {
+	Dimension string
+	Window    time.Duration
+	Limit     int64
+	Score     int
+	Counter   Counter
+}
+
+func (r *VelocityRule) Name() string {
+	return fmt.Sprintf("velocity_%s_%ds", r.Dimension, int64(r.Window/time.Second))
+}
+
+func (r *VelocityRule) Evaluate(ctx context.Context, in *Input) (*Signal, error) {
+	var value string
+	switch r.Dimension {
+	case DimensionUser:
+		if in.UserID != 0 {
+			value = fmt.Sprint(in.UserID)
+		}
+	case DimensionCard:
+		value = in.CardFingerprint
+	case DimensionIP:
+		value = in.IP
+	default:
+		return nil, fmt.Errorf("不支持的统计维度: %s", r.Dimension)
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	count, err := r.Counter.Incr(ctx, fmt.Sprintf("risk:velocity:%s:%d:%s", r.Dimension, int64(r.Window/time.Second), value), r.Window)
+	if err != nil {
+		return nil, err
+	}
+	if count <= r.Limit {
+		return nil, nil
+	}
+	return &Signal{
+		Rule:   r.Name(),
+		Score:  r.Score,
+		Reason: fmt.Sprintf("同一%s在%s内支付%d次，超过%d次", dimensionNames[r.Dimension], r.Window, count, r.Limit),
+	}, nil
+}
+
+// AmountThreshold 金额阈值
+type AmountThreshold struct {
+	Amount float64 `mapstructure:"amount"`
+	Score  int     `mapstructure:"score"`
+}
+
+// AmountRule 支付金额超过阈值时命中，取超过的最高一档
+type AmountRule struct {
+	Thresholds []AmountThreshold
+}
+
+func (r *AmountRule) Name() string { return "amount" }
+
+func (r *AmountRule) Evaluate(ctx context.Context, in *Input) (*Signal, error) {
+	var matched *AmountThreshold
+	for i := range r.Thresholds {
+		threshold := &r.Thresholds[i]
+		if in.Amount > threshold.Amount && (matched == nil || threshold.Amount > matched.Amount) {
+			matched = threshold
+		}
+	}
+	if matched == nil {
+		return nil, nil
+	}
+	return &Signal{
+		Rule:   r.Name(),
+		Score:  matched.Score,
+		Reason: fmt.Sprintf("支付金额%.2f超过%.2f", in.Amount, matched.Amount),
+	}, nil
+}
+
+// CountryMismatchRule 客户端IP所在国家与账单地址国家不一致时命中，任一未知时不命中
+type CountryMismatchRule struct {
+	Score int
+}
+
+func (r *CountryMismatchRule) Name() string { return "country_mismatch" }
+
+func (r *CountryMismatchRule) Evaluate(ctx context.Context, in *Input) (*Signal, error) {
+	if in.IPCountry == "" || in.BillingCountry == "" || strings.EqualFold(in.IPCountry, in.BillingCountry) {
+		return nil, nil
+	}
+	return &Signal{
+		Rule:   r.Name(),
+		Score:  r.Score,
+		Reason: fmt.Sprintf("IP所在国家%s与账单地址国家%s不一致", strings.ToUpper(in.IPCountry), strings.ToUpper(in.BillingCountry)),
+	}, nil
+}
+
+// BINBlacklistRule 卡号前缀在黑名单中时命中，前缀最多8位
+type BINBlacklistRule struct {
+	Prefixes []string
+	Score    int
+}
+
+func (r *BINBlacklistRule) Name() string { return "blacklisted_bin" }
+
+func (r *BINBlacklistRule) Evaluate(ctx context.Context, in *Input) (*Signal, error) {
+	if in.BIN == "" {
+		return nil, nil
+	}
+	for _, prefix := range r.Prefixes {
+		if prefix != "" && strings.HasPrefix(in.BIN, prefix) {
+			return &Signal{
+				Rule:   r.Name(),
+				Score:  r.Score,
+				Reason: fmt.Sprintf("卡号BIN %s 在黑名单中", prefix),
+			}, nil
+		}
+	}
+	return nil, nil
+}
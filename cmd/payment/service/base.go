@@ -1,8 +1,15 @@
 package service
 
 import (
+	"time"
+
 	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/card"
+	"TKMall/cmd/payment/provider"
+	"TKMall/cmd/payment/risk"
+	"TKMall/cmd/payment/vault"
 	"TKMall/common/events"
+	"TKMall/common/idempotency"
 	"TKMall/common/proxy"
 
 	"github.com/bwmarrin/snowflake"
@@ -18,20 +25,37 @@ type PaymentServiceServer struct {
 	Node     *snowflake.Node
 	Proxy    proxy.ServiceProxy
 	EventBus events.EventBus
+	Provider provider.PaymentProvider
+
+	// 单次请求支付网关的超时时间，为0时使用DefaultGatewayTimeout
+	GatewayTimeout time.Duration
+
+	// 幂等键存储，为nil时不做幂等处理
+	Idempotency *idempotency.Store
+
+	// 预授权的有效期，为0时使用DefaultAuthorizationTTL
+	AuthorizationTTL time.Duration
+
+	// 卡号保险库，为nil时只能直接传卡号支付
+	Vault *vault.Vault
+
+	// 识别卡组织和校验卡号使用的BIN表，为nil时使用内置的BIN表
+	BINs *card.BINTable
+
+	// 授权前的风控评分，为nil时不做风控
+	Risk *risk.Engine
 }
 
-// 信用卡相关常量
+const DefaultGatewayTimeout = 10 * time.Second
+
+// DefaultAuthorizationTTL 预授权的默认有效期，与发卡行冻结资金的期限一致
+const DefaultAuthorizationTTL = 7 * 24 * time.Hour
+
+// 支付方式
 const (
-	// 支付方式
 	PaymentMethodCreditCard = "CREDIT_CARD"
 	PaymentMethodAliPay     = "ALIPAY"
 	PaymentMethodWeChatPay  = "WECHAT_PAY"
-
-	// 卡类型
-	CardTypeVisa       = "VISA"
-	CardTypeMasterCard = "MASTERCARD"
-	CardTypeUnionPay   = "UNIONPAY"
-	CardTypeAmex       = "AMEX"
 )
 
 // 从信用卡号中获取后四位
@@ -42,29 +66,10 @@ func getLastFourDigits(cardNumber string) string {
 	return cardNumber[len(cardNumber)-4:]
 }
 
-// 识别信用卡类型
-func detectCardType(cardNumber string) string {
-	if len(cardNumber) == 0 {
-		return ""
-	}
-
-	// 简化的信用卡类型检测
-	firstDigit := cardNumber[0]
-	firstTwoDigits := ""
-	if len(cardNumber) >= 2 {
-		firstTwoDigits = cardNumber[0:2]
-	}
-
-	switch {
-	case firstDigit == '4':
-		return CardTypeVisa
-	case firstTwoDigits >= "51" && firstTwoDigits <= "55":
-		return CardTypeMasterCard
-	case firstTwoDigits == "62":
-		return CardTypeUnionPay
-	case firstDigit == '3' && (cardNumber[1] == '4' || cardNumber[1] == '7'):
-		return CardTypeAmex
-	default:
-		return "UNKNOWN"
+// binTable 识别卡组织和校验卡号使用的BIN表
+func (s *PaymentServiceServer) binTable() *card.BINTable {
+	if s.BINs != nil {
+		return s.BINs
 	}
+	return card.DefaultBINTable()
 }
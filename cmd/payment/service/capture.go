@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/common/events"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuthorizationSweepInterval = 5 * time.Minute
+	authorizationSweepBatchSize       = 100
+)
+
+// Capture 对预授权的交易扣款。已扣款的交易直接返回扣款结果，订单服务重试发货时不会出错
+func (s *PaymentServiceServer) Capture(ctx context.Context, req *payment.CaptureReq) (*payment.CaptureResp, error) {
+	if req.TransactionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "交易ID不能为空")
+	}
+	if req.Amount < 0 {
+		return nil, status.Error(codes.InvalidArgument, "扣款金额不能小于0")
+	}
+	transaction, err := s.findTransaction(ctx, req.TransactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch transaction.Status {
+	case model.PaymentStatusAuthorized:
+	case model.PaymentStatusCompleted, model.PaymentStatusCaptured,
+		model.PaymentStatusPartRefunded, model.PaymentStatusRefunded:
+		return captureResp(transaction), nil
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "交易状态不允许扣款，当前状态: %s", transaction.Status)
+	}
+	if transaction.Risk.ReviewStatus == model.RiskReviewPending {
+		return nil, status.Error(codes.FailedPrecondition, "交易待风控审核，审核通过后才能扣款")
+	}
+	if transaction.AuthExpiresAt != nil && transaction.AuthExpiresAt.Before(time.Now()) {
+		return nil, status.Error(codes.FailedPrecondition, "预授权已过期")
+	}
+	amount := transaction.Amount
+	if req.Amount > 0 {
+		amount = roundAmount(float64(req.Amount))
+		if amount > transaction.Amount {
+			return nil, status.Errorf(codes.FailedPrecondition, "扣款金额超过授权金额%.2f", transaction.Amount)
+		}
+	}
+
+	gatewayCtx, cancel := context.WithTimeout(ctx, s.gatewayTimeout())
+	result, err := s.Provider.Capture(gatewayCtx, transaction.ProviderRef, amount)
+	cancel()
+	if errors.Is(err, provider.ErrInvalidState) {
+		// 上次扣款请求超时但网关已经扣款时，以网关的状态为准
+		result, err = s.queryGateway(ctx, transaction.ProviderRef)
+	}
+	if err != nil {
+		return nil, gatewayError("扣款", err)
+	}
+	if result.Status != provider.StatusCaptured {
+		return nil, status.Errorf(codes.FailedPrecondition, "扣款失败，网关状态: %s", result.Status)
+	}
+
+	now := time.Now()
+	transaction.Status = model.PaymentStatusCaptured
+	transaction.CapturedAmount = amount
+	transaction.CapturedAt = &now
+	transaction.GatewayResponseRaw = result.Raw
+	saved := s.DB.WithContext(ctx).Model(&model.Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, model.PaymentStatusAuthorized).
+		Updates(map[string]interface{}{
+			"status":               transaction.Status,
+			"captured_amount":      transaction.CapturedAmount,
+			"captured_at":          now,
+			"gateway_response_raw": transaction.GatewayResponseRaw,
+		})
+	if saved.Error != nil {
+		return nil, status.Errorf(codes.Internal, "保存交易记录失败: %v", saved.Error)
+	}
+	if saved.RowsAffected == 0 {
+		// 并发的扣款或撤销已经修改了交易，以保存的状态为准
+		current, err := s.findTransaction(ctx, transaction.TransactionID)
+		if err != nil {
+			return nil, err
+		}
+		switch current.Status {
+		case model.PaymentStatusCompleted, model.PaymentStatusCaptured,
+			model.PaymentStatusPartRefunded, model.PaymentStatusRefunded:
+			return captureResp(current), nil
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "交易状态已变化，当前状态: %s", current.Status)
+	}
+	return captureResp(transaction), nil
+}
+
+// Void 撤销未扣款的预授权，释放冻结的金额。已撤销的交易直接返回成功
+func (s *PaymentServiceServer) Void(ctx context.Context, req *payment.VoidReq) (*payment.VoidResp, error) {
+	if req.TransactionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "交易ID不能为空")
+	}
+	transaction, err := s.findTransaction(ctx, req.TransactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch transaction.Status {
+	case model.PaymentStatusVoided:
+	case model.PaymentStatusAuthorized, model.PaymentStatusRequiresAction:
+		if err := s.voidTransaction(ctx, transaction, "", req.Reason); err != nil {
+			return nil, err
+		}
+	case model.PaymentStatusCompleted, model.PaymentStatusCaptured, model.PaymentStatusPartRefunded:
+		return nil, status.Error(codes.FailedPrecondition, "交易已扣款，请使用退款")
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "交易状态不允许撤销，当前状态: %s", transaction.Status)
+	}
+	return &payment.VoidResp{
+		TransactionId: transaction.TransactionID,
+		Status:        string(model.PaymentStatusVoided),
+	}, nil
+}
+
+// voidTransaction 请求网关撤销授权并保存为VOIDED
+func (s *PaymentServiceServer) voidTransaction(ctx context.Context, transaction *model.Transaction, errorCode, message string) error {
+	gatewayCtx, cancel := context.WithTimeout(ctx, s.gatewayTimeout())
+	result, err := s.Provider.Void(gatewayCtx, transaction.ProviderRef)
+	cancel()
+	if errors.Is(err, provider.ErrInvalidState) {
+		result, err = s.queryGateway(ctx, transaction.ProviderRef)
+	}
+	if err != nil {
+		return gatewayError("撤销授权", err)
+	}
+	if result.Status != provider.StatusVoided {
+		return status.Errorf(codes.FailedPrecondition, "撤销授权失败，网关状态: %s", result.Status)
+	}
+
+	previous := transaction.Status
+	saved := s.DB.WithContext(ctx).Model(&model.Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, previous).
+		Updates(map[string]interface{}{
+			"status":               model.PaymentStatusVoided,
+			"error_code":           errorCode,
+			"error_message":        message,
+			"gateway_response_raw": result.Raw,
+		})
+	if saved.Error != nil {
+		return status.Errorf(codes.Internal, "保存交易记录失败: %v", saved.Error)
+	}
+	if saved.RowsAffected == 0 {
+		// 并发的撤销已经保存时返回成功，其他状态说明交易已被扣款等操作修改
+		current, err := s.findTransaction(ctx, transaction.TransactionID)
+		if err != nil {
+			return err
+		}
+		*transaction = *current
+		if current.Status != model.PaymentStatusVoided {
+			return status.Errorf(codes.FailedPrecondition, "交易状态已变化，当前状态: %s", current.Status)
+		}
+		return nil
+	}
+	transaction.Status = model.PaymentStatusVoided
+	transaction.ErrorCode = errorCode
+	transaction.ErrorMessage = message
+	transaction.GatewayResponseRaw = result.Raw
+	return nil
+}
+
+// RunAuthorizationSweeper 定期撤销过期的预授权
+func (s *PaymentServiceServer) RunAuthorizationSweeper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultAuthorizationSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.VoidExpiredAuthorizations(ctx, time.Now()); err != nil {
+				log.Errorf("撤销过期预授权失败: %v", err)
+			}
+		}
+	}
+}
+
+// VoidExpiredAuthorizations 撤销now之前过期的预授权，并通知订单服务取消订单
+func (s *PaymentServiceServer) VoidExpiredAuthorizations(ctx context.Context, now time.Time) error {
+	var transactions []model.Transaction
+	if err := s.DB.WithContext(ctx).
+		Where("status = ? AND auth_expires_at < ?", model.PaymentStatusAuthorized, now).
+		Order("auth_expires_at ASC").Limit(authorizationSweepBatchSize).
+		Find(&transactions).Error; err != nil {
+		return err
+	}
+
+	for i := range transactions {
+		transaction := &transactions[i]
+		if err := s.voidTransaction(ctx, transaction, "AUTHORIZATION_EXPIRED", "预授权已过期"); err != nil {
+			log.Errorf("撤销过期预授权失败: transaction=%s: %v", transaction.TransactionID, err)
+			continue
+		}
+		s.publishAuthorizationExpired(transaction, now)
+	}
+	return nil
+}
+
+func (s *PaymentServiceServer) publishAuthorizationExpired(transaction *model.Transaction, expiredAt time.Time) {
+	if s.EventBus == nil {
+		return
+	}
+	event := events.Event{
+		Type: events.PaymentAuthorizationExpired,
+		Payload: events.PaymentAuthorizationExpiredPayload{
+			TransactionID: transaction.TransactionID,
+			OrderID:       transaction.OrderID,
+			UserID:        transaction.UserID,
+			Amount:        transaction.Amount,
+			ExpiredAt:     expiredAt,
+		},
+		Timestamp: time.Now(),
+	}
+	go func() {
+		if err := s.EventBus.Publish(context.Background(), event); err != nil {
+			log.Errorf("发布预授权过期事件失败: transaction=%s: %v", transaction.TransactionID, err)
+		}
+	}()
+}
+
+func (s *PaymentServiceServer) findTransaction(ctx context.Context, transactionID string) (*model.Transaction, error) {
+	var transaction model.Transaction
+	if err := s.DB.WithContext(ctx).Where("transaction_id = ?", transactionID).First(&transaction).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "交易不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询交易失败: %v", err)
+	}
+	return &transaction, nil
+}
+
+func (s *PaymentServiceServer) queryGateway(ctx context.Context, providerRef string) (*provider.Result, error) {
+	gatewayCtx, cancel := context.WithTimeout(ctx, s.gatewayTimeout())
+	defer cancel()
+	return s.Provider.QueryStatus(gatewayCtx, providerRef)
+}
+
+// gatewayError 将网关错误转换为gRPC错误，超时时结果未知，调用方可以重试
+func gatewayError(action string, err error) error {
+	switch {
+	case errors.Is(err, provider.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return status.Errorf(codes.DeadlineExceeded, "%s结果未知，请重试", action)
+	case errors.Is(err, provider.ErrNotFound):
+		return status.Errorf(codes.FailedPrecondition, "%s失败: %v", action, err)
+	default:
+		return status.Errorf(codes.Unavailable, "支付网关不可用: %v", err)
+	}
+}
+
+func captureResp(transaction *model.Transaction) *payment.CaptureResp {
+	return &payment.CaptureResp{
+		TransactionId:  transaction.TransactionID,
+		Status:         string(transaction.Status),
+		CapturedAmount: float32(capturedAmount(transaction)),
+	}
+}
+
+// capturedAmount 交易实际扣款的金额，早期的一步扣款交易没有记录扣款金额，即为交易金额
+func capturedAmount(transaction *model.Transaction) float64 {
+	if transaction.CapturedAmount > 0 {
+		return transaction.CapturedAmount
+	}
+	return transaction.Amount
+}
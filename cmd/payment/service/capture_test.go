@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authorizedSimulator 返回已对TXN-1预授权100元的模拟网关
+func authorizedSimulator(t *testing.T) *provider.Simulator {
+	sim := provider.NewSimulator(provider.SimulatorConfig{})
+	_, err := sim.Authorize(context.Background(), provider.AuthorizeRequest{
+		TransactionID: "TXN-1", Amount: 100, Card: provider.Card{Number: "4242424242424242"},
+	})
+	require.NoError(t, err)
+	return sim
+}
+
+func authorizedRows(status model.PaymentStatus, expiresAt time.Time) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "amount", "status", "provider_ref", "auth_expires_at"}).
+		AddRow(1, "TXN-1", "ORD-1", 1001, 100.0, status, "sim_TXN-1", expiresAt)
+}
+
+// 测试部分扣款，重复扣款返回第一次的结果
+func TestCapture(t *testing.T) {
+	sim := authorizedSimulator(t)
+	s, mock := newChargeTestServer(t, sim, &orderProxy{})
+	expiresAt := time.Now().Add(time.Hour)
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusAuthorized, expiresAt))
+	// Updates的字段按名称排序：captured_amount, captured_at, gateway_response_raw, status, updated_at
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(80.0, sqlmock.AnyArg(), sqlmock.AnyArg(), model.PaymentStatusCaptured, sqlmock.AnyArg(), 1, model.PaymentStatusAuthorized).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := s.Capture(context.Background(), &payment.CaptureReq{TransactionId: "TXN-1", Amount: 80})
+	require.NoError(t, err)
+	assert.Equal(t, string(model.PaymentStatusCaptured), resp.Status)
+	assert.InDelta(t, 80, resp.CapturedAmount, 0.001)
+
+	rows := authorizedRows(model.PaymentStatusCaptured, expiresAt)
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(rows)
+	resp, err = s.Capture(context.Background(), &payment.CaptureReq{TransactionId: "TXN-1"})
+	require.NoError(t, err, "重复扣款不能失败")
+	assert.Equal(t, string(model.PaymentStatusCaptured), resp.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试不能扣款的情况不请求网关
+func TestCaptureRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     *sqlmock.Rows
+		amount   float32
+		wantCode codes.Code
+	}{
+		{"预授权已过期", authorizedRows(model.PaymentStatusAuthorized, time.Now().Add(-time.Minute)), 0, codes.FailedPrecondition},
+		{"超过授权金额", authorizedRows(model.PaymentStatusAuthorized, time.Now().Add(time.Hour)), 120, codes.FailedPrecondition},
+		{"已撤销", authorizedRows(model.PaymentStatusVoided, time.Now().Add(time.Hour)), 0, codes.FailedPrecondition},
+		{"交易不存在", sqlmock.NewRows([]string{"id"}), 0, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newChargeTestServer(t, brokenProvider{}, &orderProxy{})
+			mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(tt.rows)
+
+			_, err := s.Capture(context.Background(), &payment.CaptureReq{TransactionId: "TXN-1", Amount: tt.amount})
+			assert.Equal(t, tt.wantCode, status.Code(err), "%v", err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// 测试撤销预授权，已扣款的交易只能退款
+func TestVoid(t *testing.T) {
+	s, mock := newChargeTestServer(t, authorizedSimulator(t), &orderProxy{})
+	expiresAt := time.Now().Add(time.Hour)
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusAuthorized, expiresAt))
+	// Updates的字段按名称排序：error_code, error_message, gateway_response_raw, status, updated_at
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs("", "用户取消订单", sqlmock.AnyArg(), model.PaymentStatusVoided, sqlmock.AnyArg(), 1, model.PaymentStatusAuthorized).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err := s.Void(context.Background(), &payment.VoidReq{TransactionId: "TXN-1", Reason: "用户取消订单"})
+	require.NoError(t, err)
+	assert.Equal(t, string(model.PaymentStatusVoided), resp.Status)
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusVoided, expiresAt))
+	_, err = s.Void(context.Background(), &payment.VoidReq{TransactionId: "TXN-1"})
+	assert.NoError(t, err, "重复撤销不能失败")
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusCaptured, expiresAt))
+	_, err = s.Void(context.Background(), &payment.VoidReq{TransactionId: "TXN-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试扣款和撤销并发时以先保存的结果为准
+func TestCaptureVoidRace(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+
+	s, mock := newChargeTestServer(t, authorizedSimulator(t), &orderProxy{})
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusAuthorized, expiresAt))
+	mock.ExpectExec("UPDATE `transactions` SET").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusVoided, expiresAt))
+	_, err := s.Capture(context.Background(), &payment.CaptureReq{TransactionId: "TXN-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "交易已被撤销")
+
+	s, mock = newChargeTestServer(t, authorizedSimulator(t), &orderProxy{})
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusAuthorized, expiresAt))
+	mock.ExpectExec("UPDATE `transactions` SET").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusCaptured, expiresAt))
+	resp, err := s.Capture(context.Background(), &payment.CaptureReq{TransactionId: "TXN-1"})
+	require.NoError(t, err, "并发的扣款已经保存")
+	assert.Equal(t, string(model.PaymentStatusCaptured), resp.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	s, mock = newChargeTestServer(t, authorizedSimulator(t), &orderProxy{})
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusAuthorized, expiresAt))
+	mock.ExpectExec("UPDATE `transactions` SET").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusCaptured, expiresAt))
+	_, err = s.Void(context.Background(), &payment.VoidReq{TransactionId: "TXN-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "交易已被扣款")
+
+	s, mock = newChargeTestServer(t, authorizedSimulator(t), &orderProxy{})
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusAuthorized, expiresAt))
+	mock.ExpectExec("UPDATE `transactions` SET").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(authorizedRows(model.PaymentStatusVoided, expiresAt))
+	_, err = s.Void(context.Background(), &payment.VoidReq{TransactionId: "TXN-1"})
+	assert.NoError(t, err, "并发的撤销已经保存")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
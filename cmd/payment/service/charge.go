@@ -2,20 +2,41 @@ package service
 
 import (
 	"context"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"time"
 
 	"TKMall/build/proto_gen/order"
 	"TKMall/build/proto_gen/payment"
 	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/common/idempotency"
+	"TKMall/common/proxy"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
 )
 
-// Charge 处理支付请求
+// Charge 处理支付请求。携带幂等键的重复请求返回第一次的结果，避免重试导致重复扣款
 func (s *PaymentServiceServer) Charge(ctx context.Context, req *payment.ChargeReq) (*payment.ChargeResp, error) {
+	key := idempotency.KeyFromContext(ctx, req.IdempotencyKey)
+	return idempotency.Do(ctx, s.Idempotency, "payment.Charge", req.UserId, key, req, func() (*payment.ChargeResp, error) {
+		return s.pay(ctx, req, true)
+	})
+}
+
+// Authorize 预授权，只冻结金额不扣款。发货时调用Capture扣款，订单取消时调用Void释放，
+// 超过有效期未扣款的预授权由定时任务撤销
+func (s *PaymentServiceServer) Authorize(ctx context.Context, req *payment.ChargeReq) (*payment.ChargeResp, error) {
+	key := idempotency.KeyFromContext(ctx, req.IdempotencyKey)
+	return idempotency.Do(ctx, s.Idempotency, "payment.Authorize", req.UserId, key, req, func() (*payment.ChargeResp, error) {
+		return s.pay(ctx, req, false)
+	})
+}
+
+// pay 请求网关授权，capture为true时授权后立即扣款
+func (s *PaymentServiceServer) pay(ctx context.Context, req *payment.ChargeReq, capture bool) (*payment.ChargeResp, error) {
 	// 参数校验
 	if req.OrderId == "" {
 		return nil, status.Error(codes.InvalidArgument, "订单ID不能为空")
@@ -26,110 +47,322 @@ func (s *PaymentServiceServer) Charge(ctx context.Context, req *payment.ChargeRe
 	if req.Amount <= 0 {
 		return nil, status.Error(codes.InvalidArgument, "支付金额必须大于0")
 	}
-	if req.CreditCard == nil {
-		return nil, status.Error(codes.InvalidArgument, "支付信息不能为空")
+	if err := s.validatePaymentMethod(req, capture); err != nil {
+		return nil, err
 	}
 
-	// 生成唯一的交易ID
-	transactionID := fmt.Sprintf("TXN-%s", s.Node.Generate().String())
-
-	// 获取信用卡信息
-	cardNumber := req.CreditCard.CreditCardNumber
-	// CVV在真实支付场景中会被使用，这里仅做演示
-	// cardCvv := req.CreditCard.CreditCardCvv
-	cardExpMonth := req.CreditCard.CreditCardExpirationMonth
-	cardExpYear := req.CreditCard.CreditCardExpirationYear
-
-	// 卡号基本验证
-	if len(cardNumber) < 13 || len(cardNumber) > 19 {
-		return nil, status.Error(codes.InvalidArgument, "无效的信用卡号")
+	// 订单已支付或上次支付结果未知时不再请求网关，避免超时后的重试重复扣款
+	if resp, err := s.existingPayment(ctx, req); resp != nil || err != nil {
+		return resp, err
 	}
 
-	// 获取卡的后四位和卡类型
-	lastFourDigits := getLastFourDigits(cardNumber)
-	cardType := detectCardType(cardNumber)
-
-	// 创建账单地址哈希（实际环境中应包含完整地址信息）
-	billingAddressHash := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%d", req.UserId))))
+	// 生成唯一的交易ID
+	transactionID := fmt.Sprintf("TXN-%s", s.Node.Generate().String())
 
-	// 保存信用卡信息（实际环境中应该使用专业的PCI合规存储）
-	creditCard := model.CreditCard{
-		UserID:             req.UserId,
-		LastFourDigits:     lastFourDigits,
-		ExpirationMonth:    int(cardExpMonth),
-		ExpirationYear:     int(cardExpYear),
-		CardType:           cardType,
-		BillingAddressHash: billingAddressHash,
+	// 使用令牌时从保险库取出卡号；直接传卡号时保存脱敏信息；钱包支付没有卡信息
+	var card provider.Card
+	var creditCard *model.CreditCard
+	var wallet *payment.WalletPayment
+	paymentMethod := PaymentMethodCreditCard
+	switch method := req.PaymentMethod.(type) {
+	case *payment.ChargeReq_PaymentMethodToken:
+		var err error
+		card, creditCard, err = s.redeemToken(ctx, req.UserId, method.PaymentMethodToken)
+		if err != nil {
+			return nil, err
+		}
+	case *payment.ChargeReq_CreditCard:
+		card = provider.Card{
+			Number:   method.CreditCard.CreditCardNumber,
+			CVV:      method.CreditCard.CreditCardCvv,
+			ExpMonth: method.CreditCard.CreditCardExpirationMonth,
+			ExpYear:  method.CreditCard.CreditCardExpirationYear,
+		}
+		info := s.newCreditCard(req.UserId, method.CreditCard)
+		creditCard = &info
+	case *payment.ChargeReq_Wallet:
+		wallet = method.Wallet
+		paymentMethod = walletPaymentMethods[wallet.Wallet]
 	}
 
-	// 在真实环境中，这里应该调用实际的支付网关API
-	// mockGatewayResponse := callPaymentGateway(cardNumber, cardCvv, cardExpMonth, cardExpYear, req.Amount)
-
-	// 模拟支付处理（实际环境中应该使用真实的支付网关）
-	// 为了演示，我们假设支付总是成功的
-	paymentSuccessful := true
-	errorCode := ""
-	errorMessage := ""
-
-	// 记录交易信息
+	// 先保存待支付的交易，网关超时等结果未知的情况下仍有记录可以后续确认
 	now := time.Now()
 	transaction := model.Transaction{
 		TransactionID:   transactionID,
 		OrderID:         req.OrderId,
 		UserID:          req.UserId,
-		Amount:          float64(req.Amount),
+		Amount:          roundAmount(float64(req.Amount)),
+		Currency:        "CNY",
 		Status:          model.PaymentStatusPending,
-		PaymentMethod:   PaymentMethodCreditCard,
-		LastFourDigits:  lastFourDigits,
+		PaymentMethod:   paymentMethod,
 		TransactionTime: &now,
+		Provider:        s.Provider.Name(),
+	}
+	// 风控拒绝的交易同样保存，便于审核和统计频率，但不请求网关
+	s.assessRisk(ctx, req, &transaction, card)
+	if transaction.Risk.ReviewStatus == model.RiskReviewPending && capture {
+		// 审核通过前不能扣款：银行卡只预授权，发货时再扣款；钱包支付不支持预授权，直接拒绝
+		if wallet != nil {
+			rejectForReview(&transaction)
+		} else {
+			capture = false
+		}
+	}
+	if creditCard != nil {
+		if creditCard.ID == 0 {
+			if err := s.DB.WithContext(ctx).Create(creditCard).Error; err != nil {
+				return nil, status.Errorf(codes.Internal, "保存信用卡信息失败: %v", err)
+			}
+		}
+		transaction.CreditCardID = creditCard.ID
+		transaction.LastFourDigits = creditCard.LastFourDigits
+	}
+	if err := s.DB.WithContext(ctx).Create(&transaction).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "保存交易记录失败: %v", err)
+	}
+	if transaction.ErrorCode == ErrorCodeRiskRejected {
+		return nil, status.Error(codes.FailedPrecondition, "支付被风控拒绝")
 	}
 
-	// 模拟支付网关响应
-	gatewayResponse := fmt.Sprintf(`{"success":%t,"transaction_id":"%s","time":"%s"}`,
-		paymentSuccessful, transactionID, now.Format(time.RFC3339))
-	transaction.GatewayResponseRaw = gatewayResponse
-
-	// 根据支付结果更新交易状态
-	if paymentSuccessful {
-		transaction.Status = model.PaymentStatusCompleted
-	} else {
-		transaction.Status = model.PaymentStatusFailed
-		transaction.ErrorCode = errorCode
-		transaction.ErrorMessage = errorMessage
-		return nil, status.Errorf(codes.FailedPrecondition, "支付失败: %s", errorMessage)
+	// 调用支付网关
+	authorizeReq := provider.AuthorizeRequest{
+		TransactionID: transactionID,
+		OrderID:       req.OrderId,
+		UserID:        req.UserId,
+		Amount:        roundAmount(float64(req.Amount)),
+		Currency:      transaction.Currency,
+		Card:          card,
+		Capture:       capture,
+	}
+	if wallet != nil {
+		authorizeReq.Wallet = paymentMethod
+		authorizeReq.ReturnURL = wallet.ReturnUrl
 	}
+	gatewayCtx, cancel := context.WithTimeout(ctx, s.gatewayTimeout())
+	result, gatewayErr := s.Provider.Authorize(gatewayCtx, authorizeReq)
+	cancel()
 
-	// 保存信用卡和交易信息
-	if err := s.DB.Create(&creditCard).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "保存信用卡信息失败: %v", err)
+	if gatewayErr != nil {
+		// 超时时交易保持PENDING，由定时任务向网关确认最终结果
+		timedOut := errors.Is(gatewayErr, provider.ErrTimeout) || errors.Is(gatewayErr, context.DeadlineExceeded)
+		if timedOut {
+			transaction.ErrorCode = "GATEWAY_TIMEOUT"
+		} else {
+			transaction.Status = model.PaymentStatusFailed
+			transaction.ErrorCode = "GATEWAY_ERROR"
+		}
+		transaction.ErrorMessage = gatewayErr.Error()
+		if err := s.saveGatewayResult(ctx, &transaction); err != nil {
+			return nil, err
+		}
+		if timedOut {
+			return nil, status.Error(codes.DeadlineExceeded, "支付结果未知，请稍后查询订单状态")
+		}
+		return nil, status.Errorf(codes.Unavailable, "支付网关不可用: %v", gatewayErr)
 	}
-	transaction.CreditCardID = creditCard.ID
 
-	if err := s.DB.Create(&transaction).Error; err != nil {
-		return nil, status.Errorf(codes.Internal, "保存交易记录失败: %v", err)
+	applyGatewayResult(&transaction, result)
+	s.stampPaid(&transaction, time.Now())
+	if err := s.saveGatewayResult(ctx, &transaction); err != nil {
+		return nil, err
 	}
 
-	// 调用订单服务标记订单为已支付
-	if transaction.Status == model.PaymentStatusCompleted {
-		markOrderPaidReq := &order.MarkOrderPaidReq{
-			UserId:  req.UserId,
-			OrderId: req.OrderId,
+	resp := &payment.ChargeResp{
+		TransactionId: transactionID,
+		Status:        string(transaction.Status),
+	}
+	switch transaction.Status {
+	case model.PaymentStatusFailed:
+		return nil, status.Errorf(codes.FailedPrecondition, "支付失败: %s", transaction.ErrorMessage)
+	case model.PaymentStatusRequiresAction:
+		resp.NextActionUrl = result.ActionURL
+		resp.QrCode = result.QRCode
+		if !result.ExpiresAt.IsZero() {
+			resp.ExpiresAt = result.ExpiresAt.Unix()
 		}
+		return resp, nil
+	case model.PaymentStatusPending:
+		return resp, nil
+	}
 
-		_, err := s.Proxy.Call(ctx, "order", "MarkOrderPaid", markOrderPaidReq)
-		if err != nil {
-			// 仅记录日志，不影响支付结果
-			return nil, status.Errorf(codes.Internal, "标记订单已支付失败: %v", err)
-		}
+	// 扣款或预授权成功，调用订单服务标记订单为已支付。失败时交易保留待通知标记，由定时任务重试
+	if err := s.markOrderPaid(ctx, &transaction); err != nil {
+		return nil, err
 	}
+	return resp, nil
+}
 
-	// 发布支付成功事件
-	if s.EventBus != nil && transaction.Status == model.PaymentStatusCompleted {
-		// 在实际项目中可以发布支付成功事件
-		// s.EventBus.Publish("payment.completed", paymentEvent)
+// existingPayment 查询订单已支付或结果未知的交易。已支付时返回该交易，上次通知订单服务失败时重新通知；
+// 结果未知时先向网关确认，确认未扣款后才可以重新支付。没有这样的交易时返回nil
+func (s *PaymentServiceServer) existingPayment(ctx context.Context, req *payment.ChargeReq) (*payment.ChargeResp, error) {
+	var transaction model.Transaction
+	err := s.DB.WithContext(ctx).
+		Where("order_id = ? AND user_id = ? AND status IN ?", req.OrderId, req.UserId, []model.PaymentStatus{
+			model.PaymentStatusPending, model.PaymentStatusCompleted, model.PaymentStatusAuthorized, model.PaymentStatusCaptured,
+		}).
+		Order("id DESC").First(&transaction).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "查询交易记录失败: %v", err)
 	}
 
+	if transaction.Status == model.PaymentStatusPending {
+		// 创建不久的交易可能还在等待网关的响应
+		if time.Since(transaction.CreatedAt) < s.gatewayTimeout() {
+			return nil, status.Error(codes.Aborted, "订单的支付正在处理中，请稍后重试")
+		}
+		err := s.syncTransaction(ctx, &transaction)
+		switch {
+		case err != nil && isPaid(transaction.Status):
+			// 已扣款，结果或订单通知没有保存成功，由定时任务重试
+			return nil, status.Errorf(codes.Internal, "确认上次支付结果失败: %v", err)
+		case err != nil, !isPaid(transaction.Status) && transaction.Status != model.PaymentStatusFailed:
+			return nil, status.Error(codes.DeadlineExceeded, "上次支付结果未知，请稍后查询订单状态")
+		case transaction.Status == model.PaymentStatusFailed:
+			return nil, nil
+		}
+	} else if transaction.OrderNotifyPending {
+		if err := s.markOrderPaid(ctx, &transaction); err != nil {
+			return nil, err
+		}
+	}
 	return &payment.ChargeResp{
-		TransactionId: transactionID,
+		TransactionId: transaction.TransactionID,
+		Status:        string(transaction.Status),
 	}, nil
 }
+
+// 钱包类型对应的支付方式
+var walletPaymentMethods = map[payment.Wallet]string{
+	payment.Wallet_ALIPAY:     PaymentMethodAliPay,
+	payment.Wallet_WECHAT_PAY: PaymentMethodWeChatPay,
+}
+
+// validatePaymentMethod 校验支付方式，钱包支付在用户付款时直接扣款，不支持预授权
+func (s *PaymentServiceServer) validatePaymentMethod(req *payment.ChargeReq, capture bool) error {
+	switch method := req.PaymentMethod.(type) {
+	case *payment.ChargeReq_CreditCard:
+		return s.validateCardInfo(method.CreditCard)
+	case *payment.ChargeReq_PaymentMethodToken:
+		if method.PaymentMethodToken == "" {
+			return status.Error(codes.InvalidArgument, "支付令牌不能为空")
+		}
+	case *payment.ChargeReq_Wallet:
+		if _, ok := walletPaymentMethods[method.Wallet.GetWallet()]; !ok {
+			return status.Error(codes.InvalidArgument, "不支持的钱包类型")
+		}
+		if !capture {
+			return status.Error(codes.InvalidArgument, "钱包支付不支持预授权，请使用Charge")
+		}
+	default:
+		return status.Error(codes.InvalidArgument, "支付方式不能为空")
+	}
+	return nil
+}
+
+// applyGatewayResult 根据网关结果更新交易状态
+func applyGatewayResult(transaction *model.Transaction, result *provider.Result) {
+	transaction.ProviderRef = result.ProviderRef
+	transaction.GatewayResponseRaw = result.Raw
+
+	switch result.Status {
+	case provider.StatusCaptured:
+		transaction.Status = model.PaymentStatusCompleted
+		transaction.ErrorCode = ""
+		transaction.ErrorMessage = ""
+	case provider.StatusAuthorized:
+		transaction.Status = model.PaymentStatusAuthorized
+		transaction.ErrorCode = ""
+		transaction.ErrorMessage = ""
+	case provider.StatusPending:
+		transaction.Status = model.PaymentStatusPending
+	case provider.StatusRequiresAction:
+		transaction.Status = model.PaymentStatusRequiresAction
+	case provider.StatusDeclined, provider.StatusFailed:
+		transaction.Status = model.PaymentStatusFailed
+		transaction.ErrorCode = result.DeclineCode
+		transaction.ErrorMessage = result.Message
+	default:
+		transaction.Status = model.PaymentStatusFailed
+		transaction.ErrorCode = "UNEXPECTED_STATUS"
+		transaction.ErrorMessage = fmt.Sprintf("网关返回了未预期的状态: %s", result.Status)
+	}
+	if transaction.Status == model.PaymentStatusFailed && transaction.ErrorMessage == "" {
+		transaction.ErrorMessage = "支付被拒绝"
+	}
+}
+
+// stampPaid 记录扣款金额和时间，预授权成功时记录过期时间
+func (s *PaymentServiceServer) stampPaid(transaction *model.Transaction, now time.Time) {
+	switch transaction.Status {
+	case model.PaymentStatusCompleted:
+		transaction.CapturedAmount = transaction.Amount
+		transaction.CapturedAt = &now
+	case model.PaymentStatusAuthorized:
+		expiresAt := now.Add(s.authorizationTTL())
+		transaction.AuthExpiresAt = &expiresAt
+	}
+}
+
+// saveGatewayResult 保存网关处理后的交易状态
+func (s *PaymentServiceServer) saveGatewayResult(ctx context.Context, transaction *model.Transaction) error {
+	if err := s.DB.WithContext(ctx).Model(transaction).Updates(gatewayResultUpdates(transaction)).Error; err != nil {
+		return status.Errorf(codes.Internal, "保存交易记录失败: %v", err)
+	}
+	return nil
+}
+
+func gatewayResultUpdates(transaction *model.Transaction) map[string]interface{} {
+	return map[string]interface{}{
+		"status":               transaction.Status,
+		"provider_ref":         transaction.ProviderRef,
+		"gateway_response_raw": transaction.GatewayResponseRaw,
+		"error_code":           transaction.ErrorCode,
+		"error_message":        transaction.ErrorMessage,
+		"captured_amount":      transaction.CapturedAmount,
+		"captured_at":          transaction.CapturedAt,
+		"auth_expires_at":      transaction.AuthExpiresAt,
+		"order_notify_pending": isPaid(transaction.Status),
+	}
+}
+
+// isPaid 扣款或预授权成功后订单即视为已支付
+func isPaid(s model.PaymentStatus) bool {
+	return s == model.PaymentStatusCompleted || s == model.PaymentStatusAuthorized
+}
+
+// markOrderPaid 通知订单服务订单已支付，成功后清除待通知标记。
+// 通知失败的交易保留标记，由定时任务或重发的异步通知重试，订单服务对同一交易的重复通知直接返回成功
+func (s *PaymentServiceServer) markOrderPaid(ctx context.Context, transaction *model.Transaction) error {
+	_, err := s.Proxy.Call(proxy.WithoutCache(ctx), "order", "MarkOrderPaid", &order.MarkOrderPaidReq{
+		UserId:        transaction.UserID,
+		OrderId:       transaction.OrderID,
+		TransactionId: transaction.TransactionID,
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "标记订单已支付失败: %v", err)
+	}
+	if err := s.DB.WithContext(ctx).Model(&model.Transaction{}).
+		Where("id = ?", transaction.ID).
+		Update("order_notify_pending", false).Error; err != nil {
+		return status.Errorf(codes.Internal, "保存订单通知状态失败: %v", err)
+	}
+	transaction.OrderNotifyPending = false
+	return nil
+}
+
+func (s *PaymentServiceServer) gatewayTimeout() time.Duration {
+	if s.GatewayTimeout > 0 {
+		return s.GatewayTimeout
+	}
+	return DefaultGatewayTimeout
+}
+
+func (s *PaymentServiceServer) authorizationTTL() time.Duration {
+	if s.AuthorizationTTL > 0 {
+		return s.AuthorizationTTL
+	}
+	return DefaultAuthorizationTTL
+}
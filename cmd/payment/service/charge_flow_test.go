@@ -0,0 +1,360 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/order"
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/common/proxy"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bwmarrin/snowflake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// orderProxy 记录对订单服务的调用
+type orderProxy struct {
+	err   error
+	calls []*order.MarkOrderPaidReq
+}
+
+func (p *orderProxy) Call(ctx context.Context, service, method string, req interface{}) (interface{}, error) {
+	p.calls = append(p.calls, req.(*order.MarkOrderPaidReq))
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &order.MarkOrderPaidResp{}, nil
+}
+
+func (p *orderProxy) AsyncCall(ctx context.Context, service, method string, req interface{}) (<-chan proxy.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+// brokenProvider 模拟网关连接失败
+type brokenProvider struct {
+	*provider.Simulator
+}
+
+func (brokenProvider) Authorize(ctx context.Context, req provider.AuthorizeRequest) (*provider.Result, error) {
+	return nil, errors.New("connection refused")
+}
+
+// recordingProvider 记录请求网关的授权金额
+type recordingProvider struct {
+	*provider.Simulator
+	amounts []float64
+}
+
+func (p *recordingProvider) Authorize(ctx context.Context, req provider.AuthorizeRequest) (*provider.Result, error) {
+	p.amounts = append(p.amounts, req.Amount)
+	return p.Simulator.Authorize(ctx, req)
+}
+
+func newChargeTestServer(t *testing.T, p provider.PaymentProvider, orders *orderProxy) (*PaymentServiceServer, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	node, err := snowflake.NewNode(1)
+	require.NoError(t, err)
+
+	return &PaymentServiceServer{
+		DB:             db,
+		Node:           node,
+		Proxy:          orders,
+		Provider:       p,
+		GatewayTimeout: 50 * time.Millisecond,
+	}, mock
+}
+
+func chargeReq(card string) *payment.ChargeReq {
+	return &payment.ChargeReq{
+		OrderId: "ORD-1",
+		UserId:  1001,
+		Amount:  99.9,
+		PaymentMethod: &payment.ChargeReq_CreditCard{CreditCard: &payment.CreditCardInfo{
+			CreditCardNumber:          card,
+			CreditCardCvv:             123,
+			CreditCardExpirationMonth: 12,
+			CreditCardExpirationYear:  2030,
+		}},
+	}
+}
+
+// savedTransactions 记录创建的交易，测试断言保存的字段，不按列的位置匹配INSERT的参数
+func savedTransactions(t *testing.T, db *gorm.DB) *[]model.Transaction {
+	var saved []model.Transaction
+	err := db.Callback().Create().Before("gorm:create").Register("test:saved_transactions", func(tx *gorm.DB) {
+		if transaction, ok := tx.Statement.Dest.(*model.Transaction); ok {
+			saved = append(saved, *transaction)
+		}
+	})
+	require.NoError(t, err)
+	return &saved
+}
+
+// expectNoExistingPayment 期望查询订单已支付或结果未知的交易，没有查到
+func expectNoExistingPayment(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(order_id = \\? AND user_id = \\? AND status IN").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+// expectCharge 期望保存信用卡、创建交易，再以finalStatus保存网关结果
+func expectCharge(mock sqlmock.Sqlmock, finalStatus model.PaymentStatus) {
+	expectNoExistingPayment(mock)
+	mock.ExpectExec("INSERT INTO `credit_cards`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO `transactions`").WillReturnResult(sqlmock.NewResult(1, 1))
+	// Updates的字段按名称排序：auth_expires_at, captured_amount, captured_at, error_code, error_message,
+	// gateway_response_raw, order_notify_pending, provider_ref, status, updated_at
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), isPaid(finalStatus), sqlmock.AnyArg(), finalStatus, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectOrderNotified 期望通知订单服务成功后清除交易的待通知标记
+func expectOrderNotified(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("UPDATE `transactions` SET `order_notify_pending`=\\?").
+		WithArgs(false, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// 测试Charge的各个分支
+func TestChargeGatewayOutcomes(t *testing.T) {
+	tests := []struct {
+		name       string
+		card       string
+		provider   func() provider.PaymentProvider
+		orderErr   error
+		saved      model.PaymentStatus
+		code       codes.Code
+		status     string
+		actionURL  bool
+		markedPaid bool
+		authorize  bool
+	}{
+		{name: "支付成功", card: "4242424242424242", saved: model.PaymentStatusCompleted, code: codes.OK,
+			status: "COMPLETED", markedPaid: true},
+		{name: "发卡行拒绝", card: "4000000000000002", saved: model.PaymentStatusFailed, code: codes.FailedPrecondition},
+		{name: "余额不足", card: "4000000000009995", saved: model.PaymentStatusFailed, code: codes.FailedPrecondition},
+		{name: "卡片过期", card: "4000000000000069", saved: model.PaymentStatusFailed, code: codes.FailedPrecondition},
+		{name: "网关处理失败", card: "4000000000000119", saved: model.PaymentStatusFailed, code: codes.FailedPrecondition},
+		{name: "网关超时", card: "4000000000000408", saved: model.PaymentStatusPending, code: codes.DeadlineExceeded},
+		{name: "需要3DS", card: "4000000000003220", saved: model.PaymentStatusRequiresAction, code: codes.OK,
+			status: "REQUIRES_ACTION", actionURL: true},
+		{name: "异步处理", card: "4000000000000077", saved: model.PaymentStatusPending, code: codes.OK, status: "PENDING"},
+		{name: "网关不可用", card: "4242424242424242", saved: model.PaymentStatusFailed, code: codes.Unavailable,
+			provider: func() provider.PaymentProvider {
+				return brokenProvider{provider.NewSimulator(provider.SimulatorConfig{})}
+			}},
+		{name: "标记订单已支付失败", card: "4242424242424242", orderErr: errors.New("order unavailable"),
+			saved: model.PaymentStatusCompleted, code: codes.Internal, markedPaid: true},
+		{name: "预授权成功", card: "4242424242424242", authorize: true, saved: model.PaymentStatusAuthorized,
+			code: codes.OK, status: "AUTHORIZED", markedPaid: true},
+		{name: "预授权被拒绝", card: "4000000000000002", authorize: true, saved: model.PaymentStatusFailed,
+			code: codes.FailedPrecondition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p provider.PaymentProvider = provider.NewSimulator(provider.SimulatorConfig{})
+			if tt.provider != nil {
+				p = tt.provider()
+			}
+			orders := &orderProxy{err: tt.orderErr}
+			s, mock := newChargeTestServer(t, p, orders)
+			saved := savedTransactions(t, s.DB)
+			expectCharge(mock, tt.saved)
+			if tt.markedPaid && tt.orderErr == nil {
+				expectOrderNotified(mock)
+			}
+
+			call := s.Charge
+			if tt.authorize {
+				call = s.Authorize
+			}
+			resp, err := call(context.Background(), chargeReq(tt.card))
+			assert.Equal(t, tt.code, status.Code(err), "%v", err)
+			require.Len(t, *saved, 1)
+			transaction := (*saved)[0]
+			assert.Equal(t, "ORD-1", transaction.OrderID)
+			assert.Equal(t, int64(1001), transaction.UserID)
+			assert.Equal(t, 99.9, transaction.Amount, "金额保留两位小数，不带float32转换的误差")
+			assert.Equal(t, "CNY", transaction.Currency)
+			assert.Equal(t, model.PaymentStatusPending, transaction.Status, "请求网关前先保存PENDING交易")
+			assert.Equal(t, PaymentMethodCreditCard, transaction.PaymentMethod)
+			assert.NotZero(t, transaction.CreditCardID)
+			if tt.code == codes.OK {
+				require.NotNil(t, resp)
+				assert.NotEmpty(t, resp.TransactionId)
+				assert.Equal(t, tt.status, resp.Status)
+				assert.Equal(t, tt.actionURL, resp.NextActionUrl != "")
+			}
+			if tt.markedPaid {
+				require.Len(t, orders.calls, 1)
+				assert.Equal(t, "ORD-1", orders.calls[0].OrderId)
+				assert.NotEmpty(t, orders.calls[0].TransactionId)
+			} else {
+				assert.Empty(t, orders.calls, "未支付成功不能标记订单已支付")
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// 测试请求网关的金额保留两位小数，与保存的交易金额一致
+func TestChargeGatewayAmount(t *testing.T) {
+	p := &recordingProvider{Simulator: provider.NewSimulator(provider.SimulatorConfig{})}
+	s, mock := newChargeTestServer(t, p, &orderProxy{})
+	expectCharge(mock, model.PaymentStatusCompleted)
+	expectOrderNotified(mock)
+
+	_, err := s.Charge(context.Background(), chargeReq("4242424242424242"))
+	require.NoError(t, err)
+	assert.Equal(t, []float64{99.9}, p.amounts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试订单已有支付成功或结果未知的交易时不重复扣款
+func TestChargeExistingPayment(t *testing.T) {
+	existingRows := func(s model.PaymentStatus, createdAt time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "amount", "status", "created_at"}).
+			AddRow(1, "TXN-1", "ORD-1", 1001, 99.9, s, createdAt)
+	}
+
+	t.Run("已支付", func(t *testing.T) {
+		orders := &orderProxy{}
+		s, mock := newChargeTestServer(t, brokenProvider{}, orders)
+		mock.ExpectQuery("SELECT \\* FROM `transactions`").
+			WithArgs("ORD-1", int64(1001), model.PaymentStatusPending, model.PaymentStatusCompleted,
+				model.PaymentStatusAuthorized, model.PaymentStatusCaptured, 1).
+			WillReturnRows(existingRows(model.PaymentStatusCompleted, time.Now().Add(-time.Hour)))
+
+		resp, err := s.Charge(context.Background(), chargeReq("4242424242424242"))
+		require.NoError(t, err)
+		assert.Equal(t, "TXN-1", resp.TransactionId)
+		assert.Equal(t, string(model.PaymentStatusCompleted), resp.Status)
+		assert.Empty(t, orders.calls)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("上次超时但已扣款", func(t *testing.T) {
+		orders := &orderProxy{}
+		sim := provider.NewSimulator(provider.SimulatorConfig{})
+		_, err := sim.Authorize(context.Background(), provider.AuthorizeRequest{
+			TransactionID: "TXN-1", Amount: 99.9, Card: provider.Card{Number: "4242424242424242"}, Capture: true,
+		})
+		require.NoError(t, err)
+		s, mock := newChargeTestServer(t, sim, orders)
+		mock.ExpectQuery("SELECT \\* FROM `transactions`").
+			WillReturnRows(existingRows(model.PaymentStatusPending, time.Now().Add(-time.Hour)))
+		mock.ExpectExec("UPDATE `transactions` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectOrderNotified(mock)
+
+		resp, err := s.Charge(context.Background(), chargeReq("4242424242424242"))
+		require.NoError(t, err)
+		assert.Equal(t, "TXN-1", resp.TransactionId)
+		assert.Equal(t, string(model.PaymentStatusCompleted), resp.Status)
+		require.Len(t, orders.calls, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("上次请求还在处理", func(t *testing.T) {
+		s, mock := newChargeTestServer(t, brokenProvider{}, &orderProxy{})
+		mock.ExpectQuery("SELECT \\* FROM `transactions`").
+			WillReturnRows(existingRows(model.PaymentStatusPending, time.Now()))
+
+		_, err := s.Charge(context.Background(), chargeReq("4242424242424242"))
+		assert.Equal(t, codes.Aborted, status.Code(err), "%v", err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// 测试参数校验失败时不访问数据库和网关
+func TestChargeInvalidRequest(t *testing.T) {
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), &orderProxy{})
+
+	card := chargeReq("4242424242424242").PaymentMethod
+	noCard := chargeReq("4242424242424242")
+	noCard.PaymentMethod = nil
+	for _, req := range []*payment.ChargeReq{
+		{UserId: 1, Amount: 1, PaymentMethod: card},
+		{OrderId: "ORD-1", Amount: 1, PaymentMethod: card},
+		{OrderId: "ORD-1", UserId: 1, PaymentMethod: card},
+		noCard,
+		chargeReq("4242"),
+		{OrderId: "ORD-1", UserId: 1, Amount: 1, PaymentMethod: &payment.ChargeReq_PaymentMethodToken{}},
+		{OrderId: "ORD-1", UserId: 1, Amount: 1, PaymentMethod: &payment.ChargeReq_Wallet{Wallet: &payment.WalletPayment{}}},
+	} {
+		_, err := s.Charge(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	// 钱包支付不支持预授权
+	_, err := s.Authorize(context.Background(), walletChargeReq(payment.Wallet_ALIPAY))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 卡信息校验失败时在错误详情中列出每个字段
+	expired := chargeReq("4242424242424241")
+	expired.GetCreditCard().CreditCardExpirationYear = 2020
+	_, err = s.Charge(context.Background(), expired)
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	var fields []string
+	for _, v := range badRequest.FieldViolations {
+		fields = append(fields, v.Field)
+	}
+	assert.Equal(t, []string{"credit_card.credit_card_number", "credit_card.credit_card_expiration_year"}, fields)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试网关结果到交易状态的转换
+func TestApplyGatewayResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  provider.Result
+		status  model.PaymentStatus
+		errCode string
+	}{
+		{"已扣款", provider.Result{Status: provider.StatusCaptured}, model.PaymentStatusCompleted, ""},
+		{"已预授权", provider.Result{Status: provider.StatusAuthorized}, model.PaymentStatusAuthorized, ""},
+		{"异步处理", provider.Result{Status: provider.StatusPending}, model.PaymentStatusPending, ""},
+		{"需要3DS", provider.Result{Status: provider.StatusRequiresAction}, model.PaymentStatusRequiresAction, ""},
+		{"拒绝", provider.Result{Status: provider.StatusDeclined, DeclineCode: "insufficient_funds", Message: "余额不足"},
+			model.PaymentStatusFailed, "insufficient_funds"},
+		{"未预期的状态", provider.Result{Status: provider.StatusVoided}, model.PaymentStatusFailed, "UNEXPECTED_STATUS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transaction := model.Transaction{Status: model.PaymentStatusPending, ErrorCode: "GATEWAY_TIMEOUT"}
+			tt.result.ProviderRef = "ref_1"
+			applyGatewayResult(&transaction, &tt.result)
+			assert.Equal(t, tt.status, transaction.Status)
+			assert.Equal(t, "ref_1", transaction.ProviderRef)
+			if tt.status == model.PaymentStatusFailed {
+				assert.Equal(t, tt.errCode, transaction.ErrorCode)
+				assert.NotEmpty(t, transaction.ErrorMessage)
+			}
+		})
+	}
+}
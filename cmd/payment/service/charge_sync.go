@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/common/log"
+)
+
+const (
+	defaultPendingSyncInterval = time.Minute
+	pendingSyncBatchSize       = 100
+)
+
+// RunPendingSync 定期向支付网关确认结果未知的交易：网关超时、异步处理中、等待3DS验证
+func (s *PaymentServiceServer) RunPendingSync(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultPendingSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.SyncPendingTransactions(ctx, time.Now().Add(-interval)); err != nil {
+				log.Errorf("同步待确认交易失败: %v", err)
+			}
+		}
+	}
+}
+
+// SyncPendingTransactions 查询before之前创建的待确认交易在网关中的状态，支付成功的交易通知订单服务。
+// 已支付但通知订单服务失败的交易重新通知，结果未知的退款重新提交，发布失败的退款事件重新发布
+func (s *PaymentServiceServer) SyncPendingTransactions(ctx context.Context, before time.Time) error {
+	var transactions []model.Transaction
+	if err := s.DB.WithContext(ctx).
+		Where("status IN ? AND provider = ? AND created_at < ?",
+			[]model.PaymentStatus{model.PaymentStatusPending, model.PaymentStatusRequiresAction}, s.Provider.Name(), before).
+		Order("id ASC").Limit(pendingSyncBatchSize).
+		Find(&transactions).Error; err != nil {
+		return err
+	}
+
+	for i := range transactions {
+		if err := s.syncTransaction(ctx, &transactions[i]); err != nil {
+			log.Errorf("同步交易状态失败: transaction=%s: %v", transactions[i].TransactionID, err)
+		}
+	}
+	if err := s.retryOrderNotifications(ctx, before); err != nil {
+		return err
+	}
+	if err := s.syncPendingRefunds(ctx, before); err != nil {
+		return err
+	}
+	return s.retryRefundEvents(ctx, before)
+}
+
+// retryOrderNotifications 重新通知订单服务已支付但上次通知失败的交易
+func (s *PaymentServiceServer) retryOrderNotifications(ctx context.Context, before time.Time) error {
+	var transactions []model.Transaction
+	if err := s.DB.WithContext(ctx).
+		Where("order_notify_pending = ? AND created_at < ?", true, before).
+		Order("id ASC").Limit(pendingSyncBatchSize).
+		Find(&transactions).Error; err != nil {
+		return err
+	}
+
+	for i := range transactions {
+		if err := s.markOrderPaid(ctx, &transactions[i]); err != nil {
+			log.Errorf("重新通知订单已支付失败: transaction=%s: %v", transactions[i].TransactionID, err)
+		}
+	}
+	return nil
+}
+
+func (s *PaymentServiceServer) syncTransaction(ctx context.Context, transaction *model.Transaction) error {
+	// 网关超时的交易没有网关交易号，按本系统的交易ID查询
+	ref := transaction.ProviderRef
+	if ref == "" {
+		ref = transaction.TransactionID
+	}
+
+	gatewayCtx, cancel := context.WithTimeout(ctx, s.gatewayTimeout())
+	result, err := s.Provider.QueryStatus(gatewayCtx, ref)
+	cancel()
+
+	previous := transaction.Status
+	switch {
+	case errors.Is(err, provider.ErrNotFound) && transaction.ProviderRef == "":
+		// 请求没有到达网关，不会扣款
+		transaction.Status = model.PaymentStatusFailed
+		transaction.ErrorCode = "GATEWAY_NOT_FOUND"
+		transaction.ErrorMessage = err.Error()
+	case err != nil:
+		return err
+	default:
+		applyGatewayResult(transaction, result)
+		s.stampPaid(transaction, time.Now())
+	}
+	return s.saveConfirmedResult(ctx, transaction, previous)
+}
+
+// saveConfirmedResult 保存定时查询或异步通知确认的交易结果，支付成功时通知订单服务。
+// 待通知标记和交易状态一起保存，通知失败时由定时任务重试
+func (s *PaymentServiceServer) saveConfirmedResult(ctx context.Context, transaction *model.Transaction, previous model.PaymentStatus) error {
+	if transaction.Status == previous {
+		return nil
+	}
+
+	// 只更新仍处于原状态的交易，避免多个副本重复通知订单服务
+	updated := s.DB.WithContext(ctx).Model(&model.Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, previous).
+		Updates(gatewayResultUpdates(transaction))
+	if updated.Error != nil {
+		return updated.Error
+	}
+	if updated.RowsAffected == 0 || !isPaid(transaction.Status) {
+		return nil
+	}
+	return s.markOrderPaid(ctx, transaction)
+}
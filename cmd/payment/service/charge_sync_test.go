@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试定时任务重新通知已支付但通知订单服务失败的交易
+func TestSyncRetriesOrderNotification(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), orders)
+	before := time.Now()
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(status IN").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(order_notify_pending = \\? AND created_at < \\?\\)").
+		WithArgs(true, before, pendingSyncBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "status", "order_notify_pending"}).
+			AddRow(1, "TXN-1", "ORD-1", 1001, model.PaymentStatusCompleted, true))
+	expectOrderNotified(mock)
+	mock.ExpectQuery("SELECT \\* FROM `refunds`").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	require.NoError(t, s.SyncPendingTransactions(context.Background(), before))
+	require.Len(t, orders.calls, 1)
+	assert.Equal(t, "TXN-1", orders.calls[0].TransactionId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -52,44 +52,3 @@ func (m *MockPaymentServer) Charge(ctx context.Context, req *payment.ChargeReq)
 	}
 	return args.Get(0).(*payment.ChargeResp), args.Error(1)
 }
-
-// 测试辅助函数
-func isValidCreditCard(cardNum string) bool {
-	length := len(cardNum)
-	return length >= 13 && length <= 19
-}
-
-func detectCardType(cardNum string) string {
-	if len(cardNum) == 0 {
-		return "Unknown"
-	}
-
-	firstDigit := cardNum[0]
-	firstTwoDigits := ""
-	if len(cardNum) >= 2 {
-		firstTwoDigits = cardNum[0:2]
-	}
-
-	if firstDigit == '4' {
-		return "Visa"
-	} else if firstDigit == '5' {
-		return "MasterCard"
-	} else if firstDigit == '3' && len(cardNum) >= 2 && (cardNum[1] == '4' || cardNum[1] == '7') {
-		return "AmericanExpress"
-	} else if firstTwoDigits == "60" {
-		return "Discover"
-	}
-
-	return "Unknown"
-}
-
-func getLastFourDigits(cardNum string) string {
-	length := len(cardNum)
-	if length == 0 {
-		return ""
-	}
-	if length <= 4 {
-		return cardNum
-	}
-	return cardNum[length-4:]
-}
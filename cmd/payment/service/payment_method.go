@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/card"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/cmd/payment/vault"
+	"TKMall/common/idempotency"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// TokenizeCard 卡号加密存入保险库，返回支付令牌。save为true时保存为用户的支付方式
+func (s *PaymentServiceServer) TokenizeCard(ctx context.Context, req *payment.TokenizeCardReq) (*payment.TokenizeCardResp, error) {
+	if s.Vault == nil {
+		return nil, status.Error(codes.Unimplemented, "未启用支付令牌")
+	}
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if err := s.validateCardInfo(req.CreditCard); err != nil {
+		return nil, err
+	}
+
+	// 幂等键的请求哈希会落库，用卡号指纹代替卡号，不包含CVV
+	hashReq := &payment.TokenizeCardReq{
+		UserId: req.UserId,
+		Save:   req.Save,
+		CreditCard: &payment.CreditCardInfo{
+			CreditCardNumber:          s.Vault.Fingerprint(req.CreditCard.CreditCardNumber),
+			CreditCardExpirationYear:  req.CreditCard.CreditCardExpirationYear,
+			CreditCardExpirationMonth: req.CreditCard.CreditCardExpirationMonth,
+		},
+	}
+	key := idempotency.KeyFromContext(ctx, req.IdempotencyKey)
+	return idempotency.Do(ctx, s.Idempotency, "payment.TokenizeCard", req.UserId, key, hashReq, func() (*payment.TokenizeCardResp, error) {
+		return s.tokenizeCard(ctx, req)
+	})
+}
+
+func (s *PaymentServiceServer) tokenizeCard(ctx context.Context, req *payment.TokenizeCardReq) (*payment.TokenizeCardResp, error) {
+	info := req.CreditCard
+	token, err := s.Vault.Tokenize(ctx, req.UserId, provider.Card{
+		Number: info.CreditCardNumber,
+		CVV:    info.CreditCardCvv,
+	}, req.Save)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "保存支付令牌失败: %v", err)
+	}
+
+	// 卡片的脱敏信息保存在credit_cards中，重复保存的卡更新有效期
+	var creditCard model.CreditCard
+	err = s.DB.WithContext(ctx).Where("token = ?", token.Token).First(&creditCard).Error
+	switch {
+	case err == nil:
+		creditCard.ExpirationMonth = int(info.CreditCardExpirationMonth)
+		creditCard.ExpirationYear = int(info.CreditCardExpirationYear)
+		err = s.DB.WithContext(ctx).Model(&creditCard).Updates(map[string]interface{}{
+			"expiration_month": creditCard.ExpirationMonth,
+			"expiration_year":  creditCard.ExpirationYear,
+		}).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		creditCard = s.newCreditCard(req.UserId, info)
+		creditCard.Token = token.Token
+		creditCard.Saved = req.Save
+		err = s.DB.WithContext(ctx).Create(&creditCard).Error
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "保存信用卡信息失败: %v", err)
+	}
+
+	return &payment.TokenizeCardResp{
+		Token:         token.Token,
+		PaymentMethod: paymentMethod(&creditCard),
+	}, nil
+}
+
+// ListPaymentMethods 用户保存的卡
+func (s *PaymentServiceServer) ListPaymentMethods(ctx context.Context, req *payment.ListPaymentMethodsReq) (*payment.ListPaymentMethodsResp, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	var cards []model.CreditCard
+	if err := s.DB.WithContext(ctx).Where("user_id = ? AND saved = ?", req.UserId, true).
+		Order("id DESC").Find(&cards).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询支付方式失败: %v", err)
+	}
+	resp := &payment.ListPaymentMethodsResp{PaymentMethods: make([]*payment.PaymentMethod, 0, len(cards))}
+	for i := range cards {
+		resp.PaymentMethods = append(resp.PaymentMethods, paymentMethod(&cards[i]))
+	}
+	return resp, nil
+}
+
+// DeletePaymentMethod 删除保存的卡，保险库中的密文一并删除，历史交易仍保留脱敏信息
+func (s *PaymentServiceServer) DeletePaymentMethod(ctx context.Context, req *payment.DeletePaymentMethodReq) (*payment.DeletePaymentMethodResp, error) {
+	if s.Vault == nil {
+		return nil, status.Error(codes.Unimplemented, "未启用支付令牌")
+	}
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "用户ID不能为空")
+	}
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "支付令牌不能为空")
+	}
+
+	result := s.DB.WithContext(ctx).Model(&model.CreditCard{}).
+		Where("token = ? AND user_id = ? AND saved = ?", req.Token, req.UserId, true).
+		Update("saved", false)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "删除支付方式失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.NotFound, "支付方式不存在")
+	}
+	if err := s.Vault.Delete(ctx, req.UserId, req.Token); err != nil && !errors.Is(err, vault.ErrTokenNotFound) {
+		return nil, status.Errorf(codes.Internal, "删除支付令牌失败: %v", err)
+	}
+	return &payment.DeletePaymentMethodResp{}, nil
+}
+
+// redeemToken 取出令牌对应的卡，用于请求网关
+func (s *PaymentServiceServer) redeemToken(ctx context.Context, userID int64, token string) (provider.Card, *model.CreditCard, error) {
+	if s.Vault == nil {
+		return provider.Card{}, nil, status.Error(codes.Unimplemented, "未启用支付令牌")
+	}
+	var creditCard model.CreditCard
+	if err := s.DB.WithContext(ctx).Where("token = ? AND user_id = ?", token, userID).
+		First(&creditCard).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return provider.Card{}, nil, status.Error(codes.InvalidArgument, "支付令牌无效")
+		}
+		return provider.Card{}, nil, status.Errorf(codes.Internal, "查询信用卡信息失败: %v", err)
+	}
+
+	card, err := s.Vault.Redeem(ctx, userID, token)
+	switch {
+	case errors.Is(err, vault.ErrTokenNotFound):
+		return provider.Card{}, nil, status.Error(codes.InvalidArgument, "支付令牌无效")
+	case errors.Is(err, vault.ErrTokenUsed), errors.Is(err, vault.ErrTokenExpired):
+		return provider.Card{}, nil, status.Errorf(codes.FailedPrecondition, "%v，请重新填写卡号", err)
+	case err != nil:
+		return provider.Card{}, nil, status.Errorf(codes.Internal, "读取支付令牌失败: %v", err)
+	}
+	card.ExpMonth = int32(creditCard.ExpirationMonth)
+	card.ExpYear = int32(creditCard.ExpirationYear)
+	return card, &creditCard, nil
+}
+
+// validateCardInfo 校验卡号、有效期和CVV，错误详情中列出每个不合法的字段
+func (s *PaymentServiceServer) validateCardInfo(info *payment.CreditCardInfo) error {
+	if info == nil {
+		return status.Error(codes.InvalidArgument, "支付信息不能为空")
+	}
+	_, err := s.binTable().Validate(card.Info{
+		Number:   info.CreditCardNumber,
+		CVV:      info.CreditCardCvv,
+		ExpMonth: info.CreditCardExpirationMonth,
+		ExpYear:  info.CreditCardExpirationYear,
+	}, time.Now())
+	var cardErr *card.Error
+	if !errors.As(err, &cardErr) {
+		return err
+	}
+
+	st := status.New(codes.InvalidArgument, cardErr.Error())
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range cardErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "credit_card." + v.Field,
+			Description: v.Description,
+		})
+	}
+	if detailed, err := st.WithDetails(badRequest); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// newCreditCard 卡片的脱敏信息
+func (s *PaymentServiceServer) newCreditCard(userID int64, info *payment.CreditCardInfo) model.CreditCard {
+	return model.CreditCard{
+		UserID:          userID,
+		LastFourDigits:  getLastFourDigits(info.CreditCardNumber),
+		ExpirationMonth: int(info.CreditCardExpirationMonth),
+		ExpirationYear:  int(info.CreditCardExpirationYear),
+		CardType:        string(s.binTable().Detect(info.CreditCardNumber)),
+		// 创建账单地址哈希（实际环境中应包含完整地址信息）
+		BillingAddressHash: fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%d", userID)))),
+	}
+}
+
+func paymentMethod(card *model.CreditCard) *payment.PaymentMethod {
+	return &payment.PaymentMethod{
+		Token:           card.Token,
+		CardType:        card.CardType,
+		LastFourDigits:  card.LastFourDigits,
+		ExpirationYear:  int32(card.ExpirationYear),
+		ExpirationMonth: int32(card.ExpirationMonth),
+		Saved:           card.Saved,
+	}
+}
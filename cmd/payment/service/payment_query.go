@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetPayment 查询交易状态。等待用户操作或网关处理中的交易先向网关查询一次，
+// 钱包扫码支付时前端轮询该接口，不必等待异步通知
+func (s *PaymentServiceServer) GetPayment(ctx context.Context, req *payment.GetPaymentReq) (*payment.GetPaymentResp, error) {
+	if req.TransactionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "交易ID不能为空")
+	}
+	transaction, err := s.findTransaction(ctx, req.TransactionId)
+	if err != nil {
+		return nil, err
+	}
+	if req.UserId != 0 && transaction.UserID != req.UserId {
+		return nil, status.Error(codes.NotFound, "交易不存在")
+	}
+
+	// 查询网关失败时返回当前状态，由定时任务继续确认
+	if (transaction.Status == model.PaymentStatusPending || transaction.Status == model.PaymentStatusRequiresAction) &&
+		transaction.Provider == s.Provider.Name() {
+		synced := *transaction
+		if err := s.syncTransaction(ctx, &synced); err == nil {
+			transaction = &synced
+		}
+	}
+
+	return &payment.GetPaymentResp{
+		TransactionId: transaction.TransactionID,
+		OrderId:       transaction.OrderID,
+		Status:        string(transaction.Status),
+		Amount:        float32(transaction.Amount),
+		PaymentMethod: transaction.PaymentMethod,
+		ErrorCode:     transaction.ErrorCode,
+		ErrorMessage:  transaction.ErrorMessage,
+	}, nil
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gorm.io/gorm"
+)
+
+// 对账问题的类型
+const (
+	ReconcileMissing  = "MISSING"
+	ReconcileExtra    = "EXTRA"
+	ReconcileMismatch = "MISMATCH"
+	ReconcileInvalid  = "INVALID"
+)
+
+const (
+	reconcileBatchSize = 500
+	maxReconcileIssues = 1000
+)
+
+// 网关已经处理的交易，结算文件中应当有记录
+var settledStatuses = []model.PaymentStatus{
+	model.PaymentStatusCompleted,
+	model.PaymentStatusAuthorized,
+	model.PaymentStatusCaptured,
+	model.PaymentStatusVoided,
+	model.PaymentStatusRefunded,
+	model.PaymentStatusPartRefunded,
+}
+
+type reconcileResult struct {
+	resp *payment.ReconcileResp
+}
+
+func (r *reconcileResult) add(issue *payment.ReconcileIssue) {
+	if len(r.resp.Issues) < maxReconcileIssues {
+		r.resp.Issues = append(r.resp.Issues, issue)
+	}
+}
+
+// Reconcile 将网关的结算记录与交易记录逐笔比对，报告缺失、多出和不一致的交易，
+// 开启fix时自动修复可以安全修复的差异
+func (s *PaymentServiceServer) Reconcile(stream payment.PaymentService_ReconcileServer) error {
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	options := first.GetOptions()
+	if options == nil {
+		return status.Error(codes.InvalidArgument, "第一条消息必须是对账参数")
+	}
+	if options.PeriodStart >= options.PeriodEnd {
+		return status.Error(codes.InvalidArgument, "结算周期的开始时间必须早于结束时间")
+	}
+
+	result := &reconcileResult{resp: &payment.ReconcileResp{}}
+	seen := make(map[string]bool)
+	batch := make([]*payment.SettlementRecord, 0, reconcileBatchSize)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		record := req.GetRecord()
+		if record == nil {
+			return status.Error(codes.InvalidArgument, "对账参数只能在第一条消息中")
+		}
+
+		result.resp.Total++
+		if message := validateSettlementRecord(record, seen); message != "" {
+			result.resp.Invalid++
+			result.add(&payment.ReconcileIssue{
+				Type:          ReconcileInvalid,
+				Line:          record.Line,
+				TransactionId: record.TransactionId,
+				ProviderRef:   record.ProviderRef,
+				Message:       message,
+			})
+			continue
+		}
+		seen[record.TransactionId] = true
+
+		batch = append(batch, record)
+		if len(batch) == reconcileBatchSize {
+			if err := s.reconcileBatch(ctx, batch, options.Fix, result); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := s.reconcileBatch(ctx, batch, options.Fix, result); err != nil {
+			return err
+		}
+	}
+	if err := s.findMissing(ctx, options, seen, result); err != nil {
+		return err
+	}
+	return stream.SendAndClose(result.resp)
+}
+
+func validateSettlementRecord(record *payment.SettlementRecord, seen map[string]bool) string {
+	switch {
+	case record.TransactionId == "":
+		return "缺少交易ID"
+	case seen[record.TransactionId]:
+		return "重复的交易ID"
+	case record.Status == "":
+		return "缺少交易状态"
+	}
+	return ""
+}
+
+// reconcileBatch 比对一批结算记录
+func (s *PaymentServiceServer) reconcileBatch(ctx context.Context, records []*payment.SettlementRecord, fix bool, result *reconcileResult) error {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.TransactionId
+	}
+	var transactions []model.Transaction
+	if err := s.DB.WithContext(ctx).Where("transaction_id IN ?", ids).Find(&transactions).Error; err != nil {
+		return status.Errorf(codes.Internal, "查询交易记录失败: %v", err)
+	}
+	byID := make(map[string]*model.Transaction, len(transactions))
+	for i := range transactions {
+		byID[transactions[i].TransactionID] = &transactions[i]
+	}
+
+	for _, record := range records {
+		transaction, ok := byID[record.TransactionId]
+		if !ok {
+			result.resp.Extra++
+			result.add(&payment.ReconcileIssue{
+				Type:          ReconcileExtra,
+				Line:          record.Line,
+				TransactionId: record.TransactionId,
+				ProviderRef:   record.ProviderRef,
+				Actual:        record.Status,
+				Message:       "网关有结算记录，本系统没有该交易",
+			})
+			continue
+		}
+
+		issues := compareSettlement(transaction, record)
+		if len(issues) == 0 {
+			result.resp.Matched++
+			continue
+		}
+		result.resp.Mismatched++
+		var fixErr error
+		fixed := false
+		if fix {
+			fixed, fixErr = s.fixSettlement(ctx, transaction, record)
+		}
+		if fixed {
+			result.resp.Fixed++
+		}
+		for _, issue := range issues {
+			issue.Fixed = fixed
+			if fixErr != nil {
+				issue.Message = fmt.Sprintf("自动修复失败: %v", fixErr)
+			}
+			result.add(issue)
+		}
+	}
+	return nil
+}
+
+// compareSettlement 比对交易状态和金额，状态不一致时不再比较扣款和退款金额
+func compareSettlement(transaction *model.Transaction, record *payment.SettlementRecord) []*payment.ReconcileIssue {
+	issue := func(field, expected, actual string) *payment.ReconcileIssue {
+		return &payment.ReconcileIssue{
+			Type:          ReconcileMismatch,
+			Line:          record.Line,
+			TransactionId: transaction.TransactionID,
+			ProviderRef:   record.ProviderRef,
+			Field:         field,
+			Expected:      expected,
+			Actual:        actual,
+		}
+	}
+
+	var issues []*payment.ReconcileIssue
+	if roundAmount(transaction.Amount) != roundAmount(record.Amount) {
+		issues = append(issues, issue("amount", formatAmount(transaction.Amount), formatAmount(record.Amount)))
+	}
+	if !statusMatches(transaction.Status, provider.Status(record.Status)) {
+		return append(issues, issue("status", string(transaction.Status), record.Status))
+	}
+	switch transaction.Status {
+	case model.PaymentStatusCompleted, model.PaymentStatusCaptured,
+		model.PaymentStatusRefunded, model.PaymentStatusPartRefunded:
+		if captured := capturedAmount(transaction); roundAmount(captured) != roundAmount(record.CapturedAmount) {
+			issues = append(issues, issue("captured_amount", formatAmount(captured), formatAmount(record.CapturedAmount)))
+		}
+		if roundAmount(transaction.RefundedAmount) != roundAmount(record.RefundedAmount) {
+			issues = append(issues, issue("refunded_amount", formatAmount(transaction.RefundedAmount), formatAmount(record.RefundedAmount)))
+		}
+	}
+	return issues
+}
+
+// statusMatches 本系统的交易状态与网关状态是否一致
+func statusMatches(local model.PaymentStatus, remote provider.Status) bool {
+	switch local {
+	case model.PaymentStatusCompleted, model.PaymentStatusCaptured:
+		return remote == provider.StatusCaptured
+	case model.PaymentStatusFailed:
+		return remote == provider.StatusDeclined || remote == provider.StatusFailed
+	default:
+		// 其余状态与网关状态同名
+		return string(local) == string(remote)
+	}
+}
+
+// fixSettlement 修复可以安全修复的差异，返回是否已修复：
+// 等待确认的交易在网关已有结果时按网关结果更新；预授权在网关已扣款时记为已扣款。
+// 金额不一致、本系统已支付但网关拒绝等情况需要人工处理
+func (s *PaymentServiceServer) fixSettlement(ctx context.Context, transaction *model.Transaction, record *payment.SettlementRecord) (bool, error) {
+	if roundAmount(transaction.Amount) != roundAmount(record.Amount) {
+		return false, nil
+	}
+	remote := provider.Status(record.Status)
+	raw, _ := protojson.Marshal(record)
+
+	switch {
+	case transaction.Status == model.PaymentStatusPending || transaction.Status == model.PaymentStatusRequiresAction:
+		switch remote {
+		case provider.StatusCaptured, provider.StatusAuthorized, provider.StatusDeclined, provider.StatusFailed:
+		default:
+			return false, nil
+		}
+		previous := transaction.Status
+		applyGatewayResult(transaction, &provider.Result{
+			ProviderRef: record.ProviderRef,
+			Status:      remote,
+			DeclineCode: "SETTLEMENT_" + record.Status,
+			Message:     "对账确认网关状态为" + record.Status,
+			Raw:         string(raw),
+		})
+		s.stampPaid(transaction, time.Now())
+		if err := s.saveConfirmedResult(ctx, transaction, previous); err != nil {
+			return false, err
+		}
+		return true, nil
+
+	case transaction.Status == model.PaymentStatusAuthorized && remote == provider.StatusCaptured:
+		captured := roundAmount(record.CapturedAmount)
+		if captured <= 0 || captured > transaction.Amount {
+			return false, nil
+		}
+		updated := s.DB.WithContext(ctx).Model(&model.Transaction{}).
+			Where("id = ? AND status = ?", transaction.ID, model.PaymentStatusAuthorized).
+			Updates(map[string]interface{}{
+				"status":               model.PaymentStatusCaptured,
+				"captured_amount":      captured,
+				"captured_at":          time.Now(),
+				"gateway_response_raw": string(raw),
+			})
+		if updated.Error != nil {
+			return false, updated.Error
+		}
+		return updated.RowsAffected == 1, nil
+	}
+	return false, nil
+}
+
+// findMissing 找出结算周期内网关已处理、但结算文件中没有的交易
+func (s *PaymentServiceServer) findMissing(ctx context.Context, options *payment.ReconcileOptions, seen map[string]bool, result *reconcileResult) error {
+	var transactions []model.Transaction
+	err := s.DB.WithContext(ctx).
+		Where("provider = ? AND status IN ? AND created_at >= ? AND created_at < ?", s.Provider.Name(), settledStatuses,
+			time.Unix(options.PeriodStart, 0), time.Unix(options.PeriodEnd, 0)).
+		FindInBatches(&transactions, reconcileBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range transactions {
+				transaction := &transactions[i]
+				if seen[transaction.TransactionID] {
+					continue
+				}
+				result.resp.Missing++
+				result.add(&payment.ReconcileIssue{
+					Type:          ReconcileMissing,
+					TransactionId: transaction.TransactionID,
+					ProviderRef:   transaction.ProviderRef,
+					Expected:      string(transaction.Status),
+					Message:       "本系统已支付，结算文件中没有该交易",
+				})
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return status.Errorf(codes.Internal, "查询交易记录失败: %v", err)
+	}
+	return nil
+}
+
+// ExportSettlement 导出网关的结算记录，格式与对账上传的记录相同
+func (s *PaymentServiceServer) ExportSettlement(req *payment.ExportSettlementReq, stream payment.PaymentService_ExportSettlementServer) error {
+	settlementProvider, ok := s.Provider.(provider.SettlementProvider)
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "支付网关%s不支持导出结算记录", s.Provider.Name())
+	}
+	if req.PeriodStart >= req.PeriodEnd {
+		return status.Error(codes.InvalidArgument, "结算周期的开始时间必须早于结束时间")
+	}
+	entries, err := settlementProvider.Settlement(stream.Context(), time.Unix(req.PeriodStart, 0), time.Unix(req.PeriodEnd, 0))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "查询结算记录失败: %v", err)
+	}
+	for _, entry := range entries {
+		if err := stream.Send(&payment.SettlementRecord{
+			TransactionId:  entry.TransactionID,
+			ProviderRef:    entry.ProviderRef,
+			Status:         string(entry.Status),
+			Amount:         roundAmount(entry.Amount),
+			CapturedAmount: roundAmount(entry.CapturedAmount),
+			RefundedAmount: roundAmount(entry.RefundedAmount),
+			Currency:       entry.Currency,
+			CreatedAt:      entry.CreatedAt.Unix(),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(roundAmount(amount), 'f', 2, 64)
+}
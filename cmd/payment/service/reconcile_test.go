@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type reconcileStream struct {
+	grpc.ServerStream
+	reqs []*payment.ReconcileReq
+	resp *payment.ReconcileResp
+}
+
+func (s *reconcileStream) Context() context.Context { return context.Background() }
+
+func (s *reconcileStream) Recv() (*payment.ReconcileReq, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *reconcileStream) SendAndClose(resp *payment.ReconcileResp) error {
+	s.resp = resp
+	return nil
+}
+
+func newReconcileStream(fix bool, records ...*payment.SettlementRecord) *reconcileStream {
+	stream := &reconcileStream{reqs: []*payment.ReconcileReq{{Payload: &payment.ReconcileReq_Options{Options: &payment.ReconcileOptions{
+		PeriodStart: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		PeriodEnd:   time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC).Unix(),
+		Fix:         fix,
+	}}}}}
+	for i, record := range records {
+		record.Line = int64(i + 2)
+		stream.reqs = append(stream.reqs, &payment.ReconcileReq{Payload: &payment.ReconcileReq_Record{Record: record}})
+	}
+	return stream
+}
+
+func settledRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "amount", "status", "captured_amount", "refunded_amount", "provider_ref"})
+}
+
+// 测试一致、不一致、多出、缺失和无效记录的统计
+func TestReconcile(t *testing.T) {
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), &orderProxy{})
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE transaction_id IN").
+		WithArgs("TXN-1", "TXN-2", "TXN-3").
+		WillReturnRows(settledRows().
+			AddRow(1, "TXN-1", "ORD-1", 99.9, model.PaymentStatusCompleted, 99.9, 0, "sim_TXN-1").
+			AddRow(2, "TXN-2", "ORD-2", 50, model.PaymentStatusPartRefunded, 50, 10, "sim_TXN-2"))
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(provider = \\? AND status IN").
+		WillReturnRows(settledRows().
+			AddRow(1, "TXN-1", "ORD-1", 99.9, model.PaymentStatusCompleted, 99.9, 0, "sim_TXN-1").
+			AddRow(4, "TXN-4", "ORD-4", 20, model.PaymentStatusCompleted, 20, 0, "sim_TXN-4"))
+
+	stream := newReconcileStream(false,
+		&payment.SettlementRecord{TransactionId: "TXN-1", Status: "CAPTURED", Amount: 99.9, CapturedAmount: 99.9},
+		&payment.SettlementRecord{TransactionId: "TXN-2", Status: "PARTIALLY_REFUNDED", Amount: 50, CapturedAmount: 50, RefundedAmount: 20},
+		&payment.SettlementRecord{TransactionId: "TXN-3", Status: "CAPTURED", Amount: 10, CapturedAmount: 10},
+		&payment.SettlementRecord{Status: "CAPTURED", Amount: 10},
+		&payment.SettlementRecord{TransactionId: "TXN-1", Status: "CAPTURED", Amount: 99.9},
+	)
+	require.NoError(t, s.Reconcile(stream))
+
+	resp := stream.resp
+	assert.Equal(t, int32(5), resp.Total)
+	assert.Equal(t, int32(1), resp.Matched)
+	assert.Equal(t, int32(1), resp.Mismatched)
+	assert.Equal(t, int32(1), resp.Extra)
+	assert.Equal(t, int32(1), resp.Missing)
+	assert.Equal(t, int32(2), resp.Invalid)
+	assert.Equal(t, int32(0), resp.Fixed)
+
+	byType := make(map[string][]*payment.ReconcileIssue)
+	for _, issue := range resp.Issues {
+		byType[issue.Type] = append(byType[issue.Type], issue)
+	}
+	require.Len(t, byType[ReconcileMismatch], 1)
+	assert.Equal(t, "refunded_amount", byType[ReconcileMismatch][0].Field)
+	assert.Equal(t, "10.00", byType[ReconcileMismatch][0].Expected)
+	assert.Equal(t, "20.00", byType[ReconcileMismatch][0].Actual)
+	assert.Equal(t, "TXN-3", byType[ReconcileExtra][0].TransactionId)
+	assert.Equal(t, "TXN-4", byType[ReconcileMissing][0].TransactionId)
+	assert.Equal(t, int64(6), byType[ReconcileInvalid][1].Line)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试自动修复：等待确认的交易按网关结果更新，预授权记为已扣款，金额不一致的不修复
+func TestReconcileFix(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), orders)
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE transaction_id IN").
+		WillReturnRows(settledRows().
+			AddRow(1, "TXN-1", "ORD-1", 99.9, model.PaymentStatusPending, 0, 0, "").
+			AddRow(2, "TXN-2", "ORD-2", 50, model.PaymentStatusAuthorized, 0, 0, "sim_TXN-2").
+			AddRow(3, "TXN-3", "ORD-3", 30, model.PaymentStatusPending, 0, 0, ""))
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(sqlmock.AnyArg(), 99.9, sqlmock.AnyArg(), "", "", sqlmock.AnyArg(), true, "sim_TXN-1",
+			model.PaymentStatusCompleted, sqlmock.AnyArg(), 1, model.PaymentStatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOrderNotified(mock)
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(40.0, sqlmock.AnyArg(), sqlmock.AnyArg(), model.PaymentStatusCaptured, sqlmock.AnyArg(), 2, model.PaymentStatusAuthorized).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(provider = \\? AND status IN").
+		WillReturnRows(settledRows())
+
+	stream := newReconcileStream(true,
+		&payment.SettlementRecord{TransactionId: "TXN-1", ProviderRef: "sim_TXN-1", Status: "CAPTURED", Amount: 99.9, CapturedAmount: 99.9},
+		&payment.SettlementRecord{TransactionId: "TXN-2", ProviderRef: "sim_TXN-2", Status: "CAPTURED", Amount: 50, CapturedAmount: 40},
+		&payment.SettlementRecord{TransactionId: "TXN-3", ProviderRef: "sim_TXN-3", Status: "CAPTURED", Amount: 35, CapturedAmount: 35},
+	)
+	require.NoError(t, s.Reconcile(stream))
+
+	resp := stream.resp
+	assert.Equal(t, int32(3), resp.Mismatched)
+	assert.Equal(t, int32(2), resp.Fixed)
+	for _, issue := range resp.Issues {
+		assert.Equal(t, issue.TransactionId != "TXN-3", issue.Fixed, issue.TransactionId)
+	}
+	require.Len(t, orders.calls, 1)
+	assert.Equal(t, "TXN-1", orders.calls[0].TransactionId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试第一条消息必须是对账参数
+func TestReconcileRequiresOptions(t *testing.T) {
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), &orderProxy{})
+
+	stream := newReconcileStream(false, &payment.SettlementRecord{TransactionId: "TXN-1", Status: "CAPTURED"})
+	stream.reqs = stream.reqs[1:]
+	assert.Equal(t, codes.InvalidArgument, status.Code(s.Reconcile(stream)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/common/events"
+	"TKMall/common/idempotency"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Refund 对已支付的交易退款。携带幂等键的重复请求返回同一笔退款，避免重试导致重复退款
+func (s *PaymentServiceServer) Refund(ctx context.Context, req *payment.RefundReq) (*payment.RefundResp, error) {
+	key := idempotency.KeyFromContext(ctx, req.IdempotencyKey)
+	return idempotency.Do(ctx, s.Idempotency, "payment.Refund", 0, key, req, func() (*payment.RefundResp, error) {
+		return s.refund(ctx, req, key)
+	})
+}
+
+// refund 先在交易上预留退款金额，再请求网关，保证并发的多笔部分退款累计不会超过支付金额。
+// 退款记录保存幂等键，网关超时后的重试不会再预留一笔，而是用相同的退款ID重新提交
+func (s *PaymentServiceServer) refund(ctx context.Context, req *payment.RefundReq, key string) (*payment.RefundResp, error) {
+	// 参数校验
+	if req.TransactionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "交易ID不能为空")
+	}
+	amount := roundAmount(float64(req.Amount))
+	if amount <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "退款金额必须大于0")
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(reason) > 255 {
+		return nil, status.Error(codes.InvalidArgument, "退款原因不能超过255个字符")
+	}
+
+	refund := model.Refund{
+		RefundID:       fmt.Sprintf("RFD-%s", s.Node.Generate().String()),
+		Amount:         amount,
+		Reason:         reason,
+		Status:         model.RefundStatusPending,
+		IdempotencyKey: key,
+	}
+	transaction, existing, err := s.reserveRefund(ctx, req.TransactionId, &refund)
+	if err != nil {
+		return nil, err
+	}
+	if existing && refund.Amount != amount {
+		return nil, status.Error(codes.InvalidArgument, "幂等键已用于其他退款请求")
+	}
+	if existing && refund.Status != model.RefundStatusPending {
+		return s.existingRefund(ctx, transaction, &refund)
+	}
+
+	gatewayCtx, cancel := context.WithTimeout(ctx, s.gatewayTimeout())
+	// 重试结果未知的退款时使用相同的退款ID，网关只退款一次
+	result, gatewayErr := s.Provider.Refund(gatewayCtx, transaction.ProviderRef, refund.RefundID, refund.Amount)
+	cancel()
+
+	refunded, err := s.applyRefundResult(ctx, transaction, &refund, result, gatewayErr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment.RefundResp{
+		RefundId:         refund.RefundID,
+		Status:           string(refund.Status),
+		RefundedAmount:   float32(refunded),
+		RefundableAmount: float32(roundAmount(capturedAmount(transaction) - refunded)),
+	}, nil
+}
+
+// applyRefundResult 保存网关的退款结果，返回交易累计成功退款的金额。
+// 超时时网关可能已经退款，保持PENDING并继续占用退款金额，由定时任务用相同的退款ID重新提交
+func (s *PaymentServiceServer) applyRefundResult(ctx context.Context, transaction *model.Transaction, refund *model.Refund, result *provider.Result, gatewayErr error) (float64, error) {
+	if gatewayErr != nil {
+		if errors.Is(gatewayErr, provider.ErrTimeout) || errors.Is(gatewayErr, context.DeadlineExceeded) {
+			return 0, status.Error(codes.DeadlineExceeded, "退款结果未知，请稍后查询")
+		}
+		if err := s.releaseRefund(ctx, transaction, refund, gatewayErr); err != nil {
+			return 0, err
+		}
+		if errors.Is(gatewayErr, provider.ErrInvalidState) {
+			return 0, status.Errorf(codes.FailedPrecondition, "退款失败: %v", gatewayErr)
+		}
+		return 0, status.Errorf(codes.Unavailable, "支付网关不可用: %v", gatewayErr)
+	}
+
+	refunded, err := s.completeRefund(ctx, transaction, refund, result)
+	if err != nil {
+		return 0, err
+	}
+	// 退款已经成功，事件发布失败时保留待发布标记由定时任务重试，不返回错误，避免调用方重新发起退款
+	_ = s.publishRefunded(ctx, transaction, refund, refunded)
+	return refunded, nil
+}
+
+// reserveRefund 锁定交易，校验可退金额后创建PENDING的退款记录并预留退款金额。
+// 交易上已有相同幂等键的退款时不再创建，refund替换为已有的退款，返回的existing为true
+func (s *PaymentServiceServer) reserveRefund(ctx context.Context, transactionID string, refund *model.Refund) (*model.Transaction, bool, error) {
+	var transaction model.Transaction
+	existing := false
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("transaction_id = ?", transactionID).First(&transaction).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return status.Error(codes.NotFound, "交易不存在")
+			}
+			return status.Errorf(codes.Internal, "查询交易失败: %v", err)
+		}
+		if refund.IdempotencyKey != "" {
+			// 交易行已锁定，相同幂等键的并发请求只有一个会创建退款
+			var previous model.Refund
+			err := tx.Where("transaction_id = ? AND idempotency_key = ?", transactionID, refund.IdempotencyKey).
+				First(&previous).Error
+			if err == nil {
+				*refund = previous
+				existing = true
+				return nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return status.Errorf(codes.Internal, "查询退款记录失败: %v", err)
+			}
+		}
+		switch transaction.Status {
+		case model.PaymentStatusCompleted, model.PaymentStatusCaptured, model.PaymentStatusPartRefunded:
+		default:
+			return status.Errorf(codes.FailedPrecondition, "交易状态不允许退款，当前状态: %s", transaction.Status)
+		}
+		if transaction.ProviderRef == "" {
+			return status.Error(codes.FailedPrecondition, "交易缺少网关交易号，无法退款")
+		}
+		if refundable := refundableAmount(&transaction); refund.Amount > refundable {
+			return status.Errorf(codes.FailedPrecondition, "退款金额超过可退金额%.2f", refundable)
+		}
+
+		refund.TransactionID = transaction.TransactionID
+		refund.OrderID = transaction.OrderID
+		refund.UserID = transaction.UserID
+		if err := tx.Create(refund).Error; err != nil {
+			return status.Errorf(codes.Internal, "保存退款记录失败: %v", err)
+		}
+		if err := tx.Model(&transaction).
+			Update("refunded_amount", gorm.Expr("refunded_amount + ?", refund.Amount)).Error; err != nil {
+			return status.Errorf(codes.Internal, "更新交易记录失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &transaction, existing, nil
+}
+
+// existingRefund 返回重复请求对应的已完成的退款结果
+func (s *PaymentServiceServer) existingRefund(ctx context.Context, transaction *model.Transaction, refund *model.Refund) (*payment.RefundResp, error) {
+	if refund.Status == model.RefundStatusFailed {
+		return nil, status.Errorf(codes.FailedPrecondition, "退款失败: %s", refund.ErrorMessage)
+	}
+	refunded, err := succeededRefundAmount(s.DB.WithContext(ctx), transaction.TransactionID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "查询退款记录失败: %v", err)
+	}
+	refunded = roundAmount(refunded)
+	return &payment.RefundResp{
+		RefundId:         refund.RefundID,
+		Status:           string(refund.Status),
+		RefundedAmount:   float32(refunded),
+		RefundableAmount: float32(roundAmount(capturedAmount(transaction) - refunded)),
+	}, nil
+}
+
+// releaseRefund 网关明确退款失败时释放预留的金额
+func (s *PaymentServiceServer) releaseRefund(ctx context.Context, transaction *model.Transaction, refund *model.Refund, gatewayErr error) error {
+	refund.Status = model.RefundStatusFailed
+	refund.ErrorCode = "GATEWAY_ERROR"
+	if errors.Is(gatewayErr, provider.ErrInvalidState) {
+		refund.ErrorCode = "INVALID_STATE"
+	}
+	refund.ErrorMessage = gatewayErr.Error()
+
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(refund).Updates(map[string]interface{}{
+			"status":        refund.Status,
+			"error_code":    refund.ErrorCode,
+			"error_message": refund.ErrorMessage,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(transaction).
+			Update("refunded_amount", gorm.Expr("refunded_amount - ?", refund.Amount)).Error
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "更新退款记录失败: %v", err)
+	}
+	return nil
+}
+
+// completeRefund 记录退款成功，并在交易的行锁内按累计成功退款的金额更新交易状态，返回累计金额。
+// 并发完成的多笔退款依次计算，后完成的一笔能看到之前所有成功的退款
+func (s *PaymentServiceServer) completeRefund(ctx context.Context, transaction *model.Transaction, refund *model.Refund, result *provider.Result) (float64, error) {
+	now := time.Now()
+	refund.Status = model.RefundStatusSucceeded
+	refund.ProviderRef = result.ProviderRef
+	refund.RefundedAt = &now
+
+	// 有事件总线时退款事件和退款结果一起标记为待发布
+	refund.EventPending = s.EventBus != nil
+
+	var refunded float64
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked model.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", transaction.ID).First(&locked).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(refund).Updates(map[string]interface{}{
+			"status":        refund.Status,
+			"provider_ref":  refund.ProviderRef,
+			"refunded_at":   now,
+			"event_pending": refund.EventPending,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		if refunded, err = succeededRefundAmount(tx, transaction.TransactionID); err != nil {
+			return err
+		}
+		transaction.Status = refundedStatus(capturedAmount(&locked), refunded)
+		return tx.Model(transaction).Update("status", transaction.Status).Error
+	})
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "更新退款记录失败: %v", err)
+	}
+	return roundAmount(refunded), nil
+}
+
+// publishRefunded 发布退款成功事件，订单服务据此更新订单状态。发布成功后清除待发布标记
+func (s *PaymentServiceServer) publishRefunded(ctx context.Context, transaction *model.Transaction, refund *model.Refund, refunded float64) error {
+	if s.EventBus == nil || !refund.EventPending {
+		return nil
+	}
+	event := events.Event{
+		Type: events.PaymentRefunded,
+		Payload: events.PaymentRefundedPayload{
+			RefundID:       refund.RefundID,
+			TransactionID:  transaction.TransactionID,
+			OrderID:        transaction.OrderID,
+			UserID:         transaction.UserID,
+			Amount:         refund.Amount,
+			RefundedAmount: refunded,
+			FullyRefunded:  transaction.Status == model.PaymentStatusRefunded,
+			Reason:         refund.Reason,
+			RefundedAt:     *refund.RefundedAt,
+		},
+		Timestamp: time.Now(),
+	}
+	if err := s.EventBus.Publish(ctx, event); err != nil {
+		return fmt.Errorf("发布退款事件失败: %w", err)
+	}
+	if err := s.DB.WithContext(ctx).Model(&model.Refund{}).
+		Where("id = ?", refund.ID).
+		Update("event_pending", false).Error; err != nil {
+		return fmt.Errorf("保存退款事件状态失败: %w", err)
+	}
+	refund.EventPending = false
+	return nil
+}
+
+// succeededRefundAmount 交易累计成功退款的金额
+func succeededRefundAmount(db *gorm.DB, transactionID string) (float64, error) {
+	var refunded float64
+	err := db.Model(&model.Refund{}).
+		Where("transaction_id = ? AND status = ?", transactionID, model.RefundStatusSucceeded).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error
+	return refunded, err
+}
+
+// refundableAmount 交易剩余可退金额，处理中的退款已从中扣除
+func refundableAmount(transaction *model.Transaction) float64 {
+	return math.Max(roundAmount(capturedAmount(transaction)-transaction.RefundedAmount), 0)
+}
+
+// refundedStatus 根据累计成功退款的金额判断交易是全额退款还是部分退款
+func refundedStatus(captured, refunded float64) model.PaymentStatus {
+	if roundAmount(captured-refunded) <= 0 {
+		return model.PaymentStatusRefunded
+	}
+	return model.PaymentStatusPartRefunded
+}
+
+// roundAmount 金额保留两位小数，避免float32转换和累加带来的误差
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"TKMall/cmd/payment/model"
+	"TKMall/common/log"
+)
+
+// syncPendingRefunds 用相同的退款ID重新提交before之前创建的结果未知的退款，网关对同一退款ID只退款一次
+func (s *PaymentServiceServer) syncPendingRefunds(ctx context.Context, before time.Time) error {
+	var refunds []model.Refund
+	if err := s.DB.WithContext(ctx).
+		Where("status = ? AND created_at < ?", model.RefundStatusPending, before).
+		Order("id ASC").Limit(pendingSyncBatchSize).
+		Find(&refunds).Error; err != nil {
+		return err
+	}
+
+	for i := range refunds {
+		if err := s.syncRefund(ctx, &refunds[i]); err != nil {
+			log.Errorf("同步退款状态失败: refund=%s: %v", refunds[i].RefundID, err)
+		}
+	}
+	return nil
+}
+
+func (s *PaymentServiceServer) syncRefund(ctx context.Context, refund *model.Refund) error {
+	transaction, err := s.findTransaction(ctx, refund.TransactionID)
+	if err != nil {
+		return err
+	}
+
+	gatewayCtx, cancel := context.WithTimeout(ctx, s.gatewayTimeout())
+	result, gatewayErr := s.Provider.Refund(gatewayCtx, transaction.ProviderRef, refund.RefundID, refund.Amount)
+	cancel()
+
+	_, err = s.applyRefundResult(ctx, transaction, refund, result, gatewayErr)
+	return err
+}
+
+// retryRefundEvents 重新发布退款成功但事件发布失败的退款
+func (s *PaymentServiceServer) retryRefundEvents(ctx context.Context, before time.Time) error {
+	if s.EventBus == nil {
+		return nil
+	}
+	var refunds []model.Refund
+	if err := s.DB.WithContext(ctx).
+		Where("event_pending = ? AND created_at < ?", true, before).
+		Order("id ASC").Limit(pendingSyncBatchSize).
+		Find(&refunds).Error; err != nil {
+		return err
+	}
+
+	for i := range refunds {
+		if err := s.republishRefunded(ctx, &refunds[i]); err != nil {
+			log.Errorf("重新发布退款事件失败: refund=%s: %v", refunds[i].RefundID, err)
+		}
+	}
+	return nil
+}
+
+func (s *PaymentServiceServer) republishRefunded(ctx context.Context, refund *model.Refund) error {
+	if refund.RefundedAt == nil {
+		return errors.New("退款缺少成功时间")
+	}
+	transaction, err := s.findTransaction(ctx, refund.TransactionID)
+	if err != nil {
+		return err
+	}
+	refunded, err := succeededRefundAmount(s.DB.WithContext(ctx), refund.TransactionID)
+	if err != nil {
+		return err
+	}
+	return s.publishRefunded(ctx, transaction, refund, roundAmount(refunded))
+}
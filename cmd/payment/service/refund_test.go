@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/common/events"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 测试可退金额和退款后的交易状态
+func TestRefundAmounts(t *testing.T) {
+	assert.InDelta(t, 70, refundableAmount(&model.Transaction{Amount: 99.9, RefundedAmount: 29.9}), 0.001)
+	assert.Equal(t, 0.0, refundableAmount(&model.Transaction{Amount: 99.9, RefundedAmount: 99.9}))
+	assert.Equal(t, 0.0, refundableAmount(&model.Transaction{Amount: 0.3, RefundedAmount: 0.1 + 0.2}))
+
+	assert.Equal(t, model.PaymentStatusPartRefunded, refundedStatus(99.9, 29.9))
+	assert.Equal(t, model.PaymentStatusRefunded, refundedStatus(99.9, 99.9))
+	assert.Equal(t, model.PaymentStatusRefunded, refundedStatus(0.3, 0.1+0.2), "浮点误差不影响全额退款判断")
+
+	assert.Equal(t, 99.9, roundAmount(float64(float32(99.9))))
+}
+
+func transactionRows(amount, refunded float64, status model.PaymentStatus) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "amount", "status", "provider_ref", "refunded_amount"}).
+		AddRow(1, "TXN-1", "ORD-1", 1001, amount, status, "sim_TXN-1", refunded)
+}
+
+// 测试部分退款：预留金额、请求网关、按累计退款金额更新交易状态
+func TestRefundPartial(t *testing.T) {
+	sim := provider.NewSimulator(provider.SimulatorConfig{})
+	_, err := sim.Authorize(context.Background(), provider.AuthorizeRequest{
+		TransactionID: "TXN-1", Amount: 100, Card: provider.Card{Number: "4242424242424242"}, Capture: true,
+	})
+	require.NoError(t, err)
+	s, mock := newChargeTestServer(t, sim, &orderProxy{})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `transactions` .* FOR UPDATE").
+		WillReturnRows(transactionRows(100, 0, model.PaymentStatusCompleted))
+	mock.ExpectExec("INSERT INTO `refunds`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE `transactions` SET `refunded_amount`=refunded_amount \\+ \\?").
+		WithArgs(30.0, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `transactions` .* FOR UPDATE").
+		WillReturnRows(transactionRows(100, 30, model.PaymentStatusCompleted))
+	mock.ExpectExec("UPDATE `refunds` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM `refunds`").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(30.0))
+	mock.ExpectExec("UPDATE `transactions` SET `status`").
+		WithArgs(model.PaymentStatusPartRefunded, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp, err := s.Refund(context.Background(), &payment.RefundReq{TransactionId: "TXN-1", Amount: 30, Reason: "商品破损"})
+	require.NoError(t, err)
+	assert.Equal(t, string(model.RefundStatusSucceeded), resp.Status)
+	assert.InDelta(t, 30, resp.RefundedAmount, 0.001)
+	assert.InDelta(t, 70, resp.RefundableAmount, 0.001)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试退款金额超过可退金额或交易状态不允许退款时不请求网关
+func TestRefundRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     *sqlmock.Rows
+		amount   float32
+		wantCode codes.Code
+	}{
+		{"超过可退金额", transactionRows(100, 80, model.PaymentStatusPartRefunded), 30, codes.FailedPrecondition},
+		{"已全额退款", transactionRows(100, 100, model.PaymentStatusRefunded), 1, codes.FailedPrecondition},
+		{"未支付", transactionRows(100, 0, model.PaymentStatusPending), 1, codes.FailedPrecondition},
+		{"交易不存在", sqlmock.NewRows([]string{"id"}), 1, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), &orderProxy{})
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(tt.rows)
+			mock.ExpectRollback()
+
+			_, err := s.Refund(context.Background(), &payment.RefundReq{TransactionId: "TXN-1", Amount: tt.amount})
+			assert.Equal(t, tt.wantCode, status.Code(err), "%v", err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	s, _ := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), &orderProxy{})
+	_, err := s.Refund(context.Background(), &payment.RefundReq{TransactionId: "TXN-1", Amount: 0})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// 测试携带幂等键的重复退款请求返回已有的退款，不再预留金额和请求网关
+func TestRefundIdempotencyKey(t *testing.T) {
+	existingRefund := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "refund_id", "transaction_id", "amount", "status", "idempotency_key"}).
+			AddRow(5, "RFD-1", "TXN-1", 30.0, model.RefundStatusSucceeded, "refund-key")
+	}
+	expectExisting := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT \\* FROM `transactions` .* FOR UPDATE").
+			WillReturnRows(transactionRows(100, 30, model.PaymentStatusPartRefunded))
+		mock.ExpectQuery("SELECT \\* FROM `refunds` WHERE \\(transaction_id = \\? AND idempotency_key = \\?\\)").
+			WithArgs("TXN-1", "refund-key", 1).
+			WillReturnRows(existingRefund())
+		mock.ExpectCommit()
+	}
+
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), &orderProxy{})
+	expectExisting(mock)
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM `refunds`").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(30.0))
+
+	resp, err := s.Refund(context.Background(), &payment.RefundReq{TransactionId: "TXN-1", Amount: 30, IdempotencyKey: "refund-key"})
+	require.NoError(t, err, "网关中没有该交易，重复请求不应再请求网关")
+	assert.Equal(t, "RFD-1", resp.RefundId)
+	assert.Equal(t, string(model.RefundStatusSucceeded), resp.Status)
+	assert.InDelta(t, 70, resp.RefundableAmount, 0.001)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// 相同幂等键的退款金额不一致
+	expectExisting(mock)
+	_, err = s.Refund(context.Background(), &payment.RefundReq{TransactionId: "TXN-1", Amount: 40, IdempotencyKey: "refund-key"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// eventBus 记录发布的事件，err不为空时发布失败
+type eventBus struct {
+	err    error
+	events []events.Event
+}
+
+func (b *eventBus) Publish(ctx context.Context, event events.Event) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.events = append(b.events, event)
+	return nil
+}
+
+func (b *eventBus) Subscribe(eventType events.EventType, handler func(context.Context, events.Event) error) {
+}
+
+func pendingRefundRows(eventPending bool, refundedAt *time.Time) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "refund_id", "transaction_id", "order_id", "user_id", "amount", "status", "event_pending", "refunded_at"}).
+		AddRow(5, "RFD-1", "TXN-1", "ORD-1", 1001, 30.0, model.RefundStatusPending, eventPending, refundedAt)
+}
+
+// 测试定时任务用相同的退款ID重新提交超时的退款，网关不会重复退款；事件发布失败时保留待发布标记并由定时任务重新发布
+func TestSyncPendingRefunds(t *testing.T) {
+	ctx := context.Background()
+	sim := provider.NewSimulator(provider.SimulatorConfig{})
+	auth, err := sim.Authorize(ctx, provider.AuthorizeRequest{
+		TransactionID: "TXN-1", Amount: 100, Card: provider.Card{Number: "4242424242424242"}, Capture: true,
+	})
+	require.NoError(t, err)
+	// 网关已处理退款，但响应超时
+	_, err = sim.Refund(ctx, auth.ProviderRef, "RFD-1", 30)
+	require.NoError(t, err)
+
+	s, mock := newChargeTestServer(t, sim, &orderProxy{})
+	bus := &eventBus{err: errors.New("kafka unavailable")}
+	s.EventBus = bus
+	before := time.Now()
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(status IN").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(order_notify_pending").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM `refunds` WHERE \\(status = \\? AND created_at < \\?\\)").
+		WithArgs(model.RefundStatusPending, before, pendingSyncBatchSize).
+		WillReturnRows(pendingRefundRows(false, nil))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(transactionRows(100, 30, model.PaymentStatusCompleted))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `transactions` .* FOR UPDATE").
+		WillReturnRows(transactionRows(100, 30, model.PaymentStatusCompleted))
+	// Updates的字段按名称排序：event_pending, provider_ref, refunded_at, status, updated_at
+	mock.ExpectExec("UPDATE `refunds` SET").
+		WithArgs(true, sqlmock.AnyArg(), sqlmock.AnyArg(), model.RefundStatusSucceeded, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM `refunds`").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(30.0))
+	mock.ExpectExec("UPDATE `transactions` SET `status`").
+		WithArgs(model.PaymentStatusPartRefunded, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT \\* FROM `refunds` WHERE \\(event_pending = \\? AND created_at < \\?\\)").
+		WithArgs(true, before, pendingSyncBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	require.NoError(t, s.SyncPendingTransactions(ctx, before))
+	assert.Empty(t, bus.events)
+
+	remaining, err := sim.Refund(ctx, auth.ProviderRef, "RFD-2", 70)
+	require.NoError(t, err, "重新提交没有重复退款")
+	assert.Equal(t, provider.StatusRefunded, remaining.Status)
+
+	bus.err = nil
+	refundedAt := time.Now()
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(status IN").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE \\(order_notify_pending").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM `refunds` WHERE \\(status = \\?").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM `refunds` WHERE \\(event_pending = \\?").WillReturnRows(pendingRefundRows(true, &refundedAt))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(transactionRows(100, 30, model.PaymentStatusPartRefunded))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM `refunds`").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(30.0))
+	mock.ExpectExec("UPDATE `refunds` SET `event_pending`").
+		WithArgs(false, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, s.SyncPendingTransactions(ctx, before))
+	require.Len(t, bus.events, 1)
+	payload := bus.events[0].Payload.(events.PaymentRefundedPayload)
+	assert.Equal(t, "RFD-1", payload.RefundID)
+	assert.Equal(t, 30.0, payload.RefundedAmount)
+	assert.False(t, payload.FullyRefunded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/cmd/payment/risk"
+	"TKMall/common/clientinfo"
+	"TKMall/common/events"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCodeRiskRejected 被风控拒绝或风控审核拒绝的交易的错误代码
+const ErrorCodeRiskRejected = "RISK_REJECTED"
+
+// assessRisk 请求网关前的风控评分，结果保存在交易上。未配置风控时不评分
+func (s *PaymentServiceServer) assessRisk(ctx context.Context, req *payment.ChargeReq, transaction *model.Transaction, card provider.Card) {
+	if s.Risk == nil {
+		return
+	}
+
+	// 客户端IP和国家只取网关写入的metadata，不使用请求消息中客户端可以伪造的值
+	client := clientinfo.FromIncomingContext(ctx)
+	in := &risk.Input{
+		UserID:         transaction.UserID,
+		Amount:         transaction.Amount,
+		Currency:       transaction.Currency,
+		PaymentMethod:  transaction.PaymentMethod,
+		IP:             client.IP,
+		IPCountry:      client.Country,
+		BillingCountry: req.RiskContext.GetBillingCountry(),
+	}
+	if card.Number != "" {
+		in.BIN = card.Number[:min(len(card.Number), 8)]
+		if s.Vault != nil {
+			in.CardFingerprint = s.Vault.Fingerprint(card.Number)
+		}
+	}
+
+	assessment := s.Risk.Assess(ctx, in)
+	signals, _ := json.Marshal(assessment.Signals)
+	transaction.Risk = model.RiskAssessment{
+		Score:    assessment.Score,
+		Decision: string(assessment.Decision),
+		Signals:  string(signals),
+	}
+	switch assessment.Decision {
+	case risk.DecisionReject:
+		transaction.Status = model.PaymentStatusFailed
+		transaction.ErrorCode = ErrorCodeRiskRejected
+		transaction.ErrorMessage = "支付被风控拒绝"
+	case risk.DecisionReview:
+		transaction.Risk.ReviewStatus = model.RiskReviewPending
+	}
+}
+
+// rejectForReview 拒绝需要人工审核但无法只预授权的支付，交易不进入审核队列
+func rejectForReview(transaction *model.Transaction) {
+	transaction.Status = model.PaymentStatusFailed
+	transaction.ErrorCode = ErrorCodeRiskRejected
+	transaction.ErrorMessage = "支付需要风控审核，钱包支付不支持预授权"
+	transaction.Risk.ReviewStatus = ""
+}
+
+// ListRiskReviews 管理端查询风控审核，默认查询待审核的交易
+func (s *PaymentServiceServer) ListRiskReviews(ctx context.Context, req *payment.ListRiskReviewsReq) (*payment.ListRiskReviewsResp, error) {
+	reviewStatus := model.RiskReviewStatus(req.ReviewStatus)
+	if reviewStatus == "" && req.Decision == "" {
+		reviewStatus = model.RiskReviewPending
+	}
+	switch reviewStatus {
+	case "", model.RiskReviewPending, model.RiskReviewApproved, model.RiskReviewRejected:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "无效的审核状态: %s", req.ReviewStatus)
+	}
+	switch risk.Decision(req.Decision) {
+	case "", risk.DecisionApprove, risk.DecisionReview, risk.DecisionReject:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "无效的风控结论: %s", req.Decision)
+	}
+
+	page, pageSize := req.Page, req.PageSize
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	query := s.DB.WithContext(ctx).Model(&model.Transaction{})
+	if reviewStatus != "" {
+		query = query.Where("risk_review_status = ?", reviewStatus)
+	}
+	if req.Decision != "" {
+		query = query.Where("risk_decision = ?", req.Decision)
+	}
+	if req.UserId != 0 {
+		query = query.Where("user_id = ?", req.UserId)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "获取总数失败: %v", err)
+	}
+
+	var transactions []model.Transaction
+	if err := query.Order("created_at DESC, id DESC").
+		Offset(int((page - 1) * pageSize)).Limit(int(pageSize)).
+		Find(&transactions).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询风控审核失败: %v", err)
+	}
+
+	reviews := make([]*payment.RiskReview, 0, len(transactions))
+	for i := range transactions {
+		reviews = append(reviews, riskReview(&transactions[i]))
+	}
+	return &payment.ListRiskReviewsResp{Reviews: reviews, Total: total}, nil
+}
+
+// ReviewRisk 审核待审核的交易。审核通过后可以扣款；审核拒绝时撤销未扣款的授权并通知订单服务取消订单，
+// 已扣款的交易全额退款。重复提交相同的审核结果直接返回
+func (s *PaymentServiceServer) ReviewRisk(ctx context.Context, req *payment.ReviewRiskReq) (*payment.ReviewRiskResp, error) {
+	if req.TransactionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "交易ID不能为空")
+	}
+	reviewStatus := model.RiskReviewStatus(req.ReviewStatus)
+	if reviewStatus != model.RiskReviewApproved && reviewStatus != model.RiskReviewRejected {
+		return nil, status.Error(codes.InvalidArgument, "审核状态只能为APPROVED或REJECTED")
+	}
+	reviewer := strings.TrimSpace(req.Reviewer)
+	if utf8.RuneCountInString(reviewer) > 64 {
+		return nil, status.Error(codes.InvalidArgument, "审核人不能超过64个字符")
+	}
+	note := strings.TrimSpace(req.Note)
+	if utf8.RuneCountInString(note) > 255 {
+		return nil, status.Error(codes.InvalidArgument, "审核备注不能超过255个字符")
+	}
+
+	transaction, err := s.findTransaction(ctx, req.TransactionId)
+	if err != nil {
+		return nil, err
+	}
+	switch transaction.Risk.ReviewStatus {
+	case model.RiskReviewPending:
+	case reviewStatus:
+		return &payment.ReviewRiskResp{Review: riskReview(transaction)}, nil
+	case "":
+		return nil, status.Error(codes.FailedPrecondition, "交易不需要风控审核")
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "交易已审核，审核结果: %s", transaction.Risk.ReviewStatus)
+	}
+
+	if reviewStatus == model.RiskReviewRejected {
+		if err := s.rejectRiskReview(ctx, transaction, reviewer, note); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	result := s.DB.WithContext(ctx).Model(&model.Transaction{}).
+		Where("id = ? AND risk_review_status = ?", transaction.ID, model.RiskReviewPending).
+		Updates(map[string]interface{}{
+			"risk_review_status": reviewStatus,
+			"risk_reviewer":      reviewer,
+			"risk_review_note":   note,
+			"risk_reviewed_at":   now,
+		})
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "保存审核结果失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.Error(codes.Aborted, "审核状态已变化，请刷新后重试")
+	}
+	transaction.Risk.ReviewStatus = reviewStatus
+	transaction.Risk.Reviewer = reviewer
+	transaction.Risk.ReviewNote = note
+	transaction.Risk.ReviewedAt = &now
+	return &payment.ReviewRiskResp{Review: riskReview(transaction)}, nil
+}
+
+// rejectRiskReview 撤销审核拒绝的交易的授权，已扣款时全额退款。
+// 审核结果保存失败后重试时交易已撤销或已退款，不会重复处理
+func (s *PaymentServiceServer) rejectRiskReview(ctx context.Context, transaction *model.Transaction, reviewer, note string) error {
+	switch transaction.Status {
+	case model.PaymentStatusPending, model.PaymentStatusRequiresAction:
+		return status.Error(codes.FailedPrecondition, "支付结果未确认，请稍后审核")
+	case model.PaymentStatusAuthorized:
+		if err := s.voidTransaction(ctx, transaction, ErrorCodeRiskRejected, "风控审核拒绝"); err != nil {
+			return err
+		}
+		s.publishRiskRejected(transaction, reviewer, note)
+	case model.PaymentStatusCompleted, model.PaymentStatusCaptured, model.PaymentStatusPartRefunded:
+		if amount := refundableAmount(transaction); amount > 0 {
+			// 每笔交易只会因审核拒绝退款一次，审核结果保存失败后重试时返回同一笔退款
+			if _, err := s.refund(ctx, &payment.RefundReq{
+				TransactionId: transaction.TransactionID,
+				Amount:        float32(amount),
+				Reason:        "风控审核拒绝",
+			}, "risk-review-rejected"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *PaymentServiceServer) publishRiskRejected(transaction *model.Transaction, reviewer, note string) {
+	if s.EventBus == nil {
+		return
+	}
+	event := events.Event{
+		Type: events.PaymentRiskRejected,
+		Payload: events.PaymentRiskRejectedPayload{
+			TransactionID: transaction.TransactionID,
+			OrderID:       transaction.OrderID,
+			UserID:        transaction.UserID,
+			Amount:        transaction.Amount,
+			Reviewer:      reviewer,
+			Note:          note,
+			RejectedAt:    time.Now(),
+		},
+		Timestamp: time.Now(),
+	}
+	go func() {
+		if err := s.EventBus.Publish(context.Background(), event); err != nil {
+			log.Errorf("发布风控审核拒绝事件失败: transaction=%s: %v", transaction.TransactionID, err)
+		}
+	}()
+}
+
+func riskReview(transaction *model.Transaction) *payment.RiskReview {
+	review := &payment.RiskReview{
+		TransactionId: transaction.TransactionID,
+		OrderId:       transaction.OrderID,
+		UserId:        transaction.UserID,
+		Amount:        float32(transaction.Amount),
+		PaymentMethod: transaction.PaymentMethod,
+		PaymentStatus: string(transaction.Status),
+		Score:         int32(transaction.Risk.Score),
+		Decision:      transaction.Risk.Decision,
+		ReviewStatus:  string(transaction.Risk.ReviewStatus),
+		Reviewer:      transaction.Risk.Reviewer,
+		ReviewNote:    transaction.Risk.ReviewNote,
+		CreatedAt:     transaction.CreatedAt.Unix(),
+	}
+	if transaction.Risk.ReviewedAt != nil {
+		review.ReviewedAt = transaction.Risk.ReviewedAt.Unix()
+	}
+	var signals []risk.Signal
+	if transaction.Risk.Signals != "" {
+		_ = json.Unmarshal([]byte(transaction.Risk.Signals), &signals)
+	}
+	for _, signal := range signals {
+		review.Signals = append(review.Signals, &payment.RiskSignal{
+			Rule:   signal.Rule,
+			Score:  int32(signal.Score),
+			Reason: signal.Reason,
+		})
+	}
+	return review
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/cmd/payment/risk"
+	"TKMall/common/clientinfo"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const riskSignals = `[{"rule":"country_mismatch","score":60,"reason":"IP所在国家US与账单地址国家CN不一致"}]`
+
+func riskRows(status model.PaymentStatus, reviewStatus model.RiskReviewStatus) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "amount", "status", "provider_ref",
+		"auth_expires_at", "risk_score", "risk_decision", "risk_signals", "risk_review_status"}).
+		AddRow(1, "TXN-1", "ORD-1", 1001, 100.0, status, "sim_TXN-1",
+			time.Now().Add(time.Hour), 60, "REVIEW", riskSignals, reviewStatus)
+}
+
+// 测试风控拒绝的支付保存为FAILED，不请求网关也不通知订单服务
+func TestChargeRiskRejected(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, brokenProvider{}, orders)
+	s.Risk = &risk.Engine{
+		Rules:       []risk.Rule{&risk.BINBlacklistRule{Prefixes: []string{"424242"}, Score: 100}},
+		ReviewScore: 50,
+		RejectScore: 80,
+	}
+
+	saved := savedTransactions(t, s.DB)
+
+	expectNoExistingPayment(mock)
+	mock.ExpectExec("INSERT INTO `credit_cards`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO `transactions`").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	_, err := s.Charge(context.Background(), chargeReq("4242424242424242"))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "%v", err)
+	assert.Empty(t, orders.calls)
+	require.Len(t, *saved, 1)
+	transaction := (*saved)[0]
+	assert.Equal(t, model.PaymentStatusFailed, transaction.Status)
+	assert.Equal(t, ErrorCodeRiskRejected, transaction.ErrorCode)
+	assert.Equal(t, 100, transaction.Risk.Score)
+	assert.Equal(t, string(risk.DecisionReject), transaction.Risk.Decision)
+	assert.Empty(t, transaction.Risk.ReviewStatus, "拒绝的交易不需要审核")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试需要审核的支付正常预授权，IP所在国家从gRPC metadata读取
+func TestAuthorizeRiskReview(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, authorizedSimulator(t), orders)
+	s.Risk = &risk.Engine{
+		Rules:       []risk.Rule{&risk.CountryMismatchRule{Score: 60}},
+		ReviewScore: 50,
+		RejectScore: 80,
+	}
+
+	saved := savedTransactions(t, s.DB)
+
+	expectNoExistingPayment(mock)
+	mock.ExpectExec("INSERT INTO `credit_cards`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO `transactions`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), model.PaymentStatusAuthorized, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOrderNotified(mock)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientinfo.CountryMetadataKey, "US"))
+	req := chargeReq("4242424242424242")
+	req.RiskContext = &payment.RiskContext{BillingCountry: "CN"}
+	resp, err := s.Authorize(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, string(model.PaymentStatusAuthorized), resp.Status)
+	assert.Len(t, orders.calls, 1)
+	require.Len(t, *saved, 1)
+	transaction := (*saved)[0]
+	assert.Equal(t, model.PaymentStatusPending, transaction.Status)
+	assert.Equal(t, 60, transaction.Risk.Score)
+	assert.Equal(t, string(risk.DecisionReview), transaction.Risk.Decision)
+	assert.Equal(t, model.RiskReviewPending, transaction.Risk.ReviewStatus)
+	assert.Contains(t, transaction.Risk.Signals, "country_mismatch")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试需要审核的Charge只预授权不扣款，钱包支付不支持预授权时拒绝
+func TestChargeRiskReview(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), orders)
+	s.Risk = &risk.Engine{
+		Rules:       []risk.Rule{&risk.CountryMismatchRule{Score: 60}},
+		ReviewScore: 50,
+		RejectScore: 80,
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientinfo.CountryMetadataKey, "US"))
+
+	expectCharge(mock, model.PaymentStatusAuthorized)
+	expectOrderNotified(mock)
+	req := chargeReq("4242424242424242")
+	req.RiskContext = &payment.RiskContext{BillingCountry: "CN"}
+	resp, err := s.Charge(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, string(model.PaymentStatusAuthorized), resp.Status, "审核通过前不能扣款")
+	assert.Len(t, orders.calls, 1)
+
+	saved := savedTransactions(t, s.DB)
+	expectNoExistingPayment(mock)
+	mock.ExpectExec("INSERT INTO `transactions`").WillReturnResult(sqlmock.NewResult(2, 1))
+	walletReq := walletChargeReq(payment.Wallet_ALIPAY)
+	walletReq.OrderId = "ORD-2"
+	walletReq.RiskContext = &payment.RiskContext{BillingCountry: "CN"}
+	_, err = s.Charge(ctx, walletReq)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "%v", err)
+	require.Len(t, *saved, 1)
+	assert.Equal(t, model.PaymentStatusFailed, (*saved)[0].Status)
+	assert.Equal(t, ErrorCodeRiskRejected, (*saved)[0].ErrorCode)
+	assert.Empty(t, (*saved)[0].Risk.ReviewStatus, "拒绝的交易不进入审核队列")
+	assert.Len(t, orders.calls, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试审核通过前不能扣款，审核通过后记录审核人
+func TestReviewRiskApprove(t *testing.T) {
+	s, mock := newChargeTestServer(t, authorizedSimulator(t), &orderProxy{})
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(riskRows(model.PaymentStatusAuthorized, model.RiskReviewPending))
+	_, err := s.Capture(context.Background(), &payment.CaptureReq{TransactionId: "TXN-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "%v", err)
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(riskRows(model.PaymentStatusAuthorized, model.RiskReviewPending))
+	// Updates的字段按名称排序：risk_review_note, risk_review_status, risk_reviewed_at, risk_reviewer, updated_at
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs("已电话核实", model.RiskReviewApproved, sqlmock.AnyArg(), "admin", sqlmock.AnyArg(), 1, model.RiskReviewPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err := s.ReviewRisk(context.Background(), &payment.ReviewRiskReq{
+		TransactionId: "TXN-1", ReviewStatus: "APPROVED", Reviewer: "admin", Note: "已电话核实",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "APPROVED", resp.Review.ReviewStatus)
+	assert.Equal(t, string(model.PaymentStatusAuthorized), resp.Review.PaymentStatus)
+	assert.NotZero(t, resp.Review.ReviewedAt)
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(riskRows(model.PaymentStatusAuthorized, model.RiskReviewApproved))
+	_, err = s.ReviewRisk(context.Background(), &payment.ReviewRiskReq{TransactionId: "TXN-1", ReviewStatus: "REJECTED"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "已审核的交易不能改变结果")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试审核拒绝时撤销预授权，重复提交直接返回
+func TestReviewRiskReject(t *testing.T) {
+	s, mock := newChargeTestServer(t, authorizedSimulator(t), &orderProxy{})
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(riskRows(model.PaymentStatusAuthorized, model.RiskReviewPending))
+	// Updates的字段按名称排序：error_code, error_message, gateway_response_raw, status, updated_at
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(ErrorCodeRiskRejected, "风控审核拒绝", sqlmock.AnyArg(), model.PaymentStatusVoided, sqlmock.AnyArg(), 1, model.PaymentStatusAuthorized).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs("", model.RiskReviewRejected, sqlmock.AnyArg(), "admin", sqlmock.AnyArg(), 1, model.RiskReviewPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err := s.ReviewRisk(context.Background(), &payment.ReviewRiskReq{
+		TransactionId: "TXN-1", ReviewStatus: "REJECTED", Reviewer: "admin",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "REJECTED", resp.Review.ReviewStatus)
+	assert.Equal(t, string(model.PaymentStatusVoided), resp.Review.PaymentStatus)
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(riskRows(model.PaymentStatusVoided, model.RiskReviewRejected))
+	_, err = s.ReviewRisk(context.Background(), &payment.ReviewRiskReq{TransactionId: "TXN-1", ReviewStatus: "REJECTED"})
+	assert.NoError(t, err, "重复提交相同的审核结果不能失败")
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").WillReturnRows(riskRows(model.PaymentStatusPending, model.RiskReviewPending))
+	_, err = s.ReviewRisk(context.Background(), &payment.ReviewRiskReq{TransactionId: "TXN-1", ReviewStatus: "REJECTED"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "支付结果未确认时不能拒绝")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试默认查询待审核的交易，命中的规则从JSON解析
+func TestListRiskReviews(t *testing.T) {
+	s, mock := newChargeTestServer(t, brokenProvider{}, &orderProxy{})
+
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM `transactions` WHERE risk_review_status = \\?").
+		WithArgs(model.RiskReviewPending).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT \\* FROM `transactions` WHERE risk_review_status = \\?.* ORDER BY created_at DESC, id DESC LIMIT \\?").
+		WithArgs(model.RiskReviewPending, 10).
+		WillReturnRows(riskRows(model.PaymentStatusAuthorized, model.RiskReviewPending))
+
+	resp, err := s.ListRiskReviews(context.Background(), &payment.ListRiskReviewsReq{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.Total)
+	require.Len(t, resp.Reviews, 1)
+	review := resp.Reviews[0]
+	assert.Equal(t, int32(60), review.Score)
+	require.Len(t, review.Signals, 1)
+	assert.Equal(t, "country_mismatch", review.Signals[0].Rule)
+
+	_, err = s.ListRiskReviews(context.Background(), &payment.ListRiskReviewsReq{ReviewStatus: "DONE"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
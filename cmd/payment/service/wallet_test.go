@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func walletChargeReq(wallet payment.Wallet) *payment.ChargeReq {
+	return &payment.ChargeReq{
+		OrderId:       "ORD-1",
+		UserId:        1001,
+		Amount:        99.9,
+		PaymentMethod: &payment.ChargeReq_Wallet{Wallet: &payment.WalletPayment{Wallet: wallet}},
+	}
+}
+
+// 测试钱包支付返回二维码，交易等待用户付款，不保存卡信息也不通知订单服务
+func TestChargeWallet(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{}), orders)
+
+	saved := savedTransactions(t, s.DB)
+	expectNoExistingPayment(mock)
+	mock.ExpectExec("INSERT INTO `transactions`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), model.PaymentStatusRequiresAction, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := s.Charge(context.Background(), walletChargeReq(payment.Wallet_WECHAT_PAY))
+	require.NoError(t, err)
+	assert.Equal(t, string(model.PaymentStatusRequiresAction), resp.Status)
+	assert.Contains(t, resp.QrCode, "wechat_pay")
+	assert.NotZero(t, resp.ExpiresAt)
+	assert.Empty(t, orders.calls)
+	require.Len(t, *saved, 1)
+	transaction := (*saved)[0]
+	assert.Equal(t, model.PaymentStatusPending, transaction.Status)
+	assert.Equal(t, PaymentMethodWeChatPay, transaction.PaymentMethod)
+	assert.Zero(t, transaction.CreditCardID, "钱包支付不保存卡信息")
+	assert.Empty(t, transaction.LastFourDigits)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试轮询时向网关查询等待付款的交易，用户已付款时更新交易并通知订单服务
+func TestGetPaymentSyncsWallet(t *testing.T) {
+	orders := &orderProxy{}
+	sim := provider.NewSimulator(provider.SimulatorConfig{})
+	s, mock := newChargeTestServer(t, sim, orders)
+
+	_, err := sim.Authorize(context.Background(), provider.AuthorizeRequest{
+		TransactionID: "TXN-1", Amount: 99.9, Wallet: provider.WalletAlipay, Capture: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, sim.CompleteAction("sim_TXN-1", true))
+
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "amount", "status", "payment_method", "provider", "provider_ref"}).
+			AddRow(1, "TXN-1", "ORD-1", 1001, 99.9, model.PaymentStatusRequiresAction, PaymentMethodAliPay, "simulator", "sim_TXN-1"))
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(sqlmock.AnyArg(), 99.9, sqlmock.AnyArg(), "", "", sqlmock.AnyArg(), true, "sim_TXN-1",
+			model.PaymentStatusCompleted, sqlmock.AnyArg(), 1, model.PaymentStatusRequiresAction).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOrderNotified(mock)
+
+	resp, err := s.GetPayment(context.Background(), &payment.GetPaymentReq{TransactionId: "TXN-1", UserId: 1001})
+	require.NoError(t, err)
+	assert.Equal(t, string(model.PaymentStatusCompleted), resp.Status)
+	assert.Equal(t, PaymentMethodAliPay, resp.PaymentMethod)
+	require.Len(t, orders.calls, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
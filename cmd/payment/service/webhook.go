@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HandleWebhook 处理支付网关的异步通知：校验签名、按事件ID去重，再更新交易状态。
+// 处理失败时返回错误，网关会重发通知
+func (s *PaymentServiceServer) HandleWebhook(ctx context.Context, req *payment.HandleWebhookReq) (*payment.HandleWebhookResp, error) {
+	if req.Provider != s.Provider.Name() {
+		return nil, status.Errorf(codes.NotFound, "不支持的支付网关: %s", req.Provider)
+	}
+	webhookProvider, ok := s.Provider.(provider.WebhookProvider)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "支付网关%s不支持异步通知", req.Provider)
+	}
+
+	event, err := webhookProvider.ParseWebhook(req.Payload, req.Headers, time.Now())
+	switch {
+	case errors.Is(err, provider.ErrInvalidSignature):
+		return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+	case err != nil:
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	record := model.WebhookEvent{
+		Provider:      req.Provider,
+		EventID:       event.ID,
+		EventType:     event.Type,
+		TransactionID: event.TransactionID,
+		Status:        model.WebhookStatusReceived,
+		Payload:       string(req.Payload),
+	}
+	duplicate, err := s.receiveWebhook(ctx, &record)
+	if err != nil {
+		return nil, err
+	}
+	resp := &payment.HandleWebhookResp{EventId: event.ID, Duplicate: duplicate}
+	if duplicate {
+		return resp, nil
+	}
+
+	if err := s.applyWebhook(ctx, event); err != nil {
+		log.Errorf("处理支付通知失败: provider=%s event=%s: %v", req.Provider, event.ID, err)
+		return nil, status.Errorf(codes.Internal, "处理支付通知失败: %v", err)
+	}
+	if err := s.DB.WithContext(ctx).Model(&record).Updates(map[string]interface{}{
+		"status":       model.WebhookStatusProcessed,
+		"processed_at": time.Now(),
+	}).Error; err != nil {
+		// 交易已更新，重发的通知会因交易状态不再变化而被忽略
+		log.Errorf("保存支付通知状态失败: event=%s: %v", event.ID, err)
+	}
+	return resp, nil
+}
+
+// receiveWebhook 保存通知，返回是否为已处理过的重复通知。上次处理失败的通知会再次处理
+func (s *PaymentServiceServer) receiveWebhook(ctx context.Context, record *model.WebhookEvent) (bool, error) {
+	db := s.DB.WithContext(ctx)
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return false, status.Errorf(codes.Internal, "保存支付通知失败: %v", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return false, nil
+	}
+
+	var existing model.WebhookEvent
+	if err := db.Where("provider = ? AND event_id = ?", record.Provider, record.EventID).
+		First(&existing).Error; err != nil {
+		return false, status.Errorf(codes.Internal, "查询支付通知失败: %v", err)
+	}
+	record.ID = existing.ID
+	return existing.Status == model.WebhookStatusProcessed, nil
+}
+
+// applyWebhook 用通知中的结果更新等待确认的交易，已有最终结果的交易不受重发或乱序的通知影响
+func (s *PaymentServiceServer) applyWebhook(ctx context.Context, event *provider.WebhookEvent) error {
+	var transaction model.Transaction
+	query := s.DB.WithContext(ctx)
+	if event.TransactionID != "" {
+		query = query.Where("transaction_id = ?", event.TransactionID)
+	} else {
+		query = query.Where("provider_ref = ?", event.Result.ProviderRef)
+	}
+	if err := query.First(&transaction).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 不是本系统的交易，重发也不会成功
+			log.Errorf("支付通知对应的交易不存在: event=%s transaction=%s", event.ID, event.TransactionID)
+			return nil
+		}
+		return err
+	}
+
+	// 交易已由同步请求或定时任务确认，通知只是迟到的重复结果。上次通知订单服务失败时重新通知
+	if transaction.Status != model.PaymentStatusPending && transaction.Status != model.PaymentStatusRequiresAction {
+		if transaction.OrderNotifyPending {
+			return s.markOrderPaid(ctx, &transaction)
+		}
+		return nil
+	}
+
+	previous := transaction.Status
+	applyGatewayResult(&transaction, &event.Result)
+	s.stampPaid(&transaction, time.Now())
+	return s.saveConfirmedResult(ctx, &transaction, previous)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testWebhookSecret = "whsec_test"
+
+func webhookReq(t *testing.T, eventID string, s provider.Status) *payment.HandleWebhookReq {
+	payload, signature, err := provider.SignSimulatorWebhook([]byte(testWebhookSecret), provider.SimulatorWebhook{
+		ID:   eventID,
+		Type: "payment.updated",
+		Data: provider.SimulatorWebhookData{TransactionID: "TXN-1", Status: s, Amount: 99.9},
+	}, time.Now())
+	require.NoError(t, err)
+	return &payment.HandleWebhookReq{
+		Provider: "simulator",
+		Payload:  payload,
+		Headers:  map[string]string{"simulator-signature": signature},
+	}
+}
+
+func webhookEventRows(s model.WebhookStatus) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "provider", "event_id", "status"}).AddRow(7, "simulator", "evt_1", s)
+}
+
+// 测试异步支付成功的通知：更新交易、通知订单服务、记录已处理
+func TestHandleWebhookConfirmsPayment(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{WebhookSecret: testWebhookSecret}), orders)
+
+	mock.ExpectExec("INSERT INTO `webhook_events`").WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "amount", "status"}).
+			AddRow(1, "TXN-1", "ORD-1", 1001, 99.9, model.PaymentStatusPending))
+	mock.ExpectExec("UPDATE `transactions` SET").
+		WithArgs(sqlmock.AnyArg(), 99.9, sqlmock.AnyArg(), "", "", sqlmock.AnyArg(), true, "sim_TXN-1",
+			model.PaymentStatusCompleted, sqlmock.AnyArg(), 1, model.PaymentStatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOrderNotified(mock)
+	mock.ExpectExec("UPDATE `webhook_events` SET").
+		WithArgs(sqlmock.AnyArg(), model.WebhookStatusProcessed, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := s.HandleWebhook(context.Background(), webhookReq(t, "evt_1", provider.StatusCaptured))
+	require.NoError(t, err)
+	assert.False(t, resp.Duplicate)
+	require.Len(t, orders.calls, 1)
+	assert.Equal(t, "TXN-1", orders.calls[0].TransactionId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试重复的通知和已有结果的交易不会重复处理
+func TestHandleWebhookIdempotent(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{WebhookSecret: testWebhookSecret}), orders)
+
+	// 已处理过的事件
+	mock.ExpectExec("INSERT INTO `webhook_events`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `webhook_events`").WillReturnRows(webhookEventRows(model.WebhookStatusProcessed))
+	resp, err := s.HandleWebhook(context.Background(), webhookReq(t, "evt_1", provider.StatusCaptured))
+	require.NoError(t, err)
+	assert.True(t, resp.Duplicate)
+
+	// 新事件，但交易已经由定时任务确认
+	mock.ExpectExec("INSERT INTO `webhook_events`").WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "status"}).AddRow(1, "TXN-1", model.PaymentStatusCompleted))
+	mock.ExpectExec("UPDATE `webhook_events` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err = s.HandleWebhook(context.Background(), webhookReq(t, "evt_2", provider.StatusDeclined))
+	require.NoError(t, err)
+	assert.False(t, resp.Duplicate)
+
+	assert.Empty(t, orders.calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试上次通知订单服务失败的交易，重发的通知重新通知订单服务
+func TestHandleWebhookRetriesOrderNotification(t *testing.T) {
+	orders := &orderProxy{}
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{WebhookSecret: testWebhookSecret}), orders)
+
+	mock.ExpectExec("INSERT INTO `webhook_events`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `webhook_events`").WillReturnRows(webhookEventRows(model.WebhookStatusReceived))
+	mock.ExpectQuery("SELECT \\* FROM `transactions`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "order_id", "user_id", "status", "order_notify_pending"}).
+			AddRow(1, "TXN-1", "ORD-1", 1001, model.PaymentStatusCompleted, true))
+	expectOrderNotified(mock)
+	mock.ExpectExec("UPDATE `webhook_events` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := s.HandleWebhook(context.Background(), webhookReq(t, "evt_1", provider.StatusCaptured))
+	require.NoError(t, err)
+	require.Len(t, orders.calls, 1)
+	assert.Equal(t, "ORD-1", orders.calls[0].OrderId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试签名错误、未知网关时不访问数据库
+func TestHandleWebhookRejected(t *testing.T) {
+	s, mock := newChargeTestServer(t, provider.NewSimulator(provider.SimulatorConfig{WebhookSecret: testWebhookSecret}), &orderProxy{})
+
+	req := webhookReq(t, "evt_1", provider.StatusCaptured)
+	req.Headers["simulator-signature"] = provider.SignPayload([]byte("other"), req.Payload, time.Now())
+	_, err := s.HandleWebhook(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	req = webhookReq(t, "evt_1", provider.StatusCaptured)
+	req.Provider = "alipay"
+	_, err = s.HandleWebhook(context.Background(), req)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
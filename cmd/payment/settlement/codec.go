@@ -0,0 +1,160 @@
+// Package settlement 支付网关结算文件的格式，逐行读写，不会一次性加载整个文件。
+//
+// 结算文件为UTF-8编码的CSV，第一行为表头，列顺序不限：
+//
+//	transaction_id   本系统的交易ID，按该列与交易记录匹配，必填
+//	provider_ref     网关交易号
+//	status           网关的交易状态：CAPTURED、AUTHORIZED、VOIDED、REFUNDED、PARTIALLY_REFUNDED、DECLINED、FAILED、PENDING、REQUIRES_ACTION
+//	amount           交易（授权）金额，两位小数
+//	captured_amount  已扣款金额
+//	refunded_amount  已退款金额
+//	currency         货币，如 CNY
+//	created_at       网关创建交易的时间，RFC3339格式
+package settlement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+)
+
+// CSVHeader 结算文件的列
+var CSVHeader = []string{"transaction_id", "provider_ref", "status", "amount", "captured_amount", "refunded_amount", "currency", "created_at"}
+
+// RowError 某一行无法解析，读取可以继续
+type RowError struct {
+	Line int64
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("第%d行: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error { return e.Err }
+
+// Reader 逐行读取结算记录，读完时返回io.EOF
+type Reader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取CSV表头失败: %w", err)
+	}
+
+	known := make(map[string]bool, len(CSVHeader))
+	for _, name := range CSVHeader {
+		known[name] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !known[name] {
+			return nil, fmt.Errorf("未知的CSV列: %s", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"transaction_id", "status", "amount"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV表头缺少%s列", name)
+		}
+	}
+	return &Reader{r: cr, columns: columns}, nil
+}
+
+func (c *Reader) Next() (*payment.SettlementRecord, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &RowError{Line: int64(parseErr.StartLine), Err: err}
+		}
+		return nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+
+	get := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row := &payment.SettlementRecord{
+		Line:          int64(line),
+		TransactionId: get("transaction_id"),
+		ProviderRef:   get("provider_ref"),
+		Status:        strings.ToUpper(get("status")),
+		Currency:      get("currency"),
+	}
+	amounts := []struct {
+		column string
+		dst    *float64
+	}{
+		{"amount", &row.Amount},
+		{"captured_amount", &row.CapturedAmount},
+		{"refunded_amount", &row.RefundedAmount},
+	}
+	for _, amount := range amounts {
+		v := get(amount.column)
+		if v == "" {
+			continue
+		}
+		if *amount.dst, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, &RowError{Line: row.Line, Err: fmt.Errorf("%s格式错误: %s", amount.column, v)}
+		}
+	}
+	if v := get("created_at"); v != "" {
+		createdAt, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, &RowError{Line: row.Line, Err: fmt.Errorf("created_at格式错误: %s", v)}
+		}
+		row.CreatedAt = createdAt.Unix()
+	}
+	return row, nil
+}
+
+// Writer 逐行写出结算记录，结束时需要调用Flush
+type Writer struct {
+	w *csv.Writer
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return nil, err
+	}
+	return &Writer{w: cw}, nil
+}
+
+func (c *Writer) Write(row *payment.SettlementRecord) error {
+	return c.w.Write([]string{
+		row.TransactionId,
+		row.ProviderRef,
+		row.Status,
+		strconv.FormatFloat(row.Amount, 'f', 2, 64),
+		strconv.FormatFloat(row.CapturedAmount, 'f', 2, 64),
+		strconv.FormatFloat(row.RefundedAmount, 'f', 2, 64),
+		row.Currency,
+		time.Unix(row.CreatedAt, 0).Format(time.RFC3339),
+	})
+}
+
+func (c *Writer) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
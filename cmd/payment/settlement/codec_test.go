@@ -0,0 +1,62 @@
+package settlement
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"TKMall/build/proto_gen/payment"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试写出后能完整读回
+func TestRoundTrip(t *testing.T) {
+	rows := []*payment.SettlementRecord{
+		{TransactionId: "TXN-1", ProviderRef: "sim_TXN-1", Status: "CAPTURED", Amount: 99.9, CapturedAmount: 99.9, Currency: "CNY", CreatedAt: 1700000000},
+		{TransactionId: "TXN-2", ProviderRef: "sim_TXN-2", Status: "PARTIALLY_REFUNDED", Amount: 100, CapturedAmount: 80, RefundedAmount: 30.5, Currency: "CNY", CreatedAt: 1700000100},
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, w.Write(row))
+	}
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	for i, want := range rows {
+		got, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, int64(i+2), got.Line, "CSV有表头，首行数据在第2行")
+		got.Line = 0
+		assert.Equal(t, want.String(), got.String())
+	}
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+// 测试格式错误的行单独报告，读取继续
+func TestReaderErrors(t *testing.T) {
+	_, err := NewReader(strings.NewReader("transaction_id,status\n"))
+	assert.Error(t, err, "缺少amount列")
+	_, err = NewReader(strings.NewReader("transaction_id,status,amount,fee\n"))
+	assert.Error(t, err, "未知的列")
+
+	r, err := NewReader(strings.NewReader("status,amount,transaction_id\ncaptured,abc,TXN-1\ncaptured,1.5,TXN-2\n"))
+	require.NoError(t, err)
+	_, err = r.Next()
+	var rowErr *RowError
+	require.True(t, errors.As(err, &rowErr))
+	assert.Equal(t, int64(2), rowErr.Line)
+
+	row, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "TXN-2", row.TransactionId)
+	assert.Equal(t, "CAPTURED", row.Status)
+	assert.Equal(t, 1.5, row.Amount)
+}
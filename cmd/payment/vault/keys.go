@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// KeyManager 加密卡号使用的密钥管理。本地实现从配置读取密钥，
+// 生产环境可以替换为KMS，只需实现该接口
+type KeyManager interface {
+	// KeyID 当前用于加密的密钥ID，密钥轮换后旧的密钥仍用于解密
+	KeyID() string
+	// Encrypt 使用keyID对应的密钥加密，aad为附加认证数据，解密时必须相同
+	Encrypt(keyID string, plaintext, aad []byte) ([]byte, error)
+	Decrypt(keyID string, ciphertext, aad []byte) ([]byte, error)
+	// Fingerprint 计算数据的指纹，相同的卡号得到相同的指纹，不随密钥轮换变化
+	Fingerprint(data []byte) string
+}
+
+// ErrUnknownKey 密钥ID不存在，通常是轮换时删除了仍在使用的旧密钥
+var ErrUnknownKey = errors.New("加密密钥不存在")
+
+// LocalKeyManager 使用本地AES-256-GCM密钥
+type LocalKeyManager struct {
+	activeKeyID    string
+	keys           map[string]cipher.AEAD
+	fingerprintKey []byte
+}
+
+// NewLocalKeyManager keys为密钥ID到32字节密钥的映射，activeKeyID为当前加密使用的密钥
+func NewLocalKeyManager(keys map[string][]byte, activeKeyID string, fingerprintKey []byte) (*LocalKeyManager, error) {
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, activeKeyID)
+	}
+	if len(fingerprintKey) < 32 {
+		return nil, errors.New("指纹密钥至少需要32字节")
+	}
+	m := &LocalKeyManager{
+		activeKeyID:    activeKeyID,
+		keys:           make(map[string]cipher.AEAD, len(keys)),
+		fingerprintKey: fingerprintKey,
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("密钥%s必须为32字节", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		m.keys[id] = aead
+	}
+	return m, nil
+}
+
+// NewKeyManagerFromConfig 从配置读取本地密钥，环境变量VAULT_KEY、VAULT_FINGERPRINT_KEY
+// 覆盖当前密钥和指纹密钥，生产环境不应把密钥写在配置文件中
+func NewKeyManagerFromConfig() (*LocalKeyManager, error) {
+	activeKeyID := viper.GetString("vault.active_key_id")
+	encoded := viper.GetStringMapString("vault.keys")
+	if key := os.Getenv("VAULT_KEY"); key != "" {
+		encoded[activeKeyID] = key
+	}
+	keys := make(map[string][]byte, len(encoded))
+	for id, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("解析密钥%s失败: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	fingerprintKey := viper.GetString("vault.fingerprint_key")
+	if key := os.Getenv("VAULT_FINGERPRINT_KEY"); key != "" {
+		fingerprintKey = key
+	}
+	fpKey, err := base64.StdEncoding.DecodeString(fingerprintKey)
+	if err != nil {
+		return nil, fmt.Errorf("解析指纹密钥失败: %w", err)
+	}
+	return NewLocalKeyManager(keys, activeKeyID, fpKey)
+}
+
+func (m *LocalKeyManager) KeyID() string {
+	return m.activeKeyID
+}
+
+// Encrypt 输出为随机nonce加密文
+func (m *LocalKeyManager) Encrypt(keyID string, plaintext, aad []byte) ([]byte, error) {
+	aead, ok := m.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func (m *LocalKeyManager) Decrypt(keyID string, ciphertext, aad []byte) ([]byte, error) {
+	aead, ok := m.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("密文长度不正确")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, aad)
+}
+
+// Fingerprint 使用HMAC-SHA256，没有指纹密钥无法通过穷举卡号反推
+func (m *LocalKeyManager) Fingerprint(data []byte) string {
+	mac := hmac.New(sha256.New, m.fingerprintKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
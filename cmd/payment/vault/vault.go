@@ -0,0 +1,201 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"TKMall/cmd/payment/model"
+	"TKMall/cmd/payment/provider"
+
+	"gorm.io/gorm"
+)
+
+// DefaultTokenTTL 一次性令牌的默认有效期，足够完成一次结账
+const DefaultTokenTTL = 15 * time.Minute
+
+var (
+	// ErrTokenNotFound 令牌不存在或不属于该用户
+	ErrTokenNotFound = errors.New("支付令牌不存在")
+	// ErrTokenUsed 一次性令牌已经用于支付
+	ErrTokenUsed = errors.New("支付令牌已使用")
+	// ErrTokenExpired 一次性令牌已过期
+	ErrTokenExpired = errors.New("支付令牌已过期")
+)
+
+// Vault 保存加密的卡号，只有支付服务在请求网关时解密
+type Vault struct {
+	DB       *gorm.DB
+	Keys     KeyManager
+	TokenTTL time.Duration
+}
+
+func New(db *gorm.DB, keys KeyManager, tokenTTL time.Duration) *Vault {
+	if tokenTTL <= 0 {
+		tokenTTL = DefaultTokenTTL
+	}
+	return &Vault{DB: db, Keys: keys, TokenTTL: tokenTTL}
+}
+
+// Fingerprint 卡号的指纹
+func (v *Vault) Fingerprint(number string) string {
+	return v.Keys.Fingerprint([]byte(number))
+}
+
+// Tokenize 加密保存卡号，返回令牌。reusable为true时同一用户重复保存的卡返回已有的令牌。
+// CVV只保存在短期有效的一次性令牌中，保存的卡不保存CVV
+func (v *Vault) Tokenize(ctx context.Context, userID int64, card provider.Card, reusable bool) (*model.CardToken, error) {
+	if reusable {
+		card.CVV = 0
+	}
+	token := &model.CardToken{
+		UserID:      userID,
+		Fingerprint: v.Fingerprint(card.Number),
+		Reusable:    reusable,
+	}
+	db := v.DB.WithContext(ctx)
+	if reusable {
+		err := db.Where("user_id = ? AND fingerprint = ? AND reusable = ?", userID, token.Fingerprint, true).
+			First(token).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	if token.Token == "" {
+		value, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		token.Token = value
+	}
+	if !reusable {
+		expiresAt := time.Now().Add(v.TokenTTL)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := v.seal(token, card); err != nil {
+		return nil, err
+	}
+
+	if token.ID != 0 {
+		// 重新保存的卡更新密文
+		err := db.Model(token).Updates(map[string]interface{}{
+			"key_id":        token.KeyID,
+			"encrypted_pan": token.EncryptedPAN,
+			"encrypted_cvv": token.EncryptedCVV,
+			"used_at":       nil,
+		}).Error
+		return token, err
+	}
+	return token, db.Create(token).Error
+}
+
+// Redeem 解密令牌对应的卡号用于支付。一次性令牌只能成功兑换一次，CVV在兑换后清除；
+// 保存的卡不返回CVV
+func (v *Vault) Redeem(ctx context.Context, userID int64, value string) (provider.Card, error) {
+	var token model.CardToken
+	db := v.DB.WithContext(ctx)
+	if err := db.Where("token = ? AND user_id = ?", value, userID).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return provider.Card{}, ErrTokenNotFound
+		}
+		return provider.Card{}, err
+	}
+	if !token.Reusable {
+		if token.UsedAt != nil {
+			return provider.Card{}, ErrTokenUsed
+		}
+		if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+			return provider.Card{}, ErrTokenExpired
+		}
+	}
+
+	card, err := v.open(&token)
+	if err != nil {
+		return provider.Card{}, err
+	}
+	if token.Reusable {
+		card.CVV = 0
+	}
+	if token.UsedAt != nil {
+		return card, nil
+	}
+	// 只有一个请求能兑换一次性令牌
+	result := db.Model(&model.CardToken{}).Where("id = ? AND used_at IS NULL", token.ID).
+		Updates(map[string]interface{}{
+			"used_at":       time.Now(),
+			"encrypted_cvv": nil,
+		})
+	if result.Error != nil {
+		return provider.Card{}, result.Error
+	}
+	if result.RowsAffected == 0 && !token.Reusable {
+		return provider.Card{}, ErrTokenUsed
+	}
+	return card, nil
+}
+
+// Delete 删除令牌和密文
+func (v *Vault) Delete(ctx context.Context, userID int64, value string) error {
+	result := v.DB.WithContext(ctx).Unscoped().
+		Where("token = ? AND user_id = ?", value, userID).Delete(&model.CardToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// seal 加密卡号和CVV，以令牌作为附加认证数据，密文不能挪到其他令牌上解密
+func (v *Vault) seal(token *model.CardToken, card provider.Card) error {
+	token.KeyID = v.Keys.KeyID()
+	aad := []byte(token.Token)
+	pan, err := v.Keys.Encrypt(token.KeyID, []byte(card.Number), aad)
+	if err != nil {
+		return err
+	}
+	token.EncryptedPAN = pan
+	token.EncryptedCVV = nil
+	if card.CVV != 0 {
+		cvv, err := v.Keys.Encrypt(token.KeyID, []byte(strconv.Itoa(int(card.CVV))), aad)
+		if err != nil {
+			return err
+		}
+		token.EncryptedCVV = cvv
+	}
+	return nil
+}
+
+func (v *Vault) open(token *model.CardToken) (provider.Card, error) {
+	aad := []byte(token.Token)
+	pan, err := v.Keys.Decrypt(token.KeyID, token.EncryptedPAN, aad)
+	if err != nil {
+		return provider.Card{}, err
+	}
+	card := provider.Card{Number: string(pan)}
+	if len(token.EncryptedCVV) > 0 {
+		cvv, err := v.Keys.Decrypt(token.KeyID, token.EncryptedCVV, aad)
+		if err != nil {
+			return provider.Card{}, err
+		}
+		value, err := strconv.Atoi(string(cvv))
+		if err != nil {
+			return provider.Card{}, err
+		}
+		card.CVV = int32(value)
+	}
+	return card, nil
+}
+
+// newToken 生成随机令牌，不包含卡号的任何信息
+func newToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "tok_" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"TKMall/cmd/payment/provider"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testKeys(t *testing.T, active string) *LocalKeyManager {
+	m, err := NewLocalKeyManager(map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}, active, bytes.Repeat([]byte{9}, 32))
+	require.NoError(t, err)
+	return m
+}
+
+// 测试加解密、密钥轮换和附加认证数据
+func TestLocalKeyManager(t *testing.T) {
+	old := testKeys(t, "k1")
+	sealed, err := old.Encrypt(old.KeyID(), []byte("4242424242424242"), []byte("tok_1"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "4242424242424242")
+
+	rotated := testKeys(t, "k2")
+	plain, err := rotated.Decrypt("k1", sealed, []byte("tok_1"))
+	require.NoError(t, err, "轮换后旧密钥仍能解密")
+	assert.Equal(t, "4242424242424242", string(plain))
+
+	_, err = rotated.Decrypt("k1", sealed, []byte("tok_2"))
+	assert.Error(t, err, "密文不能挪到其他令牌上解密")
+	_, err = rotated.Decrypt("k3", sealed, []byte("tok_1"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	assert.Equal(t, old.Fingerprint([]byte("4242424242424242")), rotated.Fingerprint([]byte("4242424242424242")),
+		"指纹不随密钥轮换变化")
+	assert.NotEqual(t, old.Fingerprint([]byte("4242424242424242")), old.Fingerprint([]byte("4000000000000002")))
+
+	_, err = NewLocalKeyManager(map[string][]byte{"k1": []byte("short")}, "k1", bytes.Repeat([]byte{9}, 32))
+	assert.Error(t, err)
+}
+
+func newTestVault(t *testing.T) (*Vault, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	return New(db, testKeys(t, "k1"), time.Minute), mock
+}
+
+// tokenRows 一次性令牌tok_1的密文
+func tokenRows(t *testing.T, v *Vault, usedAt, expiresAt interface{}) *sqlmock.Rows {
+	pan, err := v.Keys.Encrypt("k1", []byte("4242424242424242"), []byte("tok_1"))
+	require.NoError(t, err)
+	cvv, err := v.Keys.Encrypt("k1", []byte("123"), []byte("tok_1"))
+	require.NoError(t, err)
+	return sqlmock.NewRows([]string{"id", "token", "user_id", "key_id", "encrypted_pan", "encrypted_cvv", "reusable", "used_at", "expires_at"}).
+		AddRow(1, "tok_1", 1001, "k1", pan, cvv, false, usedAt, expiresAt)
+}
+
+// 测试一次性令牌只能兑换一次
+func TestRedeemSingleUse(t *testing.T) {
+	v, mock := newTestVault(t)
+	mock.ExpectQuery("SELECT \\* FROM `card_tokens`").WillReturnRows(tokenRows(t, v, nil, time.Now().Add(time.Minute)))
+	mock.ExpectExec("UPDATE `card_tokens` SET .* WHERE \\(id = \\? AND used_at IS NULL\\)").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	card, err := v.Redeem(context.Background(), 1001, "tok_1")
+	require.NoError(t, err)
+	assert.Equal(t, provider.Card{Number: "4242424242424242", CVV: 123}, card)
+
+	mock.ExpectQuery("SELECT \\* FROM `card_tokens`").WillReturnRows(tokenRows(t, v, time.Now(), time.Now().Add(time.Minute)))
+	_, err = v.Redeem(context.Background(), 1001, "tok_1")
+	assert.ErrorIs(t, err, ErrTokenUsed)
+
+	mock.ExpectQuery("SELECT \\* FROM `card_tokens`").WillReturnRows(tokenRows(t, v, nil, time.Now().Add(-time.Second)))
+	_, err = v.Redeem(context.Background(), 1001, "tok_1")
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	// 并发兑换时只有一个请求成功
+	mock.ExpectQuery("SELECT \\* FROM `card_tokens`").WillReturnRows(tokenRows(t, v, nil, time.Now().Add(time.Minute)))
+	mock.ExpectExec("UPDATE `card_tokens` SET").WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err = v.Redeem(context.Background(), 1001, "tok_1")
+	assert.ErrorIs(t, err, ErrTokenUsed)
+
+	mock.ExpectQuery("SELECT \\* FROM `card_tokens`").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = v.Redeem(context.Background(), 1002, "tok_1")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试保存的卡不保存CVV，一次性令牌保存CVV并设置有效期
+func TestTokenizeCVV(t *testing.T) {
+	v, mock := newTestVault(t)
+	card := provider.Card{Number: "4242424242424242", CVV: 123}
+
+	mock.ExpectQuery("SELECT \\* FROM `card_tokens`").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec("INSERT INTO `card_tokens`").WillReturnResult(sqlmock.NewResult(1, 1))
+	saved, err := v.Tokenize(context.Background(), 1001, card, true)
+	require.NoError(t, err)
+	assert.Empty(t, saved.EncryptedCVV)
+	assert.Nil(t, saved.ExpiresAt)
+
+	mock.ExpectExec("INSERT INTO `card_tokens`").WillReturnResult(sqlmock.NewResult(2, 1))
+	oneTime, err := v.Tokenize(context.Background(), 1001, card, false)
+	require.NoError(t, err)
+	assert.NotEmpty(t, oneTime.EncryptedCVV)
+	assert.NotNil(t, oneTime.ExpiresAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
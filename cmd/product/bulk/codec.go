@@ -0,0 +1,263 @@
+// Package bulk 商品批量导入导出的文件格式（CSV、JSON Lines），逐行读写，不会一次性加载整个文件。
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"TKMall/build/proto_gen/product"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// CSVHeader CSV文件的列，导入时按表头名称匹配，列顺序不限
+var CSVHeader = []string{"product_code", "sku", "name", "description", "category", "price", "stock", "specs", "published", "purchase_limit"}
+
+// 单行JSON的长度上限
+const maxJSONLineSize = 1 << 20
+
+// FormatFromPath 根据文件扩展名推断格式
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, nil
+	}
+	return "", fmt.Errorf("无法根据文件名识别格式: %s", path)
+}
+
+// RowError 某一行无法解析，读取可以继续
+type RowError struct {
+	Line int64
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("第%d行: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error { return e.Err }
+
+// Reader 逐行读取商品数据，读完时返回io.EOF
+type Reader interface {
+	Next() (*product.ProductRow, error)
+}
+
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxJSONLineSize)
+		return &jsonlReader{scanner: scanner}, nil
+	}
+	return nil, fmt.Errorf("不支持的格式: %s", format)
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取CSV表头失败: %w", err)
+	}
+
+	known := make(map[string]bool, len(CSVHeader))
+	for _, name := range CSVHeader {
+		known[name] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !known[name] {
+			return nil, fmt.Errorf("未知的CSV列: %s", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["sku"]; !ok {
+		if _, ok := columns["product_code"]; !ok {
+			return nil, errors.New("CSV表头至少需要包含sku或product_code列")
+		}
+	}
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (c *csvReader) Next() (*product.ProductRow, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &RowError{Line: int64(parseErr.StartLine), Err: err}
+		}
+		return nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+
+	get := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row := &product.ProductRow{
+		Line:        int64(line),
+		ProductCode: get("product_code"),
+		Sku:         get("sku"),
+		Name:        get("name"),
+		Description: get("description"),
+		Category:    get("category"),
+		Specs:       get("specs"),
+	}
+	if v := get("price"); v != "" {
+		if row.Price, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, &RowError{Line: row.Line, Err: fmt.Errorf("价格格式错误: %s", v)}
+		}
+	}
+	if v := get("stock"); v != "" {
+		stock, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, &RowError{Line: row.Line, Err: fmt.Errorf("库存格式错误: %s", v)}
+		}
+		row.Stock = int32(stock)
+	}
+	if v := get("published"); v != "" {
+		if row.Published, err = strconv.ParseBool(v); err != nil {
+			return nil, &RowError{Line: row.Line, Err: fmt.Errorf("published格式错误: %s", v)}
+		}
+	}
+	if v := get("purchase_limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, &RowError{Line: row.Line, Err: fmt.Errorf("限购数量格式错误: %s", v)}
+		}
+		row.PurchaseLimit = int32(limit)
+	}
+	return row, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int64
+}
+
+func (j *jsonlReader) Next() (*product.ProductRow, error) {
+	for j.scanner.Scan() {
+		j.line++
+		text := strings.TrimSpace(j.scanner.Text())
+		if text == "" {
+			continue
+		}
+		var row product.ProductRow
+		if err := protojson.Unmarshal([]byte(text), &row); err != nil {
+			return nil, &RowError{Line: j.line, Err: fmt.Errorf("JSON格式错误: %v", err)}
+		}
+		row.Line = j.line
+		return &row, nil
+	}
+	if err := j.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Writer 逐行写出商品数据，结束时需要调用Flush
+type Writer interface {
+	Write(row *product.ProductRow) error
+	Flush() error
+}
+
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(CSVHeader); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case FormatJSONL:
+		return &jsonlWriter{w: bufio.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("不支持的格式: %s", format)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(row *product.ProductRow) error {
+	return c.w.Write([]string{
+		row.ProductCode,
+		row.Sku,
+		row.Name,
+		row.Description,
+		row.Category,
+		strconv.FormatFloat(row.Price, 'f', 2, 64),
+		strconv.FormatInt(int64(row.Stock), 10),
+		row.Specs,
+		strconv.FormatBool(row.Published),
+		strconv.FormatInt(int64(row.PurchaseLimit), 10),
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	w *bufio.Writer
+}
+
+var jsonlMarshal = protojson.MarshalOptions{UseProtoNames: true}
+
+func (j *jsonlWriter) Write(row *product.ProductRow) error {
+	// 行号只在导入时有意义
+	out := &product.ProductRow{
+		ProductCode:   row.ProductCode,
+		Sku:           row.Sku,
+		Name:          row.Name,
+		Description:   row.Description,
+		Category:      row.Category,
+		Price:         row.Price,
+		Stock:         row.Stock,
+		Specs:         row.Specs,
+		Published:     row.Published,
+		PurchaseLimit: row.PurchaseLimit,
+	}
+	data, err := jsonlMarshal.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(data); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
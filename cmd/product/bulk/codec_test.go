@@ -0,0 +1,94 @@
+package bulk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"TKMall/build/proto_gen/product"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, r Reader) ([]*product.ProductRow, []*RowError) {
+	var rows []*product.ProductRow
+	var rowErrs []*RowError
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return rows, rowErrs
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			rowErrs = append(rowErrs, rowErr)
+			continue
+		}
+		require.NoError(t, err)
+		rows = append(rows, row)
+	}
+}
+
+// 测试两种格式写出后能完整读回
+func TestRoundTrip(t *testing.T) {
+	rows := []*product.ProductRow{
+		{ProductCode: "IP15", Sku: "IP15-256-BLK", Name: "iPhone 15", Description: "含逗号, 和\"引号\"", Category: "手机", Price: 5999, Stock: 10, Specs: `{"color":"黑色"}`, Published: true, PurchaseLimit: 2},
+		{ProductCode: "MBP16", Name: "MacBook Pro", Category: "电脑/笔记本", Price: 18999.5, Specs: "{}"},
+	}
+
+	// CSV有表头，首行数据在第2行
+	firstLine := map[Format]int64{FormatCSV: 2, FormatJSONL: 1}
+	for _, format := range []Format{FormatCSV, FormatJSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			require.NoError(t, err)
+			for _, row := range rows {
+				require.NoError(t, w.Write(row))
+			}
+			require.NoError(t, w.Flush())
+
+			r, err := NewReader(&buf, format)
+			require.NoError(t, err)
+			got, rowErrs := readAll(t, r)
+			assert.Empty(t, rowErrs)
+			require.Len(t, got, len(rows))
+			for i := range rows {
+				assert.Equal(t, rows[i].Sku, got[i].Sku)
+				assert.Equal(t, rows[i].Description, got[i].Description)
+				assert.Equal(t, rows[i].Category, got[i].Category)
+				assert.Equal(t, rows[i].Price, got[i].Price)
+				assert.Equal(t, rows[i].Specs, got[i].Specs)
+				assert.Equal(t, rows[i].Published, got[i].Published)
+				assert.Equal(t, rows[i].PurchaseLimit, got[i].PurchaseLimit)
+			}
+			assert.Equal(t, firstLine[format], got[0].Line, "首行数据的行号")
+		})
+	}
+}
+
+// 测试单行格式错误不影响后续行
+func TestReaderRowErrors(t *testing.T) {
+	csvData := "sku,name,price\nA1,商品A,abc\nA2,商品B,10\n"
+	r, err := NewReader(strings.NewReader(csvData), FormatCSV)
+	require.NoError(t, err)
+	rows, rowErrs := readAll(t, r)
+	require.Len(t, rowErrs, 1)
+	assert.Equal(t, int64(2), rowErrs[0].Line)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "A2", rows[0].Sku)
+
+	jsonlData := "{\"sku\":\"B1\",\"name\":\"商品\",\"price\":1}\nnot json\n\n{\"sku\":\"B2\",\"name\":\"商品\",\"price\":2}\n"
+	r, err = NewReader(strings.NewReader(jsonlData), FormatJSONL)
+	require.NoError(t, err)
+	rows, rowErrs = readAll(t, r)
+	require.Len(t, rowErrs, 1)
+	assert.Equal(t, int64(2), rowErrs[0].Line)
+	require.Len(t, rows, 2)
+	assert.Equal(t, int64(4), rows[1].Line)
+
+	_, err = NewReader(strings.NewReader("sku,unknown\n"), FormatCSV)
+	assert.Error(t, err, "未知列应报错")
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/media"
 	"TKMall/cmd/product/model"
 	"TKMall/cmd/product/service"
 	"TKMall/common/config"
 	"TKMall/common/etcd"
+	"TKMall/common/events"
 	"TKMall/common/log"
 	commonModel "TKMall/common/model"
 	"TKMall/common/proxy"
@@ -81,24 +87,91 @@ func main() {
 	}
 
 	// 初始化服务代理
+	// 从环境变量获取order服务地址，如果不存在则使用配置文件
+	orderServiceAddr := viper.GetString("order_service.address")
+	if addr := os.Getenv("ORDER_SERVICE_ADDR"); addr != "" {
+		log.Infof("使用环境变量地址 ORDER_SERVICE_ADDR: %s", addr)
+		orderServiceAddr = addr
+	}
 	serviceEndpoints := map[string]string{
-		// 当商品服务需要调用其他服务时，在这里添加
+		"order": orderServiceAddr, // 校验评价资格
 	}
 	serviceProxy := proxy.NewGrpcProxy(serviceEndpoints, viper.GetString("redis.addr"))
 
+	// 初始化图片存储
+	mediaStorage, err := media.NewStorageFromConfig()
+	if err != nil {
+		log.Fatalf("初始化图片存储失败: %v", err)
+	}
+
+	// 初始化事件总线，失败时不发布价格变更事件
+	kafkaBrokers := []string{"localhost:9092"} // 默认值
+	if brokers := viper.GetStringSlice("kafka.brokers"); len(brokers) > 0 {
+		kafkaBrokers = brokers
+	}
+	if os.Getenv("KAFKA_BROKERS") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_BROKERS"), ",")
+	} else if os.Getenv("KAFKA_ADDR") != "" {
+		kafkaBrokers = strings.Split(os.Getenv("KAFKA_ADDR"), ",")
+	}
+	log.Infof("使用Kafka地址: %v", kafkaBrokers)
+	var eventBus events.EventBus
+	if bus, err := events.NewKafkaEventBus(kafkaBrokers); err != nil {
+		log.Errorf("初始化事件总线失败: %v", err)
+	} else {
+		eventBus = bus
+	}
+
 	// 启动gRPC服务
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Fatalf("监听端口失败: %v", err)
 	}
 
-	s := grpc.NewServer()
-	product.RegisterProductCatalogServiceServer(s, &service.ProductCatalogServiceServer{
-		DB:    db,
-		Redis: redisClient,
-		Node:  node,
-		Proxy: serviceProxy,
-	})
+	productService := &service.ProductCatalogServiceServer{
+		DB:       db,
+		Redis:    redisClient,
+		Node:     node,
+		Proxy:    serviceProxy,
+		EventBus: eventBus,
+		Cache:    service.NewProductCache(redisClient),
+		IDFilter: service.NewProductIDFilter(redisClient),
+		Media:    mediaStorage,
+	}
+
+	// 订阅其他副本的缓存失效通知
+	cacheCtx, cancelCache := context.WithCancel(context.Background())
+	defer cancelCache()
+	go productService.Cache.Subscribe(cacheCtx)
+
+	// 各级缓存命中统计通过 /debug/vars 导出
+	productService.Cache.PublishStats("product_cache")
+	if metricsPort := viper.GetInt("server.metrics_port"); metricsPort > 0 {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/debug/vars", expvar.Handler())
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", metricsPort), mux); err != nil {
+				log.Errorf("统计服务启动失败: %v", err)
+			}
+		}()
+	}
+
+	// 定时检查价格规则的开始和结束
+	go productService.RunPriceRuleScheduler(cacheCtx, viper.GetDuration("price_rule.check_interval")*time.Second)
+
+	// 构建商品ID布隆过滤器，失败时放行所有ID
+	if err := productService.RebuildProductIDFilter(context.Background()); err != nil {
+		log.Errorf("构建商品ID布隆过滤器失败: %v", err)
+	}
+
+	// 构建搜索联想索引，失败不影响服务启动
+	if err := productService.RebuildSuggestIndex(context.Background()); err != nil {
+		log.Errorf("构建搜索联想索引失败: %v", err)
+	}
+
+	// 图片上传请求体较大，放宽默认4MB的接收限制
+	s := grpc.NewServer(grpc.MaxRecvMsgSize(media.MaxUploadSize + 1<<20))
+	product.RegisterProductCatalogServiceServer(s, productService)
 
 	// 优雅关闭
 	go func() {
@@ -0,0 +1,43 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Storage 图片存储接口，键名采用S3风格的对象路径（如 products/1/abcd/thumb.jpg）
+type Storage interface {
+	// Put 写入对象，相同key会被覆盖
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// URL 返回对象的公开访问地址
+	URL(key string) string
+}
+
+// NewStorageFromConfig 根据配置创建存储实现
+func NewStorageFromConfig() (Storage, error) {
+	baseURL := strings.TrimRight(viper.GetString("media.public_base_url"), "/")
+
+	switch backend := viper.GetString("media.storage"); backend {
+	case "", "local":
+		root := viper.GetString("media.local.root")
+		if root == "" {
+			root = "data/media"
+		}
+		return NewLocalStorage(root, baseURL), nil
+	case "s3":
+		return NewS3Storage(S3Config{
+			Endpoint:  viper.GetString("media.s3.endpoint"),
+			Region:    viper.GetString("media.s3.region"),
+			Bucket:    viper.GetString("media.s3.bucket"),
+			AccessKey: viper.GetString("media.s3.access_key"),
+			SecretKey: viper.GetString("media.s3.secret_key"),
+		}, baseURL)
+	default:
+		return nil, fmt.Errorf("不支持的图片存储类型: %s", backend)
+	}
+}
@@ -0,0 +1,163 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// MaxUploadSize 单张图片上传大小上限
+const MaxUploadSize = 10 << 20
+
+// MaxPixels 单张图片像素数上限。压缩率很高的图片文件很小但解码后占用大量内存，解码前按尺寸拒绝
+const MaxPixels = 40_000_000
+
+const (
+	SizeOriginal = "original"
+	SizeThumb    = "thumb"
+	SizeMedium   = "medium"
+	SizeLarge    = "large"
+)
+
+// ThumbnailSizes 缩略图规格，数值为最长边像素
+var ThumbnailSizes = []struct {
+	Name    string
+	MaxEdge int
+}{
+	{SizeThumb, 150},
+	{SizeMedium, 480},
+	{SizeLarge, 960},
+}
+
+// allowedContentTypes 允许上传的图片类型及原图扩展名
+var allowedContentTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+const thumbnailQuality = 85
+
+// DetectContentType 根据文件内容（而非扩展名）识别图片类型
+func DetectContentType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := allowedContentTypes[contentType]; !ok {
+		return "", fmt.Errorf("不支持的图片类型: %s", contentType)
+	}
+	return contentType, nil
+}
+
+// Extension 返回图片类型对应的文件扩展名
+func Extension(contentType string) string {
+	if ext, ok := allowedContentTypes[contentType]; ok {
+		return ext
+	}
+	return "bin"
+}
+
+// Thumbnail 缩略图结果
+type Thumbnail struct {
+	Size   string
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// Decode 解码图片，GIF只取第一帧。像素数超过MaxPixels的图片不解码
+func Decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("图片解码失败: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, fmt.Errorf("图片尺寸%dx%d超过上限%d像素", config.Width, config.Height, MaxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("图片解码失败: %w", err)
+	}
+	return img, nil
+}
+
+// GenerateThumbnails 按ThumbnailSizes生成JPEG缩略图，不会放大小于目标尺寸的图片
+func GenerateThumbnails(img image.Image) ([]Thumbnail, error) {
+	// 透明背景统一铺白底后再缩放
+	src := flatten(img)
+
+	thumbs := make([]Thumbnail, 0, len(ThumbnailSizes))
+	for _, size := range ThumbnailSizes {
+		w, h := FitSize(src.Bounds().Dx(), src.Bounds().Dy(), size.MaxEdge)
+		resized := resize(src, w, h)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, fmt.Errorf("生成%s缩略图失败: %w", size.Name, err)
+		}
+		thumbs = append(thumbs, Thumbnail{Size: size.Name, Data: buf.Bytes(), Width: w, Height: h})
+	}
+	return thumbs, nil
+}
+
+// FitSize 等比缩放到最长边不超过maxEdge
+func FitSize(w, h, maxEdge int) (int, int) {
+	if w <= maxEdge && h <= maxEdge {
+		return w, h
+	}
+	if w >= h {
+		return maxEdge, max(1, h*maxEdge/w)
+	}
+	return max(1, w*maxEdge/h), maxEdge
+}
+
+func flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// resize 区域平均（box filter）缩小，每个目标像素取其覆盖的源像素均值
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw == w && sh == h {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := y * sh / h
+		y1 := max(y0+1, (y+1)*sh/h)
+		for x := 0; x < w; x++ {
+			x0 := x * sw / w
+			x1 := max(x0+1, (x+1)*sw/w)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				off := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[off])
+					g += uint32(src.Pix[off+1])
+					b += uint32(src.Pix[off+2])
+					a += uint32(src.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+
+			off := dst.PixOffset(x, y)
+			dst.Pix[off] = uint8(r / n)
+			dst.Pix[off+1] = uint8(g / n)
+			dst.Pix[off+2] = uint8(b / n)
+			dst.Pix[off+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
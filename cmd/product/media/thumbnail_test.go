@@ -0,0 +1,78 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// 测试按内容识别图片类型
+func TestDetectContentType(t *testing.T) {
+	contentType, err := DetectContentType(encodePNG(t, 2, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, "png", Extension(contentType))
+
+	_, err = DetectContentType([]byte("<html><body>not an image</body></html>"))
+	assert.Error(t, err, "非图片内容应被拒绝")
+}
+
+// 测试只读取图片头就拒绝尺寸过大的图片
+func TestDecodeTooLarge(t *testing.T) {
+	// GIF文件头声明65535x65535的画布，没有图像数据
+	header := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+	_, err := Decode(header)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "超过上限")
+	}
+
+	_, err = Decode(encodePNG(t, 2, 2))
+	assert.NoError(t, err)
+}
+
+// 测试缩略图尺寸：等比缩放且不放大
+func TestGenerateThumbnails(t *testing.T) {
+	img, err := Decode(encodePNG(t, 1200, 600))
+	assert.NoError(t, err)
+
+	thumbs, err := GenerateThumbnails(img)
+	assert.NoError(t, err)
+	assert.Len(t, thumbs, len(ThumbnailSizes))
+
+	expected := map[string][2]int{
+		SizeThumb:  {150, 75},
+		SizeMedium: {480, 240},
+		SizeLarge:  {960, 480},
+	}
+	for _, thumb := range thumbs {
+		decoded, err := Decode(thumb.Data)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[thumb.Size][0], decoded.Bounds().Dx(), thumb.Size)
+		assert.Equal(t, expected[thumb.Size][1], decoded.Bounds().Dy(), thumb.Size)
+
+		// 纯色图片缩放后颜色应基本不变（JPEG有轻微误差）
+		r, g, b, _ := decoded.At(10, 10).RGBA()
+		assert.InDelta(t, 200, r>>8, 4)
+		assert.InDelta(t, 100, g>>8, 4)
+		assert.InDelta(t, 50, b>>8, 4)
+	}
+
+	w, h := FitSize(100, 80, 480)
+	assert.Equal(t, [2]int{100, 80}, [2]int{w, h}, "小图不应放大")
+}
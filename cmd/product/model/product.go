@@ -9,24 +9,112 @@ import (
 
 type Product struct {
 	model.BaseModel
-	Name        string          `gorm:"type:varchar(100);not null;index:idx_search,priority:1"`
-	Description string          `gorm:"type:text;index:idx_search,priority:2,length:255"`
-	Price       float64         `gorm:"type:decimal(10,2);not null;index"`
-	Stock       int             `gorm:"type:int unsigned;not null;default:0"`
-	CategoryID  uint            `gorm:"index"`
-	Category    ProductCategory `gorm:"foreignKey:CategoryID"`
-	IsPublished bool            `gorm:"default:false"`
-	PublishedAt time.Time
-	Images      string `gorm:"type:text"` // JSON数组存储图片路径
+	ExternalCode  *string         `gorm:"type:varchar(50);uniqueIndex"` // 外部商品编码，批量导入时按此幂等更新
+	Name          string          `gorm:"type:varchar(100);not null;index:idx_search,priority:1"`
+	Description   string          `gorm:"type:text;index:idx_search,priority:2,length:255"`
+	Price         float64         `gorm:"type:decimal(10,2);not null;index"`
+	Stock         int             `gorm:"type:int unsigned;not null;default:0"`
+	PurchaseLimit int             `gorm:"not null;default:0"` // 每单限购数量，0表示不限购
+	CategoryID    uint            `gorm:"index"`
+	Category      ProductCategory `gorm:"foreignKey:CategoryID"`
+	IsPublished   bool            `gorm:"default:false"`
+	PublishedAt   time.Time
+	Images        string         `gorm:"type:text"` // 旧版图片路径（JSON数组），新上传的图片记录在ProductImage
+	Pictures      []ProductImage `gorm:"foreignKey:ProductID"`
+	ReviewCount   int            `gorm:"not null;default:0"` // 审核通过的评价数
+	RatingSum     int            `gorm:"not null;default:0"` // 审核通过的评分总和，与ReviewCount一起增量维护
 }
 
+// DefaultExternalCodePrefix 自动生成的外部商品编码前缀，如 P12
+const DefaultExternalCodePrefix = "P"
+
+// AverageRating 平均评分，没有评价时为0
+func (p *Product) AverageRating() float64 {
+	if p.ReviewCount == 0 {
+		return 0
+	}
+	return float64(p.RatingSum) / float64(p.ReviewCount)
+}
+
+// ProductCategory 商品分类，ParentID为0表示顶级分类
 type ProductCategory struct {
 	model.BaseModel
-	Name        string `gorm:"type:varchar(50);uniqueIndex;not null"`
+	ParentID    uint   `gorm:"not null;default:0;uniqueIndex:idx_parent_name,priority:1"`
+	Name        string `gorm:"type:varchar(50);not null;uniqueIndex:idx_parent_name,priority:2"`
+	Path        string `gorm:"type:varchar(255);not null;default:'';index"` // 物化路径，如 /1/3/，包含自身ID
 	Description string `gorm:"type:text"`
 	SortOrder   int    `gorm:"type:int;default:0"`
 }
 
+// ProductCategoryLink 商品与分类的多对多关联，Product.CategoryID为主分类
+type ProductCategoryLink struct {
+	ProductID  uint `gorm:"primaryKey"`
+	CategoryID uint `gorm:"primaryKey;index"`
+	CreatedAt  time.Time
+}
+
+// ProductImage 商品图片，对象按内容哈希寻址：products/{product_id}/{hash前缀}/{size}.{ext}
+type ProductImage struct {
+	model.BaseModel
+	ProductID   uint   `gorm:"not null;uniqueIndex:idx_product_hash,priority:1"`
+	Hash        string `gorm:"type:char(64);not null;uniqueIndex:idx_product_hash,priority:2"` // 原图SHA-256
+	ContentType string `gorm:"type:varchar(50);not null"`
+	Width       int    `gorm:"not null"`
+	Height      int    `gorm:"not null"`
+	SortOrder   int    `gorm:"type:int;default:0"`
+}
+
+// 评价审核状态
+type ReviewStatus string
+
+const (
+	ReviewStatusPending  ReviewStatus = "PENDING"  // 待审核
+	ReviewStatusApproved ReviewStatus = "APPROVED" // 审核通过
+	ReviewStatusRejected ReviewStatus = "REJECTED" // 审核拒绝
+)
+
+// ProductReview 商品评价，每个用户对同一商品只能评价一次
+type ProductReview struct {
+	model.BaseModel
+	ProductID uint         `gorm:"not null;uniqueIndex:idx_product_user,priority:1;index:idx_product_status,priority:1"`
+	UserID    int64        `gorm:"not null;uniqueIndex:idx_product_user,priority:2"`
+	OrderID   string       `gorm:"type:varchar(50);not null"` // 校验购买资格时的已送达订单
+	Rating    int          `gorm:"type:tinyint;not null"`
+	Content   string       `gorm:"type:text"`
+	Status    ReviewStatus `gorm:"type:varchar(20);not null;default:'PENDING';index:idx_product_status,priority:2"`
+}
+
+// 价格变更来源
+type PriceChangeSource string
+
+const (
+	PriceChangeManual PriceChangeSource = "MANUAL" // 管理端调价
+	PriceChangeImport PriceChangeSource = "IMPORT" // 批量导入
+)
+
+// ProductPriceHistory 商品基础价格（Product.Price）变更记录
+type ProductPriceHistory struct {
+	ID        uint              `gorm:"primarykey"`
+	ProductID uint              `gorm:"not null;index:idx_product_changed,priority:1"`
+	OldPrice  float64           `gorm:"type:decimal(10,2);not null"`
+	NewPrice  float64           `gorm:"type:decimal(10,2);not null"`
+	Source    PriceChangeSource `gorm:"type:varchar(20);not null"`
+	ChangedAt time.Time         `gorm:"not null;index:idx_product_changed,priority:2"`
+}
+
+// ProductPriceRule 定时价格规则，在[StartAt, EndAt)区间内以Price覆盖基础价格。
+// 多条规则重叠时，开始时间最晚的生效。
+type ProductPriceRule struct {
+	model.BaseModel
+	ProductID     uint      `gorm:"not null;index:idx_product_period,priority:1"`
+	Name          string    `gorm:"type:varchar(100)"`
+	Price         float64   `gorm:"type:decimal(10,2);not null"`
+	StartAt       time.Time `gorm:"not null;index:idx_product_period,priority:2;index"`
+	EndAt         time.Time `gorm:"not null;index"`
+	StartNotified bool      `gorm:"not null;default:false"` // 规则开始时的价格变更事件已发布
+	EndNotified   bool      `gorm:"not null;default:false"` // 规则结束时的价格变更事件已发布
+}
+
 type ProductSKU struct {
 	model.BaseModel
 	ProductID uint    `gorm:"index;not null"`
@@ -36,11 +124,40 @@ type ProductSKU struct {
 	Specs     string  `gorm:"type:json"` // 规格参数，JSON格式
 }
 
+// legacyCategoryNameIndex 旧版分类名称上的唯一索引
+const legacyCategoryNameIndex = "idx_product_categories_name"
+
 // 初始化数据库表
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	if err := db.AutoMigrate(
 		&Product{},
 		&ProductCategory{},
+		&ProductCategoryLink{},
+		&ProductImage{},
+		&ProductReview{},
+		&ProductPriceHistory{},
+		&ProductPriceRule{},
 		&ProductSKU{},
-	)
+	); err != nil {
+		return err
+	}
+
+	// 分类名称改为在同一父分类下唯一，AutoMigrate不会删除旧版名称上的全局唯一索引
+	if db.Migrator().HasIndex(&ProductCategory{}, legacyCategoryNameIndex) {
+		if err := db.Migrator().DropIndex(&ProductCategory{}, legacyCategoryNameIndex); err != nil {
+			return err
+		}
+	}
+
+	// 为旧版平铺分类补齐物化路径
+	if err := db.Model(&ProductCategory{}).
+		Where("parent_id = 0 AND path = ''").
+		Update("path", gorm.Expr("CONCAT('/', id, '/')")).Error; err != nil {
+		return err
+	}
+
+	// 为没有外部编码的商品生成默认编码，保证导出后再导入不会重复创建
+	return db.Model(&Product{}).
+		Where("external_code IS NULL").
+		Update("external_code", gorm.Expr("CONCAT(?, id)", DefaultExternalCodePrefix)).Error
 }
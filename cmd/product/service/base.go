@@ -2,6 +2,8 @@ package service
 
 import (
 	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/media"
+	"TKMall/common/cache"
 	"TKMall/common/events"
 	"TKMall/common/proxy"
 
@@ -17,6 +19,9 @@ type ProductCatalogServiceServer struct {
 	Node     *snowflake.Node
 	Proxy    proxy.ServiceProxy
 	EventBus events.EventBus
+	Cache    *cache.Cache
+	IDFilter *cache.BloomFilter
+	Media    media.Storage
 }
 
 // type ProductService struct {
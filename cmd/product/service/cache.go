@@ -1,53 +1,139 @@
 package service
 
 import (
-	"TKMall/build/proto_gen/product"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/cache"
+	"TKMall/common/log"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
 )
 
 const (
-	productListCacheKey = "product_list:%s:%s:%f:%f:%s:%d:%d"
-	cacheExpiration     = 5 * time.Minute
-	productCacheKey     = "product:%d"
+	productCacheKey        = "product:%d"
+	productListNamespace   = "product_list"
+	productSearchNamespace = "product_search"
+	productIDFilterKey     = "product:id_filter"
+	productCacheChannel    = "product:cache:invalidate"
+
+	cacheExpiration         = 30 * time.Minute
+	cacheJitter             = 0.2
+	negativeCacheExpiration = time.Minute
+
+	// 本地缓存只保存热点数据，过期时间较短，作为错过失效通知时的兜底
+	localCacheCapacity   = 10000
+	localCacheExpiration = time.Minute
+
+	// 布隆过滤器容量和误判率
+	productFilterCapacity = 1000000
+	productFilterFPRate   = 0.001
 )
 
-// func (s *ProductService) getCachedProducts(req *product.ListProductsReq) ([]*product.Product, int32, error) {
-// 	_ = fmt.Sprintf(productListCacheKey,
-// 		req.CategoryName,
-// 		req.Page,
-// 		req.PageSize,
-// 	)
-
-// 	// 从Redis获取缓存
-// 	// ...
-// 	return nil, 0, nil
-// }
-
-// func (s *ProductService) cacheProducts(req *product.ListProductsReq, products []*product.Product, total int32) {
-// 	// 存储到Redis
-// 	// ...
-// }
-
-func (s *ProductCatalogServiceServer) getCachedProduct(productID uint32) (*product.Product, error) {
-	cacheKey := fmt.Sprintf(productCacheKey, productID)
-
-	// 从Redis获取缓存
-	cachedData, err := s.Redis.Get(context.Background(), cacheKey).Result()
-	if err == nil {
-		var cachedProduct product.Product
-		if err := json.Unmarshal([]byte(cachedData), &cachedProduct); err == nil {
-			return &cachedProduct, nil
-		}
+// NewProductCache 创建商品读缓存（本地LRU + Redis）
+func NewProductCache(rdb *redis.Client) *cache.Cache {
+	return cache.New(rdb, cache.Options{
+		TTL:           cacheExpiration,
+		Jitter:        cacheJitter,
+		NegativeTTL:   negativeCacheExpiration,
+		LocalCapacity: localCacheCapacity,
+		LocalTTL:      localCacheExpiration,
+		Channel:       productCacheChannel,
+	})
+}
+
+// NewProductIDFilter 创建有效商品ID的布隆过滤器
+func NewProductIDFilter(rdb *redis.Client) *cache.BloomFilter {
+	return cache.NewBloomFilter(rdb, productIDFilterKey, productFilterCapacity, productFilterFPRate)
+}
+
+// RebuildProductIDFilter 用数据库中全部商品ID重建布隆过滤器
+func (s *ProductCatalogServiceServer) RebuildProductIDFilter(ctx context.Context) error {
+	return s.IDFilter.Rebuild(ctx, func(add func(item string) error) error {
+		var ids []uint
+		return s.DB.Model(&model.Product{}).Select("id").
+			FindInBatches(&ids, 1000, func(tx *gorm.DB, batch int) error {
+				for _, id := range ids {
+					if err := add(strconv.FormatUint(uint64(id), 10)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}).Error
+	})
+}
+
+// InvalidateProduct 商品变更后调用，删除单品缓存并使列表、搜索缓存整体失效
+func (s *ProductCatalogServiceServer) InvalidateProduct(ctx context.Context, productID uint32) error {
+	if err := s.Cache.Invalidate(ctx, fmt.Sprintf(productCacheKey, productID)); err != nil {
+		return err
 	}
+	return s.InvalidateProductLists(ctx)
+}
 
-	return nil, err
+// InvalidateProductLists 使列表和搜索缓存整体失效
+func (s *ProductCatalogServiceServer) InvalidateProductLists(ctx context.Context) error {
+	if err := s.Cache.InvalidateNamespace(ctx, productListNamespace); err != nil {
+		return err
+	}
+	return s.Cache.InvalidateNamespace(ctx, productSearchNamespace)
+}
+
+// trackProductID 新建商品后加入布隆过滤器，否则在下次重建前会被误判为不存在
+func (s *ProductCatalogServiceServer) trackProductID(ctx context.Context, productID uint32) {
+	if s.IDFilter == nil {
+		return
+	}
+	if err := s.IDFilter.Add(ctx, strconv.FormatUint(uint64(productID), 10)); err != nil {
+		log.Errorf("更新布隆过滤器失败: %v", err)
+	}
+}
+
+// productMayExist 通过布隆过滤器判断商品是否可能存在。
+// 过滤器未初始化或Redis异常时放行，交给缓存和数据库判断。
+func (s *ProductCatalogServiceServer) productMayExist(ctx context.Context, productID uint32) bool {
+	if s.IDFilter == nil {
+		return true
+	}
+	ready, err := s.IDFilter.Exists(ctx)
+	if err != nil || !ready {
+		return true
+	}
+	ok, err := s.IDFilter.MightContain(ctx, strconv.FormatUint(uint64(productID), 10))
+	if err != nil {
+		log.Errorf("查询布隆过滤器失败: %v", err)
+		return true
+	}
+	return ok
 }
 
-func (s *ProductCatalogServiceServer) cacheProduct(product *product.Product) {
-	cacheKey := fmt.Sprintf(productCacheKey, product.Id)
-	productData, _ := json.Marshal(product)
-	s.Redis.Set(context.Background(), cacheKey, productData, 30*time.Minute)
+// loadProduct 通过缓存读取单个商品
+func (s *ProductCatalogServiceServer) loadProduct(ctx context.Context, productID uint32) (*product.Product, error) {
+	var cached product.Product
+	err := s.Cache.GetOrLoad(ctx, fmt.Sprintf(productCacheKey, productID), &cached, func(ctx context.Context) (interface{}, error) {
+		var productModel model.Product
+		err := s.DB.WithContext(ctx).Preload("Category").Preload("Pictures", preloadPictures).Where("id = ?", productID).First(&productModel).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, cache.ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		protoProduct := convertToProtoProduct(&productModel)
+		s.applyPictures(protoProduct, &productModel)
+		if protoProduct.Categories, err = s.productCategoryNames(ctx, &productModel); err != nil {
+			return nil, err
+		}
+		return protoProduct, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cached, nil
 }
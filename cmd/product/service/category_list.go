@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/cache"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const categoryNamespace = "category"
+
+// ListCategories 返回分类树
+func (s *ProductCatalogServiceServer) ListCategories(ctx context.Context, req *product.ListCategoriesReq) (*product.ListCategoriesResp, error) {
+	cacheKey := s.Cache.NamespaceKey(ctx, categoryNamespace, fmt.Sprintf("tree:%d", req.RootId))
+	var resp product.ListCategoriesResp
+	err := s.Cache.GetOrLoad(ctx, cacheKey, &resp, func(ctx context.Context) (interface{}, error) {
+		return s.loadCategoryTree(ctx, req.RootId)
+	})
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "分类不存在")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "查询分类失败: %v", err)
+	}
+	return &resp, nil
+}
+
+func (s *ProductCatalogServiceServer) loadCategoryTree(ctx context.Context, rootID uint32) (*product.ListCategoriesResp, error) {
+	query := s.DB.WithContext(ctx).Model(&model.ProductCategory{})
+	if rootID != 0 {
+		var root model.ProductCategory
+		if err := s.DB.WithContext(ctx).First(&root, rootID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, cache.ErrNotFound
+			}
+			return nil, err
+		}
+		query = query.Where("path LIKE ?", root.Path+"%")
+	}
+
+	var categories []model.ProductCategory
+	if err := query.Order("sort_order ASC, id ASC").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	return &product.ListCategoriesResp{
+		Categories: buildCategoryTree(categories),
+	}, nil
+}
+
+// buildCategoryTree 将分类列表组装成树，父分类不在列表中的节点作为根节点
+func buildCategoryTree(categories []model.ProductCategory) []*product.Category {
+	nodes := make(map[uint]*product.Category, len(categories))
+	for i := range categories {
+		nodes[categories[i].ID] = convertToProtoCategory(&categories[i])
+	}
+
+	roots := make([]*product.Category, 0)
+	for i := range categories {
+		node := nodes[categories[i].ID]
+		if parent, ok := nodes[categories[i].ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// descendantCategoryIDs 返回指定分类及其全部子分类的ID。
+// 按名称查询时，同名分类（不同父分类下）都会包含在内。
+func (s *ProductCatalogServiceServer) descendantCategoryIDs(ctx context.Context, categoryID uint32, categoryName string) ([]uint, error) {
+	query := s.DB.WithContext(ctx).Model(&model.ProductCategory{})
+	if categoryID != 0 {
+		query = query.Where("id = ?", categoryID)
+	} else {
+		query = query.Where("name = ?", categoryName)
+	}
+
+	var paths []string
+	if err := query.Pluck("path", &paths).Error; err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	subQuery := s.DB.WithContext(ctx).Model(&model.ProductCategory{})
+	for i, path := range paths {
+		if i == 0 {
+			subQuery = subQuery.Where("path LIKE ?", path+"%")
+		} else {
+			subQuery = subQuery.Or("path LIKE ?", path+"%")
+		}
+	}
+
+	var ids []uint
+	if err := subQuery.Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// productCategoryNames 返回商品的主分类和关联分类名称，主分类在前
+func (s *ProductCatalogServiceServer) productCategoryNames(ctx context.Context, p *model.Product) ([]string, error) {
+	var linked []string
+	err := s.DB.WithContext(ctx).Model(&model.ProductCategory{}).
+		Joins("JOIN product_category_links ON product_category_links.category_id = product_categories.id").
+		Where("product_category_links.product_id = ?", p.ID).
+		Order("product_categories.sort_order ASC").
+		Pluck("product_categories.name", &linked).Error
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(linked)+1)
+	if p.Category.ID > 0 {
+		names = append(names, p.Category.Name)
+	}
+	for _, name := range linked {
+		if p.Category.ID > 0 && name == p.Category.Name {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func convertToProtoCategory(c *model.ProductCategory) *product.Category {
+	return &product.Category{
+		Id:          uint32(c.ID),
+		ParentId:    uint32(c.ParentID),
+		Name:        c.Name,
+		Description: c.Description,
+		SortOrder:   int32(c.SortOrder),
+		Path:        c.Path,
+	}
+}
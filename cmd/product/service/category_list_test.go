@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+
+	"TKMall/cmd/product/model"
+	commonModel "TKMall/common/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCategory(id, parentID uint, name, path string) model.ProductCategory {
+	return model.ProductCategory{
+		BaseModel: commonModel.BaseModel{ID: id},
+		ParentID:  parentID,
+		Name:      name,
+		Path:      path,
+	}
+}
+
+// 测试分类树组装
+func TestBuildCategoryTree(t *testing.T) {
+	categories := []model.ProductCategory{
+		newCategory(1, 0, "手机", "/1/"),
+		newCategory(2, 0, "电脑", "/2/"),
+		newCategory(7, 2, "笔记本", "/2/7/"),
+		newCategory(9, 7, "游戏本", "/2/7/9/"),
+		newCategory(8, 2, "平板电脑", "/2/8/"),
+	}
+
+	t.Run("完整分类树", func(t *testing.T) {
+		roots := buildCategoryTree(categories)
+		assert.Len(t, roots, 2, "应有2个顶级分类")
+		assert.Equal(t, "手机", roots[0].Name)
+		assert.Empty(t, roots[0].Children)
+
+		computer := roots[1]
+		assert.Len(t, computer.Children, 2, "电脑下应有2个子分类")
+		assert.Equal(t, "笔记本", computer.Children[0].Name)
+		assert.Equal(t, "平板电脑", computer.Children[1].Name)
+		assert.Len(t, computer.Children[0].Children, 1)
+		assert.Equal(t, "游戏本", computer.Children[0].Children[0].Name)
+	})
+
+	t.Run("子树", func(t *testing.T) {
+		// 只包含笔记本及其子分类时，笔记本作为根节点
+		roots := buildCategoryTree(categories[2:4])
+		assert.Len(t, roots, 1)
+		assert.Equal(t, uint32(7), roots[0].Id)
+		assert.Equal(t, uint32(2), roots[0].ParentId)
+		assert.Len(t, roots[0].Children, 1)
+	})
+
+	t.Run("空列表", func(t *testing.T) {
+		assert.Empty(t, buildCategoryTree(nil))
+	})
+}
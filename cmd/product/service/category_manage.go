@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const categoryNameMaxLength = 50
+
+// CreateCategory 创建分类
+func (s *ProductCatalogServiceServer) CreateCategory(ctx context.Context, req *product.CreateCategoryReq) (*product.CreateCategoryResp, error) {
+	name := strings.TrimSpace(req.Name)
+	if err := validateCategoryName(name); err != nil {
+		return nil, err
+	}
+
+	category := model.ProductCategory{
+		ParentID:    uint(req.ParentId),
+		Name:        name,
+		Description: req.Description,
+		SortOrder:   int(req.SortOrder),
+	}
+
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		parentPath, err := categoryParentPath(tx, category.ParentID)
+		if err != nil {
+			return err
+		}
+		if err := checkCategoryNameUnique(tx, category.ParentID, name, 0); err != nil {
+			return err
+		}
+
+		if err := tx.Create(&category).Error; err != nil {
+			return fmt.Errorf("创建分类失败: %w", err)
+		}
+
+		// 物化路径包含自身ID，需要在插入后才能确定
+		category.Path = fmt.Sprintf("%s%d/", parentPath, category.ID)
+		return tx.Model(&category).Update("path", category.Path).Error
+	})
+	if err != nil {
+		return nil, categoryError(err)
+	}
+
+	s.invalidateCategories(ctx)
+
+	return &product.CreateCategoryResp{
+		Category: convertToProtoCategory(&category),
+	}, nil
+}
+
+// UpdateCategory 全量更新分类信息，parent_id变化时连同子分类一起移动
+func (s *ProductCatalogServiceServer) UpdateCategory(ctx context.Context, req *product.UpdateCategoryReq) (*product.UpdateCategoryResp, error) {
+	if req.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "分类ID不能为空")
+	}
+	name := strings.TrimSpace(req.Name)
+	if err := validateCategoryName(name); err != nil {
+		return nil, err
+	}
+
+	var category model.ProductCategory
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&category, req.Id).Error; err != nil {
+			return err
+		}
+		if err := checkCategoryNameUnique(tx, uint(req.ParentId), name, category.ID); err != nil {
+			return err
+		}
+
+		if uint(req.ParentId) != category.ParentID {
+			if err := moveCategory(tx, &category, uint(req.ParentId)); err != nil {
+				return err
+			}
+		}
+
+		category.Name = name
+		category.Description = req.Description
+		category.SortOrder = int(req.SortOrder)
+		return tx.Model(&category).Updates(map[string]interface{}{
+			"name":        category.Name,
+			"description": category.Description,
+			"sort_order":  category.SortOrder,
+		}).Error
+	})
+	if err != nil {
+		return nil, categoryError(err)
+	}
+
+	s.invalidateCategories(ctx)
+
+	return &product.UpdateCategoryResp{
+		Category: convertToProtoCategory(&category),
+	}, nil
+}
+
+// DeleteCategory 删除分类，存在子分类或商品时不允许删除
+func (s *ProductCatalogServiceServer) DeleteCategory(ctx context.Context, req *product.DeleteCategoryReq) (*product.DeleteCategoryResp, error) {
+	if req.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "分类ID不能为空")
+	}
+
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var category model.ProductCategory
+		if err := tx.First(&category, req.Id).Error; err != nil {
+			return err
+		}
+
+		var children int64
+		if err := tx.Model(&model.ProductCategory{}).Where("parent_id = ?", category.ID).Count(&children).Error; err != nil {
+			return err
+		}
+		if children > 0 {
+			return status.Error(codes.FailedPrecondition, "分类下存在子分类，无法删除")
+		}
+
+		var products int64
+		if err := tx.Model(&model.Product{}).Where("category_id = ?", category.ID).Count(&products).Error; err != nil {
+			return err
+		}
+		var links int64
+		if err := tx.Model(&model.ProductCategoryLink{}).Where("category_id = ?", category.ID).Count(&links).Error; err != nil {
+			return err
+		}
+		if products+links > 0 {
+			return status.Error(codes.FailedPrecondition, "分类下存在商品，无法删除")
+		}
+
+		// 物理删除，释放(parent_id, name)唯一索引
+		return tx.Unscoped().Delete(&category).Error
+	})
+	if err != nil {
+		return nil, categoryError(err)
+	}
+
+	s.invalidateCategories(ctx)
+
+	return &product.DeleteCategoryResp{}, nil
+}
+
+// SetProductCategories 设置商品所属分类，第一个分类作为主分类
+func (s *ProductCatalogServiceServer) SetProductCategories(ctx context.Context, req *product.SetProductCategoriesReq) (*product.SetProductCategoriesResp, error) {
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	// 去重并保持顺序
+	categoryIDs := make([]uint, 0, len(req.CategoryIds))
+	seen := make(map[uint32]bool, len(req.CategoryIds))
+	for _, id := range req.CategoryIds {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		categoryIDs = append(categoryIDs, uint(id))
+	}
+
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var productModel model.Product
+		if err := tx.First(&productModel, req.ProductId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return status.Error(codes.NotFound, "商品不存在")
+			}
+			return err
+		}
+
+		if len(categoryIDs) > 0 {
+			var count int64
+			if err := tx.Model(&model.ProductCategory{}).Where("id IN ?", categoryIDs).Count(&count).Error; err != nil {
+				return err
+			}
+			if int(count) != len(categoryIDs) {
+				return status.Error(codes.InvalidArgument, "存在无效的分类ID")
+			}
+		}
+
+		if err := tx.Where("product_id = ?", productModel.ID).Delete(&model.ProductCategoryLink{}).Error; err != nil {
+			return err
+		}
+
+		primaryID := uint(0)
+		if len(categoryIDs) > 0 {
+			primaryID = categoryIDs[0]
+			links := make([]model.ProductCategoryLink, 0, len(categoryIDs))
+			for _, id := range categoryIDs {
+				links = append(links, model.ProductCategoryLink{ProductID: productModel.ID, CategoryID: id})
+			}
+			if err := tx.Create(&links).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&productModel).Update("category_id", primaryID).Error
+	})
+	if err != nil {
+		return nil, categoryError(err)
+	}
+
+	if err := s.InvalidateProduct(ctx, req.ProductId); err != nil {
+		log.Errorf("清除商品缓存失败: %v", err)
+	}
+
+	return &product.SetProductCategoriesResp{}, nil
+}
+
+// moveCategory 将分类移动到新的父分类下，并批量更新子孙分类的物化路径
+func moveCategory(tx *gorm.DB, category *model.ProductCategory, newParentID uint) error {
+	parentPath, err := categoryParentPath(tx, newParentID)
+	if err != nil {
+		return err
+	}
+	// 不能移动到自身或子孙分类下
+	if strings.HasPrefix(parentPath, category.Path) {
+		return status.Error(codes.InvalidArgument, "不能将分类移动到自身或其子分类下")
+	}
+
+	oldPath := category.Path
+	newPath := fmt.Sprintf("%s%d/", parentPath, category.ID)
+
+	err = tx.Model(&model.ProductCategory{}).
+		Where("path LIKE ?", oldPath+"%").
+		Update("path", gorm.Expr("CONCAT(?, SUBSTRING(path, ?))", newPath, len(oldPath)+1)).Error
+	if err != nil {
+		return fmt.Errorf("更新子分类路径失败: %w", err)
+	}
+
+	category.ParentID = newParentID
+	category.Path = newPath
+	return tx.Model(category).Update("parent_id", newParentID).Error
+}
+
+// categoryParentPath 返回父分类的物化路径，顶级分类为 "/"
+func categoryParentPath(tx *gorm.DB, parentID uint) (string, error) {
+	if parentID == 0 {
+		return "/", nil
+	}
+	var parent model.ProductCategory
+	if err := tx.First(&parent, parentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", status.Error(codes.InvalidArgument, "父分类不存在")
+		}
+		return "", err
+	}
+	return parent.Path, nil
+}
+
+// checkCategoryNameUnique 同一父分类下名称不能重复
+func checkCategoryNameUnique(tx *gorm.DB, parentID uint, name string, excludeID uint) error {
+	var count int64
+	query := tx.Model(&model.ProductCategory{}).Where("parent_id = ? AND name = ?", parentID, name)
+	if excludeID != 0 {
+		query = query.Where("id <> ?", excludeID)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return status.Error(codes.AlreadyExists, "同级分类下已存在相同名称")
+	}
+	return nil
+}
+
+func validateCategoryName(name string) error {
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "分类名称不能为空")
+	}
+	if utf8.RuneCountInString(name) > categoryNameMaxLength {
+		return status.Errorf(codes.InvalidArgument, "分类名称不能超过%d个字符", categoryNameMaxLength)
+	}
+	return nil
+}
+
+// invalidateCategories 分类变更后清除分类树和商品列表缓存
+func (s *ProductCatalogServiceServer) invalidateCategories(ctx context.Context) {
+	if err := s.Cache.InvalidateNamespace(ctx, categoryNamespace); err != nil {
+		log.Errorf("清除分类缓存失败: %v", err)
+	}
+	if err := s.InvalidateProductLists(ctx); err != nil {
+		log.Errorf("清除商品列表缓存失败: %v", err)
+	}
+}
+
+func categoryError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return status.Error(codes.NotFound, "分类不存在")
+	}
+	return status.Errorf(codes.Internal, "操作分类失败: %v", err)
+}
@@ -3,6 +3,8 @@ package service
 import (
 	"errors"
 
+	"TKMall/common/cache"
+
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
@@ -16,7 +18,7 @@ func handleListError(err error) error {
 }
 
 func handleGetError(err error) error {
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, cache.ErrNotFound) {
 		return status.Error(codes.NotFound, "商品不存在")
 	}
 	return status.Error(codes.Internal, "内部服务错误")
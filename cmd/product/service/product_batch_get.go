@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/cache"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 单次批量查询的商品数量上限
+const batchGetMaxIDs = 200
+
+// BatchGetProducts 批量查询商品的当前售价、库存、上架状态和限购数量，不存在的商品不返回
+func (s *ProductCatalogServiceServer) BatchGetProducts(ctx context.Context, req *product.BatchGetProductsReq) (*product.BatchGetProductsResp, error) {
+	if len(req.Ids) == 0 {
+		return &product.BatchGetProductsResp{}, nil
+	}
+	if len(req.Ids) > batchGetMaxIDs {
+		return nil, status.Errorf(codes.InvalidArgument, "单次最多查询%d个商品", batchGetMaxIDs)
+	}
+
+	// 库存和上架状态变化频繁，不走商品缓存
+	var rows []model.Product
+	if err := s.DB.WithContext(ctx).Select("id", "stock", "is_published", "purchase_limit").Where("id IN ?", req.Ids).Find(&rows).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询商品失败: %v", err)
+	}
+	live := make(map[uint32]*model.Product, len(rows))
+	for i := range rows {
+		live[uint32(rows[i].ID)] = &rows[i]
+	}
+
+	products := make([]*product.Product, 0, len(rows))
+	seen := make(map[uint32]bool, len(req.Ids))
+	for _, id := range req.Ids {
+		row, ok := live[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		protoProduct, err := s.loadProduct(ctx, id)
+		if err != nil {
+			if errors.Is(err, cache.ErrNotFound) {
+				continue
+			}
+			return nil, handleGetError(err)
+		}
+		if err := s.applyEffectivePrice(ctx, protoProduct, time.Time{}); err != nil {
+			return nil, status.Errorf(codes.Internal, "计算商品价格失败: %v", err)
+		}
+		protoProduct.Stock = int32(row.Stock)
+		protoProduct.Available = row.IsPublished
+		protoProduct.PurchaseLimit = int32(row.PurchaseLimit)
+		products = append(products, protoProduct)
+	}
+
+	return &product.BatchGetProductsResp{Products: products}, nil
+}
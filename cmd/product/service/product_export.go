@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// 每批从数据库读取的商品数量
+const exportBatchSize = 500
+
+// ExportProducts 按批读取商品并逐行推送，每个SKU一行，没有SKU的商品单独一行
+func (s *ProductCatalogServiceServer) ExportProducts(req *product.ExportProductsReq, stream product.ProductCatalogService_ExportProductsServer) error {
+	ctx := stream.Context()
+
+	categoryPaths, err := s.categoryNamePaths(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "查询分类失败: %v", err)
+	}
+
+	query := s.DB.WithContext(ctx).Model(&model.Product{})
+	if req.CategoryId != 0 {
+		categoryIDs, err := s.descendantCategoryIDs(ctx, req.CategoryId, "")
+		if err != nil {
+			return status.Errorf(codes.Internal, "查询分类失败: %v", err)
+		}
+		if len(categoryIDs) == 0 {
+			return status.Error(codes.NotFound, "分类不存在")
+		}
+		linkedProducts := s.DB.WithContext(ctx).Model(&model.ProductCategoryLink{}).Select("product_id").Where("category_id IN ?", categoryIDs)
+		query = query.Where("products.category_id IN ? OR products.id IN (?)", categoryIDs, linkedProducts)
+	}
+
+	var products []model.Product
+	err = query.FindInBatches(&products, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		ids := make([]uint, 0, len(products))
+		for _, p := range products {
+			ids = append(ids, p.ID)
+		}
+		var skus []model.ProductSKU
+		if err := s.DB.WithContext(ctx).Where("product_id IN ?", ids).Order("id ASC").Find(&skus).Error; err != nil {
+			return err
+		}
+		skusByProduct := make(map[uint][]model.ProductSKU, len(products))
+		for _, sku := range skus {
+			skusByProduct[sku.ProductID] = append(skusByProduct[sku.ProductID], sku)
+		}
+
+		for i := range products {
+			p := &products[i]
+			productSKUs := skusByProduct[p.ID]
+			if len(productSKUs) == 0 {
+				if err := stream.Send(exportRow(p, categoryPaths, nil)); err != nil {
+					return err
+				}
+				continue
+			}
+			for j := range productSKUs {
+				if err := stream.Send(exportRow(p, categoryPaths, &productSKUs[j])); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "导出商品失败: %v", err)
+	}
+	return nil
+}
+
+// categoryNamePaths 返回分类ID到名称路径（如 "电脑/笔记本"）的映射，与导入时的分类列格式一致
+func (s *ProductCatalogServiceServer) categoryNamePaths(ctx context.Context) (map[uint]string, error) {
+	var categories []model.ProductCategory
+	if err := s.DB.WithContext(ctx).Select("id", "name", "path").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(categories))
+	for _, c := range categories {
+		names[strconv.FormatUint(uint64(c.ID), 10)] = c.Name
+	}
+
+	paths := make(map[uint]string, len(categories))
+	for _, c := range categories {
+		ids := strings.Split(strings.Trim(c.Path, "/"), "/")
+		parts := make([]string, 0, len(ids))
+		for _, id := range ids {
+			parts = append(parts, names[id])
+		}
+		paths[c.ID] = strings.Join(parts, "/")
+	}
+	return paths, nil
+}
+
+// exportRow 生成导出行，sku为nil时表示没有SKU的商品
+func exportRow(p *model.Product, categoryPaths map[uint]string, sku *model.ProductSKU) *product.ProductRow {
+	row := &product.ProductRow{
+		ProductCode:   exportProductCode(p),
+		Name:          p.Name,
+		Description:   p.Description,
+		Category:      categoryPaths[p.CategoryID],
+		Price:         p.Price,
+		Stock:         int32(p.Stock),
+		Specs:         "{}",
+		Published:     p.IsPublished,
+		PurchaseLimit: int32(p.PurchaseLimit),
+	}
+	if sku != nil {
+		row.Sku = sku.SKU
+		row.Price = sku.Price
+		row.Stock = int32(sku.Stock)
+		if sku.Specs != "" {
+			row.Specs = sku.Specs
+		}
+	}
+	return row
+}
+
+func exportProductCode(p *model.Product) string {
+	if p.ExternalCode != nil && *p.ExternalCode != "" {
+		return *p.ExternalCode
+	}
+	return fmt.Sprintf("%s%d", model.DefaultExternalCodePrefix, p.ID)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"TKMall/build/proto_gen/product"
 	"TKMall/cmd/product/model"
@@ -16,24 +17,24 @@ func (s *ProductCatalogServiceServer) GetProduct(ctx context.Context, req *produ
 		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
 	}
 
-	// 尝试从缓存获取
-	if cachedProduct, err := s.getCachedProduct(req.Id); err == nil {
-		return &product.GetProductResp{Product: cachedProduct}, nil
+	// 布隆过滤器拦截一定不存在的ID，防止缓存穿透
+	if !s.productMayExist(ctx, req.Id) {
+		return nil, status.Error(codes.NotFound, "商品不存在")
 	}
 
-	// 数据库查询
-	var productModel model.Product
-	err := s.DB.Preload("Category").Where("id = ?", req.Id).First(&productModel).Error
-
+	protoProduct, err := s.loadProduct(ctx, req.Id)
 	if err != nil {
 		return nil, handleGetError(err)
 	}
 
-	// 转换proto格式
-	protoProduct := convertToProtoProduct(&productModel)
-
-	// 缓存结果
-	s.cacheProduct(protoProduct)
+	// 实际售价随价格规则变化，不放入商品缓存
+	var at time.Time
+	if req.At > 0 {
+		at = time.Unix(req.At, 0)
+	}
+	if err := s.applyEffectivePrice(ctx, protoProduct, at); err != nil {
+		return nil, status.Errorf(codes.Internal, "计算商品价格失败: %v", err)
+	}
 
 	return &product.GetProductResp{
 		Product: protoProduct,
@@ -42,11 +43,12 @@ func (s *ProductCatalogServiceServer) GetProduct(ctx context.Context, req *produ
 
 func convertToProtoProduct(p *model.Product) *product.Product {
 	protoProduct := &product.Product{
-		Id:          uint32(p.ID),
-		Name:        p.Name,
-		Description: p.Description,
-		Price:       float32(p.Price),
-		Picture:     p.Images,
+		Id:            uint32(p.ID),
+		Name:          p.Name,
+		Description:   p.Description,
+		Price:         float32(p.Price),
+		AverageRating: float32(p.AverageRating()),
+		ReviewCount:   int32(p.ReviewCount),
 	}
 
 	// 添加分类信息
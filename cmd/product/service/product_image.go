@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/media"
+	"TKMall/cmd/product/model"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// 对象键中使用的哈希前缀长度，同一商品下足以区分不同图片
+const imageHashPrefixLength = 16
+
+// UploadProductImage 上传商品图片，保存原图并生成多种尺寸的缩略图。
+// 同一商品重复上传相同内容时直接返回已有图片。
+func (s *ProductCatalogServiceServer) UploadProductImage(ctx context.Context, req *product.UploadProductImageReq) (*product.UploadProductImageResp, error) {
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+	if len(req.Content) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "图片内容不能为空")
+	}
+	if len(req.Content) > media.MaxUploadSize {
+		return nil, status.Errorf(codes.InvalidArgument, "图片大小不能超过%dMB", media.MaxUploadSize>>20)
+	}
+
+	contentType, err := media.DetectContentType(req.Content)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var productModel model.Product
+	if err := s.DB.WithContext(ctx).Select("id").First(&productModel, req.ProductId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "商品不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询商品失败: %v", err)
+	}
+
+	sum := sha256.Sum256(req.Content)
+	hash := hex.EncodeToString(sum[:])
+
+	var existing model.ProductImage
+	err = s.DB.WithContext(ctx).Where("product_id = ? AND hash = ?", req.ProductId, hash).First(&existing).Error
+	if err == nil {
+		return &product.UploadProductImageResp{Image: s.convertToProtoImage(&existing)}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, status.Errorf(codes.Internal, "查询图片失败: %v", err)
+	}
+
+	img, err := media.Decode(req.Content)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	thumbs, err := media.GenerateThumbnails(img)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	image := model.ProductImage{
+		ProductID:   productModel.ID,
+		Hash:        hash,
+		ContentType: contentType,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		SortOrder:   int(req.SortOrder),
+	}
+
+	keys := make([]string, 0, len(thumbs)+1)
+	originalKey := imageKey(&image, media.SizeOriginal)
+	if err := s.Media.Put(ctx, originalKey, contentType, req.Content); err != nil {
+		return nil, status.Errorf(codes.Internal, "保存原图失败: %v", err)
+	}
+	keys = append(keys, originalKey)
+	for _, thumb := range thumbs {
+		key := imageKey(&image, thumb.Size)
+		if err := s.Media.Put(ctx, key, "image/jpeg", thumb.Data); err != nil {
+			s.deleteImageObjects(ctx, keys)
+			return nil, status.Errorf(codes.Internal, "保存缩略图失败: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := s.DB.WithContext(ctx).Create(&image).Error; err != nil {
+		s.deleteImageObjects(ctx, keys)
+		return nil, status.Errorf(codes.Internal, "保存图片记录失败: %v", err)
+	}
+
+	if err := s.InvalidateProduct(ctx, req.ProductId); err != nil {
+		log.Errorf("清除商品缓存失败: %v", err)
+	}
+
+	log.Infof("商品图片上传成功: product=%d, image=%d, 文件名=%s", image.ProductID, image.ID, req.Filename)
+
+	return &product.UploadProductImageResp{Image: s.convertToProtoImage(&image)}, nil
+}
+
+// DeleteProductImage 删除商品图片及其所有尺寸的文件
+func (s *ProductCatalogServiceServer) DeleteProductImage(ctx context.Context, req *product.DeleteProductImageReq) (*product.DeleteProductImageResp, error) {
+	if req.ProductId == 0 || req.ImageId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID和图片ID不能为空")
+	}
+
+	var image model.ProductImage
+	err := s.DB.WithContext(ctx).Where("id = ? AND product_id = ?", req.ImageId, req.ProductId).First(&image).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "图片不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询图片失败: %v", err)
+	}
+
+	// 物理删除，释放(product_id, hash)唯一索引，允许之后重新上传同一张图片
+	if err := s.DB.WithContext(ctx).Unscoped().Delete(&image).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "删除图片记录失败: %v", err)
+	}
+
+	if err := s.InvalidateProduct(ctx, req.ProductId); err != nil {
+		log.Errorf("清除商品缓存失败: %v", err)
+	}
+
+	// 文件删除失败不影响结果，只会留下无引用的对象
+	keys := []string{imageKey(&image, media.SizeOriginal)}
+	for _, size := range media.ThumbnailSizes {
+		keys = append(keys, imageKey(&image, size.Name))
+	}
+	s.deleteImageObjects(ctx, keys)
+
+	return &product.DeleteProductImageResp{}, nil
+}
+
+func (s *ProductCatalogServiceServer) deleteImageObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.Media.Delete(ctx, key); err != nil {
+			log.Errorf("删除图片文件失败: key=%s, err=%v", key, err)
+		}
+	}
+}
+
+// imageKey 返回图片某个尺寸的对象键，内容不变则地址不变
+func imageKey(image *model.ProductImage, size string) string {
+	ext := "jpg"
+	if size == media.SizeOriginal {
+		ext = media.Extension(image.ContentType)
+	}
+	return fmt.Sprintf("products/%d/%s/%s.%s", image.ProductID, image.Hash[:imageHashPrefixLength], size, ext)
+}
+
+func (s *ProductCatalogServiceServer) convertToProtoImage(image *model.ProductImage) *product.ProductImage {
+	sizes := []*product.ProductPicture{{
+		Size:   media.SizeOriginal,
+		Url:    s.Media.URL(imageKey(image, media.SizeOriginal)),
+		Width:  int32(image.Width),
+		Height: int32(image.Height),
+	}}
+	for _, size := range media.ThumbnailSizes {
+		w, h := media.FitSize(image.Width, image.Height, size.MaxEdge)
+		sizes = append(sizes, &product.ProductPicture{
+			Size:   size.Name,
+			Url:    s.Media.URL(imageKey(image, size.Name)),
+			Width:  int32(w),
+			Height: int32(h),
+		})
+	}
+	return &product.ProductImage{
+		Id:    uint32(image.ID),
+		Sizes: sizes,
+	}
+}
+
+// applyPictures 填充商品图片，上传的图片在前，旧版Images字段中的路径作为只有原图的图片追加在后
+func (s *ProductCatalogServiceServer) applyPictures(protoProduct *product.Product, p *model.Product) {
+	pictures := make([]*product.ProductImage, 0, len(p.Pictures))
+	for i := range p.Pictures {
+		pictures = append(pictures, s.convertToProtoImage(&p.Pictures[i]))
+	}
+	for _, path := range legacyImagePaths(p.Images) {
+		pictures = append(pictures, &product.ProductImage{
+			Sizes: []*product.ProductPicture{{Size: media.SizeOriginal, Url: path}},
+		})
+	}
+
+	protoProduct.Pictures = pictures
+	if len(pictures) > 0 {
+		protoProduct.Picture = pictures[0].Sizes[0].Url
+	}
+}
+
+// legacyImagePaths 解析旧版Images字段，兼容非JSON的单个路径
+func legacyImagePaths(images string) []string {
+	images = strings.TrimSpace(images)
+	if images == "" {
+		return nil
+	}
+	var paths []string
+	if err := json.Unmarshal([]byte(images), &paths); err != nil {
+		return []string{images}
+	}
+	return paths
+}
+
+// preloadPictures 按排序加载商品图片
+func preloadPictures(db *gorm.DB) *gorm.DB {
+	return db.Order("sort_order ASC, id ASC")
+}
@@ -0,0 +1,432 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 每批写入数据库的行数
+	importBatchSize = 500
+	// 响应中最多返回的行错误数，超出部分只计入failed
+	importMaxReportedErrors = 1000
+
+	importCodeMaxLength = 50
+	importNameMaxLength = 100
+	importMaxPrice      = 99999999.99
+)
+
+// importResult 导入过程中的累计结果
+type importResult struct {
+	resp *product.ImportProductsResp
+}
+
+func (r *importResult) fail(row *product.ProductRow, message string) {
+	r.resp.Failed++
+	if len(r.resp.Errors) < importMaxReportedErrors {
+		r.resp.Errors = append(r.resp.Errors, &product.ImportRowError{
+			Line:    row.Line,
+			Sku:     row.Sku,
+			Message: message,
+		})
+	}
+}
+
+// ImportProducts 流式导入商品，按外部编码幂等更新：sku已存在则更新SKU，product_code已存在则更新商品。
+// 每满importBatchSize行写入一次数据库，单行校验失败不影响其他行。
+func (s *ProductCatalogServiceServer) ImportProducts(stream product.ProductCatalogService_ImportProductsServer) error {
+	ctx := stream.Context()
+	result := &importResult{resp: &product.ImportProductsResp{}}
+	categories := make(map[string]model.ProductCategory)
+
+	batch := make([]*product.ProductRow, 0, importBatchSize)
+	for {
+		row, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, row)
+		if len(batch) == importBatchSize {
+			s.importBatch(ctx, batch, categories, result)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		s.importBatch(ctx, batch, categories, result)
+	}
+
+	log.Infof("商品导入完成: 新建%d, 更新%d, 失败%d", result.resp.Created, result.resp.Updated, result.resp.Failed)
+	return stream.SendAndClose(result.resp)
+}
+
+func (s *ProductCatalogServiceServer) importBatch(ctx context.Context, rows []*product.ProductRow, categories map[string]model.ProductCategory, result *importResult) {
+	// 校验并去重，同一批内重复的sku（或无SKU时的product_code）以最后一行为准
+	valid := make([]*product.ProductRow, 0, len(rows))
+	index := make(map[string]int, len(rows))
+	for _, row := range rows {
+		normalizeImportRow(row)
+		if err := validateImportRow(row); err != nil {
+			result.fail(row, err.Error())
+			continue
+		}
+		key := "sku:" + row.Sku
+		if row.Sku == "" {
+			key = "product:" + row.ProductCode
+		}
+		if i, ok := index[key]; ok {
+			valid[i] = row
+			continue
+		}
+		index[key] = len(valid)
+		valid = append(valid, row)
+	}
+
+	// 解析分类路径，不存在的分类自动创建
+	categoryIDs := make(map[*product.ProductRow]uint, len(valid))
+	resolved := valid[:0]
+	for _, row := range valid {
+		if row.Category == "" {
+			resolved = append(resolved, row)
+			continue
+		}
+		id, err := s.ensureCategoryPath(ctx, row.Category, categories)
+		if err != nil {
+			result.fail(row, err.Error())
+			continue
+		}
+		categoryIDs[row] = id
+		resolved = append(resolved, row)
+	}
+	if len(resolved) == 0 {
+		return
+	}
+
+	var imported *importedRows
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		imported, err = upsertImportRows(tx, resolved, categoryIDs)
+		return err
+	})
+	if err != nil {
+		log.Errorf("批量导入写入失败: %v", err)
+		for _, row := range resolved {
+			result.fail(row, fmt.Sprintf("写入数据库失败: %v", err))
+		}
+		return
+	}
+	result.resp.Created += imported.created
+	result.resp.Updated += imported.updated
+
+	s.afterProductsChanged(ctx, imported.productIDs)
+	s.publishBasePriceChanges(ctx, imported.priceChanges, priceReasonImport)
+}
+
+// importedRows 一批行写入数据库的结果
+type importedRows struct {
+	productIDs   []uint
+	created      int32
+	updated      int32
+	priceChanges []model.ProductPriceHistory // 已有商品的基础价格变化，已写入价格历史
+}
+
+// upsertImportRows 在事务中写入一批已校验的行，返回涉及的商品ID、新建和更新的行数以及价格变化
+func upsertImportRows(tx *gorm.DB, rows []*product.ProductRow, categoryIDs map[*product.ProductRow]uint) (*importedRows, error) {
+	// 按product_code聚合商品，多行属于同一商品时以最后一行的商品信息为准
+	now := time.Now()
+	products := make(map[string]*model.Product)
+	codes := make([]string, 0)
+	skus := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if row.Sku != "" {
+			skus = append(skus, row.Sku)
+		}
+		p, ok := products[row.ProductCode]
+		if !ok {
+			code := row.ProductCode
+			p = &model.Product{ExternalCode: &code, PublishedAt: now}
+			products[row.ProductCode] = p
+			codes = append(codes, row.ProductCode)
+		}
+		p.Name = row.Name
+		p.Description = row.Description
+		p.Price = row.Price
+		p.Stock = int(row.Stock)
+		p.CategoryID = categoryIDs[row]
+		p.IsPublished = row.Published
+		p.PurchaseLimit = int(row.PurchaseLimit)
+	}
+
+	// 统计新建和更新数量。已有商品的价格加锁读取，写入后对比，记录价格历史
+	var existingProducts []model.Product
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "external_code", "price").Where("external_code IN ?", codes).
+		Find(&existingProducts).Error; err != nil {
+		return nil, err
+	}
+	var existingSKUs []string
+	if len(skus) > 0 {
+		if err := tx.Unscoped().Model(&model.ProductSKU{}).Where("sku IN ?", skus).Pluck("sku", &existingSKUs).Error; err != nil {
+			return nil, err
+		}
+	}
+	existing := make(map[string]bool, len(existingProducts)+len(existingSKUs))
+	oldPrices := make(map[uint]float64, len(existingProducts))
+	for _, p := range existingProducts {
+		existing["product:"+*p.ExternalCode] = true
+		oldPrices[p.ID] = p.Price
+	}
+	for _, sku := range existingSKUs {
+		existing["sku:"+sku] = true
+	}
+	imported := &importedRows{}
+	for _, row := range rows {
+		key := "sku:" + row.Sku
+		if row.Sku == "" {
+			key = "product:" + row.ProductCode
+		}
+		if existing[key] {
+			imported.updated++
+		} else {
+			imported.created++
+		}
+	}
+
+	productList := make([]*model.Product, 0, len(codes))
+	for _, code := range codes {
+		productList = append(productList, products[code])
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "external_code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "description", "price", "stock", "category_id", "is_published", "purchase_limit", "updated_at", "deleted_at"}),
+	}).Create(&productList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 冲突更新时MySQL不会返回已有行的ID，重新查询一次。已软删除的商品在重新导入时恢复。
+	var saved []model.Product
+	if err := tx.Unscoped().Select("id", "external_code").Where("external_code IN ?", codes).Find(&saved).Error; err != nil {
+		return nil, err
+	}
+	codeToID := make(map[string]uint, len(saved))
+	imported.productIDs = make([]uint, 0, len(saved))
+	for _, p := range saved {
+		codeToID[*p.ExternalCode] = p.ID
+		imported.productIDs = append(imported.productIDs, p.ID)
+	}
+
+	// 写入SKU
+	skuModels := make([]*model.ProductSKU, 0, len(skus))
+	withSKUs := make(map[uint]bool)
+	for _, row := range rows {
+		if row.Sku == "" {
+			continue
+		}
+		productID := codeToID[row.ProductCode]
+		withSKUs[productID] = true
+		skuModels = append(skuModels, &model.ProductSKU{
+			ProductID: productID,
+			SKU:       row.Sku,
+			Price:     row.Price,
+			Stock:     int(row.Stock),
+			Specs:     row.Specs,
+		})
+	}
+	if len(skuModels) > 0 {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "sku"}},
+			DoUpdates: clause.AssignmentColumns([]string{"product_id", "price", "stock", "specs", "updated_at", "deleted_at"}),
+		}).Create(&skuModels).Error
+		if err != nil {
+			return nil, err
+		}
+
+		// 有SKU的商品，价格取SKU最低价，库存取SKU库存之和
+		ids := make([]uint, 0, len(withSKUs))
+		for id := range withSKUs {
+			ids = append(ids, id)
+		}
+		err = tx.Exec(`UPDATE products SET
+			price = (SELECT MIN(price) FROM product_skus WHERE product_skus.product_id = products.id AND product_skus.deleted_at IS NULL),
+			stock = (SELECT SUM(stock) FROM product_skus WHERE product_skus.product_id = products.id AND product_skus.deleted_at IS NULL)
+			WHERE id IN ?`, ids).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 主分类同时写入关联表，与SetProductCategories保持一致
+	links := make([]model.ProductCategoryLink, 0, len(codes))
+	for _, code := range codes {
+		if categoryID := products[code].CategoryID; categoryID != 0 {
+			links = append(links, model.ProductCategoryLink{ProductID: codeToID[code], CategoryID: categoryID})
+		}
+	}
+	if len(links) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// 已有商品的价格（包括按SKU最低价重新计算的价格）变化时记录价格历史，与UpdateProductPrice一致
+	var current []model.Product
+	if err := tx.Unscoped().Select("id", "price").Where("id IN ?", imported.productIDs).Find(&current).Error; err != nil {
+		return nil, err
+	}
+	imported.priceChanges = importPriceChanges(oldPrices, current, now)
+	if len(imported.priceChanges) > 0 {
+		if err := tx.Create(&imported.priceChanges).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return imported, nil
+}
+
+// importPriceChanges 对比导入前后的商品价格，新建的商品没有导入前的价格，不记录
+func importPriceChanges(oldPrices map[uint]float64, current []model.Product, at time.Time) []model.ProductPriceHistory {
+	var changes []model.ProductPriceHistory
+	for _, p := range current {
+		oldPrice, ok := oldPrices[p.ID]
+		if !ok || samePrice(oldPrice, p.Price) {
+			continue
+		}
+		changes = append(changes, model.ProductPriceHistory{
+			ProductID: p.ID,
+			OldPrice:  oldPrice,
+			NewPrice:  p.Price,
+			Source:    model.PriceChangeImport,
+			ChangedAt: at,
+		})
+	}
+	return changes
+}
+
+// ensureCategoryPath 按名称路径（如 "电脑/笔记本"）查找分类，缺失的层级自动创建。
+// known缓存本次导入中已解析的路径前缀。
+func (s *ProductCatalogServiceServer) ensureCategoryPath(ctx context.Context, categoryPath string, known map[string]model.ProductCategory) (uint, error) {
+	names := strings.Split(categoryPath, "/")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+		if err := validateCategoryName(names[i]); err != nil {
+			return 0, fmt.Errorf("分类路径无效: %s", categoryPath)
+		}
+	}
+
+	parent := model.ProductCategory{Path: "/"}
+	for i, name := range names {
+		prefix := strings.Join(names[:i+1], "/")
+		if category, ok := known[prefix]; ok {
+			parent = category
+			continue
+		}
+
+		var category model.ProductCategory
+		err := s.DB.WithContext(ctx).Where("parent_id = ? AND name = ?", parent.ID, name).First(&category).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			category = model.ProductCategory{ParentID: parent.ID, Name: name}
+			err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&category).Error; err != nil {
+					return err
+				}
+				category.Path = fmt.Sprintf("%s%d/", parent.Path, category.ID)
+				return tx.Model(&category).Update("path", category.Path).Error
+			})
+			if err == nil {
+				s.invalidateCategories(ctx)
+			}
+		}
+		if err != nil {
+			return 0, fmt.Errorf("创建分类失败: %v", err)
+		}
+
+		known[prefix] = category
+		parent = category
+	}
+	return parent.ID, nil
+}
+
+// afterProductsChanged 商品批量变更后更新布隆过滤器、联想索引并清除缓存
+func (s *ProductCatalogServiceServer) afterProductsChanged(ctx context.Context, productIDs []uint) {
+	if len(productIDs) == 0 {
+		return
+	}
+
+	// 包含已删除的商品，从联想索引中移除
+	var products []model.Product
+	if err := s.DB.WithContext(ctx).Unscoped().Select("id", "name", "is_published", "deleted_at").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		log.Errorf("查询导入商品失败: %v", err)
+	}
+	s.updateProductNameIndex(ctx, products)
+	for _, p := range products {
+		if !p.DeletedAt.Valid {
+			s.trackProductID(ctx, uint32(p.ID))
+		}
+		if err := s.Cache.Invalidate(ctx, fmt.Sprintf(productCacheKey, p.ID)); err != nil {
+			log.Errorf("清除商品缓存失败: %v", err)
+		}
+	}
+	if err := s.InvalidateProductLists(ctx); err != nil {
+		log.Errorf("清除商品列表缓存失败: %v", err)
+	}
+}
+
+func normalizeImportRow(row *product.ProductRow) {
+	row.ProductCode = strings.TrimSpace(row.ProductCode)
+	row.Sku = strings.TrimSpace(row.Sku)
+	row.Name = strings.TrimSpace(row.Name)
+	row.Category = strings.Trim(strings.TrimSpace(row.Category), "/")
+	row.Specs = strings.TrimSpace(row.Specs)
+	if row.ProductCode == "" {
+		row.ProductCode = row.Sku
+	}
+	if row.Specs == "" {
+		row.Specs = "{}"
+	}
+}
+
+func validateImportRow(row *product.ProductRow) error {
+	if row.ProductCode == "" {
+		return errors.New("sku和product_code不能同时为空")
+	}
+	if len(row.ProductCode) > importCodeMaxLength || len(row.Sku) > importCodeMaxLength {
+		return fmt.Errorf("sku和product_code不能超过%d个字符", importCodeMaxLength)
+	}
+	if row.Name == "" {
+		return errors.New("商品名称不能为空")
+	}
+	if utf8.RuneCountInString(row.Name) > importNameMaxLength {
+		return fmt.Errorf("商品名称不能超过%d个字符", importNameMaxLength)
+	}
+	if row.Price <= 0 || row.Price > importMaxPrice {
+		return errors.New("价格必须大于0且不超过99999999.99")
+	}
+	if row.Stock < 0 {
+		return errors.New("库存不能为负数")
+	}
+	if row.PurchaseLimit < 0 {
+		return errors.New("限购数量不能为负数")
+	}
+	var specs map[string]interface{}
+	if err := json.Unmarshal([]byte(row.Specs), &specs); err != nil {
+		return errors.New("规格参数必须是JSON对象")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 
 	"TKMall/build/proto_gen/product"
 	"TKMall/cmd/product/model"
@@ -12,12 +13,45 @@ import (
 )
 
 func (s *ProductCatalogServiceServer) ListProducts(ctx context.Context, req *product.ListProductsReq) (*product.ListProductsResp, error) {
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 10
+	}
+	currentPage := int(req.Page)
+	if currentPage < 1 {
+		currentPage = 1
+	}
+
+	cacheKey := s.Cache.NamespaceKey(ctx, productListNamespace,
+		fmt.Sprintf("%d:%s:%d:%d", req.CategoryId, req.CategoryName, currentPage, pageSize))
+	var resp product.ListProductsResp
+	err := s.Cache.GetOrLoad(ctx, cacheKey, &resp, func(ctx context.Context) (interface{}, error) {
+		return s.queryProductList(ctx, req.CategoryId, req.CategoryName, currentPage, pageSize)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyEffectivePrices(ctx, resp.Products); err != nil {
+		return nil, status.Errorf(codes.Internal, "计算商品价格失败: %v", err)
+	}
+	return &resp, nil
+}
+
+func (s *ProductCatalogServiceServer) queryProductList(ctx context.Context, categoryID uint32, categoryName string, currentPage, pageSize int) (*product.ListProductsResp, error) {
 	// 构建查询条件
-	query := s.DB.Model(&model.Product{})
+	query := s.DB.WithContext(ctx).Model(&model.Product{})
 
-	if req.CategoryName != "" {
-		query = query.Joins("JOIN product_categories ON products.category_id = product_categories.id").
-			Where("product_categories.name = ?", req.CategoryName)
+	// 按分类筛选时包含所有子分类，商品的主分类或关联分类命中即可
+	if categoryID != 0 || categoryName != "" {
+		categoryIDs, err := s.descendantCategoryIDs(ctx, categoryID, categoryName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "查询分类失败: %v", err)
+		}
+		if len(categoryIDs) == 0 {
+			return &product.ListProductsResp{}, nil
+		}
+		linkedProducts := s.DB.WithContext(ctx).Model(&model.ProductCategoryLink{}).Select("product_id").Where("category_id IN ?", categoryIDs)
+		query = query.Where("products.category_id IN ? OR products.id IN (?)", categoryIDs, linkedProducts)
 	}
 
 	// 分页处理
@@ -26,30 +60,27 @@ func (s *ProductCatalogServiceServer) ListProducts(ctx context.Context, req *pro
 		return nil, status.Errorf(codes.Internal, "获取总数失败: %v", err)
 	}
 
-	pageSize := int(req.PageSize)
-	if pageSize <= 0 || pageSize > 100 {
-		pageSize = 10
-	}
-	currentPage := int(req.Page)
-	if currentPage < 1 {
-		currentPage = 1
-	}
 	offset := (currentPage - 1) * pageSize
 
 	var products []model.Product
-	if err := query.Offset(offset).Limit(pageSize).Find(&products).Error; err != nil {
+	if err := query.Preload("Pictures", preloadPictures).Offset(offset).Limit(pageSize).Find(&products).Error; err != nil {
 		return nil, status.Errorf(codes.Internal, "查询失败: %v", err)
 	}
 
 	// 转换为proto格式
 	var protoProducts []*product.Product
-	for _, p := range products {
-		protoProducts = append(protoProducts, &product.Product{
-			Id:          uint32(p.ID),
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       float32(p.Price),
-		})
+	for i := range products {
+		p := &products[i]
+		protoProduct := &product.Product{
+			Id:            uint32(p.ID),
+			Name:          p.Name,
+			Description:   p.Description,
+			Price:         float32(p.Price),
+			AverageRating: float32(p.AverageRating()),
+			ReviewCount:   int32(p.ReviewCount),
+		}
+		s.applyPictures(protoProduct, p)
+		protoProducts = append(protoProducts, protoProduct)
 	}
 
 	log.Debugf("query products list: %v", protoProducts)
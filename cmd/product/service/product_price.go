@@ -0,0 +1,485 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/events"
+	"TKMall/common/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 商品未结束的价格规则缓存，按时间过滤在内存中完成，只需在规则增删时失效
+	priceRulesCacheKey = "product:price_rules:%d"
+
+	defaultPriceRuleCheckInterval = 30 * time.Second
+
+	priceReasonManual    = "MANUAL"
+	priceReasonImport    = "IMPORT"
+	priceReasonRuleStart = "RULE_START"
+	priceReasonRuleEnd   = "RULE_END"
+)
+
+// UpdateProductPrice 修改商品基础价格并记录价格历史
+func (s *ProductCatalogServiceServer) UpdateProductPrice(ctx context.Context, req *product.UpdateProductPriceReq) (*product.UpdateProductPriceResp, error) {
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+	if req.Price <= 0 || float64(req.Price) > importMaxPrice {
+		return nil, status.Error(codes.InvalidArgument, "价格必须大于0且不超过99999999.99")
+	}
+	newPrice := roundPrice(float64(req.Price))
+
+	var oldPrice float64
+	now := time.Now()
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var productModel model.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "price").First(&productModel, req.ProductId).Error; err != nil {
+			return err
+		}
+		oldPrice = productModel.Price
+		if samePrice(oldPrice, newPrice) {
+			return nil
+		}
+
+		if err := tx.Model(&productModel).Update("price", newPrice).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.ProductPriceHistory{
+			ProductID: productModel.ID,
+			OldPrice:  oldPrice,
+			NewPrice:  newPrice,
+			Source:    model.PriceChangeManual,
+			ChangedAt: now,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "商品不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "修改价格失败: %v", err)
+	}
+	if samePrice(oldPrice, newPrice) {
+		return &product.UpdateProductPriceResp{}, nil
+	}
+
+	if err := s.InvalidateProduct(ctx, req.ProductId); err != nil {
+		log.Errorf("清除商品缓存失败: %v", err)
+	}
+
+	s.publishBasePriceChanges(ctx, []model.ProductPriceHistory{{
+		ProductID: uint(req.ProductId),
+		OldPrice:  oldPrice,
+		NewPrice:  newPrice,
+		ChangedAt: now,
+	}}, priceReasonManual)
+
+	return &product.UpdateProductPriceResp{}, nil
+}
+
+// CreatePriceRule 创建定时价格规则
+func (s *ProductCatalogServiceServer) CreatePriceRule(ctx context.Context, req *product.CreatePriceRuleReq) (*product.CreatePriceRuleResp, error) {
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+	if req.Price <= 0 || float64(req.Price) > importMaxPrice {
+		return nil, status.Error(codes.InvalidArgument, "价格必须大于0且不超过99999999.99")
+	}
+	startAt, endAt := time.Unix(req.StartAt, 0), time.Unix(req.EndAt, 0)
+	if req.StartAt <= 0 || !endAt.After(startAt) {
+		return nil, status.Error(codes.InvalidArgument, "结束时间必须晚于开始时间")
+	}
+	if !endAt.After(time.Now()) {
+		return nil, status.Error(codes.InvalidArgument, "结束时间必须晚于当前时间")
+	}
+
+	var productModel model.Product
+	if err := s.DB.WithContext(ctx).Select("id").First(&productModel, req.ProductId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "商品不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询商品失败: %v", err)
+	}
+
+	rule := model.ProductPriceRule{
+		ProductID: productModel.ID,
+		Name:      strings.TrimSpace(req.Name),
+		Price:     roundPrice(float64(req.Price)),
+		StartAt:   startAt,
+		EndAt:     endAt,
+	}
+	if err := s.DB.WithContext(ctx).Create(&rule).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "创建价格规则失败: %v", err)
+	}
+	s.invalidatePriceRules(ctx, req.ProductId)
+
+	return &product.CreatePriceRuleResp{
+		Rule: convertToProtoPriceRule(&rule),
+	}, nil
+}
+
+// DeletePriceRule 删除价格规则。未开始的规则直接删除；进行中的规则提前结束，保留记录以便查询历史价格。
+func (s *ProductCatalogServiceServer) DeletePriceRule(ctx context.Context, req *product.DeletePriceRuleReq) (*product.DeletePriceRuleResp, error) {
+	if req.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "规则ID不能为空")
+	}
+
+	var rule model.ProductPriceRule
+	if err := s.DB.WithContext(ctx).First(&rule, req.Id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "价格规则不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询价格规则失败: %v", err)
+	}
+
+	now := time.Now()
+	var err error
+	switch {
+	case rule.StartAt.After(now):
+		err = s.DB.WithContext(ctx).Delete(&rule).Error
+	case rule.EndAt.After(now):
+		// 结束事件由定时任务发布
+		err = s.DB.WithContext(ctx).Model(&rule).Update("end_at", now).Error
+	default:
+		return nil, status.Error(codes.FailedPrecondition, "价格规则已结束")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "删除价格规则失败: %v", err)
+	}
+	s.invalidatePriceRules(ctx, uint32(rule.ProductID))
+
+	return &product.DeletePriceRuleResp{}, nil
+}
+
+// ListPriceRules 查询商品的价格规则，默认只返回未结束的规则
+func (s *ProductCatalogServiceServer) ListPriceRules(ctx context.Context, req *product.ListPriceRulesReq) (*product.ListPriceRulesResp, error) {
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	query := s.DB.WithContext(ctx).Where("product_id = ?", req.ProductId)
+	if !req.IncludeExpired {
+		query = query.Where("end_at > ?", time.Now())
+	}
+	var rules []model.ProductPriceRule
+	if err := query.Order("start_at ASC, id ASC").Find(&rules).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询价格规则失败: %v", err)
+	}
+
+	protoRules := make([]*product.PriceRule, 0, len(rules))
+	for i := range rules {
+		protoRules = append(protoRules, convertToProtoPriceRule(&rules[i]))
+	}
+	return &product.ListPriceRulesResp{Rules: protoRules}, nil
+}
+
+// ListPriceHistory 查询商品基础价格的变更历史
+func (s *ProductCatalogServiceServer) ListPriceHistory(ctx context.Context, req *product.ListPriceHistoryReq) (*product.ListPriceHistoryResp, error) {
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+	page := int(req.Page)
+	if page < 1 {
+		page = 1
+	}
+
+	query := s.DB.WithContext(ctx).Model(&model.ProductPriceHistory{}).Where("product_id = ?", req.ProductId)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "获取总数失败: %v", err)
+	}
+	var history []model.ProductPriceHistory
+	if err := query.Order("changed_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&history).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询价格历史失败: %v", err)
+	}
+
+	changes := make([]*product.PriceChange, 0, len(history))
+	for _, h := range history {
+		changes = append(changes, &product.PriceChange{
+			OldPrice:  float32(h.OldPrice),
+			NewPrice:  float32(h.NewPrice),
+			Source:    string(h.Source),
+			ChangedAt: h.ChangedAt.Unix(),
+		})
+	}
+	return &product.ListPriceHistoryResp{Changes: changes, Total: total}, nil
+}
+
+// applyEffectivePrice 填充指定时间的实际售价。at为零值时按当前时间计算，使用缓存的价格规则；
+// 指定历史时间时按当时的基础价格和规则计算。
+func (s *ProductCatalogServiceServer) applyEffectivePrice(ctx context.Context, protoProduct *product.Product, at time.Time) error {
+	var price float64
+	var rule *model.ProductPriceRule
+	if at.IsZero() {
+		rules, err := s.activePriceRules(ctx, protoProduct.Id)
+		if err != nil {
+			return err
+		}
+		price, rule = effectivePrice(float64(protoProduct.Price), rules, time.Now())
+	} else {
+		var err error
+		if price, rule, err = s.priceAt(ctx, protoProduct.Id, at); err != nil {
+			return err
+		}
+	}
+
+	protoProduct.EffectivePrice = float32(price)
+	protoProduct.SaleEndsAt = 0
+	if rule != nil {
+		protoProduct.SaleEndsAt = rule.EndAt.Unix()
+	}
+	return nil
+}
+
+// applyEffectivePrices 填充列表中商品当前的实际售价。列表缓存的是基础价格，读取缓存后再按价格规则计算
+func (s *ProductCatalogServiceServer) applyEffectivePrices(ctx context.Context, products []*product.Product) error {
+	for _, protoProduct := range products {
+		if err := s.applyEffectivePrice(ctx, protoProduct, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishBasePriceChanges 基础价格变化后按当前的价格规则发布实际售价的变更事件
+func (s *ProductCatalogServiceServer) publishBasePriceChanges(ctx context.Context, changes []model.ProductPriceHistory, reason string) {
+	for _, change := range changes {
+		// 有价格规则生效时，基础价格变化不影响实际售价
+		rules, err := s.activePriceRules(ctx, uint32(change.ProductID))
+		if err != nil {
+			log.Errorf("查询价格规则失败: %v", err)
+		}
+		oldEffective, _ := effectivePrice(change.OldPrice, rules, change.ChangedAt)
+		newEffective, _ := effectivePrice(change.NewPrice, rules, change.ChangedAt)
+		s.publishPriceChanged(uint32(change.ProductID), oldEffective, newEffective, reason, 0, change.ChangedAt)
+	}
+}
+
+// activePriceRules 通过缓存读取商品未结束的价格规则
+func (s *ProductCatalogServiceServer) activePriceRules(ctx context.Context, productID uint32) ([]model.ProductPriceRule, error) {
+	var rules []model.ProductPriceRule
+	err := s.Cache.GetOrLoad(ctx, fmt.Sprintf(priceRulesCacheKey, productID), &rules, func(ctx context.Context) (interface{}, error) {
+		var loaded []model.ProductPriceRule
+		err := s.DB.WithContext(ctx).
+			Where("product_id = ? AND end_at > ?", productID, time.Now()).
+			Find(&loaded).Error
+		return loaded, err
+	})
+	return rules, err
+}
+
+func (s *ProductCatalogServiceServer) invalidatePriceRules(ctx context.Context, productID uint32) {
+	if err := s.Cache.Invalidate(ctx, fmt.Sprintf(priceRulesCacheKey, productID)); err != nil {
+		log.Errorf("清除价格规则缓存失败: %v", err)
+	}
+}
+
+// priceAt 从数据库计算商品在指定时间的实际售价
+func (s *ProductCatalogServiceServer) priceAt(ctx context.Context, productID uint32, at time.Time) (float64, *model.ProductPriceRule, error) {
+	base, err := s.basePriceAt(ctx, productID, at)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var rules []model.ProductPriceRule
+	err = s.DB.WithContext(ctx).
+		Where("product_id = ? AND start_at <= ? AND end_at > ?", productID, at, at).
+		Find(&rules).Error
+	if err != nil {
+		return 0, nil, err
+	}
+	price, rule := effectivePrice(base, rules, at)
+	return price, rule, nil
+}
+
+// basePriceAt 根据价格历史推算指定时间的基础价格
+func (s *ProductCatalogServiceServer) basePriceAt(ctx context.Context, productID uint32, at time.Time) (float64, error) {
+	var before model.ProductPriceHistory
+	err := s.DB.WithContext(ctx).
+		Where("product_id = ? AND changed_at <= ?", productID, at).
+		Order("changed_at DESC, id DESC").First(&before).Error
+	if err == nil {
+		return before.NewPrice, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	// 该时间之前没有调价记录，取之后第一次调价前的价格
+	var after model.ProductPriceHistory
+	err = s.DB.WithContext(ctx).
+		Where("product_id = ? AND changed_at > ?", productID, at).
+		Order("changed_at ASC, id ASC").First(&after).Error
+	if err == nil {
+		return after.OldPrice, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	// 从未调价，就是当前价格
+	var productModel model.Product
+	if err := s.DB.WithContext(ctx).Select("id", "price").First(&productModel, productID).Error; err != nil {
+		return 0, err
+	}
+	return productModel.Price, nil
+}
+
+// RunPriceRuleScheduler 定期检查价格规则的开始和结束，发布价格变更事件。
+// 多副本同时运行时通过条件更新抢占，每个变更只发布一次。
+func (s *ProductCatalogServiceServer) RunPriceRuleScheduler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultPriceRuleCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.processPriceRuleTransitions(ctx, time.Now()); err != nil {
+				log.Errorf("处理价格规则失败: %v", err)
+			}
+		}
+	}
+}
+
+func (s *ProductCatalogServiceServer) processPriceRuleTransitions(ctx context.Context, now time.Time) error {
+	// 已开始但未结束的规则
+	var starting []model.ProductPriceRule
+	if err := s.DB.WithContext(ctx).
+		Where("start_notified = ? AND start_at <= ? AND end_at > ?", false, now, now).
+		Find(&starting).Error; err != nil {
+		return err
+	}
+	for _, rule := range starting {
+		claimed, err := s.claimPriceRule(ctx, rule.ID, map[string]interface{}{"start_notified": true}, "start_notified")
+		if err != nil {
+			return err
+		}
+		if claimed {
+			s.publishPriceTransition(ctx, &rule, rule.StartAt, priceReasonRuleStart)
+		}
+	}
+
+	// 已结束的规则，两次检查之间开始又结束的规则只发布结束事件
+	var ending []model.ProductPriceRule
+	if err := s.DB.WithContext(ctx).
+		Where("end_notified = ? AND end_at <= ?", false, now).
+		Find(&ending).Error; err != nil {
+		return err
+	}
+	for _, rule := range ending {
+		claimed, err := s.claimPriceRule(ctx, rule.ID, map[string]interface{}{"start_notified": true, "end_notified": true}, "end_notified")
+		if err != nil {
+			return err
+		}
+		if claimed {
+			s.publishPriceTransition(ctx, &rule, rule.EndAt, priceReasonRuleEnd)
+		}
+	}
+	return nil
+}
+
+// claimPriceRule 将flag从false更新为true，返回是否由当前副本抢占成功
+func (s *ProductCatalogServiceServer) claimPriceRule(ctx context.Context, ruleID uint, updates map[string]interface{}, flag string) (bool, error) {
+	result := s.DB.WithContext(ctx).Model(&model.ProductPriceRule{}).
+		Where("id = ? AND "+flag+" = ?", ruleID, false).
+		Updates(updates)
+	return result.RowsAffected == 1, result.Error
+}
+
+// publishPriceTransition 比较规则变化前后的实际售价，有变化时发布事件
+func (s *ProductCatalogServiceServer) publishPriceTransition(ctx context.Context, rule *model.ProductPriceRule, at time.Time, reason string) {
+	productID := uint32(rule.ProductID)
+	oldPrice, _, err := s.priceAt(ctx, productID, at.Add(-time.Millisecond))
+	if err != nil {
+		log.Errorf("计算商品%d价格失败: %v", productID, err)
+		return
+	}
+	newPrice, _, err := s.priceAt(ctx, productID, at)
+	if err != nil {
+		log.Errorf("计算商品%d价格失败: %v", productID, err)
+		return
+	}
+	s.publishPriceChanged(productID, oldPrice, newPrice, reason, uint32(rule.ID), at)
+}
+
+func (s *ProductCatalogServiceServer) publishPriceChanged(productID uint32, oldPrice, newPrice float64, reason string, ruleID uint32, changedAt time.Time) {
+	if s.EventBus == nil || samePrice(oldPrice, newPrice) {
+		return
+	}
+	event := events.Event{
+		Type: events.ProductPriceChanged,
+		Payload: events.ProductPriceChangedPayload{
+			ProductID: productID,
+			OldPrice:  oldPrice,
+			NewPrice:  newPrice,
+			Reason:    reason,
+			RuleID:    ruleID,
+			ChangedAt: changedAt,
+		},
+		Timestamp: time.Now(),
+	}
+
+	// 异步发布事件
+	go func() {
+		if err := s.EventBus.Publish(context.Background(), event); err != nil {
+			log.Warnf("发布价格变更事件失败: %v", err)
+		}
+	}()
+}
+
+// effectivePrice 计算指定时间的实际售价：生效中的规则里开始时间最晚的优先，没有规则时为基础价格
+func effectivePrice(base float64, rules []model.ProductPriceRule, at time.Time) (float64, *model.ProductPriceRule) {
+	var active *model.ProductPriceRule
+	for i := range rules {
+		rule := &rules[i]
+		if at.Before(rule.StartAt) || !at.Before(rule.EndAt) {
+			continue
+		}
+		if active == nil || rule.StartAt.After(active.StartAt) ||
+			(rule.StartAt.Equal(active.StartAt) && rule.ID > active.ID) {
+			active = rule
+		}
+	}
+	if active == nil {
+		return base, nil
+	}
+	return active.Price, active
+}
+
+func roundPrice(price float64) float64 {
+	return math.Round(price*100) / 100
+}
+
+func samePrice(a, b float64) bool {
+	return math.Round(a*100) == math.Round(b*100)
+}
+
+func convertToProtoPriceRule(r *model.ProductPriceRule) *product.PriceRule {
+	return &product.PriceRule{
+		Id:        uint32(r.ID),
+		ProductId: uint32(r.ProductID),
+		Name:      r.Name,
+		Price:     float32(r.Price),
+		StartAt:   r.StartAt.Unix(),
+		EndAt:     r.EndAt.Unix(),
+	}
+}
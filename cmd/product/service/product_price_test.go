@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"TKMall/cmd/product/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试价格规则叠加时的实际售价
+func TestEffectivePrice(t *testing.T) {
+	base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rule := func(id uint, price float64, startHour, endHour int) model.ProductPriceRule {
+		r := model.ProductPriceRule{
+			ProductID: 1,
+			Price:     price,
+			StartAt:   base.Add(time.Duration(startHour) * time.Hour),
+			EndAt:     base.Add(time.Duration(endHour) * time.Hour),
+		}
+		r.ID = id
+		return r
+	}
+	rules := []model.ProductPriceRule{
+		rule(1, 80, 0, 48),  // 长期促销
+		rule(2, 60, 10, 12), // 限时秒杀，覆盖长期促销
+		rule(3, 70, 10, 20), // 与秒杀同时开始，ID较大优先
+	}
+
+	tests := []struct {
+		name     string
+		hour     float64
+		want     float64
+		wantRule uint
+	}{
+		{"规则开始前", -1, 100, 0},
+		{"开始时刻生效", 0, 80, 1},
+		{"同时开始取ID较大的", 11, 70, 3},
+		{"结束时刻不再生效", 20, 80, 1},
+		{"全部结束", 48, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := base.Add(time.Duration(tt.hour * float64(time.Hour)))
+			price, active := effectivePrice(100, rules, at)
+			assert.Equal(t, tt.want, price)
+			if tt.wantRule == 0 {
+				assert.Nil(t, active)
+			} else if assert.NotNil(t, active) {
+				assert.Equal(t, tt.wantRule, active.ID)
+			}
+		})
+	}
+
+	assert.True(t, samePrice(19.999, 20))
+	assert.False(t, samePrice(19.98, 19.99))
+}
+
+// 测试导入只为价格变化的已有商品记录价格历史
+func TestImportPriceChanges(t *testing.T) {
+	at := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	product := func(id uint, price float64) model.Product {
+		p := model.Product{Price: price}
+		p.ID = id
+		return p
+	}
+	oldPrices := map[uint]float64{1: 100, 2: 50}
+	current := []model.Product{
+		product(1, 89.9), // 导入新价格，或SKU最低价变化
+		product(2, 50),   // 价格不变
+		product(3, 20),   // 新建的商品
+	}
+
+	changes := importPriceChanges(oldPrices, current, at)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, uint(1), changes[0].ProductID)
+		assert.Equal(t, 100.0, changes[0].OldPrice)
+		assert.Equal(t, 89.9, changes[0].NewPrice)
+		assert.Equal(t, model.PriceChangeImport, changes[0].Source)
+		assert.Equal(t, at, changes[0].ChangedAt)
+	}
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"TKMall/build/proto_gen/order"
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/log"
+	"TKMall/common/proxy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	reviewMinRating        = 1
+	reviewMaxRating        = 5
+	reviewContentMaxLength = 1000
+)
+
+// CreateReview 发表评价，需要有包含该商品的已送达订单。新评价进入待审核状态，审核通过后计入商品评分。
+func (s *ProductCatalogServiceServer) CreateReview(ctx context.Context, req *product.CreateReviewReq) (*product.CreateReviewResp, error) {
+	// 参数校验，用户ID由网关按登录令牌填写，为0说明请求没有经过登录认证
+	if req.UserId == 0 {
+		return nil, status.Error(codes.Unauthenticated, "请先登录后再发表评价")
+	}
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+	if req.Rating < reviewMinRating || req.Rating > reviewMaxRating {
+		return nil, status.Errorf(codes.InvalidArgument, "评分必须在%d到%d之间", reviewMinRating, reviewMaxRating)
+	}
+	content := strings.TrimSpace(req.Content)
+	if utf8.RuneCountInString(content) > reviewContentMaxLength {
+		return nil, status.Errorf(codes.InvalidArgument, "评价内容不能超过%d个字符", reviewContentMaxLength)
+	}
+
+	var productModel model.Product
+	if err := s.DB.WithContext(ctx).Select("id").First(&productModel, req.ProductId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "商品不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询商品失败: %v", err)
+	}
+
+	var count int64
+	if err := s.DB.WithContext(ctx).Model(&model.ProductReview{}).
+		Where("product_id = ? AND user_id = ?", req.ProductId, req.UserId).
+		Count(&count).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询评价失败: %v", err)
+	}
+	if count > 0 {
+		return nil, status.Error(codes.AlreadyExists, "已评价过该商品")
+	}
+
+	// 通过订单服务校验购买资格
+	orderID, err := s.deliveredOrderID(ctx, req.UserId, req.ProductId)
+	if err != nil {
+		return nil, err
+	}
+
+	review := model.ProductReview{
+		ProductID: productModel.ID,
+		UserID:    req.UserId,
+		OrderID:   orderID,
+		Rating:    int(req.Rating),
+		Content:   content,
+		Status:    model.ReviewStatusPending,
+	}
+	if err := s.DB.WithContext(ctx).Create(&review).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "保存评价失败: %v", err)
+	}
+
+	return &product.CreateReviewResp{
+		Review: convertToProtoReview(&review),
+	}, nil
+}
+
+// ListReviews 查询商品审核通过的评价
+func (s *ProductCatalogServiceServer) ListReviews(ctx context.Context, req *product.ListReviewsReq) (*product.ListReviewsResp, error) {
+	if req.ProductId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "商品ID不能为空")
+	}
+
+	var productModel model.Product
+	if err := s.DB.WithContext(ctx).Select("id", "review_count", "rating_sum").First(&productModel, req.ProductId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "商品不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "查询商品失败: %v", err)
+	}
+
+	resp, err := s.queryReviews(ctx, model.ReviewStatusApproved, req.ProductId, req.Page, req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	resp.AverageRating = float32(productModel.AverageRating())
+	resp.ReviewCount = int32(productModel.ReviewCount)
+	return resp, nil
+}
+
+// ListReviewsByStatus 管理端按审核状态查询评价
+func (s *ProductCatalogServiceServer) ListReviewsByStatus(ctx context.Context, req *product.ListReviewsByStatusReq) (*product.ListReviewsResp, error) {
+	reviewStatus := model.ReviewStatus(req.Status)
+	if reviewStatus == "" {
+		reviewStatus = model.ReviewStatusPending
+	}
+	if !isValidReviewStatus(reviewStatus) {
+		return nil, status.Errorf(codes.InvalidArgument, "无效的审核状态: %s", req.Status)
+	}
+	return s.queryReviews(ctx, reviewStatus, req.ProductId, req.Page, req.PageSize)
+}
+
+// ModerateReview 审核评价。状态进入或离开APPROVED时增量更新商品的评价数和评分总和。
+func (s *ProductCatalogServiceServer) ModerateReview(ctx context.Context, req *product.ModerateReviewReq) (*product.ModerateReviewResp, error) {
+	if req.ReviewId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "评价ID不能为空")
+	}
+	newStatus := model.ReviewStatus(req.Status)
+	if newStatus != model.ReviewStatusApproved && newStatus != model.ReviewStatusRejected {
+		return nil, status.Error(codes.InvalidArgument, "审核状态只能为APPROVED或REJECTED")
+	}
+
+	var review model.ProductReview
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 锁定评价，避免并发审核重复计数
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&review, req.ReviewId).Error; err != nil {
+			return err
+		}
+		oldStatus := review.Status
+		if oldStatus == newStatus {
+			return nil
+		}
+
+		if err := tx.Model(&review).Update("status", newStatus).Error; err != nil {
+			return err
+		}
+		review.Status = newStatus
+
+		delta := reviewCountDelta(oldStatus, newStatus)
+		if delta == 0 {
+			return nil
+		}
+		return tx.Model(&model.Product{}).Where("id = ?", review.ProductID).Updates(map[string]interface{}{
+			"review_count": gorm.Expr("review_count + ?", delta),
+			"rating_sum":   gorm.Expr("rating_sum + ?", delta*review.Rating),
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "评价不存在")
+		}
+		return nil, status.Errorf(codes.Internal, "审核评价失败: %v", err)
+	}
+
+	if err := s.InvalidateProduct(ctx, uint32(review.ProductID)); err != nil {
+		log.Errorf("清除商品缓存失败: %v", err)
+	}
+
+	return &product.ModerateReviewResp{
+		Review: convertToProtoReview(&review),
+	}, nil
+}
+
+// deliveredOrderID 调用订单服务查询用户包含该商品的已送达订单。
+// 不使用代理的响应缓存，否则订单送达后的几分钟内仍然返回未送达
+func (s *ProductCatalogServiceServer) deliveredOrderID(ctx context.Context, userID int64, productID uint32) (string, error) {
+	respInterface, err := s.Proxy.Call(proxy.WithoutCache(ctx), "order", "HasDeliveredOrder", &order.HasDeliveredOrderReq{
+		UserId:    userID,
+		ProductId: productID,
+	})
+	if err != nil {
+		return "", status.Errorf(codes.Unavailable, "查询订单失败: %v", err)
+	}
+
+	resp, ok := respInterface.(*order.HasDeliveredOrderResp)
+	if !ok {
+		return "", status.Error(codes.Internal, "响应类型转换失败")
+	}
+	if !resp.Delivered {
+		return "", status.Error(codes.PermissionDenied, "只有购买并签收商品后才能评价")
+	}
+	return resp.OrderId, nil
+}
+
+func (s *ProductCatalogServiceServer) queryReviews(ctx context.Context, reviewStatus model.ReviewStatus, productID uint32, page, pageSize int32) (*product.ListReviewsResp, error) {
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	query := s.DB.WithContext(ctx).Model(&model.ProductReview{}).Where("status = ?", reviewStatus)
+	if productID != 0 {
+		query = query.Where("product_id = ?", productID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "获取总数失败: %v", err)
+	}
+
+	var reviews []model.ProductReview
+	if err := query.Order("created_at DESC, id DESC").
+		Offset(int((page - 1) * pageSize)).Limit(int(pageSize)).
+		Find(&reviews).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "查询评价失败: %v", err)
+	}
+
+	protoReviews := make([]*product.Review, 0, len(reviews))
+	for i := range reviews {
+		protoReviews = append(protoReviews, convertToProtoReview(&reviews[i]))
+	}
+	return &product.ListReviewsResp{
+		Reviews: protoReviews,
+		Total:   total,
+	}, nil
+}
+
+// reviewCountDelta 返回审核状态变化对商品评价计数的影响：进入APPROVED为+1，离开为-1
+func reviewCountDelta(oldStatus, newStatus model.ReviewStatus) int {
+	switch {
+	case oldStatus != model.ReviewStatusApproved && newStatus == model.ReviewStatusApproved:
+		return 1
+	case oldStatus == model.ReviewStatusApproved && newStatus != model.ReviewStatusApproved:
+		return -1
+	default:
+		return 0
+	}
+}
+
+func isValidReviewStatus(s model.ReviewStatus) bool {
+	switch s {
+	case model.ReviewStatusPending, model.ReviewStatusApproved, model.ReviewStatusRejected:
+		return true
+	}
+	return false
+}
+
+func convertToProtoReview(r *model.ProductReview) *product.Review {
+	return &product.Review{
+		Id:        uint32(r.ID),
+		ProductId: uint32(r.ProductID),
+		UserId:    r.UserID,
+		Rating:    int32(r.Rating),
+		Content:   r.Content,
+		Status:    string(r.Status),
+		CreatedAt: r.CreatedAt.Unix(),
+	}
+}
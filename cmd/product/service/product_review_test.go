@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/order"
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/cache"
+	"TKMall/common/proxy"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// orderProxy 模拟订单服务的已送达订单查询
+type orderProxy struct {
+	delivered *order.HasDeliveredOrderResp
+	calls     int
+}
+
+func (p *orderProxy) Call(ctx context.Context, service, method string, req interface{}) (interface{}, error) {
+	p.calls++
+	return p.delivered, nil
+}
+
+func (p *orderProxy) AsyncCall(ctx context.Context, service, method string, req interface{}) (<-chan proxy.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+func newReviewTestServer(t *testing.T, orders *orderProxy) (*ProductCatalogServiceServer, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &ProductCatalogServiceServer{
+		DB:    db,
+		Proxy: orders,
+		Cache: cache.New(client, cache.Options{TTL: time.Minute}),
+	}, mock
+}
+
+// expectReviewEligibility 期望查询商品和用户是否已评价过该商品
+func expectReviewEligibility(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT `id` FROM `products`").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM `product_reviews`").
+		WithArgs(7, int64(1001)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+}
+
+// 测试评价流程：订单送达前不能评价，送达后评价进入待审核，审核通过后计入商品评分
+func TestReviewFlow(t *testing.T) {
+	orders := &orderProxy{delivered: &order.HasDeliveredOrderResp{}}
+	s, mock := newReviewTestServer(t, orders)
+	ctx := context.Background()
+	req := &product.CreateReviewReq{UserId: 1001, ProductId: 7, Rating: 4, Content: " 物流很快 "}
+
+	_, err := s.CreateReview(ctx, &product.CreateReviewReq{ProductId: 7, Rating: 4})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "未登录的请求不能评价")
+
+	expectReviewEligibility(mock)
+	_, err = s.CreateReview(ctx, req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "%v", err)
+
+	// 订单送达后再次评价，重新向订单服务查询
+	orders.delivered = &order.HasDeliveredOrderResp{Delivered: true, OrderId: "ORD-1"}
+	expectReviewEligibility(mock)
+	mock.ExpectExec("INSERT INTO `product_reviews`").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 7, int64(1001), "ORD-1", 4, "物流很快", model.ReviewStatusPending).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	created, err := s.CreateReview(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), created.Review.Id)
+	assert.Equal(t, string(model.ReviewStatusPending), created.Review.Status)
+	assert.Equal(t, 2, orders.calls)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `product_reviews` WHERE .* FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "user_id", "order_id", "rating", "status"}).
+			AddRow(3, 7, 1001, "ORD-1", 4, model.ReviewStatusPending))
+	mock.ExpectExec("UPDATE `product_reviews` SET `status`=\\?").
+		WithArgs(model.ReviewStatusApproved, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `products` SET `rating_sum`=rating_sum \\+ \\?,`review_count`=review_count \\+ \\?").
+		WithArgs(4, 1, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	moderated, err := s.ModerateReview(ctx, &product.ModerateReviewReq{ReviewId: 3, Status: string(model.ReviewStatusApproved)})
+	require.NoError(t, err)
+	assert.Equal(t, string(model.ReviewStatusApproved), moderated.Review.Status)
+
+	// 重复审核为相同状态时不重复计数
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `product_reviews` WHERE .* FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "status"}).AddRow(3, 7, model.ReviewStatusApproved))
+	mock.ExpectCommit()
+	_, err = s.ModerateReview(ctx, &product.ModerateReviewReq{ReviewId: 3, Status: string(model.ReviewStatusApproved)})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试审核状态变化对评价计数的影响
+func TestReviewCountDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to model.ReviewStatus
+		want     int
+	}{
+		{"待审核到通过", model.ReviewStatusPending, model.ReviewStatusApproved, 1},
+		{"拒绝后改为通过", model.ReviewStatusRejected, model.ReviewStatusApproved, 1},
+		{"通过后撤回", model.ReviewStatusApproved, model.ReviewStatusRejected, -1},
+		{"待审核到拒绝", model.ReviewStatusPending, model.ReviewStatusRejected, 0},
+		{"状态不变", model.ReviewStatusApproved, model.ReviewStatusApproved, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, reviewCountDelta(tt.from, tt.to))
+		})
+	}
+
+	p := model.Product{ReviewCount: 3, RatingSum: 13}
+	assert.InDelta(t, 4.333, p.AverageRating(), 0.001)
+	assert.Equal(t, 0.0, (&model.Product{}).AverageRating(), "没有评价时平均分为0")
+}
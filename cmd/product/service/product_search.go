@@ -20,14 +20,33 @@ func (s *ProductCatalogServiceServer) SearchProducts(ctx context.Context, req *p
 		return nil, status.Error(codes.InvalidArgument, "搜索关键词不能为空")
 	}
 
+	// 记录搜索词，用于热门搜索联想
+	s.recordSearchTerm(ctx, req.Query)
+
+	keyword := normalizeSuggestTerm(req.Query)
+	cacheKey := s.Cache.NamespaceKey(ctx, productSearchNamespace, keyword)
+	var resp product.SearchProductsResp
+	err := s.Cache.GetOrLoad(ctx, cacheKey, &resp, func(ctx context.Context) (interface{}, error) {
+		return s.querySearchResults(ctx, keyword)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyEffectivePrices(ctx, resp.Results); err != nil {
+		return nil, status.Errorf(codes.Internal, "计算商品价格失败: %v", err)
+	}
+	return &resp, nil
+}
+
+func (s *ProductCatalogServiceServer) querySearchResults(ctx context.Context, keyword string) (*product.SearchProductsResp, error) {
 	// 构建搜索查询
-	searchTerm := fmt.Sprintf("%%%s%%", strings.ToLower(req.Query))
-	query := s.DB.Model(&model.Product{}).
+	searchTerm := fmt.Sprintf("%%%s%%", strings.ToLower(keyword))
+	query := s.DB.WithContext(ctx).Model(&model.Product{}).
 		Where("LOWER(name) LIKE ? OR LOWER(description) LIKE ?", searchTerm, searchTerm)
 
 	// 执行查询
 	var products []model.Product
-	if err := query.Find(&products).Error; err != nil {
+	if err := query.Preload("Pictures", preloadPictures).Find(&products).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return &product.SearchProductsResp{Results: []*product.Product{}}, nil
 		}
@@ -36,14 +55,18 @@ func (s *ProductCatalogServiceServer) SearchProducts(ctx context.Context, req *p
 
 	// 转换结果
 	var results []*product.Product
-	for _, p := range products {
-		results = append(results, &product.Product{
-			Id:          uint32(p.ID),
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       float32(p.Price),
-			Picture:     p.Images,
-		})
+	for i := range products {
+		p := &products[i]
+		result := &product.Product{
+			Id:            uint32(p.ID),
+			Name:          p.Name,
+			Description:   p.Description,
+			Price:         float32(p.Price),
+			AverageRating: float32(p.AverageRating()),
+			ReviewCount:   int32(p.ReviewCount),
+		}
+		s.applyPictures(result, p)
+		results = append(results, result)
 	}
 
 	return &product.SearchProductsResp{
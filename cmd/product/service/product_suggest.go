@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+	"TKMall/common/log"
+
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// 商品名称索引：score均为0的有序集合，按字典序做前缀匹配
+	// 成员格式为 "小写名称\x00原始名称\x00商品ID"，保证大小写不敏感的同时能还原展示名称，同名商品各占一项
+	suggestProductNamesKey = "suggest:product_names"
+	// 商品ID到名称索引成员的哈希，商品改名、下架或删除时按此移除旧的成员
+	suggestProductMembersKey = "suggest:product_members"
+	// 热门搜索词：每个前缀一个按搜索次数计分的有序集合，只保留次数最多的若干个搜索词
+	suggestQueryPrefixKey = "suggest:queries:%s"
+	// 按前缀缓存联想结果
+	suggestCacheKey        = "suggest:cache:%s"
+	suggestCacheExpiration = time.Minute
+
+	suggestDefaultLimit = 10
+	suggestMaxLimit     = 20
+	// 每个前缀保留的搜索词数量，多于返回的条数，新出现的搜索词有机会累计次数
+	suggestQueryPrefixCapacity = 200
+	// 只为前若干个字符建立前缀集合，更长的前缀在最长的前缀集合中过滤
+	suggestMaxPrefixLength = 16
+	// 一段时间没有搜索的前缀集合过期
+	suggestQueryExpiration = 30 * 24 * time.Hour
+	// 过长的搜索词不计入热门搜索
+	suggestMaxTermLength = 64
+	// 重建索引时每批写入的商品数量
+	suggestIndexBatchSize = 500
+)
+
+// SuggestProducts 根据输入前缀返回商品名称和热门搜索词
+func (s *ProductCatalogServiceServer) SuggestProducts(ctx context.Context, req *product.SuggestProductsReq) (*product.SuggestProductsResp, error) {
+	prefix := normalizeSuggestTerm(req.Q)
+	if prefix == "" {
+		return nil, status.Error(codes.InvalidArgument, "联想前缀不能为空")
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = suggestDefaultLimit
+	}
+	if limit > suggestMaxLimit {
+		limit = suggestMaxLimit
+	}
+
+	// 尝试从前缀缓存获取
+	cacheKey := fmt.Sprintf(suggestCacheKey, prefix)
+	if cached, err := s.Redis.Get(ctx, cacheKey).Result(); err == nil {
+		var resp product.SuggestProductsResp
+		if err := json.Unmarshal([]byte(cached), &resp); err == nil {
+			return truncateSuggestions(&resp, limit), nil
+		}
+	}
+
+	names, err := s.suggestProductNames(ctx, prefix, suggestMaxLimit)
+	if err != nil {
+		log.Errorf("查询商品名称联想失败: %v", err)
+		return nil, status.Error(codes.Internal, "联想服务暂时不可用")
+	}
+
+	queries, err := s.suggestPopularQueries(ctx, prefix, suggestMaxLimit)
+	if err != nil {
+		log.Errorf("查询热门搜索联想失败: %v", err)
+		return nil, status.Error(codes.Internal, "联想服务暂时不可用")
+	}
+
+	resp := &product.SuggestProductsResp{
+		ProductNames:   names,
+		PopularQueries: queries,
+	}
+
+	// 缓存按最大条数计算的结果，不同limit的请求可以共用
+	if data, err := json.Marshal(resp); err == nil {
+		if err := s.Redis.Set(ctx, cacheKey, data, suggestCacheExpiration).Err(); err != nil {
+			log.Errorf("写入联想缓存失败: %v", err)
+		}
+	}
+
+	return truncateSuggestions(resp, limit), nil
+}
+
+// RebuildSuggestIndex 用已发布商品的名称重建联想索引
+func (s *ProductCatalogServiceServer) RebuildSuggestIndex(ctx context.Context) error {
+	// 先写入临时key，完成后再原子替换，避免重建过程中联想结果为空
+	tmpNamesKey := suggestProductNamesKey + ":rebuilding"
+	tmpMembersKey := suggestProductMembersKey + ":rebuilding"
+	if err := s.Redis.Del(ctx, tmpNamesKey, tmpMembersKey).Err(); err != nil {
+		return fmt.Errorf("清理临时索引失败: %w", err)
+	}
+
+	var products []model.Product
+	err := s.DB.Model(&model.Product{}).
+		Select("id", "name").
+		Where("is_published = ?", true).
+		FindInBatches(&products, suggestIndexBatchSize, func(tx *gorm.DB, batch int) error {
+			if len(products) == 0 {
+				return nil
+			}
+			names := make([]*redis.Z, 0, len(products))
+			members := make(map[string]interface{}, len(products))
+			for _, p := range products {
+				member := suggestNameMember(&p)
+				names = append(names, &redis.Z{Score: 0, Member: member})
+				members[strconv.FormatUint(uint64(p.ID), 10)] = member
+			}
+			pipe := s.Redis.TxPipeline()
+			pipe.ZAdd(ctx, tmpNamesKey, names...)
+			pipe.HSet(ctx, tmpMembersKey, members)
+			_, err := pipe.Exec(ctx)
+			return err
+		}).Error
+	if err != nil {
+		return fmt.Errorf("构建商品名称索引失败: %w", err)
+	}
+
+	exists, err := s.Redis.Exists(ctx, tmpNamesKey).Result()
+	if err != nil {
+		return fmt.Errorf("检查临时索引失败: %w", err)
+	}
+	pipe := s.Redis.TxPipeline()
+	if exists == 0 {
+		// 没有已发布商品
+		pipe.Del(ctx, suggestProductNamesKey, suggestProductMembersKey)
+	} else {
+		pipe.Rename(ctx, tmpNamesKey, suggestProductNamesKey)
+		pipe.Rename(ctx, tmpMembersKey, suggestProductMembersKey)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// recordSearchTerm 记录一次搜索，用于热门搜索词联想。
+// 搜索词计入它的每个前缀的集合，集合超过容量时移除次数最少的搜索词
+func (s *ProductCatalogServiceServer) recordSearchTerm(ctx context.Context, query string) {
+	term := normalizeSuggestTerm(query)
+	if term == "" || utf8.RuneCountInString(term) > suggestMaxTermLength {
+		return
+	}
+
+	runes := []rune(term)
+	pipe := s.Redis.Pipeline()
+	for n := 1; n <= len(runes) && n <= suggestMaxPrefixLength; n++ {
+		key := fmt.Sprintf(suggestQueryPrefixKey, string(runes[:n]))
+		pipe.ZIncrBy(ctx, key, 1, term)
+		pipe.ZRemRangeByRank(ctx, key, 0, -suggestQueryPrefixCapacity-1)
+		pipe.Expire(ctx, key, suggestQueryExpiration)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Errorf("记录搜索词失败: %v", err)
+	}
+}
+
+// suggestProductNames 返回前缀匹配的商品名称，同名商品只返回一次。
+// 同名商品在索引中相邻，边扫描边去重，直到凑满limit个不同的名称或索引扫描完
+func (s *ProductCatalogServiceServer) suggestProductNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	names := make([]string, 0, limit)
+	seen := make(map[string]bool, limit)
+	start := "[" + prefix
+	for len(names) < limit {
+		members, err := s.Redis.ZRangeByLex(ctx, suggestProductNamesKey, &redis.ZRangeBy{
+			Min:   start,
+			Max:   "[" + prefix + "\xff",
+			Count: int64(limit),
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			parts := strings.SplitN(m, "\x00", 3)
+			if len(parts) < 2 || seen[parts[1]] {
+				continue
+			}
+			seen[parts[1]] = true
+			names = append(names, parts[1])
+			if len(names) == limit {
+				break
+			}
+		}
+		if len(members) < limit {
+			break
+		}
+		// 下一批从本批最后一个成员之后开始
+		start = "(" + members[len(members)-1]
+	}
+	return names, nil
+}
+
+// suggestPopularQueries 按搜索次数从高到低返回前缀对应的搜索词
+func (s *ProductCatalogServiceServer) suggestPopularQueries(ctx context.Context, prefix string, limit int) ([]string, error) {
+	runes := []rune(prefix)
+	if len(runes) <= suggestMaxPrefixLength {
+		return s.Redis.ZRevRange(ctx, fmt.Sprintf(suggestQueryPrefixKey, prefix), 0, int64(limit-1)).Result()
+	}
+
+	terms, err := s.Redis.ZRevRange(ctx, fmt.Sprintf(suggestQueryPrefixKey, string(runes[:suggestMaxPrefixLength])), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	queries := make([]string, 0, limit)
+	for _, term := range terms {
+		if strings.HasPrefix(term, prefix) {
+			queries = append(queries, term)
+			if len(queries) == limit {
+				break
+			}
+		}
+	}
+	return queries, nil
+}
+
+// updateProductNameIndex 按商品当前的名称和发布状态更新联想索引，改名、下架或删除的商品移除旧的成员
+func (s *ProductCatalogServiceServer) updateProductNameIndex(ctx context.Context, products []model.Product) {
+	if len(products) == 0 {
+		return
+	}
+	ids := make([]string, len(products))
+	for i, p := range products {
+		ids[i] = strconv.FormatUint(uint64(p.ID), 10)
+	}
+	previous, err := s.Redis.HMGet(ctx, suggestProductMembersKey, ids...).Result()
+	if err != nil {
+		log.Errorf("查询联想索引失败: %v", err)
+		return
+	}
+
+	pipe := s.Redis.TxPipeline()
+	for i := range products {
+		p := &products[i]
+		member := ""
+		if p.IsPublished && !p.DeletedAt.Valid {
+			member = suggestNameMember(p)
+		}
+		if old, ok := previous[i].(string); ok && old != member {
+			pipe.ZRem(ctx, suggestProductNamesKey, old)
+			// 清除包含旧名称的联想缓存，避免缓存过期前仍返回改名或下架的商品
+			if keys := suggestCacheKeys(old); len(keys) > 0 {
+				pipe.Del(ctx, keys...)
+			}
+		}
+		if member == "" {
+			pipe.HDel(ctx, suggestProductMembersKey, ids[i])
+			continue
+		}
+		pipe.ZAdd(ctx, suggestProductNamesKey, &redis.Z{Score: 0, Member: member})
+		pipe.HSet(ctx, suggestProductMembersKey, ids[i], member)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Errorf("更新联想索引失败: %v", err)
+	}
+}
+
+// normalizeSuggestTerm 统一大小写并合并多余空白
+func normalizeSuggestTerm(term string) string {
+	return strings.ToLower(strings.Join(strings.Fields(term), " "))
+}
+
+func suggestNameMember(p *model.Product) string {
+	return normalizeSuggestTerm(p.Name) + "\x00" + p.Name + "\x00" + strconv.FormatUint(uint64(p.ID), 10)
+}
+
+// suggestCacheKeys 返回可能包含该名称索引成员的所有前缀缓存key
+func suggestCacheKeys(member string) []string {
+	runes := []rune(strings.SplitN(member, "\x00", 2)[0])
+	keys := make([]string, 0, len(runes))
+	for n := 1; n <= len(runes); n++ {
+		keys = append(keys, fmt.Sprintf(suggestCacheKey, string(runes[:n])))
+	}
+	return keys
+}
+
+func truncateSuggestions(resp *product.SuggestProductsResp, limit int) *product.SuggestProductsResp {
+	names := resp.ProductNames
+	if len(names) > limit {
+		names = names[:limit]
+	}
+	queries := resp.PopularQueries
+	if len(queries) > limit {
+		queries = queries[:limit]
+	}
+	return &product.SuggestProductsResp{
+		ProductNames:   names,
+		PopularQueries: queries,
+	}
+}
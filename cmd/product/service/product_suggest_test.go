@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func newSuggestTestServer(t *testing.T) (*ProductCatalogServiceServer, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &ProductCatalogServiceServer{Redis: client}, mr
+}
+
+func suggestProduct(id uint, name string, published bool) model.Product {
+	p := model.Product{Name: name, IsPublished: published}
+	p.ID = id
+	return p
+}
+
+// 测试热门搜索词按搜索次数排序，每个前缀只保留固定数量的搜索词
+func TestSuggestPopularQueries(t *testing.T) {
+	s, mr := newSuggestTestServer(t)
+	ctx := context.Background()
+
+	searches := map[string]int{"phone case": 1, "Phone": 3, "photo": 2, "iphone": 5}
+	for term, count := range searches {
+		for i := 0; i < count; i++ {
+			s.recordSearchTerm(ctx, term)
+		}
+	}
+
+	resp, err := s.SuggestProducts(ctx, &product.SuggestProductsReq{Q: "PH"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"phone", "photo", "phone case"}, resp.PopularQueries)
+
+	resp, err = s.SuggestProducts(ctx, &product.SuggestProductsReq{Q: "ph", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"phone"}, resp.PopularQueries, "缓存的结果按limit截断")
+
+	for i := 0; i < suggestQueryPrefixCapacity+10; i++ {
+		s.recordSearchTerm(ctx, fmt.Sprintf("x%03d", i))
+	}
+	members, err := mr.ZMembers(fmt.Sprintf(suggestQueryPrefixKey, "x"))
+	require.NoError(t, err)
+	assert.Len(t, members, suggestQueryPrefixCapacity)
+
+	// 超过最长前缀的输入在最长的前缀集合中过滤
+	long := "abcdefghijklmnopqrst"
+	s.recordSearchTerm(ctx, long)
+	s.recordSearchTerm(ctx, "abcdefghijklmnopxyz")
+	queries, err := s.suggestPopularQueries(ctx, "abcdefghijklmnopqr", suggestMaxLimit)
+	require.NoError(t, err)
+	assert.Equal(t, []string{long}, queries)
+
+	_, err = s.SuggestProducts(ctx, &product.SuggestProductsReq{Q: "  "})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// 测试改名、下架和删除的商品从名称索引中移除，同名商品只返回一次
+func TestSuggestProductNames(t *testing.T) {
+	s, _ := newSuggestTestServer(t)
+	ctx := context.Background()
+
+	s.updateProductNameIndex(ctx, []model.Product{
+		suggestProduct(1, "iPhone 15", true),
+		suggestProduct(2, "iPhone 15", true),
+		suggestProduct(3, "iPhone 15 Pro", true),
+		suggestProduct(4, "iPad", false),
+	})
+	resp, err := s.SuggestProducts(ctx, &product.SuggestProductsReq{Q: "IP"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iPhone 15", "iPhone 15 Pro"}, resp.ProductNames)
+
+	renamed := suggestProduct(1, "Galaxy S24", true)
+	deleted := suggestProduct(3, "iPhone 15 Pro", true)
+	deleted.DeletedAt = gorm.DeletedAt{Valid: true}
+	s.updateProductNameIndex(ctx, []model.Product{renamed, deleted, suggestProduct(4, "iPad", true)})
+
+	// 前缀缓存已清除，不再返回改名和删除的商品
+	resp, err = s.SuggestProducts(ctx, &product.SuggestProductsReq{Q: "IP"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iPad", "iPhone 15"}, resp.ProductNames, "商品2仍然在售")
+
+	s.updateProductNameIndex(ctx, []model.Product{suggestProduct(2, "iPhone 15", false)})
+	resp, err = s.SuggestProducts(ctx, &product.SuggestProductsReq{Q: "iph"})
+	require.NoError(t, err)
+	assert.Empty(t, resp.ProductNames)
+
+	names, err := s.suggestProductNames(ctx, "galaxy", suggestMaxLimit)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Galaxy S24"}, names)
+}
+
+// 测试同名商品较多时去重后仍返回limit个不同的名称
+func TestSuggestProductNamesDedupe(t *testing.T) {
+	s, _ := newSuggestTestServer(t)
+	ctx := context.Background()
+
+	products := []model.Product{suggestProduct(100, "Apricot", true)}
+	for id := uint(1); id <= 5; id++ {
+		products = append(products, suggestProduct(id, "Apple", true))
+	}
+	s.updateProductNameIndex(ctx, products)
+
+	names, err := s.suggestProductNames(ctx, "ap", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Apple", "Apricot"}, names)
+
+	names, err = s.suggestProductNames(ctx, "ap", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Apple", "Apricot"}, names, "不同的名称不足limit个")
+}
@@ -0,0 +1,240 @@
+// tkmall-admin 运维命令行工具
+//
+//	tkmall-admin products import [-format csv|jsonl] FILE
+//	tkmall-admin products export [-format csv|jsonl] [-category-id N] FILE
+//	tkmall-admin payments webhook -transaction TXN [-status CAPTURED]
+//	tkmall-admin payments settlement [-from DATE] [-to DATE] FILE
+//	tkmall-admin payments reconcile [-from DATE] [-to DATE] [-fix] FILE
+//
+// FILE为 - 时读写标准输入输出。商品服务地址通过 -addr 或环境变量 PRODUCT_SERVICE_ADDR 指定，
+// 支付服务地址通过 -addr 或环境变量 PAYMENT_SERVICE_ADDR 指定。
+// payments webhook 向网关发送签名的模拟支付网关通知，用于本地测试异步支付。
+// payments settlement 从支付网关导出结算文件，payments reconcile 用结算文件与交易记录对账。
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"TKMall/build/proto_gen/product"
+	"TKMall/cmd/product/bulk"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const defaultProductServiceAddr = "localhost:50053"
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `用法:
+  tkmall-admin products import [-addr ADDR] [-format csv|jsonl] FILE
+  tkmall-admin products export [-addr ADDR] [-format csv|jsonl] [-category-id N] FILE
+  tkmall-admin payments webhook [-url URL] [-secret SECRET] [-event-id ID] [-status STATUS] [-amount N] -transaction TXN
+  tkmall-admin payments settlement [-addr ADDR] [-from YYYY-MM-DD] [-to YYYY-MM-DD] FILE
+  tkmall-admin payments reconcile [-addr ADDR] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-fix] FILE`)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] + " " + os.Args[2] {
+	case "products import":
+		err = runImport(os.Args[3:])
+	case "products export":
+		err = runExport(os.Args[3:])
+	case "payments webhook":
+		err = runWebhook(os.Args[3:])
+	case "payments settlement":
+		err = runSettlement(os.Args[3:])
+	case "payments reconcile":
+		err = runReconcile(os.Args[3:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type commonFlags struct {
+	addr   string
+	format string
+}
+
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	addr := os.Getenv("PRODUCT_SERVICE_ADDR")
+	if addr == "" {
+		addr = defaultProductServiceAddr
+	}
+	fs.StringVar(&c.addr, "addr", addr, "商品服务地址")
+	fs.StringVar(&c.format, "format", "", "文件格式 csv|jsonl，默认按扩展名推断")
+}
+
+func (c *commonFlags) resolveFormat(path string) (bulk.Format, error) {
+	if c.format != "" {
+		return bulk.Format(c.format), nil
+	}
+	if path == "-" {
+		return bulk.FormatJSONL, nil
+	}
+	return bulk.FormatFromPath(path)
+}
+
+func dial(addr string) (product.ProductCatalogServiceClient, func(), error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("连接商品服务失败: %w", err)
+	}
+	return product.NewProductCatalogServiceClient(conn), func() { conn.Close() }, nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var flags commonFlags
+	flags.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	path := fs.Arg(0)
+
+	format, err := flags.resolveFormat(path)
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	if path != "-" {
+		if in, err = os.Open(path); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	reader, err := bulk.NewReader(in, format)
+	if err != nil {
+		return err
+	}
+
+	client, closeConn, err := dial(flags.addr)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	stream, err := client.ImportProducts(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// 无法解析的行在本地报告，不发送给服务端
+	var parseFailed, sent int
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *bulk.RowError
+		if errors.As(err, &rowErr) {
+			parseFailed++
+			fmt.Fprintln(os.Stderr, rowErr.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(row); err != nil {
+			// 服务端提前结束流时，真正的错误需要通过CloseAndRecv获取
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		sent++
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+
+	for _, rowErr := range resp.Errors {
+		fmt.Fprintf(os.Stderr, "第%d行 [%s]: %s\n", rowErr.Line, rowErr.Sku, rowErr.Message)
+	}
+	if int(resp.Failed) > len(resp.Errors) {
+		fmt.Fprintf(os.Stderr, "... 另有%d行错误未列出\n", int(resp.Failed)-len(resp.Errors))
+	}
+	fmt.Printf("导入完成: 共%d行, 新建%d, 更新%d, 失败%d\n",
+		sent+parseFailed, resp.Created, resp.Updated, int(resp.Failed)+parseFailed)
+	if resp.Failed > 0 || parseFailed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var flags commonFlags
+	flags.register(fs)
+	categoryID := fs.Uint("category-id", 0, "只导出该分类（含子分类）下的商品")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	path := fs.Arg(0)
+
+	format, err := flags.resolveFormat(path)
+	if err != nil {
+		return err
+	}
+
+	client, closeConn, err := dial(flags.addr)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	stream, err := client.ExportProducts(context.Background(), &product.ExportProductsReq{CategoryId: uint32(*categoryID)})
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	writer, err := bulk.NewWriter(out, format)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for {
+		row, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+		count++
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "导出完成: 共%d行\n", count)
+	return nil
+}
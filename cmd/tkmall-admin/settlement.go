@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+	"TKMall/cmd/payment/settlement"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	defaultPaymentServiceAddr = "localhost:50056"
+	dateLayout                = "2006-01-02"
+	maxListedIssues           = 1000 // 支付服务最多返回的差异条数
+)
+
+type periodFlags struct {
+	addr string
+	from string
+	to   string
+}
+
+func (p *periodFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.addr, "addr", envOr("PAYMENT_SERVICE_ADDR", defaultPaymentServiceAddr), "支付服务地址")
+	fs.StringVar(&p.from, "from", "", "结算周期开始日期 YYYY-MM-DD，默认为昨天")
+	fs.StringVar(&p.to, "to", "", "结算周期结束日期（不含） YYYY-MM-DD，默认为开始日期的后一天")
+}
+
+// period 解析结算周期，日期按本地时区计算
+func (p *periodFlags) period() (start, end time.Time, err error) {
+	if p.from == "" {
+		now := time.Now()
+		start = time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.Local)
+	} else if start, err = time.ParseInLocation(dateLayout, p.from, time.Local); err != nil {
+		return start, end, fmt.Errorf("无效的开始日期: %s", p.from)
+	}
+	if p.to == "" {
+		end = start.AddDate(0, 0, 1)
+	} else if end, err = time.ParseInLocation(dateLayout, p.to, time.Local); err != nil {
+		return start, end, fmt.Errorf("无效的结束日期: %s", p.to)
+	}
+	if !start.Before(end) {
+		return start, end, errors.New("开始日期必须早于结束日期")
+	}
+	return start, end, nil
+}
+
+func dialPayment(addr string) (payment.PaymentServiceClient, func(), error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("连接支付服务失败: %w", err)
+	}
+	return payment.NewPaymentServiceClient(conn), func() { conn.Close() }, nil
+}
+
+// runSettlement 从支付网关导出结算文件，模拟网关据此生成本地测试用的结算文件
+func runSettlement(args []string) error {
+	fs := flag.NewFlagSet("settlement", flag.ExitOnError)
+	var flags periodFlags
+	flags.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	path := fs.Arg(0)
+
+	start, end, err := flags.period()
+	if err != nil {
+		return err
+	}
+	client, closeConn, err := dialPayment(flags.addr)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	stream, err := client.ExportSettlement(context.Background(), &payment.ExportSettlementReq{
+		PeriodStart: start.Unix(),
+		PeriodEnd:   end.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	writer, err := settlement.NewWriter(out)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for {
+		record, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+		count++
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "导出完成: 共%d笔交易\n", count)
+	return nil
+}
+
+// runReconcile 上传结算文件与支付服务的交易记录对账，有差异时以状态码1退出
+func runReconcile(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	var flags periodFlags
+	flags.register(fs)
+	fix := fs.Bool("fix", false, "自动修复可以安全修复的差异")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	path := fs.Arg(0)
+
+	start, end, err := flags.period()
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	if path != "-" {
+		if in, err = os.Open(path); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	reader, err := settlement.NewReader(in)
+	if err != nil {
+		return err
+	}
+
+	client, closeConn, err := dialPayment(flags.addr)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	stream, err := client.Reconcile(context.Background())
+	if err != nil {
+		return err
+	}
+	err = stream.Send(&payment.ReconcileReq{Payload: &payment.ReconcileReq_Options{Options: &payment.ReconcileOptions{
+		PeriodStart: start.Unix(),
+		PeriodEnd:   end.Unix(),
+		Fix:         *fix,
+	}}})
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// 无法解析的行在本地报告，不发送给服务端
+	parseFailed := 0
+	for err != io.EOF {
+		record, readErr := reader.Next()
+		if readErr == io.EOF {
+			break
+		}
+		var rowErr *settlement.RowError
+		if errors.As(readErr, &rowErr) {
+			parseFailed++
+			fmt.Fprintln(os.Stderr, rowErr.Error())
+			continue
+		}
+		if readErr != nil {
+			return readErr
+		}
+		// 服务端提前结束流时，真正的错误需要通过CloseAndRecv获取
+		if err = stream.Send(&payment.ReconcileReq{Payload: &payment.ReconcileReq_Record{Record: record}}); err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range resp.Issues {
+		fmt.Println(formatIssue(issue))
+	}
+	if len(resp.Issues) >= maxListedIssues {
+		fmt.Printf("... 差异过多，只列出前%d条\n", maxListedIssues)
+	}
+	fmt.Printf("对账完成: 共%d笔, 一致%d, 缺失%d, 多出%d, 不一致%d, 无效%d, 已修复%d\n",
+		int(resp.Total)+parseFailed, resp.Matched, resp.Missing, resp.Extra, resp.Mismatched, int(resp.Invalid)+parseFailed, resp.Fixed)
+	if resp.Missing+resp.Extra+resp.Invalid > 0 || resp.Mismatched > resp.Fixed || parseFailed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func formatIssue(issue *payment.ReconcileIssue) string {
+	prefix := fmt.Sprintf("[%s]", issue.Type)
+	if issue.Line > 0 {
+		prefix += fmt.Sprintf(" 第%d行", issue.Line)
+	}
+	text := fmt.Sprintf("%s %s", prefix, issue.TransactionId)
+	if issue.Field != "" {
+		text += fmt.Sprintf(" %s: 本系统=%s 网关=%s", issue.Field, issue.Expected, issue.Actual)
+	}
+	if issue.Message != "" {
+		text += " " + issue.Message
+	}
+	if issue.Fixed {
+		text += " (已修复)"
+	}
+	return text
+}
@@ -90,6 +90,7 @@ func main() {
 
 	serviceEndpoints := map[string]string{
 		"auth": authServiceAddr,
+		"cart": viper.GetString("cart_service.address"), // 登录后合并游客购物车
 	}
 
 	// 获取Redis地址，优先使用环境变量
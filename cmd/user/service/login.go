@@ -2,9 +2,11 @@ package service
 
 import (
 	"TKMall/build/proto_gen/auth"
+	"TKMall/build/proto_gen/cart"
 	"TKMall/build/proto_gen/user"
 	"TKMall/cmd/user/model"
 	"TKMall/common/log"
+	"TKMall/common/proxy"
 	"context"
 	"fmt"
 	"runtime/debug"
@@ -77,6 +79,7 @@ func (s *UserServiceServer) Login(ctx context.Context, req *user.LoginReq) (resp
 			if token, exists := mapResp["token"]; exists {
 				if tokenStr, isString := token.(string); isString {
 					log.Infof("从map中成功提取token: %s", tokenStr)
+					s.mergeGuestCart(ctx, userInfo.ID, req.CartToken)
 					return &user.LoginResp{UserId: userInfo.ID, Token: tokenStr}, nil
 				}
 			}
@@ -88,5 +91,18 @@ func (s *UserServiceServer) Login(ctx context.Context, req *user.LoginReq) (resp
 
 	log.Infof("Generated token for user %d: %s", userInfo.ID, authResp.Token)
 
+	s.mergeGuestCart(ctx, userInfo.ID, req.CartToken)
+
 	return &user.LoginResp{UserId: userInfo.ID, Token: authResp.Token}, nil
 }
+
+// mergeGuestCart 将登录前的游客购物车合并到用户购物车，失败不影响登录
+func (s *UserServiceServer) mergeGuestCart(ctx context.Context, userID int64, cartToken string) {
+	if cartToken == "" {
+		return
+	}
+	mergeReq := &cart.MergeGuestCartReq{UserId: userID, CartToken: cartToken}
+	if _, err := s.Proxy.Call(proxy.WithoutCache(ctx), "cart", "MergeGuestCart", mergeReq); err != nil {
+		log.Warnf("合并游客购物车失败, userID=%d: %v", userID, err)
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// BloomFilter 基于Redis位图的布隆过滤器，多副本共享同一份数据。
+// 用于在查缓存和数据库之前拦截一定不存在的ID。
+type BloomFilter struct {
+	client *redis.Client
+	key    string
+	bits   uint64 // 位图大小
+	hashes uint64 // 哈希函数个数
+}
+
+// NewBloomFilter 按预期元素数量和误判率计算位图大小和哈希函数个数
+func NewBloomFilter(client *redis.Client, key string, expectedItems uint64, falsePositiveRate float64) *BloomFilter {
+	bits, hashes := bloomParams(expectedItems, falsePositiveRate)
+	return &BloomFilter{
+		client: client,
+		key:    key,
+		bits:   bits,
+		hashes: hashes,
+	}
+}
+
+// Add 添加元素
+func (b *BloomFilter) Add(ctx context.Context, item string) error {
+	pipe := b.client.Pipeline()
+	for _, offset := range b.offsets(item) {
+		pipe.SetBit(ctx, b.key, int64(offset), 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// MightContain 返回false表示元素一定不存在；返回true表示可能存在
+func (b *BloomFilter) MightContain(ctx context.Context, item string) (bool, error) {
+	pipe := b.client.Pipeline()
+	offsets := b.offsets(item)
+	cmds := make([]*redis.IntCmd, len(offsets))
+	for i, offset := range offsets {
+		cmds[i] = pipe.GetBit(ctx, b.key, int64(offset))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Exists 过滤器是否已初始化
+func (b *BloomFilter) Exists(ctx context.Context) (bool, error) {
+	n, err := b.client.Exists(ctx, b.key).Result()
+	return n > 0, err
+}
+
+// Rebuild 用全量元素重建过滤器，先写临时key再原子替换
+func (b *BloomFilter) Rebuild(ctx context.Context, items func(add func(item string) error) error) error {
+	tmp := &BloomFilter{client: b.client, key: b.key + ":rebuilding", bits: b.bits, hashes: b.hashes}
+	if err := b.client.Del(ctx, tmp.key).Err(); err != nil {
+		return err
+	}
+	// 预先分配位图，保证没有元素时也能完成替换
+	if err := b.client.SetBit(ctx, tmp.key, int64(b.bits-1), 0).Err(); err != nil {
+		return err
+	}
+	if err := items(func(item string) error { return tmp.Add(ctx, item) }); err != nil {
+		return err
+	}
+	return b.client.Rename(ctx, tmp.key, b.key).Err()
+}
+
+// 双重哈希生成k个位偏移：h_i = h1 + i*h2
+func (b *BloomFilter) offsets(item string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	h1 := h.Sum64()
+	h2 := h1>>33 | h1<<31
+	if h2%2 == 0 {
+		h2++
+	}
+
+	offsets := make([]uint64, b.hashes)
+	for i := uint64(0); i < b.hashes; i++ {
+		offsets[i] = (h1 + i*h2) % b.bits
+	}
+	return offsets
+}
+
+func bloomParams(n uint64, p float64) (bits, hashes uint64) {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	return uint64(m), uint64(k)
+}
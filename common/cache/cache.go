@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"TKMall/common/log"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound 由loader返回，表示数据不存在，会被写入负缓存防止缓存穿透
+var ErrNotFound = errors.New("cache: not found")
+
+// 负缓存占位值
+const negativeValue = "\x00nil"
+
+// Options 缓存配置
+type Options struct {
+	TTL         time.Duration // 基础过期时间
+	Jitter      float64       // 过期时间随机抖动比例，如0.1表示在TTL基础上增加0~10%
+	NegativeTTL time.Duration // 负缓存过期时间，为0时不缓存不存在的数据
+
+	// 本地缓存配置，LocalCapacity为0时只使用Redis
+	LocalCapacity int           // 本地LRU容量
+	LocalTTL      time.Duration // 本地条目过期时间，兜底防止错过失效通知
+	Channel       string        // 失效通知的Redis频道，多副本之间广播失效的key
+}
+
+// Loader 缓存未命中时加载数据
+type Loader func(ctx context.Context) (interface{}, error)
+
+// Cache 两级旁路缓存：进程内LRU + Redis。
+// 提供单飞加载、负缓存、过期时间抖动、按命名空间失效，以及跨副本的失效广播。
+type Cache struct {
+	client *redis.Client
+	opts   Options
+	group  singleflight.Group
+	local  *LRU
+	stats  stats
+
+	// 本地缓存的失效次数。读取前记录，回填时已变化说明期间有失效，读到的可能是旧数据，不写入本地缓存
+	mu         sync.Mutex
+	generation uint64
+}
+
+// New 创建缓存实例
+func New(client *redis.Client, opts Options) *Cache {
+	c := &Cache{
+		client: client,
+		opts:   opts,
+	}
+	if opts.LocalCapacity > 0 {
+		c.local = NewLRU(opts.LocalCapacity, opts.LocalTTL)
+	}
+	return c
+}
+
+// GetOrLoad 依次读本地缓存、Redis，都未命中时通过loader加载并回填。
+// 同一进程内相同key的并发加载只会执行一次loader，避免缓存击穿。
+// dest需为指针，数据以JSON格式存储。
+func (c *Cache) GetOrLoad(ctx context.Context, key string, dest interface{}, loader Loader) error {
+	if raw, ok := c.getLocal(key); ok {
+		if err := decode(raw, dest); err == nil || errors.Is(err, ErrNotFound) {
+			return err
+		}
+		c.local.Remove(key)
+	}
+
+	generation := c.currentGeneration()
+	hit, err := c.getRemote(ctx, key, dest, generation)
+	if hit {
+		return err
+	}
+
+	data, err, _ := c.group.Do(key, func() (interface{}, error) {
+		// 再次检查缓存，可能已被其他请求回填
+		if raw, err := c.client.Get(ctx, key).Result(); err == nil {
+			c.setLocal(key, raw, generation)
+			return raw, nil
+		}
+
+		c.stats.loads.Add(1)
+		value, err := loader(ctx)
+		if errors.Is(err, ErrNotFound) {
+			if c.opts.NegativeTTL > 0 {
+				c.set(ctx, key, negativeValue, c.opts.NegativeTTL, generation)
+			}
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("cache: 序列化失败: %w", err)
+		}
+		raw := string(encoded)
+		c.set(ctx, key, raw, c.jitteredTTL(), generation)
+		return raw, nil
+	})
+	if err != nil {
+		return err
+	}
+	return decode(data.(string), dest)
+}
+
+// Invalidate 删除指定key，并通知其他副本删除本地缓存
+func (c *Cache) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	return c.broadcast(ctx, keys)
+}
+
+// NamespaceKey 为key加上命名空间的当前版本号。
+// 列表、搜索等无法逐条失效的缓存放在同一命名空间下，通过InvalidateNamespace整体失效。
+func (c *Cache) NamespaceKey(ctx context.Context, namespace, key string) string {
+	versionKey := namespaceVersionKey(namespace)
+	version, ok := c.getLocal(versionKey)
+	if !ok {
+		generation := c.currentGeneration()
+		v, err := c.client.Get(ctx, versionKey).Int64()
+		if err != nil && err != redis.Nil {
+			log.Errorf("读取缓存命名空间版本失败: %v", err)
+		}
+		version = strconv.FormatInt(v, 10)
+		if err == nil || err == redis.Nil {
+			c.setLocal(versionKey, version, generation)
+		}
+	}
+	return fmt.Sprintf("%s:v%s:%s", namespace, version, key)
+}
+
+// InvalidateNamespace 递增命名空间版本号，旧版本的key随过期时间自然淘汰
+func (c *Cache) InvalidateNamespace(ctx context.Context, namespace string) error {
+	if err := c.client.Incr(ctx, namespaceVersionKey(namespace)).Err(); err != nil {
+		return err
+	}
+	return c.broadcast(ctx, []string{namespaceVersionKey(namespace)})
+}
+
+// Subscribe 订阅失效通知并删除对应的本地缓存，阻塞直到ctx结束。
+// 断线期间可能错过通知，本地条目的LocalTTL作为兜底。
+func (c *Cache) Subscribe(ctx context.Context) {
+	if c.local == nil || c.opts.Channel == "" {
+		return
+	}
+
+	pubsub := c.client.Subscribe(ctx, c.opts.Channel)
+	defer pubsub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-pubsub.Channel():
+			if !ok {
+				return
+			}
+			var keys []string
+			if err := json.Unmarshal([]byte(msg.Payload), &keys); err != nil {
+				log.Errorf("解析缓存失效通知失败: %v", err)
+				continue
+			}
+			c.removeLocal(keys)
+		}
+	}
+}
+
+func (c *Cache) broadcast(ctx context.Context, keys []string) error {
+	if c.local == nil {
+		return nil
+	}
+	// 先删除本进程的副本，不依赖自己收到通知
+	c.removeLocal(keys)
+	if c.opts.Channel == "" {
+		return nil
+	}
+	payload, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, c.opts.Channel, payload).Err()
+}
+
+func (c *Cache) getLocal(key string) (string, bool) {
+	if c.local == nil {
+		return "", false
+	}
+	raw, ok := c.local.Get(key)
+	if ok {
+		c.stats.localHits.Add(1)
+	} else {
+		c.stats.localMisses.Add(1)
+	}
+	return raw, ok
+}
+
+func (c *Cache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// setLocal 写入本地缓存。generation之后发生过失效时不写入，
+// 避免失效前开始的读取在失效通知之后回填旧数据，直到LocalTTL才过期
+func (c *Cache) setLocal(key, raw string, generation uint64) {
+	if c.local == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.local.Set(key, raw)
+	}
+}
+
+func (c *Cache) removeLocal(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.local.Remove(keys...)
+}
+
+func (c *Cache) getRemote(ctx context.Context, key string, dest interface{}, generation uint64) (bool, error) {
+	raw, err := c.client.Get(ctx, key).Result()
+	if err != nil {
+		c.stats.redisMisses.Add(1)
+		if err != redis.Nil {
+			log.Errorf("读取缓存失败 key=%s: %v", key, err)
+		}
+		return false, nil
+	}
+	c.stats.redisHits.Add(1)
+
+	if err := decode(raw, dest); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.setLocal(key, raw, generation)
+			return true, err
+		}
+		// 缓存数据损坏时按未命中处理，由loader重新加载
+		log.Errorf("解析缓存失败 key=%s: %v", key, err)
+		return false, nil
+	}
+	c.setLocal(key, raw, generation)
+	return true, nil
+}
+
+func (c *Cache) set(ctx context.Context, key, value string, ttl time.Duration, generation uint64) {
+	// 加载期间发生过失效，加载的数据可能已过期，不回填
+	if c.currentGeneration() != generation {
+		return
+	}
+	c.setLocal(key, value, generation)
+	if err := c.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		log.Errorf("写入缓存失败 key=%s: %v", key, err)
+	}
+}
+
+// 在TTL基础上增加随机抖动，避免大量key同时过期造成缓存雪崩
+func (c *Cache) jitteredTTL() time.Duration {
+	if c.opts.Jitter <= 0 || c.opts.TTL <= 0 {
+		return c.opts.TTL
+	}
+	return c.opts.TTL + time.Duration(rand.Float64()*c.opts.Jitter*float64(c.opts.TTL))
+}
+
+func decode(raw string, dest interface{}) error {
+	if raw == negativeValue {
+		return ErrNotFound
+	}
+	return json.Unmarshal([]byte(raw), dest)
+}
+
+func namespaceVersionKey(namespace string) string {
+	return namespace + ":version"
+}
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func newTestClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	return mr, redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+// 测试并发加载只执行一次loader
+func TestGetOrLoadSingleflight(t *testing.T) {
+	_, client := newTestClient(t)
+	c := New(client, Options{TTL: time.Minute})
+
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return item{ID: 1, Name: "手机"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got item
+			err := c.GetOrLoad(context.Background(), "item:1", &got, loader)
+			assert.NoError(t, err)
+			assert.Equal(t, "手机", got.Name)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "并发请求应只加载一次")
+}
+
+// 测试不存在的数据写入负缓存
+func TestGetOrLoadNegativeCache(t *testing.T) {
+	mr, client := newTestClient(t)
+	c := New(client, Options{TTL: time.Minute, NegativeTTL: 10 * time.Second})
+
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, ErrNotFound
+	}
+
+	var got item
+	assert.ErrorIs(t, c.GetOrLoad(context.Background(), "item:404", &got, loader), ErrNotFound)
+	assert.ErrorIs(t, c.GetOrLoad(context.Background(), "item:404", &got, loader), ErrNotFound)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "负缓存命中时不应再次加载")
+
+	mr.FastForward(11 * time.Second)
+	assert.ErrorIs(t, c.GetOrLoad(context.Background(), "item:404", &got, loader), ErrNotFound)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "负缓存过期后应重新加载")
+}
+
+// 测试加载期间收到失效通知时，加载的旧数据不回填本地缓存和Redis
+func TestGetOrLoadInvalidatedDuringLoad(t *testing.T) {
+	mr, client := newTestClient(t)
+	c := New(client, Options{TTL: time.Minute, LocalCapacity: 10, LocalTTL: time.Minute})
+	ctx := context.Background()
+
+	var got item
+	err := c.GetOrLoad(ctx, "item:1", &got, func(ctx context.Context) (interface{}, error) {
+		// 加载读到旧数据后，其他副本更新数据并发出失效通知
+		c.removeLocal([]string{"item:1"})
+		return item{ID: 1, Name: "旧名称"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "旧名称", got.Name, "本次请求仍返回加载的结果")
+	assert.False(t, mr.Exists("item:1"))
+
+	err = c.GetOrLoad(ctx, "item:1", &got, func(ctx context.Context) (interface{}, error) {
+		return item{ID: 1, Name: "新名称"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "新名称", got.Name)
+
+	// 没有失效时正常回填本地缓存
+	mr.Del("item:1")
+	err = c.GetOrLoad(ctx, "item:1", &got, func(ctx context.Context) (interface{}, error) {
+		t.Fatal("应命中本地缓存")
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "新名称", got.Name)
+}
+
+// 测试过期时间抖动范围
+func TestJitteredTTL(t *testing.T) {
+	c := New(nil, Options{TTL: time.Minute, Jitter: 0.2})
+	for i := 0; i < 100; i++ {
+		ttl := c.jitteredTTL()
+		assert.GreaterOrEqual(t, ttl, time.Minute)
+		assert.LessOrEqual(t, ttl, 72*time.Second)
+	}
+}
+
+// 测试命名空间整体失效
+func TestInvalidateNamespace(t *testing.T) {
+	_, client := newTestClient(t)
+	c := New(client, Options{TTL: time.Minute})
+	ctx := context.Background()
+
+	version := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		version++
+		return item{ID: version}, nil
+	}
+
+	var got item
+	assert.NoError(t, c.GetOrLoad(ctx, c.NamespaceKey(ctx, "list", "page:1"), &got, loader))
+	assert.NoError(t, c.GetOrLoad(ctx, c.NamespaceKey(ctx, "list", "page:1"), &got, loader))
+	assert.Equal(t, 1, got.ID)
+
+	assert.NoError(t, c.InvalidateNamespace(ctx, "list"))
+	assert.NoError(t, c.GetOrLoad(ctx, c.NamespaceKey(ctx, "list", "page:1"), &got, loader))
+	assert.Equal(t, 2, got.ID, "失效后应重新加载")
+}
+
+// 测试布隆过滤器
+func TestBloomFilter(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+	bf := NewBloomFilter(client, "bloom", 1000, 0.01)
+
+	err := bf.Rebuild(ctx, func(add func(item string) error) error {
+		for _, id := range []string{"1", "2", "3"} {
+			if err := add(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		ok, err := bf.MightContain(ctx, id)
+		assert.NoError(t, err)
+		assert.True(t, ok, "已添加的元素应返回可能存在: "+id)
+	}
+
+	ok, err := bf.MightContain(ctx, "999999")
+	assert.NoError(t, err)
+	assert.False(t, ok, "未添加的元素应返回不存在")
+}
+
+// 测试LRU淘汰和过期
+func TestLRU(t *testing.T) {
+	l := NewLRU(2, 50*time.Millisecond)
+	l.Set("a", "1")
+	l.Set("b", "2")
+	l.Get("a")
+	l.Set("c", "3")
+
+	_, ok := l.Get("b")
+	assert.False(t, ok, "最久未使用的条目应被淘汰")
+	v, ok := l.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", v)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = l.Get("a")
+	assert.False(t, ok, "过期条目应视为不存在")
+}
+
+// 测试失效通知清除其他副本的本地缓存
+func TestLocalInvalidationBroadcast(t *testing.T) {
+	_, client := newTestClient(t)
+	opts := Options{TTL: time.Minute, LocalCapacity: 100, LocalTTL: time.Minute, Channel: "invalidate"}
+	replicaA := New(client, opts)
+	replicaB := New(client, opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go replicaB.Subscribe(ctx)
+
+	name := "旧名称"
+	loader := func(ctx context.Context) (interface{}, error) {
+		return item{ID: 1, Name: name}, nil
+	}
+
+	var got item
+	assert.NoError(t, replicaB.GetOrLoad(ctx, "item:1", &got, loader))
+	assert.NoError(t, replicaB.GetOrLoad(ctx, "item:1", &got, loader))
+	assert.Equal(t, uint64(1), replicaB.Stats().LocalHits, "第二次读取应命中本地缓存")
+
+	// 等待订阅建立
+	time.Sleep(50 * time.Millisecond)
+	name = "新名称"
+	assert.NoError(t, replicaA.Invalidate(ctx, "item:1"))
+
+	assert.Eventually(t, func() bool {
+		var latest item
+		return replicaB.GetOrLoad(ctx, "item:1", &latest, loader) == nil && latest.Name == "新名称"
+	}, time.Second, 10*time.Millisecond, "副本B应在收到通知后读取到新数据")
+}
+
+// 测试统计通过expvar导出
+func TestPublishStats(t *testing.T) {
+	_, client := newTestClient(t)
+	c := New(client, Options{TTL: time.Minute, LocalCapacity: 10})
+	c.PublishStats("test_cache")
+
+	ctx := context.Background()
+	var got item
+	loader := func(ctx context.Context) (interface{}, error) { return item{ID: 1}, nil }
+	assert.NoError(t, c.GetOrLoad(ctx, "item:1", &got, loader))
+	assert.NoError(t, c.GetOrLoad(ctx, "item:1", &got, loader))
+
+	var stats Stats
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("test_cache").String()), &stats))
+	assert.Equal(t, c.Stats(), stats)
+	assert.Equal(t, uint64(1), stats.LocalHits)
+	assert.Equal(t, uint64(1), stats.Loads)
+}
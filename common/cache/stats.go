@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+// Stats 各级缓存的命中统计
+type Stats struct {
+	LocalHits   uint64 `json:"local_hits"`
+	LocalMisses uint64 `json:"local_misses"`
+	RedisHits   uint64 `json:"redis_hits"`
+	RedisMisses uint64 `json:"redis_misses"`
+	Loads       uint64 `json:"loads"` // 回源加载次数
+}
+
+type stats struct {
+	localHits   atomic.Uint64
+	localMisses atomic.Uint64
+	redisHits   atomic.Uint64
+	redisMisses atomic.Uint64
+	loads       atomic.Uint64
+}
+
+// Stats 返回当前统计快照
+func (c *Cache) Stats() Stats {
+	return Stats{
+		LocalHits:   c.stats.localHits.Load(),
+		LocalMisses: c.stats.localMisses.Load(),
+		RedisHits:   c.stats.redisHits.Load(),
+		RedisMisses: c.stats.redisMisses.Load(),
+		Loads:       c.stats.loads.Load(),
+	}
+}
+
+// PublishStats 将统计以name注册到expvar，通过expvar.Handler导出。同一个name只能注册一次
+func (c *Cache) PublishStats(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return c.Stats() }))
+}
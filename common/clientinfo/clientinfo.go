@@ -0,0 +1,47 @@
+// Package clientinfo 在服务间传递发起请求的客户端信息，网关写入gRPC metadata，下游服务读取，用于风控
+package clientinfo
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// gRPC metadata中的客户端信息
+const (
+	IPMetadataKey      = "x-client-ip"
+	CountryMetadataKey = "x-client-country"
+)
+
+// CountryHeader 客户端IP所在国家的请求头，由CDN或负载均衡按客户端IP设置，
+// 网关前的代理需要覆盖客户端自己传入的值
+const CountryHeader = "X-Client-Country"
+
+// Info 客户端信息
+type Info struct {
+	IP      string
+	Country string // ISO 3166-1 alpha-2
+}
+
+// AppendToOutgoingContext 将客户端信息写入发出请求的metadata，空值不写入
+func AppendToOutgoingContext(ctx context.Context, info Info) context.Context {
+	if info.IP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, IPMetadataKey, info.IP)
+	}
+	if info.Country != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, CountryMetadataKey, info.Country)
+	}
+	return ctx
+}
+
+// FromIncomingContext 读取收到请求的metadata中的客户端信息
+func FromIncomingContext(ctx context.Context) Info {
+	var info Info
+	if values := metadata.ValueFromIncomingContext(ctx, IPMetadataKey); len(values) > 0 {
+		info.IP = values[0]
+	}
+	if values := metadata.ValueFromIncomingContext(ctx, CountryMetadataKey); len(values) > 0 {
+		info.Country = values[0]
+	}
+	return info
+}
@@ -16,6 +16,14 @@ const (
 	OrderPaid      EventType = "order.paid"
 	StockUpdated   EventType = "stock.updated"
 	UserRegistered EventType = "user.registered"
+
+	ProductPriceChanged EventType = "product.price_changed"
+
+	WishlistPriceDropped EventType = "wishlist.price_dropped"
+
+	PaymentRefunded             EventType = "payment.refunded"
+	PaymentAuthorizationExpired EventType = "payment.authorization_expired"
+	PaymentRiskRejected         EventType = "payment.risk_rejected"
 )
 
 type Event struct {
@@ -24,6 +32,15 @@ type Event struct {
 	Timestamp time.Time   `json:"timestamp"`
 }
 
+// DecodePayload 将Payload解析到v。从Kafka消费的事件Payload是通用的JSON对象，需要转换成具体类型
+func (e Event) DecodePayload(v interface{}) error {
+	data, err := json.Marshal(e.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
 type EventBus interface {
 	Publish(ctx context.Context, event Event) error
 	Subscribe(eventType EventType, handler func(context.Context, Event) error)
@@ -106,3 +123,58 @@ type UserRegisteredPayload struct {
 	Email     string    `json:"email"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// 商品价格变更事件的payload结构，价格均为变更前后的实际售价（已考虑定时价格规则）
+type ProductPriceChangedPayload struct {
+	ProductID uint32    `json:"product_id"`
+	OldPrice  float64   `json:"old_price"`
+	NewPrice  float64   `json:"new_price"`
+	Reason    string    `json:"reason"` // MANUAL、RULE_START、RULE_END
+	RuleID    uint32    `json:"rule_id,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// 收藏商品降价提醒事件的payload结构，供邮件、推送等渠道发送通知
+type WishlistPriceDroppedPayload struct {
+	NotificationID uint32    `json:"notification_id"`
+	UserID         int64     `json:"user_id"`
+	ProductID      uint32    `json:"product_id"`
+	WishlistID     uint32    `json:"wishlist_id"`
+	OldPrice       float64   `json:"old_price"` // 用户加入清单时或上次提醒时的售价
+	NewPrice       float64   `json:"new_price"`
+	DroppedAt      time.Time `json:"dropped_at"`
+}
+
+// 退款成功事件的payload结构，订单服务据此更新订单的退款金额和状态
+type PaymentRefundedPayload struct {
+	RefundID       string    `json:"refund_id"`
+	TransactionID  string    `json:"transaction_id"`
+	OrderID        string    `json:"order_id"`
+	UserID         int64     `json:"user_id"`
+	Amount         float64   `json:"amount"`          // 本次退款金额
+	RefundedAmount float64   `json:"refunded_amount"` // 该交易累计成功退款的金额
+	FullyRefunded  bool      `json:"fully_refunded"`
+	Reason         string    `json:"reason"`
+	RefundedAt     time.Time `json:"refunded_at"`
+}
+
+// 预授权过期事件的payload结构，授权已被撤销，订单服务据此取消未发货的订单
+type PaymentAuthorizationExpiredPayload struct {
+	TransactionID string    `json:"transaction_id"`
+	OrderID       string    `json:"order_id"`
+	UserID        int64     `json:"user_id"`
+	Amount        float64   `json:"amount"`
+	ExpiredAt     time.Time `json:"expired_at"`
+}
+
+// 风控审核拒绝事件的payload结构，未扣款的授权已被撤销，订单服务据此取消未发货的订单。
+// 已扣款的交易审核拒绝时全额退款，订单服务通过退款事件更新订单
+type PaymentRiskRejectedPayload struct {
+	TransactionID string    `json:"transaction_id"`
+	OrderID       string    `json:"order_id"`
+	UserID        int64     `json:"user_id"`
+	Amount        float64   `json:"amount"`
+	Reviewer      string    `json:"reviewer"`
+	Note          string    `json:"note"`
+	RejectedAt    time.Time `json:"rejected_at"`
+}
@@ -0,0 +1,251 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"TKMall/common/log"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MetadataKey gRPC metadata中的幂等键，网关从Idempotency-Key请求头转发
+const MetadataKey = "idempotency-key"
+
+// 请求消息中幂等键字段的名称，计算请求哈希时排除该字段
+const keyField = "idempotency_key"
+
+const (
+	DefaultTTL   = 24 * time.Hour
+	maxKeyLength = 255
+	// 保存处理结果的超时时间，请求被取消后仍然要保存，否则幂等键会一直处于PROCESSING
+	saveTimeout = 5 * time.Second
+)
+
+const (
+	statusProcessing = "PROCESSING"
+	statusCompleted  = "COMPLETED"
+)
+
+// Record 幂等键记录，同一作用域内按用户和幂等键唯一
+type Record struct {
+	ID          uint      `gorm:"primarykey"`
+	Scope       string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_idempotency_key"` // 接口，如 payment.Charge
+	UserID      int64     `gorm:"not null;uniqueIndex:idx_idempotency_key"`
+	Key         string    `gorm:"column:idempotency_key;type:varchar(255);not null;uniqueIndex:idx_idempotency_key"`
+	RequestHash string    `gorm:"type:char(64);not null"`    // 请求内容的SHA-256
+	Status      string    `gorm:"type:varchar(20);not null"` // PROCESSING、COMPLETED
+	Response    []byte    `gorm:"type:mediumblob"`           // 成功时为响应消息，失败时为gRPC状态
+	Failed      bool      `gorm:"not null;default:false"`
+	ExpiresAt   time.Time `gorm:"index;not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Record) TableName() string {
+	return "idempotency_keys"
+}
+
+// AutoMigrate 创建幂等键表，多个服务共用同一张表，按Scope区分
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Record{})
+}
+
+// Store 保存幂等键和请求的处理结果
+type Store struct {
+	DB  *gorm.DB
+	TTL time.Duration // 幂等键的有效期，过期后可以重新使用，默认24小时
+}
+
+func NewStore(db *gorm.DB, ttl time.Duration) *Store {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Store{DB: db, TTL: ttl}
+}
+
+// KeyFromContext 优先使用请求消息中的幂等键，为空时读取gRPC metadata中的幂等键
+func KeyFromContext(ctx context.Context, key string) string {
+	if key != "" {
+		return key
+	}
+	if values := metadata.ValueFromIncomingContext(ctx, MetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Do 按幂等键执行fn：首次请求执行fn并保存结果（包括确定的失败结果），
+// 重放相同的请求直接返回保存的结果，相同幂等键的不同请求返回InvalidArgument。
+// fn返回可以重试的错误时删除幂等键，重试会再次执行fn。
+// store为nil或key为空时直接执行fn
+func Do[T proto.Message](ctx context.Context, store *Store, scope string, userID int64, key string, req proto.Message, fn func() (T, error)) (T, error) {
+	var zero T
+	if store == nil || key == "" {
+		return fn()
+	}
+	if len(key) > maxKeyLength {
+		return zero, status.Errorf(codes.InvalidArgument, "幂等键不能超过%d个字符", maxKeyLength)
+	}
+	hash, err := requestHash(req)
+	if err != nil {
+		return zero, status.Errorf(codes.Internal, "计算请求哈希失败: %v", err)
+	}
+
+	record, owner, err := store.begin(ctx, scope, userID, key, hash)
+	if err != nil {
+		return zero, err
+	}
+	if !owner {
+		return replay[T](record)
+	}
+
+	resp, fnErr := fn()
+	// 请求被取消或超时后仍然保存结果
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveTimeout)
+	defer cancel()
+	if retryable(fnErr) {
+		err = store.release(saveCtx, record)
+	} else {
+		err = store.complete(saveCtx, record, resp, fnErr)
+	}
+	if err != nil {
+		// 结果没有保存时记录保持PROCESSING直到过期，重试会返回处理中，不会重复执行
+		log.Errorf("保存幂等键结果失败: scope=%s key=%s: %v", scope, key, err)
+	}
+	return resp, fnErr
+}
+
+// retryable 暂时性的错误不保存，重试时重新执行，避免在幂等键有效期内一直重放暂时性的失败
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Aborted,
+		codes.ResourceExhausted, codes.Unknown, codes.Canceled:
+		return true
+	}
+	return false
+}
+
+// begin 抢占幂等键，返回当前请求是否需要执行。已存在的记录过期后可以被新请求重新使用
+func (s *Store) begin(ctx context.Context, scope string, userID int64, key, hash string) (*Record, bool, error) {
+	now := time.Now()
+	record := &Record{
+		Scope:       scope,
+		UserID:      userID,
+		Key:         key,
+		RequestHash: hash,
+		Status:      statusProcessing,
+		ExpiresAt:   now.Add(s.TTL),
+	}
+	db := s.DB.WithContext(ctx)
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, false, status.Errorf(codes.Internal, "保存幂等键失败: %v", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return record, true, nil
+	}
+
+	var existing Record
+	if err := db.Where("scope = ? AND user_id = ? AND idempotency_key = ?", scope, userID, key).
+		First(&existing).Error; err != nil {
+		return nil, false, status.Errorf(codes.Internal, "查询幂等键失败: %v", err)
+	}
+
+	if existing.ExpiresAt.Before(now) {
+		taken := db.Model(&Record{}).Where("id = ? AND expires_at < ?", existing.ID, now).
+			Updates(map[string]interface{}{
+				"request_hash": hash,
+				"status":       statusProcessing,
+				"response":     nil,
+				"failed":       false,
+				"expires_at":   record.ExpiresAt,
+			})
+		if taken.Error != nil {
+			return nil, false, status.Errorf(codes.Internal, "保存幂等键失败: %v", taken.Error)
+		}
+		if taken.RowsAffected == 1 {
+			record.ID = existing.ID
+			return record, true, nil
+		}
+		// 被其他请求抢先重新使用
+		return nil, false, status.Error(codes.Aborted, "相同幂等键的请求正在处理中，请稍后重试")
+	}
+
+	if existing.RequestHash != hash {
+		return nil, false, status.Error(codes.InvalidArgument, "幂等键已被用于内容不同的请求")
+	}
+	if existing.Status != statusCompleted {
+		return nil, false, status.Error(codes.Aborted, "相同幂等键的请求正在处理中，请稍后重试")
+	}
+	return &existing, false, nil
+}
+
+// complete 保存请求的处理结果，失败时保存gRPC状态，重放时返回相同的错误
+func (s *Store) complete(ctx context.Context, record *Record, resp proto.Message, fnErr error) error {
+	var data []byte
+	var err error
+	if fnErr != nil {
+		data, err = proto.Marshal(status.Convert(fnErr).Proto())
+	} else {
+		data, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		return err
+	}
+	return s.DB.WithContext(ctx).Model(&Record{}).Where("id = ?", record.ID).
+		Updates(map[string]interface{}{
+			"status":   statusCompleted,
+			"response": data,
+			"failed":   fnErr != nil,
+		}).Error
+}
+
+// release 删除处理失败的幂等键，相同幂等键的重试重新执行
+func (s *Store) release(ctx context.Context, record *Record) error {
+	return s.DB.WithContext(ctx).Where("id = ? AND status = ?", record.ID, statusProcessing).Delete(&Record{}).Error
+}
+
+// replay 返回保存的处理结果
+func replay[T proto.Message](record *Record) (T, error) {
+	var zero T
+	if record.Failed {
+		var st spb.Status
+		if err := proto.Unmarshal(record.Response, &st); err != nil {
+			return zero, status.Errorf(codes.Internal, "解析保存的结果失败: %v", err)
+		}
+		return zero, status.FromProto(&st).Err()
+	}
+
+	resp, ok := zero.ProtoReflect().Type().New().Interface().(T)
+	if !ok {
+		return zero, errors.New("响应类型不匹配")
+	}
+	if err := proto.Unmarshal(record.Response, resp); err != nil {
+		return zero, status.Errorf(codes.Internal, "解析保存的结果失败: %v", err)
+	}
+	return resp, nil
+}
+
+// requestHash 计算请求内容的哈希，不包含幂等键字段
+func requestHash(req proto.Message) (string, error) {
+	msg := proto.Clone(req)
+	if fd := msg.ProtoReflect().Descriptor().Fields().ByName(keyField); fd != nil {
+		msg.ProtoReflect().Clear(fd)
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,194 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"TKMall/build/proto_gen/payment"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestStore(t *testing.T) (*Store, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	return NewStore(db, time.Hour), mock
+}
+
+func chargeReq(amount float32) *payment.ChargeReq {
+	return &payment.ChargeReq{OrderId: "ORD-1", UserId: 1001, Amount: amount, IdempotencyKey: "key-1"}
+}
+
+func recordRows(hash, state string, failed bool, response []byte, expiresAt time.Time) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "scope", "user_id", "idempotency_key", "request_hash", "status", "response", "failed", "expires_at"}).
+		AddRow(1, "payment.Charge", 1001, "key-1", hash, state, response, failed, expiresAt)
+}
+
+// 测试请求哈希不包含幂等键字段
+func TestRequestHash(t *testing.T) {
+	a, err := requestHash(chargeReq(99.9))
+	require.NoError(t, err)
+
+	withoutKey := chargeReq(99.9)
+	withoutKey.IdempotencyKey = ""
+	b, err := requestHash(withoutKey)
+	require.NoError(t, err)
+	assert.Equal(t, a, b, "幂等键来自字段或metadata时哈希相同")
+
+	c, err := requestHash(chargeReq(100))
+	require.NoError(t, err)
+	assert.NotEqual(t, a, c)
+}
+
+// 测试幂等键优先取请求字段，其次取metadata
+func TestKeyFromContext(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "from-header"))
+	assert.Equal(t, "from-field", KeyFromContext(ctx, "from-field"))
+	assert.Equal(t, "from-header", KeyFromContext(ctx, ""))
+	assert.Equal(t, "", KeyFromContext(context.Background(), ""))
+}
+
+// 测试首次请求执行并保存结果
+func TestDoFirstRequest(t *testing.T) {
+	store, mock := newTestStore(t)
+	mock.ExpectExec("INSERT INTO `idempotency_keys`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE `idempotency_keys` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	calls := 0
+	resp, err := Do(context.Background(), store, "payment.Charge", 1001, "key-1", chargeReq(99.9), func() (*payment.ChargeResp, error) {
+		calls++
+		return &payment.ChargeResp{TransactionId: "TXN-1", Status: "COMPLETED"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "TXN-1", resp.TransactionId)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试请求被取消后仍然保存结果，幂等键不会一直处于处理中
+func TestDoCanceledContext(t *testing.T) {
+	store, mock := newTestStore(t)
+	mock.ExpectExec("INSERT INTO `idempotency_keys`").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE `idempotency_keys` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := Do(ctx, store, "payment.Charge", 1001, "key-1", chargeReq(99.9), func() (*payment.ChargeResp, error) {
+		cancel()
+		return nil, status.Error(codes.FailedPrecondition, "支付失败: 余额不足")
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试暂时性的错误不保存，删除幂等键后重试可以重新执行
+func TestDoRetryableError(t *testing.T) {
+	for _, code := range []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Internal} {
+		t.Run(code.String(), func(t *testing.T) {
+			store, mock := newTestStore(t)
+			mock.ExpectExec("INSERT INTO `idempotency_keys`").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("DELETE FROM `idempotency_keys` WHERE id = \\? AND status = \\?").
+				WithArgs(1, statusProcessing).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			_, err := Do(context.Background(), store, "payment.Charge", 1001, "key-1", chargeReq(99.9), func() (*payment.ChargeResp, error) {
+				return nil, status.Error(code, "支付网关不可用")
+			})
+			assert.Equal(t, code, status.Code(err))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// 测试重放：返回保存的结果或错误，不再执行
+func TestDoReplay(t *testing.T) {
+	req := chargeReq(99.9)
+	hash, err := requestHash(req)
+	require.NoError(t, err)
+	saved, err := proto.Marshal(&payment.ChargeResp{TransactionId: "TXN-1", Status: "COMPLETED"})
+	require.NoError(t, err)
+	savedErr, err := proto.Marshal(status.New(codes.FailedPrecondition, "支付失败: 余额不足").Proto())
+	require.NoError(t, err)
+	expiresAt := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		rows     *sqlmock.Rows
+		wantCode codes.Code
+		wantTxn  string
+	}{
+		{"重放成功的结果", recordRows(hash, statusCompleted, false, saved, expiresAt), codes.OK, "TXN-1"},
+		{"重放失败的结果", recordRows(hash, statusCompleted, true, savedErr, expiresAt), codes.FailedPrecondition, ""},
+		{"请求内容不同", recordRows("other", statusCompleted, false, saved, expiresAt), codes.InvalidArgument, ""},
+		{"第一次请求处理中", recordRows(hash, statusProcessing, false, nil, expiresAt), codes.Aborted, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newTestStore(t)
+			mock.ExpectExec("INSERT INTO `idempotency_keys`").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT \\* FROM `idempotency_keys`").WillReturnRows(tt.rows)
+
+			resp, err := Do(context.Background(), store, "payment.Charge", 1001, "key-1", req, func() (*payment.ChargeResp, error) {
+				t.Fatal("重放时不能再次执行")
+				return nil, nil
+			})
+			assert.Equal(t, tt.wantCode, status.Code(err), "%v", err)
+			if tt.wantTxn != "" {
+				require.NotNil(t, resp)
+				assert.Equal(t, tt.wantTxn, resp.TransactionId)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// 测试过期的幂等键可以重新使用
+func TestDoExpiredKey(t *testing.T) {
+	store, mock := newTestStore(t)
+	mock.ExpectExec("INSERT INTO `idempotency_keys`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `idempotency_keys`").
+		WillReturnRows(recordRows("other", statusCompleted, false, nil, time.Now().Add(-time.Minute)))
+	mock.ExpectExec("UPDATE `idempotency_keys` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `idempotency_keys` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	calls := 0
+	_, err := Do(context.Background(), store, "payment.Charge", 1001, "key-1", chargeReq(99.9), func() (*payment.ChargeResp, error) {
+		calls++
+		return &payment.ChargeResp{TransactionId: "TXN-2"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// 测试没有幂等键时直接执行
+func TestDoWithoutKey(t *testing.T) {
+	calls := 0
+	fn := func() (*payment.ChargeResp, error) {
+		calls++
+		return &payment.ChargeResp{}, nil
+	}
+	_, err := Do(context.Background(), nil, "payment.Charge", 1001, "key-1", chargeReq(1), fn)
+	require.NoError(t, err)
+	store, mock := newTestStore(t)
+	_, err = Do(context.Background(), store, "payment.Charge", 1001, "", chargeReq(1), fn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
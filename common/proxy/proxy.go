@@ -71,22 +71,33 @@ func NewGrpcProxy(endpoints map[string]string, redisAddr string) *GrpcProxy {
 	}
 }
 
+type noCacheKey struct{}
+
+// WithoutCache 返回不读写响应缓存的上下文，用于需要实时数据的调用，如价格、库存、购物车内容
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
 // 带熔断和追踪的调用
 func (p *GrpcProxy) Call(ctx context.Context, service, method string, req interface{}) (interface{}, error) {
 	ctx, span := p.tracer.Start(ctx, fmt.Sprintf("%s.%s", service, method))
 	defer span.End()
 
+	useCache := ctx.Value(noCacheKey{}) == nil
+
 	// 尝试从缓存获取
 	cacheKey := fmt.Sprintf("%s:%s:%v", service, method, req)
-	if resp, err := p.getFromCache(ctx, cacheKey); err == nil {
-		return resp, nil
+	if useCache {
+		if resp, err := p.getFromCache(ctx, cacheKey, service, method); err == nil {
+			return resp, nil
+		}
 	}
 
 	var response interface{}
 	err := hystrix.Do(fmt.Sprintf("%s.%s", service, method), func() error {
 		var err error
 		response, err = p.doCall(ctx, service, method, req)
-		if err == nil {
+		if err == nil && useCache {
 			// 写入缓存
 			p.setToCache(ctx, cacheKey, response)
 		}
@@ -111,18 +122,38 @@ func (p *GrpcProxy) AsyncCall(ctx context.Context, service, method string, req i
 	return resultChan, nil
 }
 
-// 缓存相关方法
-func (p *GrpcProxy) getFromCache(ctx context.Context, key string) (interface{}, error) {
+// 缓存相关方法，缓存内容按方法的响应类型解码，调用方可以直接做类型断言
+func (p *GrpcProxy) getFromCache(ctx context.Context, key, service, method string) (interface{}, error) {
 	val, err := p.cache.Get(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	var result interface{}
-	err = json.Unmarshal([]byte(val), &result)
+	result, err := p.newResponse(service, method)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(val), result)
 	return result, err
 }
 
+// newResponse 根据客户端方法签名创建空的响应对象，流式方法不支持缓存
+func (p *GrpcProxy) newResponse(service, method string) (interface{}, error) {
+	client, err := p.getServiceClient(nil, service)
+	if err != nil {
+		return nil, err
+	}
+	m := reflect.ValueOf(client).MethodByName(method)
+	if !m.IsValid() || m.Type().NumOut() != 2 {
+		return nil, fmt.Errorf("method not found: %s", method)
+	}
+	out := m.Type().Out(0)
+	if out.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("method %s does not support cache", method)
+	}
+	return reflect.New(out.Elem()).Interface(), nil
+}
+
 func (p *GrpcProxy) setToCache(ctx context.Context, key string, value interface{}) error {
 	data, err := json.Marshal(value)
 	if err != nil {
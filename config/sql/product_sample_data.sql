@@ -1,11 +1,13 @@
 -- 生成商品分类数据
-INSERT INTO product_categories (id, name, description, sort_order, created_at, updated_at) VALUES
-(1, '手机', '各类智能手机及配件', 10, NOW(), NOW()),
-(2, '电脑', '笔记本电脑、台式机及配件', 20, NOW(), NOW()),
-(3, '家电', '家用电器及智能家居产品', 30, NOW(), NOW()),
-(4, '服装', '男女服装、鞋帽及配饰', 40, NOW(), NOW()),
-(5, '美妆', '化妆品、护肤品及个人护理', 50, NOW(), NOW()),
-(6, '食品', '零食、饮料及生鲜食品', 60, NOW(), NOW());
+INSERT INTO product_categories (id, parent_id, name, path, description, sort_order, created_at, updated_at) VALUES
+(1, 0, '手机', '/1/', '各类智能手机及配件', 10, NOW(), NOW()),
+(2, 0, '电脑', '/2/', '笔记本电脑、台式机及配件', 20, NOW(), NOW()),
+(3, 0, '家电', '/3/', '家用电器及智能家居产品', 30, NOW(), NOW()),
+(4, 0, '服装', '/4/', '男女服装、鞋帽及配饰', 40, NOW(), NOW()),
+(5, 0, '美妆', '/5/', '化妆品、护肤品及个人护理', 50, NOW(), NOW()),
+(6, 0, '食品', '/6/', '零食、饮料及生鲜食品', 60, NOW(), NOW()),
+(7, 2, '笔记本', '/2/7/', '轻薄本、游戏本及创作本', 10, NOW(), NOW()),
+(8, 2, '平板电脑', '/2/8/', '各类平板电脑', 20, NOW(), NOW());
 
 -- 生成商品数据
 INSERT INTO products (id, name, description, price, stock, category_id, is_published, published_at, images, created_at, updated_at) VALUES
@@ -25,6 +27,12 @@ INSERT INTO products (id, name, description, price, stock, category_id, is_publi
 (14, 'SK-II神仙水 230ml', '明星产品，提亮肤色，改善肤质，提升肌肤透明度。', 1599.00, 120, 5, true, NOW(), '["images/products/skii_1.jpg", "images/products/skii_2.jpg"]', NOW(), NOW()),
 (15, '良品铺子零食大礼包', '多种休闲零食组合，味道丰富，送礼自用两相宜。', 99.00, 400, 6, true, NOW(), '["images/products/liangpin_1.jpg", "images/products/liangpin_2.jpg"]', NOW(), NOW());
 
+-- 生成商品分类关联数据
+INSERT INTO product_category_links (product_id, category_id, created_at) VALUES
+(2, 7, NOW()),
+(8, 7, NOW()),
+(12, 8, NOW());
+
 -- 生成商品SKU数据
 INSERT INTO product_skus (id, product_id, sku, price, stock, specs, created_at, updated_at) VALUES
 (1, 1, 'IP15PM-256G-BLACK', 8999.00, 30, '{"color": "黑色", "storage": "256GB"}', NOW(), NOW()),
@@ -19,6 +19,10 @@ try:
 except:
     pass
 
+# 命令行工具只构建，不作为服务运行
+GOLANG_TOOLS = ["tkmall-admin"]
+
+
 def get_golang_servers():
     return util.get_directories("./cmd/")
 
@@ -81,13 +85,15 @@ class Make:
                 util.exec_cmd_with_color("echo \"\n\"")
                 time.sleep(2)
                 # 运行其他服务（排除gateway）
-                other_services = [s for s in self.golang_targets if s != "gateway"]
+                other_services = [s for s in self.golang_targets if s != "gateway" and s not in GOLANG_TOOLS]
                 for service in other_services:
                     logger.Info(f"Starting {service} in background...")
                     util.exec_cmd_with_color(f"./build/bin/{service} &")
                 return
             # 如果不存在gateway则按原顺序运行
             for service in self.golang_targets:
+                if service in GOLANG_TOOLS:
+                    continue
                 util.exec_cmd_with_color(f"./build/bin/{service}")
         else:
             # 单个服务运行时也需要确保 etcd 启动